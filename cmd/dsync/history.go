@@ -0,0 +1,64 @@
+package main
+
+import (
+	"dsync/internal/journal"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"text/tabwriter"
+	"time"
+)
+
+const historyCommand = "history"
+
+//history prints the finished operations from the audit journal, which match the filter flags.
+func history(args []string, out io.Writer) error {
+	flagSet := flag.NewFlagSet("Directories Synchronizer history", flag.ExitOnError)
+	path := flagSet.String("journal", journal.DefaultPath, "path to the audit journal")
+	var filter journal.Filter
+	flagSet.StringVar(&filter.Path, "path", "",
+		"glob pattern of the relative path or of the name (the path without wildcards selects everything inside it)")
+	since := flagSet.String("since", "", "selects the operations finished since then: the duration back from now "+
+		"(e.g. 1h), the date (2006-01-02) or the RFC 3339 time")
+	flagSet.StringVar(&filter.Status, "status", "", "selects the operations by status: completed, failed or canceled")
+	flagSet.StringVar(&filter.Job, "job", "", "selects the operations of the sync job")
+	asJSON := flagSet.Bool("json", false, "if true, then the records are printed as JSON lines")
+	flagSet.Parse(args)
+
+	if flagSet.NArg() > 0 {
+		return errors.New("usage: history [-journal FILE] [-path PATTERN] [-since 1h] [-status failed] [-job NAME]")
+	}
+	if *since != "" {
+		var ok bool
+		if filter.Since, ok = journal.ParseSince(*since, time.Now()); !ok {
+			return fmt.Errorf("cannot parse -since %q", *since)
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	enc := json.NewEncoder(out)
+	if !*asJSON {
+		fmt.Fprintln(w, "FINISHED AT\tSTATUS\tKIND\tSIZE\tCOPY DIR\tPATH\tERROR")
+	}
+	err := journal.Read(*path, func(rec journal.Record) error {
+		if !filter.Match(rec) {
+			return nil
+		}
+		if *asJSON {
+			return enc.Encode(rec)
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", rec.FinishedAt.Local().Format("2006-01-02 15:04:05"),
+			rec.Status, rec.Kind, rec.Size, rec.CopyDir, rec.Path, rec.Error)
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("journal %q does not exist (it's kept, only if the sync is run with the -journal flag)", *path)
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"dsync/internal/log"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+//shutdownTimeout is how long the HTTP server waits for the active requests on exit.
+const shutdownTimeout = 5 * time.Second
+
+//startHTTP starts serving the handler on the address in background. The listener is created synchronously,
+//so a busy or a wrong address fails the start. The returned function stops the server.
+func startHTTP(logger log.Logger, addr string, handler http.Handler) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP server stopped with error", log.Cause(err))
+		}
+	}()
+	logger.Info("HTTP server started", log.String("addr", listener.Addr().String()))
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("HTTP server shutdown failed", log.Cause(err))
+		}
+		<-done
+	}, nil
+}
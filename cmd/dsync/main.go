@@ -7,27 +7,70 @@ import (
 	"dsync/internal/settings"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
+//The exit codes of the process. The one-shot sync tells apart its results, otherwise exitOK means the normal shutdown.
+const (
+	exitOK      = 0 // the one-shot sync has found the dirs in sync already
+	exitFatal   = 1 // the process has been stopped by the critical error
+	exitUsage   = 2 // the flags or the arguments are invalid
+	exitChanged = 3 // the one-shot sync has changed the copy dirs, and all the operations have succeeded
+	exitPartial = 4 // some operations of the one-shot sync have failed
+)
+
 func main() {
-	stg, err := settings.New(os.Args[1:], flag.ExitOnError)
+	if len(os.Args) > 1 && os.Args[1] == versionsCommand {
+		if err := listVersions(os.Args[2:]); err != nil {
+			exit(err, exitFatal)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == historyCommand {
+		if err := history(os.Args[2:], os.Stdout); err != nil {
+			exit(err, exitFatal)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == serveCommand {
+		if err := serve(os.Args[2:]); err != nil {
+			exit(err, exitFatal)
+		}
+		return
+	}
+
+	var stg *settings.Settings
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == pushCommand {
+		stg, err = pushSettings(os.Args[2:])
+	} else if len(os.Args) > 1 && (os.Args[1] == restoreCommand || os.Args[1] == decryptCommand) {
+		stg, err = restoreSettings(os.Args[1], os.Args[2:])
+	} else {
+		stg, err = settings.New(os.Args[1:], flag.ExitOnError)
+	}
 	if err != nil {
-		exit(err, 2)
+		exit(err, exitUsage)
 	}
 	if err := stg.Validate(); err != nil {
-		exit(err, 1)
+		exit(err, exitFatal)
 	}
 
 	pid := os.Getpid()
-	if err := run(stg, pid); err != nil {
-		exit(err, 1)
+	code, err := run(stg, pid)
+	if err != nil {
+		exit(err, exitFatal)
 	}
 
 	// this print proves normal (externally initialized) and graceful shutdown
 	fmt.Printf("Directories Synchronizer process (PID = %d) has been stopped\n", pid)
+	if code != exitOK {
+		os.Exit(code)
+	}
 }
 
 func exit(err error, code int) {
@@ -36,13 +79,16 @@ func exit(err error, code int) {
 }
 
 //run returns only most critical errors that make further work impossible,
-//otherwise returns nil (including the case when an external OS signal, e.g. SIGTERM, was received).
-func run(stg *settings.Settings, pid int) error {
-	logger, err := log.New(stg.LogLevel, stg.LogToStd)
+//otherwise returns nil (including the case when an external OS signal, e.g. SIGTERM, was received)
+//along with the exit code of the one-shot sync (see exitChanged and exitPartial).
+func run(stg *settings.Settings, pid int) (int, error) {
+	logger, err := log.New(log.Config{Level: stg.LogLevel, Format: stg.LogFormat, ToStd: stg.LogToStd,
+		File: stg.LogFile, Rotation: stg.LogRotation, Components: stg.ComponentLevels})
 	if err != nil {
-		return fmt.Errorf("cannot initialize the logger: %v", err)
+		return exitFatal, fmt.Errorf("cannot initialize the logger: %v", err)
 	}
 	defer logger.Sync()
+	defer handleLogRotation(logger)()
 
 	logger.Debug("logger initialized")
 	logger.Debug("cli args successfully parsed", log.Any("settings", *stg))
@@ -55,5 +101,60 @@ func run(stg *settings.Settings, pid int) error {
 		fmt.Println("Directories Synchronizer process started, its PID:", pid)
 	}
 
-	return dirsyncer.New(logger, *stg).Start(ctx, stop)
+	var syncer interface {
+		Start(ctx context.Context, stop context.CancelFunc) error
+		HTTPHandler() http.Handler
+		Summary() dirsyncer.Summary
+	}
+	if len(stg.Jobs) > 0 {
+		syncer = dirsyncer.NewGroup(logger, *stg)
+	} else {
+		syncer = dirsyncer.New(logger, *stg)
+	}
+
+	if stg.HTTPAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/", syncer.HTTPHandler())
+		mux.Handle("/loglevel", log.LevelHandler(logger))
+		stopHTTP, err := startHTTP(logger, stg.HTTPAddr, mux)
+		if err != nil {
+			return exitFatal, fmt.Errorf("cannot start the HTTP server: %v", err)
+		}
+		defer stopHTTP()
+	}
+	err = syncer.Start(ctx, stop)
+	if !stg.Once {
+		return exitOK, err
+	}
+	return summarize(logger, stg, syncer.Summary(), err)
+}
+
+//summarize prints the summary of the one-shot sync (and writes it into the report file, if it's set)
+//and returns the exit code, which depends on the sync results.
+func summarize(logger log.Logger, stg *settings.Settings, summary dirsyncer.Summary, err error) (int, error) {
+	if len(summary.CopyDirs) == 0 { // e.g. the snapshot or the archive has been made, so there is nothing to summarize
+		if err != nil {
+			return exitFatal, err
+		}
+		return exitOK, nil
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	fmt.Println(summary)
+	logger.Info("sync summary", log.Any("summary", summary))
+	if stg.ReportPath != "" {
+		if reportErr := summary.WriteFile(stg.ReportPath); reportErr != nil && err == nil {
+			err = reportErr
+		}
+	}
+	switch {
+	case err != nil:
+		return exitFatal, err
+	case summary.Failed > 0:
+		return exitPartial, nil
+	case summary.Changes() > 0:
+		return exitChanged, nil
+	}
+	return exitOK, nil
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"dsync/internal/settings"
+	"dsync/pkg/fsys/netfs"
+	"flag"
+	"fmt"
+)
+
+const pushCommand = "push"
+
+//pushSettings parses the push command args, which are the same as the default command ones,
+//except that all copy dirs must be served by the dsync servers (dsync://host[:port]/path).
+func pushSettings(args []string) (*settings.Settings, error) {
+	stg, err := settings.New(args, flag.ExitOnError)
+	if err != nil {
+		return nil, err
+	}
+	if len(stg.Jobs) > 0 {
+		return nil, fmt.Errorf("the %s command doesn't support -config flag", pushCommand)
+	}
+	for _, copyDir := range stg.AllCopyDirs() {
+		if !netfs.IsURL(copyDir) {
+			return nil, fmt.Errorf("copy directory %q must be a dsync URL (dsync://host[:port]/path)", copyDir)
+		}
+	}
+	return stg, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"dsync/internal/settings"
+	"flag"
+	"fmt"
+)
+
+const (
+	restoreCommand = "restore"
+	decryptCommand = "decrypt" // the alias of the restore command
+)
+
+//restoreSettings parses the restore command args, which are the same as the default command ones, but the source dir
+//is the copy dir made with -encrypt and/or -compress flags, and it's restored into the copy dirs only once. How
+//the source dir is encrypted and compressed is taken from the dir itself, and the encryption key is passed
+//by -keyfile flag or by the passphrase env var in the same way as on encryption.
+func restoreSettings(command string, args []string) (*settings.Settings, error) {
+	stg, err := settings.New(args, flag.ExitOnError)
+	if err != nil {
+		return nil, err
+	}
+	if len(stg.Jobs) > 0 {
+		return nil, fmt.Errorf("the %s command doesn't support -config flag", command)
+	}
+	if stg.EncryptNames || stg.Compress != "" {
+		return nil, fmt.Errorf("the %s command takes the encryption and compression from the source directory, "+
+			"-encryptnames and -compress flags are not supported", command)
+	}
+	stg.Encrypt, stg.Restore, stg.Once = false, true, true
+	return stg, nil
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "dsync/internal/log"
+
+//handleLogRotation does nothing, because there is no SIGUSR1 signal on this OS.
+func handleLogRotation(log.Logger) func() {
+	return func() {}
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"dsync/internal/log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+//handleLogRotation rotates (or reopens, if it has been moved by logrotate) the log file on each SIGUSR1 signal.
+//The returned function stops the handling.
+func handleLogRotation(logger log.Logger) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				if err := log.Rotate(logger); err != nil {
+					logger.Error("cannot rotate the log file", log.Cause(err))
+				} else {
+					logger.Info("log file rotated")
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"dsync/internal/log"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys/netfs"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const serveCommand = "serve"
+
+//serve runs the dsync server, which receives the pushed copies into the root dir, until a termination signal.
+func serve(args []string) error {
+	flagSet := flag.NewFlagSet("Directories Synchronizer server", flag.ExitOnError)
+	srv := &netfs.Server{}
+	flagSet.StringVar(&srv.Root, "root", "", "the directory, which the clients' copy directories are placed into")
+	listen := flagSet.String("listen", ":"+netfs.DefaultPort, "the address to listen on")
+	flagSet.StringVar(&srv.Token, "token", "",
+		fmt.Sprintf("the token the clients must pass (%s env var by default, empty means no authentication)",
+			settings.TokenEnv))
+	certPath := flagSet.String("tlscert", "", "path to the PEM certificate, enables TLS along with -tlskey")
+	keyPath := flagSet.String("tlskey", "", "path to the PEM private key of the certificate")
+	level := flagSet.String("loglvl", log.InfoLevel, "level of logging")
+	flagSet.Parse(args)
+
+	if srv.Token == "" {
+		srv.Token = os.Getenv(settings.TokenEnv)
+	}
+	if srv.Root == "" {
+		return errors.New("usage: serve -root /path/to/dir [-listen :7707] [-token TOKEN] [-tlscert FILE -tlskey FILE]")
+	}
+	if err := validateServeRoot(srv.Root); err != nil {
+		return err
+	}
+	if !log.Level(*level).IsValid() {
+		return fmt.Errorf("logging level %q does not exist", *level)
+	}
+	if (*certPath == "") != (*keyPath == "") {
+		return errors.New("both -tlscert and -tlskey must be passed to enable TLS")
+	}
+	if *certPath != "" {
+		cert, err := tls.LoadX509KeyPair(*certPath, *keyPath)
+		if err != nil {
+			return fmt.Errorf("cannot load the TLS certificate: %v", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	logger, err := log.New(log.Config{Level: log.Level(*level), ToStd: true})
+	if err != nil {
+		return fmt.Errorf("cannot initialize the logger: %v", err)
+	}
+	defer logger.Sync()
+	srv.OnError = func(err error) {
+		logger.Warn("client session failed", log.Cause(err))
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+	logger.Info("dsync server started", log.String("addr", listener.Addr().String()), log.String("root", srv.Root),
+		log.Bool("tls", srv.TLSConfig != nil), log.Bool("auth", srv.Token != ""))
+	if srv.Token == "" {
+		logger.Warn("no token is set, so any client can write into the root directory")
+	}
+	return srv.Serve(ctx, listener)
+}
+
+func validateServeRoot(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("root %q is not a directory", root)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"dsync/internal/versions"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+const versionsCommand = "versions"
+
+//listVersions prints all kept versions of the file, args are the copy dir and the file path relative to it.
+func listVersions(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: versions /path/to/copy/dir relative/path/to/file")
+	}
+	copyDir, path := args[0], filepath.Clean(args[1])
+	if filepath.IsAbs(path) {
+		return fmt.Errorf("path %q must be relative to the copy dir", path)
+	}
+
+	// the retention policy doesn't matter for listing
+	vers, err := versions.NewStore(copyDir, versions.RetentionPolicy{}).List(path)
+	if err != nil {
+		return err
+	}
+	if len(vers) == 0 {
+		fmt.Printf("No versions of %q found\n", path)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED AT\tSIZE\tPATH")
+	for _, v := range vers {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", v.CreatedAt.Local().Format("2006-01-02 15:04:05"), v.Size, v.FullPath)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"dsync/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	//ManifestSuffix is appended to the archive path to get the path of the manifest of its archives set.
+	ManifestSuffix = ".manifest.json"
+	//ManifestName is the entry of each archive, which holds the manifest as it was after the archive was made.
+	ManifestName = ".dsync-manifest.json"
+	//DeletionsName is the entry of an incremental archive, which lists the slash-separated paths deleted since
+	//the previous archive (one per line). A deleted dir is listed without its content.
+	DeletionsName = ".dsync-deleted"
+
+	partialSuffix = ".partial"
+)
+
+//Format is the archive file format, which is chosen by the archive path extension.
+type Format string
+
+const (
+	FormatTar     Format = "tar"
+	FormatTarGzip Format = "tar.gz"
+	FormatTarZstd Format = "tar.zst"
+	FormatZip     Format = "zip"
+)
+
+var extensions = []struct {
+	ext    string
+	format Format
+}{
+	{".tar", FormatTar},
+	{".tar.gz", FormatTarGzip},
+	{".tgz", FormatTarGzip},
+	{".tar.zst", FormatTarZstd},
+	{".tzst", FormatTarZstd},
+	{".zip", FormatZip},
+}
+
+//IsPath reports whether the path is of an archive: it has the archive's extension, but it's neither the URL
+//of a remote dir nor an existing dir (which is synced as usual).
+func IsPath(path string) bool {
+	if _, _, ok := splitExt(path); !ok || strings.Contains(path, "://") {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err != nil || !info.IsDir()
+}
+
+func splitExt(path string) (string, Format, bool) {
+	lower := strings.ToLower(path)
+	for _, e := range extensions {
+		if strings.HasSuffix(lower, e.ext) && len(path) > len(e.ext) {
+			return path[:len(path)-len(e.ext)], e.format, true
+		}
+	}
+	return "", "", false
+}
+
+//Manifest describes the set of archives: the full one and the incremental ones made after it.
+type Manifest struct {
+	Archives []string                  `json:"archives"` // the file names of the set's archives in order of making
+	Entries  map[string]model.PathInfo `json:"entries"`  // the archived source entries by their slash-separated paths
+}
+
+//Set is the full archive at the given path along with the incremental archives made after it, which are named
+//with the sequence number before the extension (e.g. out.tar.zst, out.1.tar.zst, out.2.tar.zst, etc.).
+type Set struct {
+	path   string
+	stem   string // the path without the extension
+	ext    string
+	format Format
+}
+
+func NewSet(path string) (*Set, error) {
+	stem, format, ok := splitExt(path)
+	if !ok {
+		return nil, fmt.Errorf("%q has no known archive extension", path)
+	}
+	return &Set{path: path, stem: stem, ext: path[len(stem):], format: format}, nil
+}
+
+func (s *Set) ManifestPath() string {
+	return s.path + ManifestSuffix
+}
+
+//LoadManifest reads the manifest of the set. If it's missing or lags behind the archives (it's saved after
+//the archive, so the saving may fail), then it's taken from the last archive of the set, as each archive keeps
+//the manifest inside. If there are no archives yet, then the empty manifest is returned.
+func (s *Set) LoadManifest() (*Manifest, error) {
+	data, err := os.ReadFile(s.ManifestPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read archive manifest: %w", err)
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(s.NextPath(m)); errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if data, err = s.readLastManifest(); err != nil {
+		return nil, fmt.Errorf("cannot read archive manifest: %w", err)
+	}
+	return parseManifest(data)
+}
+
+//parseManifest parses the manifest data. No data means the empty manifest.
+func parseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if data != nil {
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("cannot parse archive manifest: %w", err)
+		}
+	}
+	if m.Entries == nil {
+		m.Entries = make(map[string]model.PathInfo)
+	}
+	for path, pi := range m.Entries {
+		pi.ModTime = pi.ModTime.Local() // to be comparable with the scanned modification times as is
+		m.Entries[path] = pi
+	}
+	return m, nil
+}
+
+//readLastManifest returns the manifest kept inside the last archive of the set (nil, if there are no archives).
+func (s *Set) readLastManifest() ([]byte, error) {
+	last := ""
+	for i := 0; ; i++ {
+		path := s.archivePath(i)
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		last = path
+	}
+	if last == "" {
+		return nil, nil
+	}
+	data, err := readEntry(last, s.format, ManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("archive %q: %w", last, err)
+	}
+	return data, nil
+}
+
+//NextPath returns the path of the next archive of the set: the full one, if there are no archives yet.
+func (s *Set) NextPath(m *Manifest) string {
+	return s.archivePath(len(m.Archives))
+}
+
+func (s *Set) archivePath(seq int) string {
+	if seq == 0 {
+		return s.path
+	}
+	return s.stem + "." + strconv.Itoa(seq) + s.ext
+}
+
+//Create starts writing the archive at the path. It's written into the partial file, which is renamed on Commit.
+func (s *Set) Create(path string) (*Writer, error) {
+	return newWriter(path, s.format)
+}
+
+//SaveManifest writes the manifest next to the full archive (atomically).
+func (s *Set) SaveManifest(m *Manifest) error {
+	data, err := marshalManifest(m)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.ManifestPath() + partialSuffix
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("cannot write archive manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.ManifestPath()); err != nil {
+		return fmt.Errorf("cannot replace archive manifest: %w", err)
+	}
+	return nil
+}
+
+func marshalManifest(m *Manifest) ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal archive manifest: %w", err)
+	}
+	return data, nil
+}
+
+//ArchiveName returns the slash-separated name of the entry in the archive by its relative path.
+func ArchiveName(path string) string {
+	return filepath.ToSlash(path)
+}
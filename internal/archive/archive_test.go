@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"dsync/internal/model"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPath(t *testing.T) {
+	dirTar := filepath.Join(t.TempDir(), "dir.tar")
+	require.NoError(t, os.Mkdir(dirTar, 0o755))
+	for path, want := range map[string]bool{
+		"/data/out.tar":              true,
+		"/data/out.TAR.GZ":           true,
+		"/data/out.tgz":              true,
+		"/data/out.tar.zst":          true,
+		"/data/out.zip":              true,
+		"/data/out.zst":              false,
+		"/data/out":                  false,
+		".zip":                       false,
+		"sftp://host/backups.zip":    false,
+		"s3://bucket/x.tar":          false,
+		"dsync://host:7707/copy.tgz": false,
+		dirTar:                       false, // the existing dir is not an archive
+	} {
+		require.Equal(t, want, IsPath(path), path)
+	}
+}
+
+func TestSet_NextPath(t *testing.T) {
+	requires := require.New(t)
+	set, err := NewSet("/data/out.tar.zst")
+	requires.NoError(err)
+	requires.Equal("/data/out.tar.zst", set.NextPath(&Manifest{}))
+	requires.Equal("/data/out.2.tar.zst", set.NextPath(&Manifest{Archives: []string{"out.tar.zst", "out.1.tar.zst"}}))
+	requires.Equal("/data/out.tar.zst.manifest.json", set.ManifestPath())
+	_, err = NewSet("/data/out")
+	requires.Error(err)
+}
+
+//listEntries returns the content of the archive's entries by their names (dirs have the empty content).
+func listEntries(requires *require.Assertions, path string, format Format) map[string]string {
+	entries := make(map[string]string)
+	if format == FormatZip {
+		r, err := zip.OpenReader(path)
+		requires.NoError(err)
+		defer r.Close()
+		for _, f := range r.File {
+			rc, err := f.Open()
+			requires.NoError(err)
+			content, err := io.ReadAll(rc)
+			requires.NoError(err)
+			_ = rc.Close()
+			entries[f.Name] = string(content)
+		}
+		return entries
+	}
+	file, err := os.Open(path)
+	requires.NoError(err)
+	defer file.Close()
+	var in io.Reader = file
+	switch format {
+	case FormatTarGzip:
+		gz, err := gzip.NewReader(file)
+		requires.NoError(err)
+		in = gz
+	case FormatTarZstd:
+		zr, err := zstd.NewReader(file)
+		requires.NoError(err)
+		defer zr.Close()
+		in = zr
+	}
+	tr := tar.NewReader(in)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		requires.NoError(err)
+		content, err := io.ReadAll(tr)
+		requires.NoError(err)
+		entries[h.Name] = string(content)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 891, time.Local)
+	for _, ext := range []string{".tar", ".tar.gz", ".tar.zst", ".zip"} {
+		t.Run(ext, func(t *testing.T) {
+			requires := require.New(t)
+			set, err := NewSet(filepath.Join(t.TempDir(), "out"+ext))
+			requires.NoError(err)
+			m, err := set.LoadManifest()
+			requires.NoError(err)
+			requires.Empty(m.Archives)
+
+			// the full archive
+			path := set.NextPath(m)
+			w, err := set.Create(path)
+			requires.NoError(err)
+			dir := model.PathInfo{Exists: true, IsDir: true, ModTime: modTime, Mode: 0o755}
+			file := model.PathInfo{Exists: true, Size: 7, ModTime: modTime, Mode: 0o600}
+			requires.NoError(w.AddDir("dir", dir))
+			requires.NoError(w.AddFile("dir/file.txt", file, strings.NewReader("content and more")))
+			m = &Manifest{Archives: []string{filepath.Base(path)},
+				Entries: map[string]model.PathInfo{"dir": dir, "dir/file.txt": file}}
+			requires.NoError(w.Commit(m, []string{"old"}))
+			requires.NoFileExists(path + partialSuffix)
+			entries := listEntries(requires, path, set.format)
+			requires.Equal("content", entries["dir/file.txt"])
+			requires.Contains(entries, "dir/")
+			requires.Equal("old\n", entries[DeletionsName])
+			requires.Contains(entries[ManifestName], `"dir/file.txt"`)
+
+			// the manifest is taken from the archive, if it's not saved next to it
+			loaded, err := set.LoadManifest()
+			requires.NoError(err)
+			requires.Equal(m.Archives, loaded.Archives)
+			requires.Equal(file.Size, loaded.Entries["dir/file.txt"].Size)
+			requires.True(loaded.Entries["dir/file.txt"].ModTime.Equal(modTime))
+			requires.Equal(set.path[:len(set.path)-len(ext)]+".1"+ext, set.NextPath(loaded))
+
+			// the file, which has got shorter, is not archived, and the partial archive is removed
+			w, err = set.Create(set.NextPath(loaded))
+			requires.NoError(err)
+			requires.ErrorIs(w.AddFile("file.txt", file, strings.NewReader("short")), ErrFileChanged)
+			w.Abort()
+			requires.NoFileExists(set.NextPath(loaded))
+			requires.NoFileExists(set.NextPath(loaded) + partialSuffix)
+		})
+	}
+}
+
+func TestSet_LoadManifest(t *testing.T) {
+	requires := require.New(t)
+	set, err := NewSet(filepath.Join(t.TempDir(), "out.tar"))
+	requires.NoError(err)
+
+	// the saved manifest is used, unless there is an archive made after it
+	saved := &Manifest{Archives: []string{"out.tar"}, Entries: map[string]model.PathInfo{"a": {Exists: true}}}
+	requires.NoError(set.SaveManifest(saved))
+	requires.NoError(os.WriteFile(set.path, nil, 0o644))
+	loaded, err := set.LoadManifest()
+	requires.NoError(err)
+	requires.Equal(saved.Archives, loaded.Archives)
+
+	w, err := set.Create(set.NextPath(loaded))
+	requires.NoError(err)
+	latest := &Manifest{Archives: []string{"out.tar", "out.1.tar"}, Entries: map[string]model.PathInfo{}}
+	requires.NoError(w.Commit(latest, nil))
+	loaded, err = set.LoadManifest()
+	requires.NoError(err)
+	requires.Equal(latest.Archives, loaded.Archives)
+}
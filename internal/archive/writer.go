@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"dsync/internal/model"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//ErrFileChanged is returned, when the file's size has changed since it was scanned, so it can't be archived as is.
+var ErrFileChanged = errors.New("file has changed while it was archived")
+
+//Writer writes the archive entries in the order they are added.
+type Writer struct {
+	path       string
+	file       *os.File
+	compressor io.WriteCloser // is nil for the uncompressed formats
+	tar        *tar.Writer    // is nil for the zip format
+	zip        *zip.Writer    // is nil for the tar formats
+}
+
+func newWriter(path string, format Format) (*Writer, error) {
+	file, err := os.Create(path + partialSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create archive: %w", err)
+	}
+	w := &Writer{path: path, file: file}
+	var out io.Writer = file
+	switch format {
+	case FormatTarGzip:
+		w.compressor = gzip.NewWriter(file)
+	case FormatTarZstd:
+		if w.compressor, err = zstd.NewWriter(file); err != nil {
+			w.Abort()
+			return nil, fmt.Errorf("cannot create archive: %w", err)
+		}
+	}
+	if w.compressor != nil {
+		out = w.compressor
+	}
+	if format == FormatZip {
+		w.zip = zip.NewWriter(out)
+	} else {
+		w.tar = tar.NewWriter(out)
+	}
+	return w, nil
+}
+
+//AddDir adds the dir entry with the dir's permissions and modification time.
+func (w *Writer) AddDir(name string, pi model.PathInfo) error {
+	name += "/"
+	if w.zip != nil {
+		_, err := w.zip.CreateHeader(zipHeader(name, pi))
+		return wrapErr(name, err)
+	}
+	return wrapErr(name, w.tar.WriteHeader(tarHeader(name, tar.TypeDir, pi)))
+}
+
+//AddFile adds the file of the scanned size. If the content is shorter, then ErrFileChanged is returned
+//(the extra content is just not archived).
+func (w *Writer) AddFile(name string, pi model.PathInfo, content io.Reader) error {
+	var (
+		out io.Writer
+		err error
+	)
+	if w.zip != nil {
+		out, err = w.zip.CreateHeader(zipHeader(name, pi))
+	} else {
+		out, err = w.tar, w.tar.WriteHeader(tarHeader(name, tar.TypeReg, pi))
+	}
+	if err != nil {
+		return wrapErr(name, err)
+	}
+	if _, err := io.CopyN(out, content, pi.Size); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = ErrFileChanged
+		}
+		return wrapErr(name, err)
+	}
+	return nil
+}
+
+//addData adds the file with the content made by dsync itself (like the manifest).
+func (w *Writer) addData(name string, data []byte, modTime time.Time) error {
+	pi := model.PathInfo{Size: int64(len(data)), ModTime: modTime, Mode: 0o644}
+	return w.AddFile(name, pi, bytes.NewReader(data))
+}
+
+//Commit finishes the archive with the deletions list (if it's not empty) and the manifest, and then puts
+//the archive at its path.
+func (w *Writer) Commit(m *Manifest, deletions []string) error {
+	now := time.Now()
+	if len(deletions) > 0 {
+		var list bytes.Buffer
+		for _, path := range deletions {
+			list.WriteString(path + "\n")
+		}
+		if err := w.addData(DeletionsName, list.Bytes(), now); err != nil {
+			return err
+		}
+	}
+	data, err := marshalManifest(m)
+	if err != nil {
+		return err
+	}
+	if err := w.addData(ManifestName, data, now); err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return fmt.Errorf("cannot write archive: %w", err)
+	}
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		return fmt.Errorf("cannot complete archive: %w", err)
+	}
+	return nil
+}
+
+//Abort discards the partial archive.
+func (w *Writer) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+func (w *Writer) close() error {
+	var err error
+	if w.zip != nil {
+		err = w.zip.Close()
+	} else if w.tar != nil {
+		err = w.tar.Close()
+	}
+	if w.compressor != nil {
+		if cErr := w.compressor.Close(); err == nil {
+			err = cErr
+		}
+	}
+	if sErr := w.file.Sync(); err == nil {
+		err = sErr
+	}
+	if cErr := w.file.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+//tarHeader makes the header in the PAX format, which keeps the modification time with the full precision.
+func tarHeader(name string, typ byte, pi model.PathInfo) *tar.Header {
+	h := &tar.Header{
+		Typeflag: typ,
+		Name:     name,
+		Mode:     int64(pi.Mode.Perm()),
+		ModTime:  pi.ModTime,
+		Format:   tar.FormatPAX,
+	}
+	if typ == tar.TypeReg {
+		h.Size = pi.Size
+	}
+	return h
+}
+
+func zipHeader(name string, pi model.PathInfo) *zip.FileHeader {
+	h := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: pi.ModTime}
+	mode := pi.Mode.Perm()
+	if pi.IsDir {
+		mode |= fs.ModeDir
+		h.Method = zip.Store
+	}
+	h.SetMode(mode)
+	return h
+}
+
+func wrapErr(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("cannot archive %q: %w", name, err)
+}
@@ -0,0 +1,145 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/archive"
+	"dsync/internal/log"
+	"dsync/internal/model"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//makeArchive archives the source dir into the copy dir path, which is an archive path. The source dir is compared
+//with the manifest of the previous archives (as if it was the copy dir), so the first archive is the full one,
+//and the next ones are incremental: they contain only the changed entries and the list of the deleted ones.
+func (d *DirSyncer) makeArchive(ctx context.Context) error {
+	set, err := archive.NewSet(d.settings.CopyDir)
+	if err != nil {
+		return err
+	}
+	manifest, err := set.LoadManifest()
+	if err != nil {
+		return err
+	}
+
+	eMap := model.NewDirEntriesMap()
+	// the copy side is taken from the manifest rather than walked, so the target has no file system
+	scanner := newDirScanner(d.log, d.settings, eMap, d.fss.src, copyTarget{root: d.settings.CopyDir})
+	listing, err := scanner.scanSource(ctx)
+	if err != nil {
+		return err
+	}
+	for name, pi := range manifest.Entries {
+		pi := pi
+		eMap.UpdateValueByKey(filepath.FromSlash(name), func(entry *model.EntryInfo) { entry.SetCopyPathInfo(pi) })
+	}
+	scanner.mergeSource(listing)
+
+	var added, deleted []string
+	_ = eMap.ForEach(func(path string, eMap map[string]model.EntryInfo) error {
+		entry := eMap[path]
+		switch entry.ResolveOperationKind() {
+		case model.OpKindCopyFile, model.OpKindReplaceFile, model.OpKindCopyDir:
+			added = append(added, path)
+		case model.OpKindRemoveFile, model.OpKindRemoveDir, model.OpKindReplaceDirWithFile:
+			// the entry of another type is deleted first, and then the source one is added in its place
+			deleted = append(deleted, path)
+			if entry.SrcPathInfo.Exists {
+				added = append(added, path)
+			}
+		}
+		return nil
+	})
+	incremental := len(manifest.Archives) > 0
+	if incremental && len(added) == 0 && len(deleted) == 0 {
+		d.log.Info("archive is up to date", log.String("archive", set.NextPath(manifest)))
+		return nil
+	}
+
+	path := set.NextPath(manifest)
+	w, err := set.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := d.archiveEntries(ctx, w, listing, added); err != nil {
+		w.Abort()
+		return err
+	}
+	next := &archive.Manifest{
+		Archives: append(manifest.Archives, filepath.Base(path)),
+		Entries:  make(map[string]model.PathInfo, len(listing.entries)),
+	}
+	for path, pi := range listing.entries {
+		next.Entries[archive.ArchiveName(path)] = pi
+	}
+	deleted = topmostPaths(deleted)
+	if err := w.Commit(next, deleted); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := set.SaveManifest(next); err != nil {
+		return err
+	}
+	d.log.Info("archive made", log.String("archive", path), log.Bool("incremental", incremental),
+		log.Int("added", len(added)), log.Int("deleted", len(deleted)))
+	return nil
+}
+
+//archiveEntries adds the source entries to the archive in order of their paths, so the dirs go before
+//their content. Empty dirs are added only if they are included in synchronization.
+func (d *DirSyncer) archiveEntries(ctx context.Context, w *archive.Writer, listing *sourceListing, paths []string) error {
+	sort.Slice(paths, func(i, j int) bool { return archive.ArchiveName(paths[i]) < archive.ArchiveName(paths[j]) })
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pi := listing.entries[path]
+		name := archive.ArchiveName(path)
+		if pi.IsDir {
+			if d.settings.IncludeEmptyDirs {
+				if err := w.AddDir(name, pi); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		r, err := d.fss.src.Open(pi.FullPath)
+		if err != nil {
+			return err
+		}
+		err = w.AddFile(name, pi, r)
+		_ = r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//topmostPaths returns the slash-separated paths sorted and without the ones inside the other paths.
+func topmostPaths(paths []string) []string {
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = archive.ArchiveName(path)
+	}
+	sort.Strings(names)
+	kept := make(map[string]bool, len(names))
+	var topmost []string
+	for _, name := range names {
+		if !hasAncestorIn(kept, name) {
+			kept[name] = true
+			topmost = append(topmost, name)
+		}
+	}
+	return topmost
+}
+
+func hasAncestorIn(names map[string]bool, name string) bool {
+	for i := strings.LastIndex(name, "/"); i > 0; i = strings.LastIndex(name, "/") {
+		if name = name[:i]; names[name] {
+			return true
+		}
+	}
+	return false
+}
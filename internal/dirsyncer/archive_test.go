@@ -0,0 +1,96 @@
+package dirsyncer
+
+import (
+	"archive/tar"
+	"dsync/internal/archive"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys/memfs"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+//readTarZst returns the content of the archive's entries by their names (dirs have the empty content).
+func readTarZst(requires *require.Assertions, path string) map[string]string {
+	file, err := os.Open(path)
+	requires.NoError(err)
+	defer file.Close()
+	zr, err := zstd.NewReader(file)
+	requires.NoError(err)
+	defer zr.Close()
+	entries := make(map[string]string)
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		requires.NoError(err)
+		content, err := io.ReadAll(tr)
+		requires.NoError(err)
+		entries[h.Name] = string(content)
+	}
+}
+
+func TestDirSyncer_makeArchive(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src := memfs.New()
+	modTime := time.Now().Add(-time.Hour)
+	requires.NoError(src.WriteFile("/src/a.txt", []byte("a"), modTime))
+	requires.NoError(src.WriteFile("/src/sub/deep/b.txt", []byte("b"), modTime))
+	requires.NoError(src.MkdirAll("/src/empty"))
+	outDir := t.TempDir()
+	stg := settings.Settings{
+		SrcDir:           "/src",
+		CopyDir:          filepath.Join(outDir, "out.tar.zst"),
+		IncludeEmptyDirs: true,
+		Once:             true,
+		WorkersCount:     1,
+	}
+	makeArchive := func() error {
+		syncer := New(getMockLogger(mockCtrl, gomock.Any()), stg)
+		syncer.fss.src = src
+		return runOnce(syncer)
+	}
+
+	// 2. act & assert: the first archive is the full one
+	requires.NoError(makeArchive())
+	entries := readTarZst(requires, stg.CopyDir)
+	requires.Equal("a", entries["a.txt"])
+	requires.Equal("b", entries["sub/deep/b.txt"])
+	requires.Contains(entries, "empty/")
+	requires.Contains(entries, "sub/")
+	requires.NotContains(entries, archive.DeletionsName)
+	requires.FileExists(stg.CopyDir + archive.ManifestSuffix)
+
+	// the next one has only the changes
+	requires.NoError(src.WriteFile("/src/a.txt", []byte("changed"), time.Now()))
+	requires.NoError(src.WriteFile("/src/c.txt", []byte("c"), modTime))
+	requires.NoError(src.Remove("/src/sub/deep/b.txt"))
+	requires.NoError(src.Remove("/src/sub/deep"))
+	requires.NoError(makeArchive())
+	incremental := filepath.Join(outDir, "out.1.tar.zst")
+	entries = readTarZst(requires, incremental)
+	delete(entries, archive.ManifestName)
+	requires.Equal(map[string]string{"a.txt": "changed", "c.txt": "c", archive.DeletionsName: "sub/deep\n"}, entries)
+
+	// nothing is archived, if nothing has changed
+	requires.NoError(makeArchive())
+	requires.NoFileExists(filepath.Join(outDir, "out.2.tar.zst"))
+
+	// the lost manifest is taken from the last archive
+	requires.NoError(os.Remove(stg.CopyDir + archive.ManifestSuffix))
+	requires.NoError(makeArchive())
+	requires.NoFileExists(filepath.Join(outDir, "out.2.tar.zst"))
+}
@@ -0,0 +1,91 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys/cryptfs"
+	"dsync/pkg/fsys/memfs"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+//TestDirSyncerEncryptAndDecrypt encrypts the source dir into the copy dir and then decrypts the copy dir back.
+func TestDirSyncerEncryptAndDecrypt(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS, plainFS := memfs.New(), memfs.New(), memfs.New()
+	modTime := time.Now().Add(-time.Hour).Round(0)
+	requires.NoError(src.WriteFile("/src/a.txt", []byte("secret a"), modTime))
+	requires.NoError(src.WriteFile("/src/sub/b.txt", []byte("secret b"), modTime))
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:       "/src",
+		CopyDir:      "/copy",
+		ScanPeriod:   time.Second,
+		Once:         true,
+		WorkersCount: 4,
+		Verify:       true,
+		Encrypt:      true,
+		EncryptNames: true,
+		Passphrase:   "passphrase",
+	}
+	run := func(stg settings.Settings, fss fileSystems) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		syncer := New(loggerMock, stg)
+		syncer.fss = fss
+		requires.NoError(syncer.Start(ctx, cancel))
+	}
+	targetOf := func(f *memfs.FS) func(stg settings.Settings) (copyTarget, error) {
+		return func(stg settings.Settings) (copyTarget, error) { return copyTarget{fs: f, root: stg.CopyDir}, nil }
+	}
+
+	// 2. act & assert: neither the content nor the names are seen in the copy dir
+	run(stg, fileSystems{src: src, openTarget: targetOf(copyFS)})
+	requires.NoError(copyFS.Walk("/copy", func(name string, de fs.DirEntry, err error) error {
+		requires.NoError(err)
+		requires.NotContains(name, ".txt")
+		if de.Type().IsRegular() && !strings.HasSuffix(name, cryptfs.ConfigName) {
+			content, err := copyFS.ReadFile(name)
+			requires.NoError(err)
+			requires.NotContains(string(content), "secret")
+		}
+		return nil
+	}))
+
+	// the encrypted copy dir is in sync with the source one
+	encrypted := cryptfs.New(copyFS, "/copy", cryptfs.Config{Passphrase: stg.Passphrase, Names: true})
+	dirEntriesMap := model.NewDirEntriesMap()
+	scanner := newDirScanner(loggerMock, stg, dirEntriesMap, src, copyTarget{fs: encrypted, root: "/copy"})
+	requires.NoError(scanner.scanOnce(context.Background()))
+	requires.NoError(dirEntriesMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+		entry := eMap[key]
+		requires.False(entry.IsSyncRequired(), key)
+		return nil
+	}))
+
+	// the copy dir is decrypted back with the same passphrase
+	decryptStg := stg
+	decryptStg.SrcDir, decryptStg.CopyDir = "/copy", "/plain"
+	decryptStg.Encrypt, decryptStg.EncryptNames, decryptStg.Restore = false, false, true
+	requires.NoError(plainFS.MkdirAll("/plain"))
+	run(decryptStg, fileSystems{src: copyFS, openTarget: targetOf(plainFS)})
+	content, err := plainFS.ReadFile("/plain/sub/b.txt")
+	requires.NoError(err)
+	requires.Equal("secret b", string(content))
+	info, err := plainFS.Stat("/plain/a.txt")
+	requires.NoError(err)
+	requires.Equal(modTime, info.ModTime())
+	_, err = plainFS.Stat("/plain/" + cryptfs.ConfigName)
+	requires.ErrorIs(err, fs.ErrNotExist)
+}
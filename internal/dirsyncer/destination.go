@@ -0,0 +1,321 @@
+package dirsyncer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"dsync/internal/hooks"
+	"dsync/internal/log"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/internal/twoway"
+	"dsync/internal/versions"
+	"dsync/internal/webhook"
+	"dsync/pkg/fsys"
+	"dsync/pkg/fsys/netfs"
+	"dsync/pkg/fsys/s3fs"
+	"dsync/pkg/fsys/sftpfs"
+	"dsync/pkg/helpers/run"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//DestinationStatus is the synchronization state of one copy dir.
+type DestinationStatus struct {
+	CopyDir   string        `json:"copyDir"`
+	Pending   int           `json:"pending"`             // the number of entries out of sync on the last scan
+	SyncedAt  time.Time     `json:"syncedAt"`            // the time of the source scan, the copy dir is in sync with
+	Lag       time.Duration `json:"lag"`                 // how long the copy dir is behind the source (0 if in sync)
+	LastError string        `json:"lastError,omitempty"` // the error of the last sync cycle (if any)
+}
+
+//copyTarget is the copy dir along with the file system, where it resides.
+type copyTarget struct {
+	fs   fsys.FS
+	root string // the copy dir path in the file system
+}
+
+func localCopyTarget(copyDir string) copyTarget {
+	return copyTarget{fs: fsys.Local{}, root: copyDir}
+}
+
+//fileSystems are the file systems of the synchronized dirs. The scanner and the executor access the dirs only
+//through them, so e.g. the tests can run the whole synchronization in memory.
+type fileSystems struct {
+	src        fsys.FS                                         // where the source dir resides
+	openTarget func(stg settings.Settings) (copyTarget, error) // opens the file system of the settings' copy dir
+}
+
+//defaultFileSystems has the source dir on the local disk, and the copy dir is either local or remote.
+var defaultFileSystems = fileSystems{src: fsys.Local{}, openTarget: openCopyTarget}
+
+//openCopyTarget returns the target of the copy dir, which is either a local dir or an SFTP, dsync or S3 URL.
+//The remote file system connects lazily, so unavailability of the server is reported by the first sync cycle.
+func openCopyTarget(stg settings.Settings) (copyTarget, error) {
+	if netfs.IsURL(stg.CopyDir) {
+		return openNetCopyTarget(stg)
+	}
+	if s3fs.IsURL(stg.CopyDir) {
+		return openS3CopyTarget(stg)
+	}
+	if !sftpfs.IsURL(stg.CopyDir) {
+		return localCopyTarget(stg.CopyDir), nil
+	}
+	loc, err := sftpfs.ParseURL(stg.CopyDir)
+	if err != nil {
+		return copyTarget{}, err
+	}
+	remote, err := sftpfs.New(loc, sftpfs.Config{KeyPath: stg.SSHKeyPath, KnownHostsPath: stg.KnownHostsPath})
+	if err != nil {
+		return copyTarget{}, err
+	}
+	return copyTarget{fs: remote, root: loc.Path}, nil
+}
+
+//openNetCopyTarget returns the target of the copy dir served by the dsync server.
+func openNetCopyTarget(stg settings.Settings) (copyTarget, error) {
+	loc, err := netfs.ParseURL(stg.CopyDir)
+	if err != nil {
+		return copyTarget{}, err
+	}
+	cfg := netfs.Config{Token: stg.Token}
+	if stg.TLS {
+		cfg.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if stg.TLSCAPath != "" {
+			pem, err := os.ReadFile(stg.TLSCAPath)
+			if err != nil {
+				return copyTarget{}, fmt.Errorf("cannot read CA certificates: %w", err)
+			}
+			cfg.TLSConfig.RootCAs = x509.NewCertPool()
+			if !cfg.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return copyTarget{}, fmt.Errorf("no CA certificates are found in %q", stg.TLSCAPath)
+			}
+		}
+	}
+	return copyTarget{fs: netfs.New(loc, cfg), root: loc.Path}, nil
+}
+
+//openS3CopyTarget returns the target of the copy dir in the S3-compatible storage. The credentials are taken
+//from the standard AWS env vars, and the listing makes at most as many concurrent requests as there are workers.
+func openS3CopyTarget(stg settings.Settings) (copyTarget, error) {
+	loc, err := s3fs.ParseURL(stg.CopyDir)
+	if err != nil {
+		return copyTarget{}, err
+	}
+	remote, err := s3fs.New(loc, s3fs.Config{
+		Endpoint: stg.S3Endpoint,
+		Region:   stg.S3Region,
+		Credentials: s3fs.Credentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+		Concurrency: stg.WorkersCount,
+	})
+	if err != nil {
+		return copyTarget{}, err
+	}
+	return copyTarget{fs: remote, root: loc.Root()}, nil
+}
+
+//destination is one copy dir, which the source dir is replicated to. Each destination has its own entries map,
+//scheduler and executor, so a slow or failing copy dir doesn't block other ones.
+type destination struct {
+	log       log.Logger
+	settings  settings.Settings // its CopyDir is this destination's copy dir
+	eMap      *model.DirEntriesMap
+	scanner   *dirScanner
+	scheduler *taskScheduler
+	executor  *taskExecutor
+	tasks     chan Task
+	target    copyTarget
+	base      *twoway.BaseState // is not nil only in the two-way mode
+	hooks     *hooks.Runner     // is nil, if there are no hooks
+	webhook   *webhook.Notifier // is nil, if the failures are not notified
+	busy      int32             // is 1, while the sync cycle is in progress (it's accessed atomically)
+
+	mu        sync.Mutex
+	startedAt time.Time
+	status    DestinationStatus
+	errCount  int  // the number of consecutive failed sync cycles (roughly, as successes only decrement it)
+	converged bool // is true, since the copy dir has got in sync with the source dir, until it's out of sync again
+}
+
+func newDestination(
+	logger log.Logger, stg settings.Settings, limiter *run.FairLimiter, fss fileSystems,
+) (*destination, error) {
+	target, err := fss.openTarget(stg)
+	if err != nil {
+		return nil, err
+	}
+	target.fs = targetFS(target, stg)
+	var base *twoway.BaseState
+	if stg.TwoWay {
+		if base, err = twoway.LoadBase(stg.CopyDir); err != nil {
+			return nil, err
+		}
+	}
+	eMap := model.NewDirEntriesMap()
+	tasks := make(chan Task, tasksQueueCapacity) // we don't want scheduler to block until its tasks queue is full
+	executor := newTaskExecutor(log.Component(logger, "executor"), stg, eMap, tasks, limiter, base, fss.src, target)
+	return &destination{
+		log:       logger,
+		settings:  stg,
+		eMap:      eMap,
+		scanner:   newDirScanner(log.Component(logger, "scanner"), stg, eMap, fss.src, target),
+		scheduler: newTaskScheduler(log.Component(logger, "scheduler"), stg, eMap, tasks, base, executor.metrics),
+		executor:  executor,
+		tasks:     tasks,
+		target:    target,
+		base:      base,
+		startedAt: time.Now(),
+		status:    DestinationStatus{CopyDir: stg.CopyDir},
+	}, nil
+}
+
+//start prepares the copy dir and starts the executor's workers.
+func (dst *destination) start(ctx context.Context) {
+	if dst.settings.KeepVersions {
+		// versions are pruned on each new version of the same file, but the retention rules may have been changed
+		dirs := []string{dst.settings.CopyDir}
+		if dst.settings.TwoWay {
+			dirs = append(dirs, dst.settings.SrcDir)
+		}
+		for _, dir := range dirs {
+			if err := versions.NewStore(dir, dst.settings.Retention).PruneAll(); err != nil {
+				dst.log.Warn("cannot prune old versions of files", log.String("dir", dir), log.Cause(err))
+			}
+		}
+	}
+	dst.executor.Start(ctx) // starts workers in goroutines
+}
+
+//stop closes the tasks queue and awaits the executor's workers. There must be no sync cycle in progress.
+func (dst *destination) stop() {
+	close(dst.tasks)
+	dst.executor.Stop()
+	if closer, ok := dst.target.fs.(io.Closer); ok {
+		// e.g. the SFTP connection is closed, or the compression index is saved
+		if err := closer.Close(); err != nil {
+			dst.log.Error("cannot close the copy dir", log.Cause(err))
+		}
+	}
+	if dst.base != nil {
+		if err := dst.base.Save(); err != nil {
+			dst.log.Error("cannot save the base state", log.Cause(err))
+		}
+	}
+}
+
+//syncWith scans the copy dir, compares it with the source listing and schedules the sync tasks.
+func (dst *destination) syncWith(ctx context.Context, listing *sourceListing) error {
+	err := run.WithError(func() error {
+		if err := dst.scanner.scanCopyOnce(ctx, listing); err != nil {
+			return err
+		}
+		return dst.scheduler.scheduleOnce(ctx)
+	})
+	dst.updateStatus(listing, err)
+	return err
+}
+
+//trySyncWithAsync starts syncWith in a new goroutine, unless the previous sync cycle is still in progress
+//(in such case this source listing is skipped by this destination, and its lag grows).
+func (dst *destination) trySyncWithAsync(ctx context.Context, listing *sourceListing, wg *sync.WaitGroup) bool {
+	if !atomic.CompareAndSwapInt32(&dst.busy, 0, 1) {
+		return false
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer atomic.StoreInt32(&dst.busy, 0)
+		_ = dst.syncWith(ctx, listing) // the error is saved in the status
+	}()
+	return true
+}
+
+func (dst *destination) updateStatus(listing *sourceListing, err error) {
+	pending := 0
+	if err == nil {
+		_ = dst.eMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+			if entry := eMap[key]; isSyncRequired(dst.settings, &entry) {
+				pending++
+			}
+			return nil
+		})
+	}
+
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	if err != nil {
+		dst.status.LastError = err.Error()
+		dst.errCount++
+		if !errors.Is(err, context.Canceled) {
+			dst.webhook.Notify(webhook.Event{Kind: webhook.ScanErrors, Job: dst.settings.JobName,
+				CopyDir: dst.settings.CopyDir, Dir: dst.settings.CopyDir, Errors: dst.errCount,
+				MaxErrors: maxConsecutiveErrors, Error: err.Error()})
+		}
+		return
+	}
+	dst.status.LastError, dst.status.Pending = "", pending
+	if pending == 0 {
+		dst.status.SyncedAt = listing.scannedAt
+		if !dst.converged {
+			dst.fireConverged()
+		}
+	}
+	dst.converged = pending == 0
+	if dst.errCount > 0 {
+		dst.errCount--
+	}
+}
+
+//awaitConvergence awaits the scheduled tasks of the one-shot sync and fires the CycleConverged hook,
+//if all of them have succeeded.
+func (dst *destination) awaitConvergence(ctx context.Context) error {
+	if err := dst.scheduler.awaitTasks(ctx); err != nil {
+		return err
+	}
+	if dst.executor.metrics.counts().Failed > 0 {
+		return nil
+	}
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	if !dst.converged && dst.status.LastError == "" {
+		dst.converged = true
+		dst.fireConverged()
+	}
+	return nil
+}
+
+//fireConverged runs the CycleConverged hook (if there is such hook) in background.
+func (dst *destination) fireConverged() {
+	dst.hooks.Fire(hooks.Payload{Event: hooks.CycleConverged, Job: dst.settings.JobName,
+		CopyDir: dst.settings.CopyDir})
+}
+
+//isFailing reports whether the destination has failed too many sync cycles in a row, and returns the last error.
+func (dst *destination) isFailing() (bool, string) {
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	return dst.errCount >= maxConsecutiveErrors, dst.status.LastError
+}
+
+func (dst *destination) getStatus() DestinationStatus {
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	status := dst.status
+	if status.Pending > 0 || status.LastError != "" || status.SyncedAt.IsZero() {
+		since := status.SyncedAt
+		if since.IsZero() {
+			since = dst.startedAt
+		}
+		status.Lag = time.Since(since)
+	}
+	return status
+}
@@ -0,0 +1,121 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/log"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerWithSeveralCopyDirsByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	_ = os.Chdir("testdata")
+	wd, _ := os.Getwd()
+	srcDir := filepath.Join(wd, "src")
+	copyDirs := make([]string, 2)
+	for i := range copyDirs {
+		copyDir, err := os.MkdirTemp(wd, "copy")
+		requires.NoError(err)
+		defer os.RemoveAll(copyDir)
+		copyDirs[i] = copyDir
+	}
+	prepareCopyDir(requires, copyDirs[1], srcDir)
+	missingCopyDir := filepath.Join(wd, "noSuchCopyDir") // this copy dir fails, but it doesn't block other ones
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:           srcDir,
+		CopyDir:          copyDirs[0],
+		ExtraCopyDirs:    []string{missingCopyDir, copyDirs[1]},
+		ScanPeriod:       time.Second,
+		IncludeEmptyDirs: true,
+		LogLevel:         log.DebugLevel,
+		Once:             true,
+		WorkersCount:     2,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 2. act
+	err := New(loggerMock, stg).Start(ctx, cancel)
+
+	// 3. assert
+	requires.ErrorContains(err, missingCopyDir)
+	requires.NoDirExists(missingCopyDir)
+	for _, copyDir := range copyDirs {
+		copyStg := stg
+		copyStg.CopyDir, copyStg.ExtraCopyDirs = copyDir, nil
+		dirEntriesMap := model.NewDirEntriesMap()
+		scanner := newDirScanner(loggerMock, copyStg, dirEntriesMap, fsys.Local{}, localCopyTarget(copyStg.CopyDir))
+		requires.NoError(scanner.scanOnce(context.Background()))
+		err = dirEntriesMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+			entry := eMap[key]
+			requires.False(entry.IsSyncRequired(), key)
+			return nil
+		})
+		requires.NoError(err)
+	}
+}
+
+func TestDestination_getStatus(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	_ = os.Chdir("testdata")
+	wd, _ := os.Getwd()
+	copyDir, err := os.MkdirTemp(wd, "copy")
+	requires.NoError(err)
+	defer os.RemoveAll(copyDir)
+
+	stg := settings.Settings{SrcDir: filepath.Join(wd, "src"), CopyDir: copyDir, ScanPeriod: time.Second,
+		IncludeEmptyDirs: true, Once: true, WorkersCount: 1}
+	dst, err := newDestination(getMockLogger(mockCtrl, gomock.Any()), stg, nil, defaultFileSystems)
+	requires.NoError(err)
+	dst.startedAt = time.Now().Add(-time.Hour)
+	srcScanner := newDirScanner(dst.log, stg, nil, fsys.Local{}, localCopyTarget(stg.CopyDir))
+	ctx := context.Background()
+
+	// nothing is copied yet (the executor isn't started), so the copy dir is behind the source since the start
+	listing, err := srcScanner.scanSource(ctx)
+	requires.NoError(err)
+	requires.NoError(dst.syncWith(ctx, listing))
+	status := dst.getStatus()
+	requires.Equal(copyDir, status.CopyDir)
+	requires.Positive(status.Pending)
+	requires.True(status.SyncedAt.IsZero())
+	requires.GreaterOrEqual(status.Lag, time.Hour)
+
+	// the copy dir is in sync now
+	dst.start(ctx)
+	requires.NoError(dst.scheduler.awaitTasks(ctx))
+	dst.stop()
+	dst.eMap.ClearOverOperations()
+	listing, err = srcScanner.scanSource(ctx)
+	requires.NoError(err)
+	requires.NoError(dst.syncWith(ctx, listing))
+	status = dst.getStatus()
+	requires.Zero(status.Pending)
+	requires.Equal(listing.scannedAt, status.SyncedAt)
+	requires.Zero(status.Lag)
+
+	// the copy dir fails, so it's behind the source since the last sync
+	requires.NoError(os.RemoveAll(copyDir))
+	requires.Error(dst.syncWith(ctx, listing))
+	status = dst.getStatus()
+	requires.NotEmpty(status.LastError)
+	requires.Positive(status.Lag)
+}
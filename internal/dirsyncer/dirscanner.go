@@ -5,23 +5,50 @@ import (
 	"dsync/internal/log"
 	"dsync/internal/model"
 	"dsync/internal/settings"
+	"dsync/internal/twoway"
+	"dsync/internal/versions"
+	"dsync/pkg/fsys"
+	"dsync/pkg/helpers/iout"
 	"dsync/pkg/helpers/run"
+	"dsync/pkg/metrics"
+	"dsync/pkg/tracing"
 	"fmt"
 	"io/fs"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 //dirScanner service is responsible for scanning source and copy directories for files (recursively) and
 //saving their entry's info into the DirEntriesMap.
 type dirScanner struct {
-	log        log.Logger
-	settings   settings.Settings
-	entriesMap *model.DirEntriesMap
+	log             log.Logger
+	settings        settings.Settings
+	entriesMap      *model.DirEntriesMap
+	src             fsys.FS // the file system of the source dir
+	target          copyTarget
+	tracer          *tracing.Tracer // is nil, if the spans are not recorded
+	skippedSpecials int             // the number of special files in the source dir, that were skipped on the last scan
+
+	durations *metrics.Histogram // of the successful walks
+
+	mu       sync.Mutex
+	lastScan *ScanStats // the stats of the last successful walk (of the source dir or of the copy dir)
+}
+
+func newDirScanner(
+	logger log.Logger, stg settings.Settings, eMap *model.DirEntriesMap, src fsys.FS, target copyTarget,
+) *dirScanner {
+	return &dirScanner{log: logger, settings: stg, entriesMap: eMap, src: src, target: target,
+		durations: metrics.NewHistogram(metrics.DurationBuckets)}
 }
 
-func newDirScanner(logger log.Logger, stg settings.Settings, eMap *model.DirEntriesMap) *dirScanner {
-	return &dirScanner{log: logger, settings: stg, entriesMap: eMap}
+//sourceListing is the result of the source dir scan. The source dir is scanned once per sync cycle,
+//and its listing is shared between all the copy dirs.
+type sourceListing struct {
+	entries   map[string]model.PathInfo
+	scannedAt time.Time // when the scan was started
 }
 
 func (d *dirScanner) scanOnce(parentCtx context.Context) error {
@@ -30,20 +57,12 @@ func (d *dirScanner) scanOnce(parentCtx context.Context) error {
 	ctx, cancel := context.WithCancel(parentCtx)
 	defer cancel()
 
-	errCh1 := run.AsyncWithError(func() error {
-		// here we recursively walk through the source dir file tree and save these files' info into the map
-		if err := d.walk(ctx, d.settings.SrcDir, (*model.EntryInfo).SetSrcPathInfo); err != nil {
-			return fmt.Errorf("cannot walk through the source dir file tree: %w", err)
-		}
-		return nil
-	})
-	errCh2 := run.AsyncWithError(func() error {
-		// here we recursively walk through the copy dir file tree and save these files' info into the map
-		if err := d.walk(ctx, d.settings.CopyDir, (*model.EntryInfo).SetCopyPathInfo); err != nil {
-			return fmt.Errorf("cannot walk through the copy dir file tree: %w", err)
-		}
-		return nil
+	var listing *sourceListing
+	errCh1 := run.AsyncWithError(func() (err error) {
+		listing, err = d.scanSource(ctx)
+		return err
 	})
+	errCh2 := run.AsyncWithError(func() error { return d.walkCopy(ctx) })
 
 	for i := 0; i < 2; i++ {
 		select {
@@ -62,12 +81,113 @@ func (d *dirScanner) scanOnce(parentCtx context.Context) error {
 		}
 	}
 
-	d.entriesMap.RemoveObsolete()
+	d.mergeSource(listing)
 	return ctx.Err()
 }
 
-func (d *dirScanner) walk(ctx context.Context, root string, pathInfoSetter func(*model.EntryInfo, model.PathInfo)) error {
-	return filepath.WalkDir(root, func(fullPath string, de fs.DirEntry, err error) error {
+//scanCopyOnce does the same as scanOnce, but the source dir is not scanned - its listing is used instead.
+func (d *dirScanner) scanCopyOnce(ctx context.Context, listing *sourceListing) error {
+	d.entriesMap.PrepareForScan()
+	if err := d.walkCopy(ctx); err != nil {
+		return err
+	}
+	d.mergeSource(listing)
+	return ctx.Err()
+}
+
+//scanSource recursively walks through the source dir file tree and returns its listing.
+func (d *dirScanner) scanSource(ctx context.Context) (*sourceListing, error) {
+	listing := &sourceListing{entries: make(map[string]model.PathInfo), scannedAt: time.Now()}
+	stats := &ScanStats{StartedAt: listing.scannedAt}
+	skippedSpecials := 0
+	ctx, span := d.tracer.Start(ctx, "walk source", tracing.String("dir", d.settings.SrcDir))
+	err := d.walk(ctx, d.src, d.settings.SrcDir, func(path string, pi model.PathInfo) {
+		listing.entries[path] = pi
+		stats.add(pi)
+	}, &skippedSpecials)
+	endWalkSpan(span, stats, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot walk through the source dir file tree: %w", err)
+	}
+	d.reportSkippedSpecials(skippedSpecials)
+	d.setLastScan(stats)
+	return listing, nil
+}
+
+//walkCopy recursively walks through the copy dir file tree and saves its entries' info into the map.
+func (d *dirScanner) walkCopy(ctx context.Context) error {
+	stats := &ScanStats{StartedAt: time.Now()}
+	ctx, span := d.tracer.Start(ctx, "walk copy", tracing.String("dir", d.settings.CopyDir))
+	err := d.walk(ctx, d.target.fs, d.target.root, func(path string, pi model.PathInfo) {
+		d.entriesMap.UpdateValueByKey(path, func(entry *model.EntryInfo) { entry.SetCopyPathInfo(pi) })
+		stats.add(pi)
+	}, nil)
+	endWalkSpan(span, stats, err)
+	if err != nil {
+		return fmt.Errorf("cannot walk through the copy dir file tree: %w", err)
+	}
+	d.setLastScan(stats)
+	return nil
+}
+
+//endWalkSpan ends the span of the file tree walk with the numbers of the walked entries.
+func endWalkSpan(span *tracing.Span, stats *ScanStats, err error) {
+	span.SetAttrs(tracing.Int("files", stats.Files), tracing.Int("dirs", stats.Dirs),
+		tracing.Int64("bytes", stats.Bytes))
+	span.SetError(err)
+	span.End()
+}
+
+func (d *dirScanner) setLastScan(stats *ScanStats) {
+	stats.Duration = time.Since(stats.StartedAt)
+	d.durations.Observe(stats.Duration.Seconds())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastScan = stats
+}
+
+//lastScanStats returns the stats of the last successful walk (nil, if there was none yet).
+func (d *dirScanner) lastScanStats() *ScanStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastScan
+}
+
+//mergeSource saves the source entries' info into the map and removes the entries, that don't exist anymore.
+//The source modification times are truncated to the precision of the copy dir file system.
+func (d *dirScanner) mergeSource(listing *sourceListing) {
+	precision := fsys.ModTimePrecision(d.target.fs)
+	for path, pi := range listing.entries {
+		pi := pi
+		pi.ModTime = truncateModTime(pi.ModTime, precision)
+		d.entriesMap.UpdateValueByKey(path, func(entry *model.EntryInfo) { entry.SetSrcPathInfo(pi) })
+	}
+	d.entriesMap.RemoveObsolete()
+}
+
+//reportSkippedSpecials logs the number of skipped special files, but only if it has changed since the last scan.
+func (d *dirScanner) reportSkippedSpecials(count int) {
+	if count == d.skippedSpecials {
+		return
+	}
+	d.skippedSpecials = count
+	if count == 0 {
+		d.log.Info("no special files are skipped in the source dir anymore")
+		return
+	}
+	reason := "use -specials flag to sync them"
+	if d.settings.SyncSpecials {
+		reason = "device nodes can be created only by a privileged process"
+	}
+	d.log.Warn("special files in the source dir are skipped", log.Int("count", count), log.String("reason", reason))
+}
+
+//walk recursively walks through the root dir file tree (in the file system) and passes its entries' info to
+//the visit func. If skippedSpecials is not nil, then it's incremented for each special file, that is not synchronized.
+func (d *dirScanner) walk(
+	ctx context.Context, fsys fsys.FS, root string, visit func(path string, pi model.PathInfo), skippedSpecials *int,
+) error {
+	return fsys.Walk(root, func(fullPath string, de fs.DirEntry, err error) error {
 		if ctxErr := ctx.Err(); ctxErr != nil {
 			return ctxErr
 		}
@@ -75,20 +195,35 @@ func (d *dirScanner) walk(ctx context.Context, root string, pathInfoSetter func(
 			return fmt.Errorf("cannot visit the entry %q: %v", fullPath, err)
 		}
 
-		path, err := filepath.Rel(root, fullPath)
+		path, err := fsys.Rel(root, fullPath)
 		if err != nil {
 			return fmt.Errorf("cannot get a relative path: %v", err)
 		}
 		if path == "." || (!d.settings.IncludeHidden && strings.HasPrefix(de.Name(), ".")) {
 			return nil
 		}
+		if d.settings.KeepVersions && path == versions.DirName && de.IsDir() {
+			return fs.SkipDir // the versions tree is not synchronized
+		}
+		if d.settings.TwoWay && twoway.IsBaseFile(path) {
+			return nil // the base state is not synchronized
+		}
+		if isExcluded(d.settings.Exclude, path) {
+			if de.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
 
 		info, err := de.Info()
 		if err != nil {
 			return fmt.Errorf("cannot fetch entry's %q info: %v", fullPath, err)
 		}
-		if !(info.IsDir() || info.Mode().IsRegular()) {
-			return nil // don't sync non-regular entries like symlinks, devices, sockets, etc.
+		if !isSupported(d.settings, info.Mode()) {
+			if skippedSpecials != nil && model.IsSpecialMode(info.Mode()) {
+				*skippedSpecials++
+			}
+			return nil // don't sync non-regular entries like symlinks, etc.
 		}
 
 		pi := model.PathInfo{
@@ -97,8 +232,10 @@ func (d *dirScanner) walk(ctx context.Context, root string, pathInfoSetter func(
 			IsDir:    info.IsDir(),
 			Size:     info.Size(),
 			ModTime:  info.ModTime(),
+			Mode:     info.Mode(),
+			Rdev:     iout.DeviceNumber(info),
 		}
-		d.entriesMap.UpdateValueByKey(path, func(entry *model.EntryInfo) { pathInfoSetter(entry, pi) })
+		visit(path, pi)
 
 		//d.log.Debug("entry scanned",
 		//	log.String("path", path),
@@ -109,3 +246,34 @@ func (d *dirScanner) walk(ctx context.Context, root string, pathInfoSetter func(
 		return nil
 	})
 }
+
+//truncateModTime truncates the modification time to the precision (zero precision means no truncation).
+func truncateModTime(t time.Time, precision time.Duration) time.Time {
+	if precision <= 0 {
+		return t
+	}
+	return t.Truncate(precision)
+}
+
+//isSupported reports whether the entry of such mode is synchronized with the current settings.
+func isSupported(stg settings.Settings, mode fs.FileMode) bool {
+	if model.IsSpecialMode(mode) {
+		return stg.SyncSpecials && (mode&fs.ModeDevice == 0 || iout.CanMakeDevices())
+	}
+	return mode.IsDir() || mode.IsRegular()
+}
+
+//isExcluded reports whether the entry's relative path (or its name) matches any of the exclusion patterns.
+func isExcluded(patterns []string, path string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range patterns {
+		// patterns are validated on the settings parsing, so errors are not possible here
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
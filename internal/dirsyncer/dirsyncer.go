@@ -2,33 +2,72 @@ package dirsyncer
 
 import (
 	"context"
+	"dsync/internal/archive"
+	"dsync/internal/hooks"
+	"dsync/internal/journal"
 	"dsync/internal/log"
-	"dsync/internal/model"
 	"dsync/internal/settings"
+	"dsync/internal/webhook"
+	"dsync/pkg/helpers/run"
+	"dsync/pkg/tracing"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.uber.org/multierr"
 )
 
 const (
 	maxConsecutiveErrors = 3
 	tasksQueueCapacity   = 100
+	statusLogPeriod      = time.Minute
 )
 
 //DirSyncer is the main service of the app, that is responsible for the synchronization between the source and copy dirs.
 type DirSyncer struct {
 	log      log.Logger
 	settings settings.Settings
+	limiter  *run.FairLimiter // is not nil, if the workers budget is shared with other sync jobs
+	fss      fileSystems
+	journal  *journal.Journal  // is opened by Start, unless it's shared with other sync jobs
+	hooks    *hooks.Runner     // is started by Start, unless it's shared with other sync jobs
+	webhook  *webhook.Notifier // is started by Start, unless it's shared with other sync jobs
+	tracer   *tracing.Tracer   // is started by Start, unless it's shared with other sync jobs
+	// the number of consecutive failed scans of the source dir (roughly, as successes only decrement it);
+	// it's accessed atomically
+	scanErrors int32
+
+	mu         sync.Mutex // protects the running state below, which is read by the status API
+	srcScanner *dirScanner
+	dests      []*destination
+	startedAt  time.Time
+	finishedAt time.Time
 }
 
 func New(logger log.Logger, stg settings.Settings) *DirSyncer {
-	return &DirSyncer{log: logger, settings: stg}
+	return &DirSyncer{log: logger, settings: stg, fss: defaultFileSystems}
 }
 
 //Start returns only most critical errors (unless App runs with the -once flag) that make further work impossible,
 //otherwise returns nil. However, if any inner error repeatedly happens during the execution, then such last error is
 //returned after 3 consecutive occasions.
 func (d *DirSyncer) Start(ctx context.Context, stop context.CancelFunc) (err error) {
+	d.setStarted()
+	defer d.setFinished()
+	if d.webhook == nil {
+		if d.webhook = webhook.New(d.log, d.settings.Webhook); d.webhook != nil {
+			defer d.closeWebhook()
+		}
+	}
+	defer func() { // it's run after the panic is recovered, so the notification has the resulting error
+		event := webhook.Event{Kind: webhook.Stopped, Job: d.settings.JobName}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		d.webhook.Notify(event)
+	}()
 	defer func() {
 		if p := recover(); p != nil {
 			stop()
@@ -40,57 +79,293 @@ func (d *DirSyncer) Start(ctx context.Context, stop context.CancelFunc) (err err
 		}
 	}()
 
-	eMap := model.NewDirEntriesMap()
-	dirScanner := newDirScanner(d.log, d.settings, eMap)
+	if d.settings.Restore {
+		if d.fss.src, err = restoredFS(d.fss.src, d.settings); err != nil {
+			return err
+		}
+	}
 
-	tasks := make(chan Task, tasksQueueCapacity) // we don't want scheduler to block until its tasks queue is full
+	if d.settings.Snapshots {
+		err := d.takeSnapshot(ctx)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
 
-	executor := newTaskExecutor(d.log, d.settings, eMap, tasks)
-	executor.Start(ctx) // starts workers in goroutines
-	defer executor.Stop()
+	if archive.IsPath(d.settings.CopyDir) {
+		err := d.makeArchive(ctx)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
 
-	scheduler := newTaskScheduler(d.log, d.settings, eMap, tasks)
-	defer close(tasks)
+	if d.journal == nil && d.settings.JournalPath != "" {
+		if d.journal, err = journal.Open(d.settings.JournalPath); err != nil {
+			return err
+		}
+		defer d.closeJournal()
+	}
+	if d.hooks == nil {
+		// the pending hooks are awaited after the destinations are stopped
+		if d.hooks = hooks.NewRunner(d.log, d.settings.Hooks); d.hooks != nil {
+			defer d.closeHooks()
+		}
+	}
+	if d.tracer == nil && d.settings.Trace != "" {
+		// the spans are exported after the destinations are stopped, so the last operations are exported too
+		if d.tracer, err = newTracer(d.log, d.settings.Trace); err != nil {
+			return err
+		}
+		defer d.closeTracer()
+	}
+	dests, err := d.newDestinations()
+	if err != nil {
+		return err
+	}
+	if d.settings.Once && d.settings.JobName == "" { // the progress of the jobs is reported by their group
+		// it's stopped after the destinations, so that the final state includes all the processed tasks
+		defer startProgress(d.log, []*DirSyncer{d}, d.settings.LogToStd)()
+	}
+	for _, dst := range dests {
+		dst.start(ctx)
+		defer dst.stop()
+	}
+	srcScanner := newDirScanner(log.Component(d.log, "scanner"), d.settings, nil, d.fss.src,
+		localCopyTarget(d.settings.CopyDir))
+	srcScanner.tracer = d.tracer
+	d.setRunning(srcScanner, dests)
 
 	if d.settings.Once {
-		err := scanDirsAndScheduleTasks(ctx, dirScanner, scheduler)
+		err := d.syncOnce(ctx, srcScanner, dests)
+		if err == nil && d.hooks.Handles(hooks.CycleConverged) {
+			for _, dst := range dests {
+				if err = dst.awaitConvergence(ctx); err != nil {
+					break
+				}
+			}
+		}
 		if errors.Is(err, context.Canceled) {
 			return nil
 		}
 		return err // no need to count inner errors in case of only one execution cycle (-once flag)
 	}
 
-	errCount := 0
+	var cycles sync.WaitGroup // sync cycles of the destinations are run concurrently
+	defer cycles.Wait()       // they must be over before the tasks queues are closed
 	ticker := time.NewTicker(d.settings.ScanPeriod)
 	defer ticker.Stop()
+	lastStatusLog := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
 			stop() // stop receiving signal notifications as soon as possible
 			return nil
 		case <-ticker.C:
-			if err := scanDirsAndScheduleTasks(ctx, dirScanner, scheduler); err != nil {
+			if err := failingDestinationsError(dests); err != nil {
+				return err
+			}
+			cycleCtx, cycle := d.tracer.Start(ctx, "sync cycle", tracing.String("src_dir", d.settings.SrcDir))
+			listing, err := srcScanner.scanSource(cycleCtx)
+			if err != nil {
+				cycle.SetError(err)
+				cycle.End()
 				if errors.Is(err, context.Canceled) {
 					return nil
 				}
-				if errCount++; errCount >= maxConsecutiveErrors {
+				errCount := atomic.AddInt32(&d.scanErrors, 1)
+				d.webhook.Notify(webhook.Event{Kind: webhook.ScanErrors, Job: d.settings.JobName,
+					Dir: d.settings.SrcDir, Errors: int(errCount), MaxErrors: maxConsecutiveErrors, Error: err.Error()})
+				if errCount >= maxConsecutiveErrors {
 					return err
 				}
-			} else {
-				if errCount > 0 {
-					errCount--
+				continue
+			}
+			if atomic.LoadInt32(&d.scanErrors) > 0 {
+				atomic.AddInt32(&d.scanErrors, -1)
+			}
+			var synced sync.WaitGroup // the cycle's span ends, when the copy dirs are scanned and their tasks scheduled
+			for _, dst := range dests {
+				if !dst.trySyncWithAsync(cycleCtx, listing, &synced) {
+					d.log.Debug("copy dir is still busy with the previous sync cycle",
+						log.String("copyDir", dst.settings.CopyDir))
 				}
 			}
+			cycles.Add(1)
+			go func() {
+				defer cycles.Done()
+				synced.Wait()
+				cycle.End()
+			}()
+			if len(dests) > 1 && time.Since(lastStatusLog) >= statusLogPeriod {
+				d.logStatus(dests)
+				lastStatusLog = time.Now()
+			}
+		}
+	}
+}
+
+//newDestinations makes the destination for each copy dir. The workers budget is shared between them.
+func (d *DirSyncer) newDestinations() ([]*destination, error) {
+	copyDirs := d.settings.AllCopyDirs()
+	limiter := d.limiter
+	if limiter == nil && len(copyDirs) > 1 {
+		limiter = run.NewFairLimiter(d.settings.WorkersCount)
+	}
+	dests := make([]*destination, 0, len(copyDirs))
+	for _, copyDir := range copyDirs {
+		stg := d.settings
+		stg.CopyDir, stg.ExtraCopyDirs = copyDir, nil
+		logger := d.log
+		if len(copyDirs) > 1 {
+			logger = log.With(logger, log.String("copyDir", copyDir))
+		}
+		dst, err := newDestination(logger, stg, limiter, d.fss)
+		if err != nil {
+			return nil, err
 		}
+		dst.executor.journal = d.journal
+		dst.executor.hooks, dst.hooks = d.hooks, d.hooks
+		dst.executor.webhook, dst.webhook = d.webhook, d.webhook
+		dst.scanner.tracer, dst.scheduler.tracer, dst.executor.tracer = d.tracer, d.tracer, d.tracer
+		dests = append(dests, dst)
 	}
+	return dests, nil
 }
 
-func scanDirsAndScheduleTasks(ctx context.Context, dirScanner *dirScanner, scheduler *taskScheduler) error {
-	if err := dirScanner.scanOnce(ctx); err != nil {
+//syncOnce runs one sync cycle for all the destinations. An error of one destination doesn't stop other ones,
+//all such errors are combined into the returned one.
+func (d *DirSyncer) syncOnce(ctx context.Context, srcScanner *dirScanner, dests []*destination) (err error) {
+	ctx, cycle := d.tracer.Start(ctx, "sync cycle", tracing.String("src_dir", d.settings.SrcDir))
+	defer func() {
+		cycle.SetError(err)
+		cycle.End()
+	}()
+	listing, err := srcScanner.scanSource(ctx)
+	if err != nil {
 		return err
 	}
-	if err := scheduler.scheduleOnce(ctx); err != nil {
+	synced, err := syncDestinations(ctx, dests, listing)
+	if len(synced) == 0 || !d.settings.SyncDirMeta {
 		return err
 	}
-	return nil
+
+	// dirs metadata can be synced only when all the operations inside dirs are over,
+	// so here we await them and then make the second pass
+	for _, dst := range synced {
+		if err := dst.scheduler.awaitTasks(ctx); err != nil {
+			return err
+		}
+		dst.eMap.ClearOverOperations()
+	}
+	listing, scanErr := srcScanner.scanSource(ctx)
+	if scanErr != nil {
+		return multierr.Append(err, scanErr)
+	}
+	_, syncErr := syncDestinations(ctx, synced, listing)
+	return multierr.Append(err, syncErr)
+}
+
+//syncDestinations concurrently syncs the destinations with the source listing. It returns the destinations,
+//that have been synced successfully, and the combined error of other ones.
+func syncDestinations(ctx context.Context, dests []*destination, listing *sourceListing) ([]*destination, error) {
+	errs := make([]error, len(dests))
+	var wg sync.WaitGroup
+	for i, dst := range dests {
+		i, dst := i, dst
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = dst.syncWith(ctx, listing)
+		}()
+	}
+	wg.Wait()
+
+	var (
+		synced []*destination
+		err    error
+	)
+	for i, dst := range dests {
+		if errs[i] == nil {
+			synced = append(synced, dst)
+		} else if len(dests) > 1 {
+			err = multierr.Append(err, fmt.Errorf("copy dir %q: %w", dst.settings.CopyDir, errs[i]))
+		} else {
+			err = errs[i]
+		}
+	}
+	return synced, err
+}
+
+//failingDestinationsError returns the error, if all the destinations have failed too many sync cycles in a row.
+func failingDestinationsError(dests []*destination) error {
+	var lastErr string
+	for _, dst := range dests {
+		failing, errText := dst.isFailing()
+		if !failing {
+			return nil
+		}
+		lastErr = errText
+	}
+	return errors.New(lastErr)
+}
+
+//closeJournal closes the journal, which has been opened by Start.
+func (d *DirSyncer) closeJournal() {
+	if err := d.journal.Close(); err != nil {
+		d.log.Error("cannot close the journal", log.Cause(err))
+	}
+	d.journal = nil
+}
+
+//closeWebhook posts the pending notifications of the webhook, which has been started by Start.
+func (d *DirSyncer) closeWebhook() {
+	closeNotifier(d.webhook)
+	d.webhook = nil
+}
+
+//closeNotifier posts the pending notifications, but no longer than webhook.CloseTimeout.
+func closeNotifier(n *webhook.Notifier) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhook.CloseTimeout)
+	defer cancel()
+	n.Close(ctx)
+}
+
+//newTracer starts the tracer, which exports the spans to the OTLP collector or to the JSON file (see settings.Trace).
+func newTracer(logger log.Logger, target string) (*tracing.Tracer, error) {
+	exporter, err := tracing.NewExporter(target, "dsync")
+	if err != nil {
+		return nil, err
+	}
+	return tracing.NewTracer(exporter, func(err error) {
+		logger.Warn("cannot export tracing spans", log.String("trace", target), log.Cause(err))
+	}), nil
+}
+
+//closeTracer exports the pending spans of the tracer, which has been started by Start.
+func (d *DirSyncer) closeTracer() {
+	if err := d.tracer.Close(); err != nil {
+		d.log.Error("cannot close the tracing exporter", log.Cause(err))
+	}
+	d.tracer = nil
+}
+
+//closeHooks awaits the pending hooks, which have been started by Start.
+func (d *DirSyncer) closeHooks() {
+	d.hooks.Close()
+	d.hooks = nil
+}
+
+//logStatus logs the synchronization state (including the lag) of each destination.
+func (d *DirSyncer) logStatus(dests []*destination) {
+	for _, dst := range dests {
+		status := dst.getStatus()
+		fields := []log.Field{log.String("copyDir", status.CopyDir), log.Int("pending", status.Pending),
+			log.Duration("lag", status.Lag.Truncate(time.Second))}
+		if status.LastError != "" {
+			fields = append(fields, log.String("lastError", status.LastError))
+		}
+		d.log.Info("copy dir status", fields...)
+	}
 }
@@ -6,6 +6,9 @@ import (
 	"dsync/internal/log"
 	"dsync/internal/model"
 	"dsync/internal/settings"
+	"dsync/internal/versions"
+	"dsync/pkg/fsys"
+	"dsync/pkg/fsys/memfs"
 	"dsync/pkg/helpers/iout"
 	"os"
 	"path/filepath"
@@ -54,7 +57,8 @@ func TestDirSyncerByRunningOnce(t *testing.T) {
 	requires.NoError(err)
 
 	dirEntriesMap := model.NewDirEntriesMap()
-	requires.NoError(newDirScanner(loggerMock, stg, dirEntriesMap).scanOnce(context.Background()))
+	scanner := newDirScanner(loggerMock, stg, dirEntriesMap, fsys.Local{}, localCopyTarget(stg.CopyDir))
+	requires.NoError(scanner.scanOnce(context.Background()))
 
 	err = dirEntriesMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
 		entry := eMap[key]
@@ -104,7 +108,8 @@ func TestDirSyncerByRunningRegularly(t *testing.T) {
 	requires.NoError(err)
 
 	dirEntriesMap := model.NewDirEntriesMap()
-	requires.NoError(newDirScanner(loggerMock, stg, dirEntriesMap).scanOnce(context.Background()))
+	scanner := newDirScanner(loggerMock, stg, dirEntriesMap, fsys.Local{}, localCopyTarget(stg.CopyDir))
+	requires.NoError(scanner.scanOnce(context.Background()))
 
 	err = dirEntriesMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
 		entry := eMap[key]
@@ -114,6 +119,136 @@ func TestDirSyncerByRunningRegularly(t *testing.T) {
 	requires.NoError(err)
 }
 
+func TestDirSyncerWithDirMetaByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	_ = os.Chdir("testdata")
+	wd, _ := os.Getwd()
+	srcDir := filepath.Join(wd, "src")
+	copyDir, err := os.MkdirTemp(wd, "copy")
+	requires.NoError(err)
+	defer os.RemoveAll(copyDir)
+
+	prepareCopyDir(requires, copyDir, srcDir)
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:           srcDir,
+		CopyDir:          copyDir,
+		ScanPeriod:       2 * time.Second,
+		IncludeEmptyDirs: true,
+		LogLevel:         log.DebugLevel,
+		LogToStd:         true,
+		Once:             true,
+		WorkersCount:     2,
+		SyncDirMeta:      true,
+		SyncPerms:        true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 2. act
+	err = New(loggerMock, stg).Start(ctx, cancel)
+
+	// 3. assert
+	requires.NoError(err)
+
+	dirEntriesMap := model.NewDirEntriesMap()
+	scanner := newDirScanner(loggerMock, stg, dirEntriesMap, fsys.Local{}, localCopyTarget(stg.CopyDir))
+	requires.NoError(scanner.scanOnce(context.Background()))
+
+	err = dirEntriesMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+		entry := eMap[key]
+		requires.False(entry.IsSyncRequired())
+		requires.False(entry.IsDirMetaSyncRequired(true), key)
+		return nil
+	})
+	requires.NoError(err)
+}
+
+func TestDirSyncerWithVersionsByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	_ = os.Chdir("testdata")
+	wd, _ := os.Getwd()
+	srcDir := filepath.Join(wd, "src")
+	copyDir, err := os.MkdirTemp(wd, "copy")
+	requires.NoError(err)
+	defer os.RemoveAll(copyDir)
+
+	prepareCopyDir(requires, copyDir, srcDir)
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:           srcDir,
+		CopyDir:          copyDir,
+		ScanPeriod:       2 * time.Second,
+		IncludeHidden:    true,
+		IncludeEmptyDirs: true,
+		LogLevel:         log.DebugLevel,
+		LogToStd:         true,
+		Once:             true,
+		WorkersCount:     2,
+		KeepVersions:     true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 2. act
+	err = New(loggerMock, stg).Start(ctx, cancel)
+
+	// 3. assert
+	requires.NoError(err)
+
+	store := versions.NewStore(copyDir, versions.RetentionPolicy{})
+	for _, path := range []string{"subdir1/old_file.txt", "subdir1/wrong_file.txt"} { // replaced and removed files
+		vers, err := store.List(path)
+		requires.NoError(err)
+		requires.Len(vers, 1, path)
+	}
+	vers, err := store.List("old_file.txt") // this file is not changed
+	requires.NoError(err)
+	requires.Empty(vers)
+
+	dirEntriesMap := model.NewDirEntriesMap()
+	scanner := newDirScanner(loggerMock, stg, dirEntriesMap, fsys.Local{}, localCopyTarget(stg.CopyDir))
+	requires.NoError(scanner.scanOnce(context.Background()))
+
+	err = dirEntriesMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+		entry := eMap[key]
+		requires.False(entry.IsSyncRequired(), key)
+		return nil
+	})
+	requires.NoError(err)
+}
+
+func TestIsExcluded(t *testing.T) {
+	requires := require.New(t)
+	patterns := []string{"*.tmp", "build", filepath.Join("docs", "drafts")}
+
+	requires.True(isExcluded(patterns, "a.tmp"))
+	requires.True(isExcluded(patterns, filepath.Join("dir", "b.tmp")))
+	requires.True(isExcluded(patterns, filepath.Join("dir", "build")))
+	requires.True(isExcluded(patterns, filepath.Join("docs", "drafts")))
+	requires.False(isExcluded(patterns, filepath.Join("other", "docs", "drafts")))
+	requires.False(isExcluded(patterns, "a.txt"))
+	requires.False(isExcluded(nil, "a.tmp"))
+}
+
+func TestParentDirsOf(t *testing.T) {
+	dirs := parentDirsOf([]string{"a/b/c.txt", "a/d", "e.txt"})
+
+	require.Equal(t, map[string]bool{"a": true, "a/b": true}, dirs)
+}
+
 func prepareCopyDir(req *require.Assertions, copyDir string, srcDir string) {
 	createDir(req, copyDir, "subdir1/subdir2")
 	createDir(req, copyDir, "subdir1/wrong_dir")    // this dir has to be removed
@@ -126,15 +261,53 @@ func prepareCopyDir(req *require.Assertions, copyDir string, srcDir string) {
 	copyFileIntoDir(req, srcDir, "subdir1/subdir2/old_file.txt", copyDir)
 }
 
+//prepareMemDirs returns the in-memory source and copy dirs of the one-shot sync (see memSettings): the new file
+//dir/a.txt has to be copied, and the old.txt file has to be removed.
+func prepareMemDirs(req *require.Assertions) (src, copyFS *memfs.FS) {
+	src, copyFS = memfs.New(), memfs.New()
+	modTime := time.Now().Add(-time.Hour)
+	req.NoError(src.WriteFile("/src/dir/a.txt", []byte("abc"), modTime))
+	req.NoError(copyFS.WriteFile("/copy/old.txt", []byte("old!"), modTime))
+	return src, copyFS
+}
+
+//memSettings returns the settings of the one-shot sync from /src into /copy.
+func memSettings() settings.Settings {
+	return settings.Settings{SrcDir: "/src", CopyDir: "/copy", ScanPeriod: time.Second, Once: true, WorkersCount: 2}
+}
+
+//newMemDirSyncer returns the DirSyncer, which syncs the in-memory source dir into the in-memory copy dir.
+func newMemDirSyncer(logger log.Logger, stg settings.Settings, src, copyFS *memfs.FS) *DirSyncer {
+	syncer := New(logger, stg)
+	syncer.fss = fileSystems{src: src, openTarget: func(stg settings.Settings) (copyTarget, error) {
+		return copyTarget{fs: copyFS, root: stg.CopyDir}, nil
+	}}
+	return syncer
+}
+
+func runOnce(syncer *DirSyncer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	return syncer.Start(ctx, cancel)
+}
+
 func getMockLogger(mockCtrl *gomock.Controller, any gomock.Matcher) *logmock.MockLogger {
 	loggerMock := logmock.NewMockLogger(mockCtrl)
 	loggerMock.EXPECT().Debug(any).AnyTimes()
 	loggerMock.EXPECT().Debug(any, any).AnyTimes()
 	loggerMock.EXPECT().Debug(any, any, any).AnyTimes()
 	loggerMock.EXPECT().Debug(any, any, any, any).AnyTimes()
+	loggerMock.EXPECT().Debug(any, any, any, any, any).AnyTimes()
+	loggerMock.EXPECT().Info(any).AnyTimes()
+	loggerMock.EXPECT().Info(any, any).AnyTimes()
 	loggerMock.EXPECT().Info(any, any, any).AnyTimes()
 	loggerMock.EXPECT().Info(any, any, any, any).AnyTimes()
+	loggerMock.EXPECT().Info(any, any, any, any, any).AnyTimes()
+	loggerMock.EXPECT().Info(any, any, any, any, any, any).AnyTimes()
+	loggerMock.EXPECT().Warn(any, any, any).AnyTimes()
+	loggerMock.EXPECT().Warn(any, any, any, any).AnyTimes()
 	loggerMock.EXPECT().Error(any, any, any).AnyTimes()
+	loggerMock.EXPECT().Error(any, any, any, any).AnyTimes()
 	return loggerMock
 }
 
@@ -2,40 +2,77 @@ package dirsyncer
 
 import (
 	"context"
+	"dsync/internal/hooks"
+	"dsync/internal/journal"
 	"dsync/internal/log"
 	"dsync/internal/model"
 	"dsync/internal/settings"
+	"dsync/internal/twoway"
+	"dsync/internal/versions"
+	"dsync/internal/webhook"
+	"dsync/pkg/fsys"
 	"dsync/pkg/helpers/iout"
 	"dsync/pkg/helpers/run"
+	"dsync/pkg/tracing"
 	"errors"
 	"fmt"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"sync"
 	"time"
 )
 
+//maxCopyAttempts limits the number of copying attempts for a file, which copy fails the verification.
+const maxCopyAttempts = 3
+
 var errTaskCannotGetReady = errors.New("task can't get ready for processing, so it is discarded")
 
 //taskExecutor service is responsible for executing sync operations in order to eliminate
 //the difference between the source and copy directories.
 type taskExecutor struct {
-	log        log.Logger
-	settings   settings.Settings
-	entriesMap *model.DirEntriesMap
-	queue      <-chan Task
-	wg         sync.WaitGroup
+	log         log.Logger
+	settings    settings.Settings
+	entriesMap  *model.DirEntriesMap
+	queue       <-chan Task
+	wg          sync.WaitGroup
+	versions    *versions.Store   // is nil, if the copy dir files are not versioned
+	srcVersions *versions.Store   // is not nil, if the source dir files are versioned too (in the two-way mode)
+	limiter     *run.FairLimiter  // is not nil, if the workers budget is shared with other copy dirs or sync jobs
+	base        *twoway.BaseState // is not nil only in the two-way mode
+	target      copyTarget
+	transfer    iout.Transfer // from the source dir file system to the copy dir one
+	precision   time.Duration // of the modification times kept by the copy dir file system
+	metrics     *opMetrics
+	journal     *journal.Journal  // is nil, if the finished operations are not journaled
+	hooks       *hooks.Runner     // is nil, if there are no hooks
+	webhook     *webhook.Notifier // is nil, if the failures are not notified
+	tracer      *tracing.Tracer   // is nil, if the spans are not recorded
 }
 
-func newTaskExecutor(logger log.Logger, stg settings.Settings, eMap *model.DirEntriesMap, tasks <-chan Task) *taskExecutor {
-	return &taskExecutor{log: logger, settings: stg, entriesMap: eMap, queue: tasks}
+func newTaskExecutor(
+	logger log.Logger, stg settings.Settings, eMap *model.DirEntriesMap, tasks <-chan Task, limiter *run.FairLimiter,
+	base *twoway.BaseState, src fsys.FS, target copyTarget,
+) *taskExecutor {
+	e := &taskExecutor{log: logger, settings: stg, entriesMap: eMap, queue: tasks, limiter: limiter, base: base,
+		target:    target,
+		transfer:  iout.Transfer{Src: src, Dst: target.fs},
+		precision: fsys.ModTimePrecision(target.fs),
+		metrics:   newOpMetrics(),
+	}
+	if stg.KeepVersions {
+		e.versions = versions.NewStore(stg.CopyDir, stg.Retention)
+		if stg.TwoWay {
+			e.srcVersions = versions.NewStore(stg.SrcDir, stg.Retention)
+		}
+	}
+	return e
 }
 
 //Start starts this executor's workers in different goroutines.
 //All workers start processing the tasks that were enqueued by the scheduler.
 func (e *taskExecutor) Start(ctx context.Context) {
 	for i := 0; i < e.settings.WorkersCount; i++ {
+		worker := i + 1
 		e.wg.Add(1)
 		go func() {
 			defer e.wg.Done()
@@ -47,7 +84,26 @@ func (e *taskExecutor) Start(ctx context.Context) {
 					if !ok {
 						return
 					}
-					if err := run.WithError(func() error { return e.process(ctx, task) }); err != nil {
+					if e.limiter != nil {
+						if err := e.limiter.Acquire(ctx, e.settings.JobName, e.settings.CopyDir); err != nil {
+							// ctx is done, so the operation is canceled without being started
+							// (but only after the scheduler has put it into the map, so it's not overwritten)
+							<-task.ready
+							now := time.Now()
+							task.EntryInfo.OperationPtr.CanceledAt = &now
+							task.EntryInfo.OperationPtr.Status = model.OpStatusCanceled
+							e.entriesMap.SetValueByKey(task.Path, &(task.EntryInfo))
+							task.setDone()
+							return
+						}
+					}
+					e.metrics.begin(worker, &task)
+					err := run.WithError(func() error { return e.process(ctx, task) })
+					e.metrics.end(worker)
+					if e.limiter != nil {
+						e.limiter.Release()
+					}
+					if err != nil {
 						now := time.Now()
 						if errors.Is(err, context.Canceled) {
 							task.EntryInfo.OperationPtr.CanceledAt = &now
@@ -56,16 +112,100 @@ func (e *taskExecutor) Start(ctx context.Context) {
 						} else {
 							task.EntryInfo.OperationPtr.FailedAt = &now
 							task.EntryInfo.OperationPtr.Status = model.OpStatusFailed
+							task.EntryInfo.OperationPtr.Error = err.Error()
 							e.log.Error("failed to execute operation", log.Cause(err), log.Any("task", task))
 						}
 					}
+					if op := task.EntryInfo.OperationPtr; op.IsNotNilAndOver() {
+						e.metrics.observe(op, copiedSize(&task.EntryInfo))
+						e.writeJournal(task.Path, &task.EntryInfo)
+						e.fireHook(task.Path, &task.EntryInfo)
+						e.notifyFailed(task.Path, &task.EntryInfo)
+						e.traceOperation(&task)
+					}
 					e.entriesMap.SetValueByKey(task.Path, &(task.EntryInfo))
+					task.setDone()
 				}
 			}
 		}()
 	}
 }
 
+//writeJournal appends the finished operation to the journal (if it's kept).
+func (e *taskExecutor) writeJournal(path string, entry *model.EntryInfo) {
+	if e.journal == nil {
+		return
+	}
+	if err := e.journal.Append(e.record(path, entry)); err != nil {
+		e.log.Warn("cannot write the operation to the journal", log.String("path", path), log.Cause(err))
+	}
+}
+
+//fireHook runs the hook of the completed or failed operation (if there is such hook) in background.
+func (e *taskExecutor) fireHook(path string, entry *model.EntryInfo) {
+	var event hooks.Event
+	switch entry.OperationPtr.Status {
+	case model.OpStatusCompleted:
+		event = hooks.OpCompleted
+	case model.OpStatusFailed:
+		event = hooks.OpFailed
+	}
+	if !e.hooks.Handles(event) {
+		return
+	}
+	rec := e.record(path, entry)
+	e.hooks.Fire(hooks.Payload{Event: event, Job: e.settings.JobName, CopyDir: e.settings.CopyDir, Operation: &rec})
+}
+
+//notifyFailed posts the failed operation to the webhook (if it's set).
+func (e *taskExecutor) notifyFailed(path string, entry *model.EntryInfo) {
+	if e.webhook == nil || entry.OperationPtr.Status != model.OpStatusFailed {
+		return
+	}
+	rec := e.record(path, entry)
+	e.webhook.Notify(webhook.Event{Kind: webhook.OpFailed, Job: e.settings.JobName, CopyDir: e.settings.CopyDir,
+		Error: rec.Error, Operation: &rec})
+}
+
+//traceOperation records the span of the finished operation from its enqueuing to its end (if tracing is on).
+//The span is the child of the scheduling one, which has enqueued the task.
+func (e *taskExecutor) traceOperation(task *Task) {
+	if e.tracer == nil {
+		return
+	}
+	rec := e.record(task.Path, &task.EntryInfo)
+	span := e.tracer.StartAt(task.trace, "operation", rec.ScheduledAt,
+		tracing.Uint64("op.id", rec.ID), tracing.String("op.kind", rec.Kind), tracing.String("op.status", rec.Status),
+		tracing.String("path", rec.Path), tracing.Int64("size", rec.Size), tracing.String("copy_dir", rec.CopyDir),
+		tracing.Bool("reverse", rec.Reverse))
+	if rec.Job != "" {
+		span.SetAttrs(tracing.String("job", rec.Job))
+	}
+	if rec.Error != "" {
+		span.SetError(errors.New(rec.Error))
+	}
+	span.EndAt(rec.FinishedAt)
+}
+
+//record returns the journal record of the finished operation.
+func (e *taskExecutor) record(path string, entry *model.EntryInfo) journal.Record {
+	op := entry.OperationPtr
+	from, to := entry.SrcPathInfo, entry.CopyPathInfo
+	if op.Reverse {
+		from, to = to, from
+	}
+	var size int64
+	if from.Exists && !from.IsDir {
+		size = from.Size
+	} else if to.Exists && !to.IsDir {
+		size = to.Size // e.g. the removed file
+	}
+	return journal.Record{ID: op.ID, Job: e.settings.JobName, CopyDir: e.settings.CopyDir,
+		Path: filepath.ToSlash(path), Kind: string(op.Kind), Size: size, Status: string(op.Status),
+		Reverse: op.Reverse, ScheduledAt: op.ScheduledAt, StartedAt: op.StartedAt, FinishedAt: *op.FinishedAt(),
+		Error: op.Error}
+}
+
 //Stop awaits this executor's workers to finish their processing. But it doesn't wait forever - there is a timeout.
 func (e *taskExecutor) Stop() {
 	done := make(chan struct{})
@@ -98,6 +238,10 @@ func (e *taskExecutor) process(ctx context.Context, task Task) error {
 	case <-task.ready: // usually this will be true instantly or as soon as possible
 		//e.log.Debug("operation taken into processing", task.log()...)
 	}
+	if op.Reverse {
+		// the reverse operation is handled in the same way as the usual one, but with the swapped sides
+		*entry = entry.Swapped()
+	}
 
 	// as long as some time passed since the task was created, we need to recheck the entry info before proceeding
 	wasUpdated, err := e.actualizeEntryPathsInfo(task.Path, entry)
@@ -106,11 +250,19 @@ func (e *taskExecutor) process(ctx context.Context, task Task) error {
 	}
 
 	now := time.Now()
+	if wasUpdated && op.Kind == model.OpKindKeepConflict {
+		// the conflicting copy is kept as it is, so only its disappearance makes the operation not actual
+		wasUpdated = false
+		if !entry.CopyPathInfo.Exists {
+			op.CanceledAt, op.Status = &now, model.OpStatusCanceled
+			e.log.Debug("entry actualized, conflicting copy is gone, operation will be canceled", task.log()...)
+		}
+	}
 	if wasUpdated {
 		// as long as entry paths info has changed, the operation may become not actual anymore,
 		// and in such case we may need to cancel or redefine it
-		if entry.IsSyncRequired() {
-			opKind := entry.ResolveOperationKind()
+		if isSyncRequired(e.settings, entry) {
+			opKind := resolveOperationKind(e.settings, entry)
 			if opKind == model.OpKindNone || (!e.settings.IncludeEmptyDirs && opKind == model.OpKindCopyDir) {
 				op.CanceledAt, op.Status = &now, model.OpStatusCanceled
 				e.log.Debug("entry actualized, sync not required now, operation will be canceled", task.log()...)
@@ -142,7 +294,7 @@ func (e *taskExecutor) process(ctx context.Context, task Task) error {
 		}
 	}
 	// we need to update the entry info (with operation inside) in the main common data structure
-	e.entriesMap.SetValueByKey(task.Path, entry)
+	e.entriesMap.SetValueByKey(task.Path, unswapped(entry))
 	if op.Status != model.OpStatusInProgress {
 		return nil // no error, because no processing actually required, and we don't even start the operation
 	}
@@ -154,15 +306,32 @@ func (e *taskExecutor) process(ctx context.Context, task Task) error {
 	now = time.Now()
 	op.CompletedAt, op.Status = &now, model.OpStatusCompleted
 	e.log.Info("operation successfully executed", task.log()...)
+	e.updateBase(task.Path, entry)
 	return nil
 }
 
+//updateBase makes the entry's actual state (which is in sync now) the base state, so that it doesn't depend on
+//the next scan (the process may be stopped before it).
+func (e *taskExecutor) updateBase(path string, entry *model.EntryInfo) {
+	if e.base == nil {
+		return
+	}
+	actual := *entry
+	if _, err := e.actualizeEntryPathsInfo(path, &actual); err != nil {
+		e.log.Warn("cannot actualize entry info for the base state", log.String("path", path), log.Cause(err))
+		return
+	}
+	e.base.Update(path, &actual)
+}
+
 func (e *taskExecutor) actualizeEntryPathsInfo(path string, entry *model.EntryInfo) (bool, error) {
 	updated := false
-	srcPath, copyPath := filepath.Join(e.settings.SrcDir, path), filepath.Join(e.settings.CopyDir, path)
+	srcDir, copyDir := e.dirs(entry.OperationPtr)
+	t := e.transferOf(entry.OperationPtr)
+	srcPath, copyPath := t.Src.Join(srcDir, path), t.Dst.Join(copyDir, path)
 
 	// 1. actualize the source file info
-	srcInfo, err := os.Stat(srcPath)
+	srcInfo, err := t.Src.Stat(srcPath)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) || iout.IsErrNotDir(err) {
 			// the source file at the path does NOT exist right now
@@ -173,7 +342,7 @@ func (e *taskExecutor) actualizeEntryPathsInfo(path string, entry *model.EntryIn
 		} else {
 			return false, err
 		}
-	} else if !(srcInfo.IsDir() || srcInfo.Mode().IsRegular()) { // is non-regular entry (i.e. symlink, device, etc.)
+	} else if !isSupported(e.settings, srcInfo.Mode()) { // is non-regular entry (i.e. symlink, device, etc.)
 		if entry.SrcPathInfo.Exists {
 			entry.SrcPathInfo = model.PathInfo{}
 			updated = true
@@ -198,14 +367,22 @@ func (e *taskExecutor) actualizeEntryPathsInfo(path string, entry *model.EntryIn
 			entry.SrcPathInfo.Size = srcInfo.Size()
 			updated = true
 		}
-		if entry.SrcPathInfo.ModTime != srcInfo.ModTime() {
-			entry.SrcPathInfo.ModTime = srcInfo.ModTime()
+		if modTime := truncateModTime(srcInfo.ModTime(), e.precision); entry.SrcPathInfo.ModTime != modTime {
+			entry.SrcPathInfo.ModTime = modTime
+			updated = true
+		}
+		if entry.SrcPathInfo.Mode != srcInfo.Mode() {
+			entry.SrcPathInfo.Mode = srcInfo.Mode()
+			updated = true
+		}
+		if rdev := iout.DeviceNumber(srcInfo); entry.SrcPathInfo.Rdev != rdev {
+			entry.SrcPathInfo.Rdev = rdev
 			updated = true
 		}
 	}
 
 	// 2. actualize the copy file info
-	copyInfo, err := os.Stat(copyPath)
+	copyInfo, err := t.Dst.Stat(copyPath)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) || iout.IsErrNotDir(err) {
 			// the copy file at the path does NOT exist right now
@@ -216,7 +393,7 @@ func (e *taskExecutor) actualizeEntryPathsInfo(path string, entry *model.EntryIn
 		} else {
 			return false, err
 		}
-	} else if !(copyInfo.IsDir() || copyInfo.Mode().IsRegular()) { // is non-regular entry (i.e. symlink, device, etc.)
+	} else if !isSupported(e.settings, copyInfo.Mode()) { // is non-regular entry (i.e. symlink, device, etc.)
 		if entry.CopyPathInfo.Exists {
 			entry.CopyPathInfo = model.PathInfo{}
 			updated = true
@@ -241,8 +418,16 @@ func (e *taskExecutor) actualizeEntryPathsInfo(path string, entry *model.EntryIn
 			entry.CopyPathInfo.Size = copyInfo.Size()
 			updated = true
 		}
-		if entry.CopyPathInfo.ModTime != copyInfo.ModTime() {
-			entry.CopyPathInfo.ModTime = copyInfo.ModTime()
+		if modTime := truncateModTime(copyInfo.ModTime(), e.precision); entry.CopyPathInfo.ModTime != modTime {
+			entry.CopyPathInfo.ModTime = modTime
+			updated = true
+		}
+		if entry.CopyPathInfo.Mode != copyInfo.Mode() {
+			entry.CopyPathInfo.Mode = copyInfo.Mode()
+			updated = true
+		}
+		if rdev := iout.DeviceNumber(copyInfo); entry.CopyPathInfo.Rdev != rdev {
+			entry.CopyPathInfo.Rdev = rdev
 			updated = true
 		}
 	}
@@ -252,23 +437,135 @@ func (e *taskExecutor) actualizeEntryPathsInfo(path string, entry *model.EntryIn
 
 func (e *taskExecutor) executeOperation(ctx context.Context, path string, entry *model.EntryInfo) error {
 	src, dst := entry.SrcPathInfo.FullPath, entry.CopyPathInfo.FullPath
+	srcDir, copyDir := e.dirs(entry.OperationPtr)
+	t := e.transferOf(entry.OperationPtr)
+	store := e.versions
+	if entry.OperationPtr.Reverse {
+		store = e.srcVersions
+	}
 	opKind := entry.OperationPtr.Kind
 	switch opKind {
 	case model.OpKindCopyFile:
-		return iout.CopyFile(ctx, src, filepath.Join(e.settings.CopyDir, path), entry.SrcPathInfo.ModTime)
+		dst = t.Dst.Join(copyDir, path)
+		if err := e.withCopyRetries(path, func() error {
+			return t.CopyFile(ctx, src, dst, entry.SrcPathInfo.ModTime, e.copyOptions()...)
+		}); err != nil {
+			return err
+		}
+		return e.syncPerms(t, dst, entry.SrcPathInfo)
+	case model.OpKindCopySpecial:
+		dst = t.Dst.Join(copyDir, path)
+		return iout.CreateSpecial(ctx, dst, entry.SrcPathInfo.Mode, entry.SrcPathInfo.Rdev, entry.SrcPathInfo.ModTime)
 	case model.OpKindCopyDir:
 		// actually needed for empty dirs, because non-empty dirs are synced automatically as a part of files full path
 		if e.settings.IncludeEmptyDirs {
-			return iout.EnsureDirExists(ctx, filepath.Join(e.settings.CopyDir, path))
+			return t.EnsureDirExists(ctx, t.Dst.Join(copyDir, path))
 		}
 	case model.OpKindRemoveFile, model.OpKindRemoveDir:
-		return iout.Remove(dst)
+		if store != nil && entry.CopyPathInfo.IsFile() {
+			return store.Save(path, dst)
+		}
+		return t.Remove(dst)
 	case model.OpKindReplaceFile:
-		return iout.ReplaceFile(ctx, src, dst, entry.SrcPathInfo.ModTime)
+		if store != nil {
+			if err := store.Save(path, dst); err != nil {
+				return err
+			}
+		}
+		if err := e.withCopyRetries(path, func() error {
+			return t.ReplaceFile(ctx, src, dst, entry.SrcPathInfo.ModTime, e.copyOptions()...)
+		}); err != nil {
+			return err
+		}
+		return e.syncPerms(t, dst, entry.SrcPathInfo)
 	case model.OpKindReplaceDirWithFile:
-		return iout.ReplaceDirWithFile(ctx, src, dst, entry.SrcPathInfo.ModTime)
+		if err := e.withCopyRetries(path, func() error {
+			return t.ReplaceDirWithFile(ctx, src, dst, entry.SrcPathInfo.ModTime, e.copyOptions()...)
+		}); err != nil {
+			return err
+		}
+		return e.syncPerms(t, dst, entry.SrcPathInfo)
+	case model.OpKindSyncDirMeta:
+		var mode fs.FileMode // zero mode means that permission bits are left untouched
+		if e.settings.SyncPerms {
+			mode = entry.SrcPathInfo.Mode
+		}
+		return t.SetMeta(dst, entry.SrcPathInfo.ModTime, mode)
+	case model.OpKindKeepConflict:
+		// the copy is put aside (and copied to the source as well), then the source is copied to its place
+		conflictPath, err := twoway.ConflictPath(path, srcDir, copyDir)
+		if err != nil {
+			return err
+		}
+		copyConflict, srcConflict := t.Dst.Join(copyDir, conflictPath), t.Src.Join(srcDir, conflictPath)
+		if err := t.Dst.Rename(dst, copyConflict); err != nil {
+			return fmt.Errorf("cannot put aside the conflicting copy: %w", err)
+		}
+		err = t.Reversed().CopyFile(ctx, copyConflict, srcConflict, entry.CopyPathInfo.ModTime, e.copyOptions()...)
+		if err != nil {
+			return err
+		}
+		e.log.Warn("entry has been changed on both sides, the copy is kept as the conflicting one",
+			log.String("path", path), log.String("conflictPath", conflictPath))
+		if err := t.CopyFile(ctx, src, dst, entry.SrcPathInfo.ModTime, e.copyOptions()...); err != nil {
+			return err
+		}
+		return e.syncPerms(t, dst, entry.SrcPathInfo)
 	default: // should never happen
 		panic("invalid operation kind: " + opKind)
 	}
 	return nil
 }
+
+//dirs returns the source and copy dirs of the operation (they are swapped for the reverse operation).
+func (e *taskExecutor) dirs(op *model.Operation) (srcDir, copyDir string) {
+	if op != nil && op.Reverse {
+		return e.target.root, e.settings.SrcDir
+	}
+	return e.settings.SrcDir, e.target.root
+}
+
+//transferOf returns the transfer between the file systems of the operation's dirs (see dirs).
+func (e *taskExecutor) transferOf(op *model.Operation) iout.Transfer {
+	if op != nil && op.Reverse {
+		return e.transfer.Reversed()
+	}
+	return e.transfer
+}
+
+//unswapped returns the entry info as it's kept in the entries map, i.e. with the sides swapped back
+//for the reverse operation.
+func unswapped(entry *model.EntryInfo) *model.EntryInfo {
+	if entry.OperationPtr == nil || !entry.OperationPtr.Reverse {
+		return entry
+	}
+	swapped := entry.Swapped()
+	return &swapped
+}
+
+func (e *taskExecutor) copyOptions() []iout.CopyOption {
+	return []iout.CopyOption{iout.WithVerification(e.settings.Verify)}
+}
+
+//withCopyRetries calls copyFn again, if the copy made by it has failed the verification (e.g. due to a flaky storage).
+func (e *taskExecutor) withCopyRetries(path string, copyFn func() error) error {
+	var err error
+	for attempt := 1; attempt <= maxCopyAttempts; attempt++ {
+		if err = copyFn(); !errors.Is(err, iout.ErrVerificationFailed) {
+			return err
+		}
+		e.log.Warn("copied file failed the verification", log.String("path", path), log.Int("attempt", attempt))
+	}
+	return err
+}
+
+//syncPerms carries over the source entry's permission bits to the copy (if it's turned on in the settings).
+func (e *taskExecutor) syncPerms(t iout.Transfer, dst string, src model.PathInfo) error {
+	if !e.settings.SyncPerms {
+		return nil
+	}
+	if err := t.Dst.Chmod(dst, src.Mode.Perm()); err != nil {
+		return fmt.Errorf("cannot set permissions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys"
+	"dsync/pkg/helpers/iout"
+	"dsync/pkg/helpers/run"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskExecutor_withCopyRetries(t *testing.T) {
+	otherErr := errors.New("other error")
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "ok", errs: []error{nil}, wantAttempts: 1, wantErr: nil},
+		{name: "other error", errs: []error{otherErr}, wantAttempts: 1, wantErr: otherErr},
+		{
+			name:         "verified on retry",
+			errs:         []error{iout.ErrVerificationFailed, nil},
+			wantAttempts: 2,
+			wantErr:      nil,
+		},
+		{
+			name:         "verification always fails",
+			errs:         []error{iout.ErrVerificationFailed, iout.ErrVerificationFailed, iout.ErrVerificationFailed},
+			wantAttempts: maxCopyAttempts,
+			wantErr:      iout.ErrVerificationFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			loggerMock := getMockLogger(mockCtrl, gomock.Any())
+			executor := newTaskExecutor(
+				loggerMock, settings.Settings{Verify: true}, nil, nil, nil, nil, fsys.Local{}, localCopyTarget(""),
+			)
+
+			attempts := 0
+			err := executor.withCopyRetries("file.txt", func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantAttempts, attempts)
+		})
+	}
+}
+
+func TestTaskExecutor_CanceledWhileWaitingForWorkersBudget(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	limiter := run.NewFairLimiter(1)
+	requires.NoError(limiter.Acquire(context.Background(), "other", "")) // the whole budget is taken
+	eMap := model.NewDirEntriesMap()
+	entry := model.EntryInfo{OperationPtr: model.NewOperation(model.OpKindCopyFile)}
+	eMap.SetValueByKey("a.txt", &entry)
+	tasks := make(chan Task)
+	executor := newTaskExecutor(getMockLogger(mockCtrl, gomock.Any()), settings.Settings{WorkersCount: 1}, eMap,
+		tasks, limiter, nil, fsys.Local{}, localCopyTarget(""))
+	ctx, cancel := context.WithCancel(context.Background())
+	executor.Start(ctx)
+
+	var inFlight sync.WaitGroup
+	inFlight.Add(1)
+	task := NewTask("a.txt", entry)
+	task.inFlight = &inFlight
+	task.setReady()
+	tasks <- task // the worker has taken the task and waits for the budget
+	cancel()
+	inFlight.Wait() // the task is done, so the scheduler's side doesn't hang
+	executor.Stop()
+
+	actual, ok := eMap.Get("a.txt")
+	requires.True(ok)
+	requires.Equal(model.OpStatusCanceled, actual.OperationPtr.Status)
+	requires.NotNil(actual.OperationPtr.CanceledAt)
+}
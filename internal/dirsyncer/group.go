@@ -0,0 +1,128 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/hooks"
+	"dsync/internal/journal"
+	"dsync/internal/log"
+	"dsync/internal/settings"
+	"dsync/internal/webhook"
+	"dsync/pkg/helpers/run"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/multierr"
+)
+
+//Group runs several independent sync jobs (each one by its own DirSyncer) in one process.
+//The workers budget is shared fairly between the jobs, so a job with lots of operations can't starve other jobs.
+type Group struct {
+	log  log.Logger
+	jobs []*DirSyncer
+}
+
+func NewGroup(logger log.Logger, stg settings.Settings) *Group {
+	limiter := run.NewFairLimiter(stg.WorkersCount)
+	jobs := make([]*DirSyncer, 0, len(stg.Jobs))
+	for _, jobStg := range stg.Jobs {
+		jobs = append(jobs, &DirSyncer{
+			log:      log.With(logger, log.String("job", jobStg.JobName)),
+			settings: jobStg,
+			limiter:  limiter,
+			fss:      defaultFileSystems,
+		})
+	}
+	return &Group{log: logger, jobs: jobs}
+}
+
+//Start starts all the jobs and returns when all of them are over. The critical error of one job doesn't stop
+//other jobs, such errors are combined into the returned one.
+func (g *Group) Start(ctx context.Context, stop context.CancelFunc) error {
+	type jobResult struct {
+		name string
+		err  error
+	}
+	if len(g.jobs) > 0 && g.jobs[0].settings.JournalPath != "" {
+		// the journal is shared by all the jobs
+		shared, err := journal.Open(g.jobs[0].settings.JournalPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := shared.Close(); err != nil {
+				g.log.Error("cannot close the journal", log.Cause(err))
+			}
+		}()
+		for _, job := range g.jobs {
+			job.journal = shared
+		}
+	}
+	if len(g.jobs) > 0 {
+		// the notifications of all the jobs are batched together
+		if shared := webhook.New(g.log, g.jobs[0].settings.Webhook); shared != nil {
+			defer closeNotifier(shared)
+			for _, job := range g.jobs {
+				job.webhook = shared
+			}
+		}
+		// the spans of all the jobs are exported together
+		if target := g.jobs[0].settings.Trace; target != "" {
+			shared, err := newTracer(g.log, target)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := shared.Close(); err != nil {
+					g.log.Error("cannot close the tracing exporter", log.Cause(err))
+				}
+			}()
+			for _, job := range g.jobs {
+				job.tracer = shared
+			}
+		}
+		// the hooks are shared by all the jobs, so their commands are limited in total
+		if shared := hooks.NewRunner(g.log, g.jobs[0].settings.Hooks); shared != nil {
+			defer shared.Close()
+			for _, job := range g.jobs {
+				job.hooks = shared
+			}
+		}
+	}
+	results := make(chan jobResult, len(g.jobs))
+	if len(g.jobs) > 0 && g.jobs[0].settings.Once {
+		defer startProgress(g.log, g.jobs, g.jobs[0].settings.LogToStd)()
+	}
+	for _, job := range g.jobs {
+		job := job
+		// each job has its own context, so that its panic (which cancels the context) doesn't stop other jobs
+		jobCtx, jobStop := context.WithCancel(ctx)
+		defer jobStop()
+		go func() {
+			err := <-run.AsyncWithError(func() error { return job.Start(jobCtx, jobStop) })
+			results <- jobResult{name: job.settings.JobName, err: err}
+		}()
+	}
+
+	var err error
+	for range g.jobs {
+		res := <-results
+		if res.err != nil {
+			g.log.Error("sync job stopped with error", log.String("job", res.name), log.Cause(res.err))
+			err = multierr.Append(err, fmt.Errorf("job %q: %w", res.name, res.err))
+		} else {
+			g.log.Debug("sync job stopped", log.String("job", res.name))
+		}
+	}
+	stop() // all jobs are over, so there's no need to receive signal notifications anymore
+	return err
+}
+
+//Summary returns the result of the last run of all the jobs.
+func (g *Group) Summary() Summary {
+	return newSummary(g.jobs)
+}
+
+//HTTPHandler returns the handler of the HTTP status API (see statusAPI) and of the Prometheus metrics for all the jobs.
+func (g *Group) HTTPHandler() http.Handler {
+	return newHTTPHandler(g.jobs)
+}
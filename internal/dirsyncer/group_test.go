@@ -0,0 +1,73 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/log"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	_ = os.Chdir("testdata")
+	wd, _ := os.Getwd()
+	srcDir := filepath.Join(wd, "src")
+	copyDirs := make([]string, 2)
+	for i := range copyDirs {
+		copyDir, err := os.MkdirTemp(wd, "copy")
+		requires.NoError(err)
+		defer os.RemoveAll(copyDir)
+		copyDirs[i] = copyDir
+	}
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	job := settings.Settings{
+		SrcDir:           srcDir,
+		ScanPeriod:       time.Second,
+		IncludeEmptyDirs: true,
+		LogLevel:         log.DebugLevel,
+		Once:             true,
+		WorkersCount:     1,
+	}
+	full, partial := job, job
+	full.JobName, full.CopyDir = "full", copyDirs[0]
+	partial.JobName, partial.CopyDir, partial.Exclude = "partial", copyDirs[1], []string{"subdir2", "old_*"}
+	stg := settings.Settings{WorkersCount: 2, Jobs: []settings.Settings{full, partial}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 2. act
+	err := NewGroup(loggerMock, stg).Start(ctx, cancel)
+
+	// 3. assert
+	requires.NoError(err)
+	for _, jobStg := range stg.Jobs {
+		dirEntriesMap := model.NewDirEntriesMap()
+		scanner := newDirScanner(loggerMock, jobStg, dirEntriesMap, fsys.Local{}, localCopyTarget(jobStg.CopyDir))
+		requires.NoError(scanner.scanOnce(context.Background()))
+		err = dirEntriesMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+			entry := eMap[key]
+			requires.False(entry.IsSyncRequired(), key)
+			return nil
+		})
+		requires.NoError(err)
+	}
+
+	requires.FileExists(filepath.Join(copyDirs[0], "subdir1", "subdir2", "old_file.txt"))
+	requires.FileExists(filepath.Join(copyDirs[1], "subdir1", "new_file.txt"))
+	requires.NoFileExists(filepath.Join(copyDirs[1], "old_file.txt"))
+	requires.NoDirExists(filepath.Join(copyDirs[1], "subdir1", "subdir2"))
+}
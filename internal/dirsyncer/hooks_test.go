@@ -0,0 +1,56 @@
+package dirsyncer
+
+import (
+	"dsync/internal/hooks"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks' commands are written for sh")
+	}
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS := prepareMemDirs(requires)
+	out := filepath.Join(t.TempDir(), "events.txt")
+	command := `echo "$DSYNC_EVENT $DSYNC_COPY_DIR $DSYNC_OP_KIND $DSYNC_PATH" >> "` + out + `"`
+	stg := memSettings()
+	stg.Hooks = hooks.Config{
+		Commands:    map[hooks.Event]string{hooks.OpCompleted: command, hooks.CycleConverged: command},
+		Timeout:     10 * time.Second,
+		Concurrency: 1,
+	}
+	syncer := newMemDirSyncer(getMockLogger(mockCtrl, gomock.Any()), stg, src, copyFS)
+
+	// 2. act
+	err := runOnce(syncer)
+
+	// 3. assert
+	requires.NoError(err)
+	requires.Nil(syncer.hooks) // the pending hooks are awaited
+
+	data, err := os.ReadFile(out)
+	requires.NoError(err)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " ")) // the converged event has no operation
+	}
+	sort.Strings(lines)
+	requires.Equal([]string{
+		"on-cycle-converged /copy",
+		"on-op-completed /copy copy_file dir/a.txt",
+		"on-op-completed /copy remove_file old.txt",
+	}, lines)
+}
@@ -0,0 +1,51 @@
+package dirsyncer
+
+import (
+	"dsync/internal/journal"
+	"dsync/internal/model"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerJournal(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS := prepareMemDirs(requires)
+	stg := memSettings()
+	stg.JournalPath = filepath.Join(t.TempDir(), "journal.jsonl")
+	syncer := newMemDirSyncer(getMockLogger(mockCtrl, gomock.Any()), stg, src, copyFS)
+
+	// 2. act
+	err := runOnce(syncer)
+
+	// 3. assert
+	requires.NoError(err)
+	requires.Nil(syncer.journal) // it's closed
+
+	var records []journal.Record
+	requires.NoError(journal.Read(stg.JournalPath, func(rec journal.Record) error {
+		records = append(records, rec)
+		return nil
+	}))
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+	requires.Len(records, 2)
+	requires.Equal("dir/a.txt", records[0].Path)
+	requires.Equal(string(model.OpKindCopyFile), records[0].Kind)
+	requires.Equal(int64(3), records[0].Size)
+	requires.Equal("old.txt", records[1].Path)
+	requires.Equal(string(model.OpKindRemoveFile), records[1].Kind)
+	requires.Equal(int64(4), records[1].Size)
+	for _, rec := range records {
+		requires.Equal(string(model.OpStatusCompleted), rec.Status)
+		requires.Equal("/copy", rec.CopyDir)
+		requires.NotNil(rec.StartedAt)
+		requires.False(rec.FinishedAt.Before(*rec.StartedAt))
+	}
+}
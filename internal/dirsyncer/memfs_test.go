@@ -0,0 +1,67 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys/memfs"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+//TestDirSyncerInMemoryByRunningOnce runs the whole synchronization without touching the disk.
+func TestDirSyncerInMemoryByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS := memfs.New(), memfs.New()
+	modTime := time.Now().Add(-time.Hour)
+	requires.NoError(src.WriteFile("/src/a.txt", []byte("a"), modTime))
+	requires.NoError(src.WriteFile("/src/sub/deep/b.txt", []byte("b"), modTime))
+	requires.NoError(src.MkdirAll("/src/empty"))
+	requires.NoError(copyFS.WriteFile("/copy/a.txt", []byte("old"), modTime.Add(-time.Hour))) // it has to be replaced
+	requires.NoError(copyFS.WriteFile("/copy/old/obsolete.txt", []byte("x"), modTime))        // it has to be removed
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:           "/src",
+		CopyDir:          "/copy",
+		ScanPeriod:       time.Second,
+		IncludeEmptyDirs: true,
+		Once:             true,
+		WorkersCount:     4,
+		Verify:           true,
+	}
+
+	// 2. act (the obsolete dir may be still non-empty, when it's removed, so it's removed by the next run)
+	requires.NoError(runOnce(newMemDirSyncer(loggerMock, stg, src, copyFS)))
+	requires.NoError(runOnce(newMemDirSyncer(loggerMock, stg, src, copyFS)))
+
+	// 3. assert
+	content, err := copyFS.ReadFile("/copy/a.txt")
+	requires.NoError(err)
+	requires.Equal("a", string(content))
+	info, err := copyFS.Stat("/copy/sub/deep/b.txt")
+	requires.NoError(err)
+	requires.True(info.ModTime().Equal(modTime))
+	info, err = copyFS.Stat("/copy/empty")
+	requires.NoError(err)
+	requires.True(info.IsDir())
+	_, err = copyFS.Stat("/copy/old")
+	requires.Error(err)
+
+	target := copyTarget{fs: copyFS, root: stg.CopyDir}
+	dirEntriesMap := model.NewDirEntriesMap()
+	requires.NoError(newDirScanner(loggerMock, stg, dirEntriesMap, src, target).scanOnce(context.Background()))
+	err = dirEntriesMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+		entry := eMap[key]
+		requires.False(entry.IsSyncRequired(), key)
+		return nil
+	})
+	requires.NoError(err)
+}
@@ -0,0 +1,258 @@
+package dirsyncer
+
+import (
+	"dsync/internal/model"
+	"dsync/pkg/metrics"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//opOutcome is the kind of the operation and its final status.
+type opOutcome struct {
+	kind   model.OperationKind
+	status model.OperationStatus
+}
+
+//activeTask is the task being processed by the worker.
+type activeTask struct {
+	worker    int
+	path      string
+	kind      model.OperationKind
+	size      int64 // the number of the bytes to copy
+	startedAt time.Time
+}
+
+//opMetrics are the metrics of the operations executed in one copy dir. It's safe for concurrent use.
+type opMetrics struct {
+	mu           sync.Mutex
+	planned      int64 // the number of the enqueued operations
+	plannedBytes int64
+	outcomes     map[opOutcome]int64
+	bytes        int64 // copied from the source dir (or to it, in the two-way mode)
+	durations    map[model.OperationKind]*metrics.Histogram
+	active       map[int]activeTask // by the workers' numbers
+}
+
+func newOpMetrics() *opMetrics {
+	return &opMetrics{
+		outcomes:  make(map[opOutcome]int64),
+		durations: make(map[model.OperationKind]*metrics.Histogram),
+		active:    make(map[int]activeTask),
+	}
+}
+
+//plan counts the enqueued operation.
+func (m *opMetrics) plan(entry *model.EntryInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.planned++
+	m.plannedBytes += copiedSize(entry)
+}
+
+//begin marks the task as being processed by the worker.
+func (m *opMetrics) begin(worker int, task *Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active[worker] = activeTask{worker: worker, path: task.Path, kind: task.EntryInfo.OperationPtr.Kind,
+		size: copiedSize(&task.EntryInfo), startedAt: time.Now()}
+}
+
+//end marks the worker as idle.
+func (m *opMetrics) end(worker int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.active, worker)
+}
+
+func (m *opMetrics) busyWorkers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.active)
+}
+
+//observe counts the operation, which is over. The copied bytes are counted only for the completed operation.
+func (m *opMetrics) observe(op *model.Operation, copied int64) {
+	var duration time.Duration
+	if op.StartedAt != nil {
+		end := time.Now()
+		if finishedAt := op.FinishedAt(); finishedAt != nil {
+			end = *finishedAt
+		}
+		duration = end.Sub(*op.StartedAt)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes[opOutcome{kind: op.Kind, status: op.Status}]++
+	if op.Status == model.OpStatusCompleted {
+		m.bytes += copied
+	}
+	if op.StartedAt != nil {
+		h, ok := m.durations[op.Kind]
+		if !ok {
+			h = metrics.NewHistogram(metrics.DurationBuckets)
+			m.durations[op.Kind] = h
+		}
+		h.Observe(duration.Seconds())
+	}
+}
+
+//copiedSize returns the number of the bytes copied by the entry's operation (as of its scheduling).
+func copiedSize(entry *model.EntryInfo) int64 {
+	switch entry.OperationPtr.Kind {
+	case model.OpKindCopyFile, model.OpKindReplaceFile, model.OpKindReplaceDirWithFile:
+		if entry.OperationPtr.Reverse {
+			return entry.CopyPathInfo.Size
+		}
+		return entry.SrcPathInfo.Size
+	}
+	return 0
+}
+
+func (dst *destination) consecutiveErrors() int {
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	return dst.errCount
+}
+
+//metricsHandler serves the metrics of the running jobs in the Prometheus text exposition format.
+type metricsHandler struct {
+	jobs []*DirSyncer
+}
+
+//scanned is the dir scanned by the job (the source one or the copy one).
+type scanned struct {
+	job         *DirSyncer
+	dir, side   string
+	scanner     *dirScanner
+	errorsCount int
+}
+
+func (h metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isGet(w, r) {
+		return
+	}
+	type running struct {
+		job *DirSyncer
+		dst *destination
+	}
+	var (
+		dests []running
+		scans []scanned
+	)
+	for _, job := range h.jobs {
+		srcScanner, jobDests := job.running()
+		if srcScanner == nil {
+			continue
+		}
+		scans = append(scans, scanned{job: job, dir: job.settings.SrcDir, side: "source", scanner: srcScanner,
+			errorsCount: int(atomic.LoadInt32(&job.scanErrors))})
+		for _, dst := range jobDests {
+			dests = append(dests, running{job: job, dst: dst})
+			scans = append(scans, scanned{job: job, dir: dst.settings.CopyDir, side: "copy", scanner: dst.scanner,
+				errorsCount: dst.consecutiveErrors()})
+		}
+	}
+	copyDirLabels := func(rd running, extra ...metrics.Label) []metrics.Label {
+		return jobLabels(rd.job, append([]metrics.Label{{Name: "copy_dir", Value: rd.dst.settings.CopyDir}}, extra...)...)
+	}
+	scanLabels := func(s scanned) []metrics.Label {
+		return jobLabels(s.job, metrics.Label{Name: "dir", Value: s.dir}, metrics.Label{Name: "side", Value: s.side})
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	mw := metrics.NewWriter(w)
+
+	mw.Family("dsync_operations_total", metrics.TypeCounter,
+		"The number of the sync operations, which are over, by their kinds and final statuses.")
+	for _, rd := range dests {
+		m := rd.dst.executor.metrics
+		m.mu.Lock()
+		outcomes := make([]opOutcome, 0, len(m.outcomes))
+		for outcome := range m.outcomes {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Slice(outcomes, func(i, j int) bool {
+			if outcomes[i].kind != outcomes[j].kind {
+				return outcomes[i].kind < outcomes[j].kind
+			}
+			return outcomes[i].status < outcomes[j].status
+		})
+		for _, outcome := range outcomes {
+			mw.Sample("dsync_operations_total", float64(m.outcomes[outcome]), copyDirLabels(rd,
+				metrics.Label{Name: "kind", Value: string(outcome.kind)},
+				metrics.Label{Name: "status", Value: string(outcome.status)})...)
+		}
+		m.mu.Unlock()
+	}
+
+	mw.Family("dsync_copied_bytes_total", metrics.TypeCounter, "The number of the bytes copied by the operations.")
+	for _, rd := range dests {
+		m := rd.dst.executor.metrics
+		m.mu.Lock()
+		copied := m.bytes
+		m.mu.Unlock()
+		mw.Sample("dsync_copied_bytes_total", float64(copied), copyDirLabels(rd)...)
+	}
+
+	mw.Family("dsync_operation_duration_seconds", metrics.TypeHistogram,
+		"The duration of the started sync operations by their kinds.")
+	for _, rd := range dests {
+		m := rd.dst.executor.metrics
+		m.mu.Lock()
+		kinds := make([]model.OperationKind, 0, len(m.durations))
+		for kind := range m.durations {
+			kinds = append(kinds, kind)
+		}
+		sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+		histograms := make([]*metrics.Histogram, len(kinds))
+		for i, kind := range kinds {
+			histograms[i] = m.durations[kind]
+		}
+		m.mu.Unlock()
+		for i, kind := range kinds {
+			mw.Histogram("dsync_operation_duration_seconds", histograms[i],
+				copyDirLabels(rd, metrics.Label{Name: "kind", Value: string(kind)})...)
+		}
+	}
+
+	mw.Family("dsync_scan_duration_seconds", metrics.TypeHistogram,
+		"The duration of the successful scans of the source and copy dirs.")
+	for _, s := range scans {
+		mw.Histogram("dsync_scan_duration_seconds", s.scanner.durations, scanLabels(s)...)
+	}
+
+	mw.Family("dsync_scan_errors_consecutive", metrics.TypeGauge,
+		"The number of the consecutive failed scans of the source dir (or sync cycles of the copy dir).")
+	for _, s := range scans {
+		mw.Sample("dsync_scan_errors_consecutive", float64(s.errorsCount), scanLabels(s)...)
+	}
+
+	mw.Family("dsync_queue_depth", metrics.TypeGauge, "The number of the tasks waiting in the queue.")
+	for _, rd := range dests {
+		mw.Sample("dsync_queue_depth", float64(len(rd.dst.tasks)), copyDirLabels(rd)...)
+	}
+
+	mw.Family("dsync_busy_workers", metrics.TypeGauge, "The number of the workers processing the tasks.")
+	for _, rd := range dests {
+		mw.Sample("dsync_busy_workers", float64(rd.dst.executor.metrics.busyWorkers()), copyDirLabels(rd)...)
+	}
+
+	mw.Family("dsync_tracked_entries", metrics.TypeGauge, "The number of the entries tracked in the entries map.")
+	for _, rd := range dests {
+		mw.Sample("dsync_tracked_entries", float64(rd.dst.eMap.Len()), copyDirLabels(rd)...)
+	}
+
+	_ = mw.Flush() // the client may be gone
+}
+
+//jobLabels prepends the job label (if the job has a name) to the labels.
+func jobLabels(job *DirSyncer, labels ...metrics.Label) []metrics.Label {
+	if job.settings.JobName == "" {
+		return labels
+	}
+	return append([]metrics.Label{{Name: "job", Value: job.settings.JobName}}, labels...)
+}
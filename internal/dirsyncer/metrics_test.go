@@ -0,0 +1,54 @@
+package dirsyncer
+
+import (
+	"dsync/pkg/metrics"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncer_Metrics(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS := prepareMemDirs(requires)
+	stg := memSettings()
+	stg.JobName = "docs"
+	syncer := newMemDirSyncer(getMockLogger(mockCtrl, gomock.Any()), stg, src, copyFS)
+	srv := httptest.NewServer(syncer.HTTPHandler())
+	defer srv.Close()
+
+	// 2. act
+	requires.NoError(runOnce(syncer))
+	resp, err := http.Get(srv.URL + "/metrics")
+
+	// 3. assert
+	requires.NoError(err)
+	defer resp.Body.Close()
+	requires.Equal(http.StatusOK, resp.StatusCode)
+	requires.Equal(metrics.ContentType, resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	requires.NoError(err)
+
+	for _, line := range []string{
+		`# TYPE dsync_operations_total counter`,
+		`dsync_operations_total{job="docs",copy_dir="/copy",kind="copy_file",status="completed"} 1`,
+		`dsync_operations_total{job="docs",copy_dir="/copy",kind="remove_file",status="completed"} 1`,
+		`dsync_copied_bytes_total{job="docs",copy_dir="/copy"} 3`,
+		`dsync_operation_duration_seconds_count{job="docs",copy_dir="/copy",kind="copy_file"} 1`,
+		`dsync_scan_duration_seconds_count{job="docs",dir="/src",side="source"} 1`,
+		`dsync_scan_duration_seconds_count{job="docs",dir="/copy",side="copy"} 1`,
+		`dsync_scan_errors_consecutive{job="docs",dir="/src",side="source"} 0`,
+		`dsync_queue_depth{job="docs",copy_dir="/copy"} 0`,
+		`dsync_busy_workers{job="docs",copy_dir="/copy"} 0`,
+		`dsync_tracked_entries{job="docs",copy_dir="/copy"} 3`,
+	} {
+		requires.Contains(string(body), line+"\n")
+	}
+}
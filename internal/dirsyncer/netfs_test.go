@@ -0,0 +1,83 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys/netfs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerWithDsyncCopyDirByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	serverRoot := t.TempDir()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	requires.NoError(err)
+	serverCtx, stopServer := context.WithCancel(context.Background())
+	defer stopServer()
+	go func() { _ = (&netfs.Server{Root: serverRoot, Token: "secret"}).Serve(serverCtx, listener) }()
+
+	srcDir, remoteDir := t.TempDir(), filepath.Join(serverRoot, "copy")
+	modTime := time.Now().Add(-time.Hour)
+	big := make([]byte, 1<<20)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+	writeFileWithModTime(requires, srcDir, "a.txt", "a", modTime)
+	writeFileWithModTime(requires, srcDir, filepath.Join("sub", "big.bin"), string(big), modTime)
+	requires.NoError(os.MkdirAll(filepath.Join(srcDir, "empty"), os.ModePerm))
+	writeFileWithModTime(requires, remoteDir, "obsolete.txt", "x", modTime)
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:           srcDir,
+		CopyDir:          netfs.Location{Addr: listener.Addr().String(), Path: "/copy"}.String(),
+		ScanPeriod:       time.Second,
+		IncludeEmptyDirs: true,
+		Once:             true,
+		WorkersCount:     2,
+		Verify:           true,
+		SyncPerms:        true,
+		Token:            "secret",
+	}
+	requires.NoError(stg.Validate())
+	runOnce := func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		return New(loggerMock, stg).Start(ctx, cancel)
+	}
+
+	// 2. act
+	requires.NoError(runOnce())
+
+	// 3. assert
+	requireFileContent(requires, remoteDir, "a.txt", "a")
+	requireFileContent(requires, remoteDir, filepath.Join("sub", "big.bin"), string(big))
+	requires.DirExists(filepath.Join(remoteDir, "empty"))
+	requires.NoFileExists(filepath.Join(remoteDir, "obsolete.txt"))
+	info, err := os.Stat(filepath.Join(remoteDir, "a.txt"))
+	requires.NoError(err)
+	requires.True(info.ModTime().Equal(modTime)) // the full precision is kept
+	requireInSyncWithRemote(requires, loggerMock, stg)
+
+	// the changed file is patched in place
+	big[len(big)/2] ^= 0xff
+	writeFileWithModTime(requires, srcDir, filepath.Join("sub", "big.bin"), string(big), modTime.Add(time.Minute))
+	requires.NoError(runOnce())
+	requireFileContent(requires, remoteDir, filepath.Join("sub", "big.bin"), string(big))
+	requireInSyncWithRemote(requires, loggerMock, stg)
+
+	// the wrong token fails the run
+	stg.Token = "wrong"
+	requires.ErrorContains(runOnce(), "authentication failed")
+}
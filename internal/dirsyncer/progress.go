@@ -0,0 +1,191 @@
+package dirsyncer
+
+import (
+	"dsync/internal/log"
+	"dsync/internal/model"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ttyProgressPeriod = 500 * time.Millisecond
+	logProgressPeriod = 10 * time.Second
+	//maxProgressLineLen is the length, which the lines of the progress view are cut to (the terminal width is unknown).
+	maxProgressLineLen = 110
+	//rateSmoothing is the weight of the last period's throughput in the smoothed one.
+	rateSmoothing = 0.3
+)
+
+//progress is the summary of the operations of the running jobs.
+type progress struct {
+	planned, done, failed, canceled int64
+	plannedBytes, doneBytes         int64
+	active                          []activeTask
+}
+
+func (p *progress) add(m *opMetrics, copyDir string, multi bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.planned += m.planned
+	p.plannedBytes += m.plannedBytes
+	p.doneBytes += m.bytes
+	for outcome, count := range m.outcomes {
+		switch outcome.status {
+		case model.OpStatusCompleted:
+			p.done += count
+		case model.OpStatusFailed:
+			p.failed += count
+		default:
+			p.canceled += count
+		}
+	}
+	for _, task := range m.active {
+		if multi {
+			task.path = copyDir + ": " + task.path
+		}
+		p.active = append(p.active, task)
+	}
+}
+
+//progressReporter shows the progress of the one-shot sync: it redraws the view on the terminal,
+//or (if the output is not a terminal) logs the progress periodically.
+type progressReporter struct {
+	log  log.Logger
+	jobs []*DirSyncer
+	out  io.Writer // is nil, if the output is not a terminal
+	now  func() time.Time
+
+	lines     int // the number of the lines drawn last time
+	lastAt    time.Time
+	lastBytes int64
+	rate      float64 // the smoothed throughput in bytes per second
+}
+
+//startProgress starts reporting the progress of the jobs in background. The view is drawn on stderr, if it is
+//a terminal, and the logs don't go to the console. The returned function stops reporting.
+func startProgress(logger log.Logger, jobs []*DirSyncer, logToStd bool) func() {
+	r := &progressReporter{log: logger, jobs: jobs, now: time.Now}
+	period := logProgressPeriod
+	if !logToStd && isTerminal(os.Stderr) {
+		r.out, period = os.Stderr, ttyProgressPeriod
+	}
+	r.lastAt = r.now()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				if r.out != nil {
+					r.report() // the final state stays on the terminal
+				}
+				return
+			case <-ticker.C:
+				r.report()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+//isTerminal reports whether the file is a character device (i.e. a terminal, and not a file or a pipe).
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (r *progressReporter) collect() progress {
+	var p progress
+	for _, job := range r.jobs {
+		_, dests := job.running()
+		multi := len(r.jobs) > 1 || len(dests) > 1
+		for _, dst := range dests {
+			p.add(dst.executor.metrics, dst.settings.CopyDir, multi)
+		}
+	}
+	sort.Slice(p.active, func(i, j int) bool { return p.active[i].path < p.active[j].path })
+	return p
+}
+
+func (r *progressReporter) report() {
+	p := r.collect()
+	now := r.now()
+	if elapsed := now.Sub(r.lastAt).Seconds(); elapsed > 0 {
+		rate := float64(p.doneBytes-r.lastBytes) / elapsed
+		if r.rate == 0 {
+			r.rate = rate
+		} else {
+			r.rate = rateSmoothing*rate + (1-rateSmoothing)*r.rate
+		}
+	}
+	r.lastAt, r.lastBytes = now, p.doneBytes
+
+	eta := "-"
+	if remaining := p.plannedBytes - p.doneBytes; remaining > 0 && r.rate > 0 {
+		eta = time.Duration(float64(remaining) / r.rate * float64(time.Second)).Truncate(time.Second).String()
+	}
+	files := fmt.Sprintf("%d/%d", p.done, p.planned)
+	bytes := formatBytes(p.doneBytes) + "/" + formatBytes(p.plannedBytes)
+	rate := formatBytes(int64(r.rate)) + "/s"
+	if r.out == nil {
+		r.log.Info("sync progress", log.String("files", files), log.Int64("failed", p.failed),
+			log.String("bytes", bytes), log.String("rate", rate), log.String("eta", eta))
+		return
+	}
+
+	lines := []string{fmt.Sprintf("files %s, failed %d, bytes %s, %s, ETA %s", files, p.failed, bytes, rate, eta)}
+	for _, task := range p.active {
+		lines = append(lines, fmt.Sprintf("  worker %d: %s %s (%s, %s)", task.worker, task.kind, task.path,
+			formatBytes(task.size), now.Sub(task.startedAt).Truncate(time.Second)))
+	}
+	r.draw(lines)
+}
+
+//draw replaces the previously drawn lines with the new ones.
+func (r *progressReporter) draw(lines []string) {
+	var b strings.Builder
+	if r.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", r.lines) // moves the cursor up to the first drawn line
+	}
+	for _, line := range lines {
+		if runes := []rune(line); len(runes) > maxProgressLineLen {
+			line = string(runes[:maxProgressLineLen-3]) + "..."
+		}
+		b.WriteString("\r\x1b[2K" + line + "\n") // clears the line before writing
+	}
+	for i := len(lines); i < r.lines; i++ {
+		b.WriteString("\r\x1b[2K\n") // clears the rest of the lines drawn last time
+	}
+	if extra := r.lines - len(lines); extra > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", extra)
+	}
+	r.lines = len(lines)
+	_, _ = io.WriteString(r.out, b.String())
+}
+
+//formatBytes formats the size with the binary unit, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
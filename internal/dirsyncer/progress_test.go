@@ -0,0 +1,61 @@
+package dirsyncer
+
+import (
+	"bytes"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReporter(t *testing.T) {
+	requires := require.New(t)
+	dst := &destination{settings: settings.Settings{CopyDir: "/copy"}, executor: &taskExecutor{metrics: newOpMetrics()}}
+	job := &DirSyncer{}
+	job.setRunning(&dirScanner{}, []*destination{dst})
+	m := dst.executor.metrics
+
+	newTask := func(path string, size int64) Task {
+		entry := model.EntryInfo{SrcPathInfo: model.PathInfo{Exists: true, Size: size}}
+		entry.SetOperation(model.NewOperation(model.OpKindCopyFile))
+		m.plan(&entry)
+		return NewTask(path, entry)
+	}
+	big, small, failed := newTask("big.bin", 3<<20), newTask("small.txt", 1<<20), newTask("bad.txt", 0)
+	m.begin(1, &big)
+	m.begin(2, &small)
+	now := time.Now()
+	small.EntryInfo.OperationPtr.StartedAt, small.EntryInfo.OperationPtr.CompletedAt = &now, &now
+	small.EntryInfo.OperationPtr.Status = model.OpStatusCompleted
+	m.observe(small.EntryInfo.OperationPtr, copiedSize(&small.EntryInfo))
+	m.end(2)
+	failed.EntryInfo.OperationPtr.Status = model.OpStatusFailed
+	m.observe(failed.EntryInfo.OperationPtr, 0)
+
+	var out bytes.Buffer
+	r := &progressReporter{jobs: []*DirSyncer{job}, out: &out, now: func() time.Time { return now }}
+	r.lastAt = now.Add(-time.Second)
+	r.report()
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	requires.Len(lines, 2)
+	requires.Contains(lines[0], "files 1/3, failed 1, bytes 1.0 MiB/4.0 MiB, 1.0 MiB/s, ETA 3s")
+	requires.Contains(lines[1], "worker 1: copy_file big.bin (3.0 MiB, 0s)")
+
+	// the second view replaces the first one, and the extra line is cleared
+	m.end(1)
+	out.Reset()
+	r.report()
+	requires.True(strings.HasPrefix(out.String(), "\x1b[2A\r\x1b[2Kfiles 1/3"))
+	requires.True(strings.HasSuffix(out.String(), "\r\x1b[2K\n\x1b[1A"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	requires := require.New(t)
+	requires.Equal("0 B", formatBytes(0))
+	requires.Equal("1023 B", formatBytes(1023))
+	requires.Equal("1.5 KiB", formatBytes(1536))
+	requires.Equal("2.0 GiB", formatBytes(2<<30))
+}
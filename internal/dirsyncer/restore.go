@@ -0,0 +1,44 @@
+package dirsyncer
+
+import (
+	"dsync/internal/settings"
+	"dsync/pkg/fsys"
+	"dsync/pkg/fsys/compressfs"
+	"dsync/pkg/fsys/cryptfs"
+	"errors"
+	"io/fs"
+)
+
+//targetFS returns the file system of the copy dir, which encrypts and compresses the files, if it's configured.
+//The files are compressed before they are encrypted, as the encrypted content can't be compressed.
+func targetFS(target copyTarget, stg settings.Settings) fsys.FS {
+	f := target.fs
+	if stg.Encrypt {
+		f = cryptfs.New(f, target.root,
+			cryptfs.Config{KeyPath: stg.KeyPath, Passphrase: stg.Passphrase, Names: stg.EncryptNames})
+	}
+	if stg.Compress == settings.CompressZstd {
+		f = compressfs.New(f, target.root, compressfs.Config{})
+	}
+	return f
+}
+
+//restoredFS returns the file system of the source dir, which is the copy dir to be restored. It decrypts and
+//decompresses the files, if the dir is encrypted or compressed (that's told by the dir itself).
+func restoredFS(src fsys.FS, stg settings.Settings) (fsys.FS, error) {
+	f := src
+	_, err := src.Stat(src.Join(stg.SrcDir, cryptfs.ConfigName))
+	if err == nil {
+		f = cryptfs.New(f, stg.SrcDir, cryptfs.Config{KeyPath: stg.KeyPath, Passphrase: stg.Passphrase, Restore: true})
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	compressed, err := compressfs.IsCompressed(f, stg.SrcDir)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		f = compressfs.New(f, stg.SrcDir, compressfs.Config{Restore: true})
+	}
+	return f, nil
+}
@@ -0,0 +1,79 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys/s3fs/s3test"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerWithS3CopyDirByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	server := s3test.NewServer()
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", s3test.AccessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", s3test.SecretKey)
+
+	srcDir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	writeFileWithModTime(requires, srcDir, "a.txt", "a", modTime)
+	writeFileWithModTime(requires, srcDir, filepath.Join("sub", "deep", "b.txt"), "b", modTime)
+	requires.NoError(os.MkdirAll(filepath.Join(srcDir, "empty"), os.ModePerm))
+	server.PutObject("bucket", "copy/obsolete1.txt", []byte("x")) // they have to be removed
+	server.PutObject("bucket", "copy/obsolete2.txt", []byte("x"))
+	server.PutObject("bucket", "copy/old/obsolete3.txt", []byte("x"))
+	server.PutObject("bucket", "copy/a.txt", []byte("old")) // it has to be replaced
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:           srcDir,
+		CopyDir:          "s3://bucket/copy",
+		ScanPeriod:       time.Second,
+		IncludeEmptyDirs: true,
+		Once:             true,
+		WorkersCount:     4,
+		Verify:           true,
+		S3Endpoint:       server.URL,
+	}
+	requires.NoError(stg.Validate())
+	runOnce := func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		return New(loggerMock, stg).Start(ctx, cancel)
+	}
+
+	// 2. act
+	requires.NoError(runOnce())
+
+	// 3. assert
+	var files []string
+	for _, key := range server.Keys("bucket") {
+		if !strings.HasSuffix(key, "/") { // the made dirs have the marker objects
+			files = append(files, key)
+		}
+	}
+	requires.Equal([]string{"copy/a.txt", "copy/sub/deep/b.txt"}, files)
+	requires.Contains(server.Keys("bucket"), "copy/empty/")
+	content, meta, _ := server.Object("bucket", "copy/a.txt")
+	requires.Equal("a", string(content))
+	requires.Equal(modTime.UTC().Format(time.RFC3339Nano), meta.Get("X-Amz-Meta-Mtime"))
+	requires.Equal(0, server.Count("CopyObject")) // the modification time is set along with the content
+	requires.Less(server.Count("DeleteObjects"), 3)
+	requireInSyncWithRemote(requires, loggerMock, stg)
+
+	// the second run finds nothing to sync, and the metadata of the unchanged objects is not requested again
+	server.ResetCounts()
+	requires.NoError(runOnce())
+	requires.Equal(0, server.Count("PutObject"))
+}
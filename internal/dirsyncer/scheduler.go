@@ -5,14 +5,20 @@ import (
 	"dsync/internal/log"
 	"dsync/internal/model"
 	"dsync/internal/settings"
+	"dsync/internal/twoway"
+	"dsync/pkg/tracing"
 	"errors"
+	"path/filepath"
+	"sync"
 )
 
 //Task is a sync task. taskScheduler puts it into its queue.
 type Task struct {
-	Path      string          `json:"path"`  // it's a key in DirEntriesMap
-	EntryInfo model.EntryInfo `json:"entry"` // it's a value in DirEntriesMap
-	ready     chan struct{}   // is task ready to be processed by a worker
+	Path      string              `json:"path"`  // it's a key in DirEntriesMap
+	EntryInfo model.EntryInfo     `json:"entry"` // it's a value in DirEntriesMap
+	ready     chan struct{}       // is task ready to be processed by a worker
+	inFlight  *sync.WaitGroup     // counts the enqueued tasks, which are not yet processed by workers
+	trace     tracing.SpanContext // the scheduling span, which has enqueued the task (is zero, if tracing is off)
 }
 
 func NewTask(path string, ei model.EntryInfo) Task {
@@ -24,6 +30,13 @@ func (t *Task) setReady() {
 	close(t.ready)
 }
 
+//setDone tells the scheduler's side that this task has been processed by a worker.
+func (t *Task) setDone() {
+	if t.inFlight != nil {
+		t.inFlight.Done()
+	}
+}
+
 func (t *Task) log() []log.Field {
 	opPtr := t.EntryInfo.OperationPtr
 	var opField log.Field
@@ -47,14 +60,46 @@ type taskScheduler struct {
 	settings   settings.Settings
 	entriesMap *model.DirEntriesMap
 	queue      chan<- Task // only taskScheduler can write to this channel
+	inFlight   sync.WaitGroup
+	base       *twoway.BaseState // is not nil only in the two-way mode
+	metrics    *opMetrics        // counts the enqueued operations
+	tracer     *tracing.Tracer   // is nil, if the spans are not recorded
 }
 
-func newTaskScheduler(logger log.Logger, stg settings.Settings, eMap *model.DirEntriesMap, tasks chan<- Task) *taskScheduler {
-	return &taskScheduler{log: logger, settings: stg, entriesMap: eMap, queue: tasks}
+func newTaskScheduler(
+	logger log.Logger, stg settings.Settings, eMap *model.DirEntriesMap, tasks chan<- Task, base *twoway.BaseState,
+	metrics *opMetrics,
+) *taskScheduler {
+	return &taskScheduler{log: logger, settings: stg, entriesMap: eMap, queue: tasks, base: base, metrics: metrics}
 }
 
-func (s *taskScheduler) scheduleOnce(ctx context.Context) error {
-	var tasksToEnqueue []Task
+//awaitTasks blocks until all the enqueued tasks are processed by workers (or until ctx is done).
+func (s *taskScheduler) awaitTasks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.inFlight.Wait()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+func (s *taskScheduler) scheduleOnce(ctx context.Context) (err error) {
+	ctx, span := s.tracer.Start(ctx, "schedule", tracing.String("copy_dir", s.settings.CopyDir))
+	enqueued := 0
+	defer func() {
+		span.SetAttrs(tracing.Int("tasks", enqueued))
+		span.SetError(err)
+		span.End()
+	}()
+	var (
+		tasksToEnqueue []Task
+		busyPaths      []string // paths with pending changes, their parent dirs' metadata can't be synced yet
+	)
 	if err := s.entriesMap.ForEach(
 		func(key string, eMap map[string]model.EntryInfo) error {
 			entry := eMap[key] // entry may have zero value
@@ -67,7 +112,14 @@ func (s *taskScheduler) scheduleOnce(ctx context.Context) error {
 				return ctx.Err()
 			}
 
-			if entry.IsSyncRequired() {
+			if op != nil || entry.IsSyncRequired() {
+				busyPaths = append(busyPaths, key)
+			}
+			if s.base != nil && op == nil {
+				s.base.Update(key, &entry) // the entries, which are in sync now, become the base for the next changes
+			}
+
+			if isSyncRequired(s.settings, &entry) {
 				// here we create new sync task
 				if op == nil {
 					tasksToEnqueue = append(tasksToEnqueue, NewTask(key, entry))
@@ -89,6 +141,11 @@ func (s *taskScheduler) scheduleOnce(ctx context.Context) error {
 	); err != nil {
 		return err
 	}
+	if s.base != nil {
+		if err := s.base.Save(); err != nil {
+			return err
+		}
+	}
 
 	// we don't want to be blocked forever if s.queue is full
 	timeout := s.settings.ScanPeriod
@@ -97,21 +154,32 @@ func (s *taskScheduler) scheduleOnce(ctx context.Context) error {
 	}
 	childCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
+	busyDirs := parentDirsOf(busyPaths)
 	for _, t := range tasksToEnqueue {
 		t := t
-		opKind := t.EntryInfo.ResolveOperationKind()
+		opKind, reverse := s.resolveOperation(&t)
 		if opKind == model.OpKindCopyDir && !s.settings.IncludeEmptyDirs {
 			// do not copy dir (non-empty dir will be copied automatically on the file copying)
 			continue
 		}
+		if opKind == model.OpKindSyncDirMeta && busyDirs[t.Path] {
+			// any change inside the dir would clobber its modTime, so we postpone it until all children are synced
+			continue
+		}
 		if opKind == model.OpKindNone {
 			s.log.Warn("sync operation kind cannot be properly resolved", t.log()...)
 			continue
 		}
 		op := model.NewOperation(opKind)
+		op.Reverse = reverse
 		t.EntryInfo.OperationPtr = op
+		scheduled := *op // the map keeps its own copy of the operation, because the worker changes the task's one
+		t.inFlight = &s.inFlight
+		t.trace = span.Context()
+		s.inFlight.Add(1)
 		select {
 		case <-childCtx.Done():
+			s.inFlight.Done()
 			// if timeout is exceeded we don't consider that as an error,
 			// because we'll be back to this method on the next sync cycle
 			if !s.settings.Once && errors.Is(childCtx.Err(), context.DeadlineExceeded) {
@@ -119,11 +187,59 @@ func (s *taskScheduler) scheduleOnce(ctx context.Context) error {
 			}
 			return childCtx.Err()
 		case s.queue <- t: // enqueue new task with a scheduled operation inside to the queue of tasks
-			s.entriesMap.UpdateValueByKey(t.Path, func(entry *model.EntryInfo) { entry.SetOperation(op) })
+			s.entriesMap.UpdateValueByKey(t.Path, func(entry *model.EntryInfo) { entry.SetOperation(&scheduled) })
+			s.metrics.plan(&t.EntryInfo)
 			s.log.Debug("new task enqueued by scheduler", t.log()...)
+			enqueued++
 			t.setReady() // tell the worker that task is ready for processing
 		}
 	}
 
 	return nil
 }
+
+//resolveOperation resolves the kind of the task's operation and (in the two-way mode) its direction:
+//it returns true, if the operation is from the copy to the source.
+func (s *taskScheduler) resolveOperation(t *Task) (model.OperationKind, bool) {
+	if s.base == nil {
+		return resolveOperationKind(s.settings, &t.EntryInfo), false
+	}
+	base, hasBase := s.base.Get(t.Path)
+	switch twoway.Resolve(&t.EntryInfo, base, hasBase, s.settings.ConflictPolicy) {
+	case twoway.Reverse:
+		swapped := t.EntryInfo.Swapped()
+		return resolveOperationKind(s.settings, &swapped), true
+	case twoway.KeepConflict:
+		return model.OpKindKeepConflict, false
+	default:
+		return resolveOperationKind(s.settings, &t.EntryInfo), false
+	}
+}
+
+//isSyncRequired extends EntryInfo.IsSyncRequired with the dirs metadata comparison (if it's turned on in the settings).
+func isSyncRequired(stg settings.Settings, entry *model.EntryInfo) bool {
+	return entry.IsSyncRequired() || (stg.SyncDirMeta && entry.IsDirMetaSyncRequired(stg.SyncPerms))
+}
+
+//resolveOperationKind extends EntryInfo.ResolveOperationKind with the dirs metadata sync operation
+//(if it's turned on in the settings).
+func resolveOperationKind(stg settings.Settings, entry *model.EntryInfo) model.OperationKind {
+	if opKind := entry.ResolveOperationKind(); opKind != model.OpKindNone {
+		return opKind
+	}
+	if stg.SyncDirMeta && entry.IsDirMetaSyncRequired(stg.SyncPerms) {
+		return model.OpKindSyncDirMeta
+	}
+	return model.OpKindNone
+}
+
+//parentDirsOf returns the set of all parent dirs (relative paths) of the given relative paths.
+func parentDirsOf(paths []string) map[string]bool {
+	dirs := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		for dir := filepath.Dir(path); dir != "." && !dirs[dir]; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,95 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/log"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys"
+	"dsync/pkg/fsys/sftpfs/sftptest"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerWithSFTPCopyDirByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	server, err := sftptest.NewServer(t.TempDir())
+	requires.NoError(err)
+	defer server.Close()
+
+	srcDir, remoteDir := t.TempDir(), t.TempDir()
+	modTime := time.Now().Add(-time.Hour) // it has nanoseconds, which are lost over SFTP
+	writeFileWithModTime(requires, srcDir, "a.txt", "a", modTime)
+	writeFileWithModTime(requires, srcDir, filepath.Join("sub", "deep", "b.txt"), "b", modTime)
+	requires.NoError(os.MkdirAll(filepath.Join(srcDir, "empty"), os.ModePerm))
+	writeFileWithModTime(requires, remoteDir, "obsolete.txt", "x", modTime)            // it has to be removed
+	writeFileWithModTime(requires, remoteDir, "a.txt", "old", modTime.Add(-time.Hour)) // it has to be replaced
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:           srcDir,
+		CopyDir:          server.URL("tester", remoteDir),
+		ScanPeriod:       time.Second,
+		IncludeEmptyDirs: true,
+		Once:             true,
+		WorkersCount:     2,
+		Verify:           true,
+		SyncPerms:        true,
+		SSHKeyPath:       server.KeyPath,
+		KnownHostsPath:   server.KnownHostsPath,
+	}
+	requires.NoError(stg.Validate())
+	runOnce := func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		return New(loggerMock, stg).Start(ctx, cancel)
+	}
+
+	// 2. act
+	requires.NoError(runOnce())
+
+	// 3. assert
+	requireFileContent(requires, remoteDir, "a.txt", "a")
+	requireFileContent(requires, remoteDir, filepath.Join("sub", "deep", "b.txt"), "b")
+	requires.DirExists(filepath.Join(remoteDir, "empty"))
+	requires.NoFileExists(filepath.Join(remoteDir, "obsolete.txt"))
+	info, err := os.Stat(filepath.Join(remoteDir, "a.txt"))
+	requires.NoError(err)
+	requires.True(info.ModTime().Equal(modTime.Truncate(time.Second)))
+
+	// the second run finds nothing to sync, despite the lost precision of the modification times,
+	// and it reconnects, as the server has dropped the previous connection
+	requireInSyncWithRemote(requires, loggerMock, stg)
+	server.DropConnections()
+	writeFileWithModTime(requires, srcDir, "a.txt", "new", modTime.Add(time.Minute))
+	requires.NoError(runOnce())
+	requireFileContent(requires, remoteDir, "a.txt", "new")
+	requireInSyncWithRemote(requires, loggerMock, stg)
+
+	// the unavailable server fails the run
+	requires.NoError(server.Close())
+	requires.ErrorContains(runOnce(), "cannot connect")
+}
+
+func requireInSyncWithRemote(req *require.Assertions, logger log.Logger, stg settings.Settings) {
+	target, err := openCopyTarget(stg)
+	req.NoError(err)
+	defer target.fs.(io.Closer).Close()
+	eMap := model.NewDirEntriesMap()
+	req.NoError(newDirScanner(logger, stg, eMap, fsys.Local{}, target).scanOnce(context.Background()))
+	req.NoError(eMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+		entry := eMap[key]
+		req.False(isSyncRequired(stg, &entry), key)
+		return nil
+	}))
+}
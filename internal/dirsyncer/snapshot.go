@@ -0,0 +1,204 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/log"
+	"dsync/internal/model"
+	"dsync/internal/snapshots"
+	"dsync/pkg/fsys"
+	"dsync/pkg/helpers/iout"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//takeSnapshot makes the new point-in-time snapshot of the source dir inside the copy dir.
+//The source dir is compared with the previous snapshot (as if it was the copy dir), and the files, that haven't
+//changed since then, are hard-linked to the previous snapshot, while other files are copied.
+func (d *DirSyncer) takeSnapshot(ctx context.Context) error {
+	store := snapshots.NewStore(d.settings.CopyDir)
+	prev, hasPrev, err := store.Latest()
+	if err != nil {
+		return err
+	}
+	partialPath, err := store.Begin()
+	if err != nil {
+		return err
+	}
+
+	scanSettings := d.settings
+	scanSettings.CopyDir = partialPath // it's empty, so everything will be copied, if there is no previous snapshot
+	if hasPrev {
+		scanSettings.CopyDir = prev.FullPath
+	}
+	eMap := model.NewDirEntriesMap()
+	scanner := newDirScanner(d.log, scanSettings, eMap, fsys.Local{}, localCopyTarget(scanSettings.CopyDir))
+	if err := scanner.scanOnce(ctx); err != nil {
+		return err
+	}
+
+	entries := make(map[string]model.EntryInfo)
+	_ = eMap.ForEach(func(key string, eMap map[string]model.EntryInfo) error {
+		if entry := eMap[key]; entry.SrcPathInfo.Exists {
+			entries[key] = entry
+		}
+		return nil
+	})
+
+	var copied, linked int64
+	snapshotFn := func(ctx context.Context, path string, entry model.EntryInfo) error {
+		wasLinked, err := d.snapshotEntry(ctx, partialPath, path, &entry)
+		if err != nil {
+			return fmt.Errorf("cannot snapshot entry %q: %w", path, err)
+		}
+		if wasLinked {
+			atomic.AddInt64(&linked, 1)
+		} else if entry.SrcPathInfo.IsFile() {
+			atomic.AddInt64(&copied, 1)
+		}
+		return nil
+	}
+	if err := d.forEachEntryConcurrently(ctx, entries, snapshotFn); err != nil {
+		return err
+	}
+	if d.settings.SyncDirMeta {
+		// dirs metadata is set after all their children are in place
+		if err := syncSnapshotDirsMeta(partialPath, entries, d.settings.SyncPerms); err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := store.Commit(partialPath)
+	if err != nil {
+		return err
+	}
+	d.log.Info("snapshot taken",
+		log.String("snapshot", snapshot.FullPath), log.Int64("copied", copied), log.Int64("linked", linked))
+
+	removed, err := store.Prune(d.settings.Retention)
+	for _, s := range removed {
+		d.log.Info("obsolete snapshot removed", log.String("snapshot", s.FullPath))
+	}
+	return err
+}
+
+//snapshotEntry puts the source entry into the snapshot dir. It returns true, if the file was hard-linked
+//to the previous snapshot (instead of copying).
+func (d *DirSyncer) snapshotEntry(ctx context.Context, snapshotDir, path string, entry *model.EntryInfo) (bool, error) {
+	src, prev := entry.SrcPathInfo, entry.CopyPathInfo
+	dst := filepath.Join(snapshotDir, path)
+	switch {
+	case src.IsDir:
+		// non-empty dirs are made automatically as a part of files full path
+		if d.settings.IncludeEmptyDirs {
+			return false, iout.EnsureDirExists(ctx, dst)
+		}
+		return false, nil
+	case src.IsSpecial():
+		return false, iout.CreateSpecial(ctx, dst, src.Mode, src.Rdev, src.ModTime)
+	case prev.Exists && prev.IsFile() && !entry.IsSyncRequired() &&
+		(!d.settings.SyncPerms || src.Mode.Perm() == prev.Mode.Perm()):
+		// the file hasn't changed since the previous snapshot, and the hard link shares its permissions as well
+		return true, iout.LinkFile(ctx, prev.FullPath, dst)
+	default:
+		opts := []iout.CopyOption{iout.WithVerification(d.settings.Verify)}
+		if err := iout.CopyFile(ctx, src.FullPath, dst, src.ModTime, opts...); err != nil {
+			return false, err
+		}
+		if d.settings.SyncPerms {
+			if err := os.Chmod(dst, src.Mode.Perm()); err != nil {
+				return false, fmt.Errorf("cannot set permissions: %w", err)
+			}
+		}
+		return false, nil
+	}
+}
+
+//forEachEntryConcurrently calls fn for each entry in the pool of workers. It stops on the first error.
+func (d *DirSyncer) forEachEntryConcurrently(
+	parentCtx context.Context,
+	entries map[string]model.EntryInfo,
+	fn func(ctx context.Context, path string, entry model.EntryInfo) error,
+) error {
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	paths := make(chan string)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < d.settings.WorkersCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if d.limiter != nil {
+					if err := d.limiter.Acquire(ctx, d.settings.JobName, d.settings.CopyDir); err != nil {
+						return // ctx is done
+					}
+				}
+				err := fn(ctx, path, entries[path])
+				if d.limiter != nil {
+					d.limiter.Release()
+				}
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+loop:
+	for path := range entries {
+		select {
+		case <-ctx.Done():
+			break loop
+		case paths <- path:
+		}
+	}
+	close(paths)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return parentCtx.Err()
+}
+
+//syncSnapshotDirsMeta sets the dirs modTime (and permissions, if withMode is true) in the snapshot,
+//the deepest dirs go first.
+func syncSnapshotDirsMeta(snapshotDir string, entries map[string]model.EntryInfo, withMode bool) error {
+	var dirs []string
+	for path, entry := range entries {
+		if entry.SrcPathInfo.IsDir {
+			dirs = append(dirs, path)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+	})
+	for _, dir := range dirs {
+		fullPath := filepath.Join(snapshotDir, dir)
+		if _, err := os.Lstat(fullPath); err != nil {
+			continue // the empty dir isn't in the snapshot, if empty dirs are not included
+		}
+		src := entries[dir].SrcPathInfo
+		mode := src.Mode
+		if !withMode {
+			mode = 0
+		}
+		if err := iout.SetMeta(fullPath, src.ModTime, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
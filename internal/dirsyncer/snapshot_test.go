@@ -0,0 +1,79 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/log"
+	"dsync/internal/settings"
+	"dsync/internal/snapshots"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerTakingSnapshots(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	_ = os.Chdir("testdata")
+	wd, _ := os.Getwd()
+	srcDir, err := os.MkdirTemp(wd, "src")
+	requires.NoError(err)
+	defer os.RemoveAll(srcDir)
+	copyDir, err := os.MkdirTemp(wd, "copy")
+	requires.NoError(err)
+	defer os.RemoveAll(copyDir)
+
+	copyFileIntoDir(requires, filepath.Join(wd, "src"), "old_file.txt", srcDir)
+	copyFileIntoDir(requires, filepath.Join(wd, "src"), "subdir1/new_file.txt", srcDir)
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:       srcDir,
+		CopyDir:      copyDir,
+		ScanPeriod:   time.Second,
+		LogLevel:     log.DebugLevel,
+		LogToStd:     true,
+		Once:         true,
+		WorkersCount: 2,
+		SyncDirMeta:  true,
+		Snapshots:    true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 2. act: take the first snapshot, then change one file and take the second one
+	requires.NoError(New(loggerMock, stg).Start(ctx, cancel))
+	changedModTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	requires.NoError(os.Chtimes(filepath.Join(srcDir, "subdir1/new_file.txt"), changedModTime, changedModTime))
+	requires.NoError(New(loggerMock, stg).Start(ctx, cancel))
+
+	// 3. assert
+	taken, err := snapshots.NewStore(copyDir).List()
+	requires.NoError(err)
+	requires.Len(taken, 2)
+	second, first := taken[0].FullPath, taken[1].FullPath
+
+	target, err := os.Readlink(filepath.Join(copyDir, snapshots.LatestLink))
+	requires.NoError(err)
+	requires.Equal(taken[0].Name, target)
+
+	requireSameFiles := func(path string, wantSame bool) {
+		firstInfo, err := os.Stat(filepath.Join(first, path))
+		requires.NoError(err)
+		secondInfo, err := os.Stat(filepath.Join(second, path))
+		requires.NoError(err)
+		requires.Equal(wantSame, os.SameFile(firstInfo, secondInfo), path)
+	}
+	requireSameFiles("old_file.txt", true)          // unchanged file is hard-linked
+	requireSameFiles("subdir1/new_file.txt", false) // changed file is copied
+
+	info, err := os.Stat(filepath.Join(second, "subdir1/new_file.txt"))
+	requires.NoError(err)
+	requires.True(info.ModTime().Equal(changedModTime))
+}
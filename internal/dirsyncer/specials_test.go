@@ -0,0 +1,86 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/log"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/pkg/fsys"
+	"dsync/pkg/helpers/iout"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerWithSpecials(t *testing.T) {
+	tests := []struct {
+		name         string
+		syncSpecials bool
+		wantSkipped  int
+	}{
+		{name: "specials synced", syncSpecials: true, wantSkipped: 0},
+		{name: "specials skipped", syncSpecials: false, wantSkipped: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requires := require.New(t)
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			// 1. arrange
+			_ = os.Chdir("testdata")
+			wd, _ := os.Getwd()
+			srcDir, err := os.MkdirTemp(wd, "src")
+			requires.NoError(err)
+			defer os.RemoveAll(srcDir)
+			copyDir, err := os.MkdirTemp(wd, "copy")
+			requires.NoError(err)
+			defer os.RemoveAll(copyDir)
+
+			fifoPath := filepath.Join(srcDir, "subdir/fifo")
+			requires.NoError(iout.CreateSpecial(context.Background(), fifoPath, fs.ModeNamedPipe|0644, 0, time.Now()))
+
+			loggerMock := getMockLogger(mockCtrl, gomock.Any())
+			stg := settings.Settings{
+				SrcDir:       srcDir,
+				CopyDir:      copyDir,
+				ScanPeriod:   time.Second,
+				LogLevel:     log.DebugLevel,
+				LogToStd:     true,
+				Once:         true,
+				WorkersCount: 1,
+				SyncSpecials: tt.syncSpecials,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// 2. act
+			err = New(loggerMock, stg).Start(ctx, cancel)
+
+			// 3. assert
+			requires.NoError(err)
+
+			dirEntriesMap := model.NewDirEntriesMap()
+			scanner := newDirScanner(loggerMock, stg, dirEntriesMap, fsys.Local{}, localCopyTarget(stg.CopyDir))
+			requires.NoError(scanner.scanOnce(context.Background()))
+			requires.Equal(tt.wantSkipped, scanner.skippedSpecials)
+
+			info, err := os.Lstat(filepath.Join(copyDir, "subdir/fifo"))
+			if tt.syncSpecials {
+				requires.NoError(err)
+				requires.Equal(fs.ModeNamedPipe, info.Mode().Type())
+			} else {
+				requires.ErrorIs(err, fs.ErrNotExist)
+			}
+		})
+	}
+}
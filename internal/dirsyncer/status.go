@@ -0,0 +1,230 @@
+package dirsyncer
+
+import (
+	"dsync/internal/model"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//ScanStats are the results of the last walk through the dir.
+type ScanStats struct {
+	StartedAt time.Time     `json:"startedAt"`
+	Duration  time.Duration `json:"duration"`
+	Files     int           `json:"files"` // the number of the non-dir entries
+	Dirs      int           `json:"dirs"`
+	Bytes     int64         `json:"bytes"` // the total size of the files
+}
+
+func (s *ScanStats) add(pi model.PathInfo) {
+	if pi.IsDir {
+		s.Dirs++
+		return
+	}
+	s.Files++
+	s.Bytes += pi.Size
+}
+
+//JobStatus is the synchronization state of one sync job (or of the only DirSyncer, if there are no jobs).
+type JobStatus struct {
+	Job          string              `json:"job,omitempty"`
+	SrcDir       string              `json:"srcDir"`
+	Running      bool                `json:"running"`            // false, until the sync is started
+	LastScan     *ScanStats          `json:"lastScan,omitempty"` // the last scan of the source dir
+	Destinations []DestinationReport `json:"destinations"`
+}
+
+//DestinationReport is the state of one copy dir along with the counts of its operations by their statuses.
+type DestinationReport struct {
+	DestinationStatus
+	LastScan   *ScanStats                    `json:"lastScan,omitempty"` // the last scan of the copy dir
+	Entries    int                           `json:"entries"`            // the number of the entries in the map
+	Operations map[model.OperationStatus]int `json:"operations"`
+}
+
+//OperationReport is the operation of the entry in the copy dir.
+type OperationReport struct {
+	Job       string           `json:"job,omitempty"`
+	CopyDir   string           `json:"copyDir"`
+	Path      string           `json:"path"`
+	Operation *model.Operation `json:"operation"`
+}
+
+//EntryReport is the entry's info in the entries map of the copy dir.
+type EntryReport struct {
+	Job     string          `json:"job,omitempty"`
+	CopyDir string          `json:"copyDir"`
+	Path    string          `json:"path"`
+	Entry   model.EntryInfo `json:"entry"`
+}
+
+func (d *DirSyncer) setRunning(srcScanner *dirScanner, dests []*destination) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.srcScanner, d.dests = srcScanner, dests
+}
+
+func (d *DirSyncer) running() (*dirScanner, []*destination) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.srcScanner, d.dests
+}
+
+//status returns the job's state. The entries maps are locked only to count the operations.
+func (d *DirSyncer) status() JobStatus {
+	srcScanner, dests := d.running()
+	status := JobStatus{Job: d.settings.JobName, SrcDir: d.settings.SrcDir, Running: srcScanner != nil,
+		Destinations: []DestinationReport{}}
+	if srcScanner == nil {
+		return status
+	}
+	status.LastScan = srcScanner.lastScanStats()
+	for _, dst := range dests {
+		report := DestinationReport{
+			DestinationStatus: dst.getStatus(),
+			LastScan:          dst.scanner.lastScanStats(),
+			Entries:           dst.eMap.Len(),
+			Operations:        make(map[model.OperationStatus]int),
+		}
+		dst.eMap.Collect(func(_ string, entry *model.EntryInfo) bool {
+			if entry.OperationPtr != nil {
+				report.Operations[entry.OperationPtr.Status]++
+			}
+			return false
+		})
+		status.Destinations = append(status.Destinations, report)
+	}
+	return status
+}
+
+//statusAPI serves the JSON endpoints with the state of the running jobs:
+//   - /status is the state of each job and its copy dirs (including the last scans' durations and counts);
+//   - /operations lists the operations, optionally filtered by the status (the repeated "status" parameter,
+//     the scheduled, in progress and failed ones by default), the "job" and the "copyDir";
+//   - /entries?path=relative/path returns the entry's info in each copy dir (optionally filtered in the same way).
+type statusAPI struct {
+	jobs      []*DirSyncer
+	startedAt time.Time
+}
+
+//newHTTPHandler returns the handler of the status API endpoints and of the /metrics one (see metricsHandler).
+func newHTTPHandler(jobs []*DirSyncer) http.Handler {
+	api := &statusAPI{jobs: jobs, startedAt: time.Now()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", api.handleStatus)
+	mux.HandleFunc("/operations", api.handleOperations)
+	mux.HandleFunc("/entries", api.handleEntries)
+	mux.Handle("/metrics", metricsHandler{jobs: jobs})
+	return mux
+}
+
+//HTTPHandler returns the handler of the HTTP status API (see statusAPI) and of the Prometheus metrics.
+func (d *DirSyncer) HTTPHandler() http.Handler {
+	return newHTTPHandler([]*DirSyncer{d})
+}
+
+func (api *statusAPI) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !isGet(w, r) {
+		return
+	}
+	jobs := make([]JobStatus, 0, len(api.jobs))
+	for _, job := range api.jobs {
+		jobs = append(jobs, job.status())
+	}
+	writeJSON(w, http.StatusOK, struct {
+		StartedAt time.Time   `json:"startedAt"`
+		Uptime    string      `json:"uptime"`
+		Jobs      []JobStatus `json:"jobs"`
+	}{api.startedAt, time.Since(api.startedAt).Truncate(time.Second).String(), jobs})
+}
+
+func (api *statusAPI) handleOperations(w http.ResponseWriter, r *http.Request) {
+	if !isGet(w, r) {
+		return
+	}
+	statuses := make(map[model.OperationStatus]bool)
+	for _, status := range r.URL.Query()["status"] {
+		statuses[model.OperationStatus(status)] = true
+	}
+	if len(statuses) == 0 {
+		statuses = map[model.OperationStatus]bool{
+			model.OpStatusScheduled: true, model.OpStatusInProgress: true, model.OpStatusFailed: true,
+		}
+	}
+	reports := []OperationReport{}
+	api.forEachDestination(r, func(job *DirSyncer, dst *destination) {
+		entries := dst.eMap.Collect(func(_ string, entry *model.EntryInfo) bool {
+			return entry.OperationPtr != nil && statuses[entry.OperationPtr.Status]
+		})
+		for path, entry := range entries {
+			reports = append(reports, OperationReport{Job: job.settings.JobName, CopyDir: dst.settings.CopyDir,
+				Path: filepath.ToSlash(path), Operation: entry.OperationPtr})
+		}
+	})
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].CopyDir != reports[j].CopyDir {
+			return reports[i].CopyDir < reports[j].CopyDir
+		}
+		return reports[i].Path < reports[j].Path
+	})
+	writeJSON(w, http.StatusOK, reports)
+}
+
+func (api *statusAPI) handleEntries(w http.ResponseWriter, r *http.Request) {
+	if !isGet(w, r) {
+		return
+	}
+	path := strings.Trim(r.URL.Query().Get("path"), "/")
+	if path == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "path parameter is required"})
+		return
+	}
+	reports := []EntryReport{}
+	api.forEachDestination(r, func(job *DirSyncer, dst *destination) {
+		if entry, ok := dst.eMap.Get(filepath.FromSlash(path)); ok {
+			reports = append(reports, EntryReport{Job: job.settings.JobName, CopyDir: dst.settings.CopyDir,
+				Path: path, Entry: entry})
+		}
+	})
+	if len(reports) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "entry is not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+//forEachDestination calls fn for each copy dir of the running jobs, that matches the "job" and "copyDir" parameters.
+func (api *statusAPI) forEachDestination(r *http.Request, fn func(job *DirSyncer, dst *destination)) {
+	jobName, copyDir := r.URL.Query().Get("job"), r.URL.Query().Get("copyDir")
+	for _, job := range api.jobs {
+		if jobName != "" && job.settings.JobName != jobName {
+			continue
+		}
+		_, dests := job.running()
+		for _, dst := range dests {
+			if copyDir == "" || dst.settings.CopyDir == copyDir {
+				fn(job, dst)
+			}
+		}
+	}
+}
+
+func isGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method is not allowed"})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,131 @@
+package dirsyncer
+
+import (
+	"bytes"
+	"dsync/internal/model"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncer_HTTPHandler(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS := prepareMemDirs(requires)
+	syncer := newMemDirSyncer(getMockLogger(mockCtrl, gomock.Any()), memSettings(), src, copyFS)
+	srv := httptest.NewServer(syncer.HTTPHandler())
+	defer srv.Close()
+	get := func(path string, wantCode int, v interface{}) {
+		resp, err := http.Get(srv.URL + path)
+		requires.NoError(err)
+		defer resp.Body.Close()
+		requires.Equal(wantCode, resp.StatusCode, path)
+		requires.Equal("application/json", resp.Header.Get("Content-Type"))
+		requires.NoError(json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	// 2. act & assert: the sync is not started yet
+	var status struct {
+		Jobs []JobStatus `json:"jobs"`
+	}
+	get("/status", http.StatusOK, &status)
+	requires.Len(status.Jobs, 1)
+	requires.False(status.Jobs[0].Running)
+	requires.Empty(status.Jobs[0].Destinations)
+
+	// the sync is done
+	requires.NoError(runOnce(syncer))
+	get("/status", http.StatusOK, &status)
+	job := status.Jobs[0]
+	requires.True(job.Running)
+	requires.Equal("/src", job.SrcDir)
+	requires.NotNil(job.LastScan)
+	requires.Equal(1, job.LastScan.Files)
+	requires.Equal(1, job.LastScan.Dirs)
+	requires.Equal(int64(3), job.LastScan.Bytes)
+	requires.Len(job.Destinations, 1)
+	requires.Equal("/copy", job.Destinations[0].CopyDir)
+	requires.Equal(2, job.Destinations[0].Operations[model.OpStatusCompleted])
+
+	// nothing is pending after the sync, but the completed operations can be requested explicitly
+	var ops []OperationReport
+	get("/operations", http.StatusOK, &ops)
+	requires.Empty(ops)
+	get("/operations?status=completed&copyDir=/copy", http.StatusOK, &ops)
+	requires.Len(ops, 2)
+	requires.Equal("dir/a.txt", ops[0].Path)
+	requires.Equal(model.OpStatusCompleted, ops[0].Operation.Status)
+	get("/operations?status=completed&copyDir=/other", http.StatusOK, &ops)
+	requires.Empty(ops)
+
+	var entries []EntryReport
+	get("/entries?path=dir/a.txt", http.StatusOK, &entries)
+	requires.Len(entries, 1)
+	requires.Equal(int64(3), entries[0].Entry.SrcPathInfo.Size)
+	var errResp map[string]string
+	get("/entries?path=nope.txt", http.StatusNotFound, &errResp)
+	get("/entries", http.StatusBadRequest, &errResp)
+
+	resp, err := http.Post(srv.URL+"/status", "application/json", nil)
+	requires.NoError(err)
+	resp.Body.Close()
+	requires.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+//TestDirSyncer_HTTPHandlerDuringSync polls the status API while the workers are changing the operations
+//(it makes sense with the race detector).
+func TestDirSyncer_HTTPHandlerDuringSync(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS := prepareMemDirs(requires)
+	modTime := time.Now().Add(-time.Hour)
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	for i := 0; i < 300; i++ {
+		requires.NoError(src.WriteFile(fmt.Sprintf("/src/dir%d/%d.txt", i%10, i), content, modTime))
+	}
+	stg := memSettings()
+	stg.WorkersCount, stg.Verify = 4, true
+	syncer := newMemDirSyncer(getMockLogger(mockCtrl, gomock.Any()), stg, src, copyFS)
+	srv := httptest.NewServer(syncer.HTTPHandler())
+	defer srv.Close()
+
+	// 2. act & assert
+	done := make(chan error, 1)
+	go func() { done <- runOnce(syncer) }()
+	for polling := true; polling; {
+		select {
+		case err := <-done:
+			requires.NoError(err)
+			polling = false
+		default:
+		}
+		for _, path := range []string{"/status", "/operations?status=scheduled&status=in_progress&status=completed"} {
+			resp, err := http.Get(srv.URL + path)
+			requires.NoError(err)
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			requires.NoError(err)
+			requires.Equal(http.StatusOK, resp.StatusCode, path)
+		}
+	}
+
+	var ops []OperationReport
+	resp, err := http.Get(srv.URL + "/operations?status=completed")
+	requires.NoError(err)
+	defer resp.Body.Close()
+	requires.NoError(json.NewDecoder(resp.Body).Decode(&ops))
+	requires.Len(ops, 302) // along with the copied and removed files of the fixture
+}
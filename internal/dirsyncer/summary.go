@@ -0,0 +1,157 @@
+package dirsyncer
+
+import (
+	"dsync/internal/model"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+//Counts are the numbers of the finished operations by their results.
+type Counts struct {
+	Copied   int64 `json:"copied"`   // the new files and dirs
+	Replaced int64 `json:"replaced"` // the changed files
+	Removed  int64 `json:"removed"`
+	Updated  int64 `json:"updated"` // the dirs' metadata and the kept conflicting copies
+	Skipped  int64 `json:"skipped"` // the canceled operations (e.g. the entry is in sync already, when it's processed)
+	Failed   int64 `json:"failed"`
+	Bytes    int64 `json:"bytes"` // copied
+}
+
+func (c *Counts) add(other Counts) {
+	c.Copied += other.Copied
+	c.Replaced += other.Replaced
+	c.Removed += other.Removed
+	c.Updated += other.Updated
+	c.Skipped += other.Skipped
+	c.Failed += other.Failed
+	c.Bytes += other.Bytes
+}
+
+//Changes returns the number of the changes made in the copy dirs.
+func (c Counts) Changes() int64 {
+	return c.Copied + c.Replaced + c.Removed + c.Updated
+}
+
+func (c Counts) String() string {
+	return fmt.Sprintf("%d copied, %d replaced, %d removed, %d updated, %d skipped, %d failed, %s",
+		c.Copied, c.Replaced, c.Removed, c.Updated, c.Skipped, c.Failed, formatBytes(c.Bytes))
+}
+
+//counts returns the numbers of the operations, which are over.
+func (m *opMetrics) counts() Counts {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := Counts{Bytes: m.bytes}
+	for outcome, n := range m.outcomes {
+		switch outcome.status {
+		case model.OpStatusFailed:
+			c.Failed += n
+			continue
+		case model.OpStatusCanceled:
+			c.Skipped += n
+			continue
+		}
+		switch outcome.kind {
+		case model.OpKindCopyFile, model.OpKindCopyDir, model.OpKindCopySpecial:
+			c.Copied += n
+		case model.OpKindReplaceFile, model.OpKindReplaceDirWithFile:
+			c.Replaced += n
+		case model.OpKindRemoveFile, model.OpKindRemoveDir:
+			c.Removed += n
+		default:
+			c.Updated += n
+		}
+	}
+	return c
+}
+
+//CopyDirSummary is the result of the sync of one copy dir.
+type CopyDirSummary struct {
+	Job     string `json:"job,omitempty"`
+	CopyDir string `json:"copyDir"`
+	Counts
+}
+
+//Summary is the result of the run (it's meaningful for the one-shot sync).
+type Summary struct {
+	StartedAt time.Time        `json:"startedAt"`
+	Duration  time.Duration    `json:"duration"`
+	Error     string           `json:"error,omitempty"` // the fatal error, which has stopped the run
+	Counts                     // the totals of all the copy dirs
+	CopyDirs  []CopyDirSummary `json:"copyDirs"`
+}
+
+func newSummary(jobs []*DirSyncer) Summary {
+	s := Summary{CopyDirs: []CopyDirSummary{}}
+	var finishedAt time.Time
+	for _, job := range jobs {
+		job.mu.Lock()
+		startedAt, jobFinishedAt := job.startedAt, job.finishedAt
+		job.mu.Unlock()
+		if s.StartedAt.IsZero() || startedAt.Before(s.StartedAt) {
+			s.StartedAt = startedAt
+		}
+		if jobFinishedAt.After(finishedAt) {
+			finishedAt = jobFinishedAt
+		}
+		_, dests := job.running()
+		for _, dst := range dests {
+			counts := dst.executor.metrics.counts()
+			s.Counts.add(counts)
+			s.CopyDirs = append(s.CopyDirs, CopyDirSummary{Job: job.settings.JobName, CopyDir: dst.settings.CopyDir,
+				Counts: counts})
+		}
+	}
+	if !s.StartedAt.IsZero() && !finishedAt.IsZero() {
+		s.Duration = finishedAt.Sub(s.StartedAt)
+	}
+	return s
+}
+
+//Summary returns the result of the last run (Start).
+func (d *DirSyncer) Summary() Summary {
+	return newSummary([]*DirSyncer{d})
+}
+
+func (d *DirSyncer) setStarted() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.startedAt, d.finishedAt = time.Now(), time.Time{}
+}
+
+func (d *DirSyncer) setFinished() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.finishedAt = time.Now()
+}
+
+//String returns the human-readable summary: the totals and (if there are several copy dirs) the ones of each copy dir.
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary: %s in %s", s.Counts, s.Duration.Truncate(time.Millisecond))
+	if len(s.CopyDirs) > 1 {
+		for _, c := range s.CopyDirs {
+			name := c.CopyDir
+			if c.Job != "" {
+				name = c.Job + ": " + name
+			}
+			fmt.Fprintf(&b, "\n  %s: %s", name, c.Counts)
+		}
+	}
+	return b.String()
+}
+
+//WriteFile writes the summary as JSON.
+func (s Summary) WriteFile(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("cannot write the report: %w", err)
+	}
+	return nil
+}
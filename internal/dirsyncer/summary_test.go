@@ -0,0 +1,64 @@
+package dirsyncer
+
+import (
+	"dsync/internal/model"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpMetrics_Counts(t *testing.T) {
+	m := newOpMetrics()
+	m.outcomes = map[opOutcome]int64{
+		{kind: model.OpKindCopyFile, status: model.OpStatusCompleted}:    3,
+		{kind: model.OpKindCopyDir, status: model.OpStatusCompleted}:     1,
+		{kind: model.OpKindReplaceFile, status: model.OpStatusCompleted}: 2,
+		{kind: model.OpKindRemoveDir, status: model.OpStatusCompleted}:   1,
+		{kind: model.OpKindSyncDirMeta, status: model.OpStatusCompleted}: 4,
+		{kind: model.OpKindCopyFile, status: model.OpStatusCanceled}:     5,
+		{kind: model.OpKindCopyFile, status: model.OpStatusFailed}:       6,
+	}
+	m.bytes = 100
+	require.Equal(t, Counts{Copied: 4, Replaced: 2, Removed: 1, Updated: 4, Skipped: 5, Failed: 6, Bytes: 100},
+		m.counts())
+}
+
+func TestDirSyncer_Summary(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS := prepareMemDirs(requires)
+	modTime := time.Now().Add(-time.Hour)
+	requires.NoError(src.WriteFile("/src/b.txt", []byte("new"), modTime))
+	requires.NoError(copyFS.WriteFile("/copy/b.txt", []byte("old!"), modTime.Add(-time.Hour))) // it's replaced
+	syncer := newMemDirSyncer(getMockLogger(mockCtrl, gomock.Any()), memSettings(), src, copyFS)
+
+	// 2. act
+	err := runOnce(syncer)
+
+	// 3. assert
+	requires.NoError(err)
+	summary := syncer.Summary()
+	want := Counts{Copied: 1, Replaced: 1, Removed: 1, Bytes: 6}
+	requires.Equal(want, summary.Counts)
+	requires.Equal([]CopyDirSummary{{CopyDir: "/copy", Counts: want}}, summary.CopyDirs)
+	requires.Equal(int64(3), summary.Changes())
+	requires.Positive(summary.Duration)
+	requires.Contains(summary.String(), "Summary: 1 copied, 1 replaced, 1 removed, 0 updated, 0 skipped, 0 failed, 6 B")
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	requires.NoError(summary.WriteFile(path))
+	data, err := os.ReadFile(path)
+	requires.NoError(err)
+	var report map[string]interface{}
+	requires.NoError(json.Unmarshal(data, &report))
+	requires.Equal(float64(1), report["copied"])
+	requires.Len(report["copyDirs"], 1)
+}
@@ -0,0 +1,75 @@
+package dirsyncer
+
+import (
+	"bufio"
+	"dsync/internal/model"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+//exportedSpan is the line of the trace file.
+type exportedSpan struct {
+	Name         string                 `json:"name"`
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId"`
+	Attributes   map[string]interface{} `json:"attributes"`
+}
+
+func TestDirSyncerTracing(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	src, copyFS := prepareMemDirs(requires)
+	stg := memSettings()
+	stg.Trace = filepath.Join(t.TempDir(), "trace.jsonl")
+	syncer := newMemDirSyncer(getMockLogger(mockCtrl, gomock.Any()), stg, src, copyFS)
+
+	// 2. act
+	err := runOnce(syncer)
+
+	// 3. assert
+	requires.NoError(err)
+	requires.Nil(syncer.tracer) // the spans are exported on closing
+	file, err := os.Open(stg.Trace)
+	requires.NoError(err)
+	defer file.Close()
+	spans := make(map[string][]exportedSpan)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var span exportedSpan
+		requires.NoError(json.Unmarshal(scanner.Bytes(), &span))
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+	requires.Len(spans["sync cycle"], 1)
+	cycle := spans["sync cycle"][0]
+	requires.Empty(cycle.ParentSpanID)
+	for _, name := range []string{"walk source", "walk copy", "schedule"} {
+		requires.Len(spans[name], 1, name)
+		requires.Equal(cycle.TraceID, spans[name][0].TraceID, name)
+		requires.Equal(cycle.SpanID, spans[name][0].ParentSpanID, name)
+	}
+	requires.Equal(float64(1), spans["walk source"][0].Attributes["files"])
+	requires.Equal(float64(2), spans["schedule"][0].Attributes["tasks"])
+
+	requires.Len(spans["operation"], 2)
+	kinds := make(map[string]string)
+	for _, op := range spans["operation"] {
+		requires.Equal(cycle.TraceID, op.TraceID)
+		requires.Equal(spans["schedule"][0].SpanID, op.ParentSpanID)
+		requires.NotZero(op.Attributes["op.id"])
+		requires.Equal(string(model.OpStatusCompleted), op.Attributes["op.status"])
+		kinds[op.Attributes["path"].(string)] = op.Attributes["op.kind"].(string)
+	}
+	requires.Equal(map[string]string{
+		"dir/a.txt": string(model.OpKindCopyFile),
+		"old.txt":   string(model.OpKindRemoveFile),
+	}, kinds)
+}
@@ -0,0 +1,88 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/log"
+	"dsync/internal/settings"
+	"dsync/internal/twoway"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncerTwoWayByRunningOnce(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	srcDir, copyDir := t.TempDir(), t.TempDir()
+	t0 := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	writeFileWithModTime(requires, srcDir, "a.txt", "a", t0)
+	writeFileWithModTime(requires, srcDir, "shared.txt", "shared", t0)
+	writeFileWithModTime(requires, copyDir, "b.txt", "b", t0)
+	writeFileWithModTime(requires, copyDir, filepath.Join("d", "x.txt"), "x", t0)
+
+	loggerMock := getMockLogger(mockCtrl, gomock.Any())
+	stg := settings.Settings{
+		SrcDir:         srcDir,
+		CopyDir:        copyDir,
+		ScanPeriod:     time.Second,
+		LogLevel:       log.DebugLevel,
+		Once:           true,
+		WorkersCount:   2,
+		TwoWay:         true,
+		ConflictPolicy: twoway.PolicyKeepBoth,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 2. act: the first run merges both sides
+	requires.NoError(New(loggerMock, stg).Start(ctx, cancel))
+
+	// 3. assert
+	for _, dir := range []string{srcDir, copyDir} {
+		requireFileContent(requires, dir, "a.txt", "a")
+		requireFileContent(requires, dir, "b.txt", "b")
+		requireFileContent(requires, dir, "shared.txt", "shared")
+		requireFileContent(requires, dir, filepath.Join("d", "x.txt"), "x")
+	}
+	requires.FileExists(filepath.Join(copyDir, twoway.BaseFileName))
+	requires.NoFileExists(filepath.Join(srcDir, twoway.BaseFileName))
+
+	// 4. arrange: both sides are changed
+	requires.NoError(os.Remove(filepath.Join(copyDir, "b.txt")))
+	writeFileWithModTime(requires, copyDir, "a.txt", "a changed in copy", t0.Add(time.Hour))
+	writeFileWithModTime(requires, srcDir, "c.txt", "c", t0)
+	writeFileWithModTime(requires, srcDir, "shared.txt", "changed in source", t0.Add(time.Hour))
+	writeFileWithModTime(requires, copyDir, "shared.txt", "changed in copy", t0.Add(2*time.Hour))
+
+	// 5. act: the second run propagates the changes in both directions
+	requires.NoError(New(loggerMock, stg).Start(ctx, cancel))
+
+	// 6. assert
+	for _, dir := range []string{srcDir, copyDir} {
+		requireFileContent(requires, dir, "a.txt", "a changed in copy")
+		requires.NoFileExists(filepath.Join(dir, "b.txt"))
+		requireFileContent(requires, dir, "c.txt", "c")
+		requireFileContent(requires, dir, "shared.txt", "changed in source")
+		requireFileContent(requires, dir, "shared.txt.conflict", "changed in copy")
+	}
+}
+
+func writeFileWithModTime(req *require.Assertions, dir, path, content string, modTime time.Time) {
+	fullPath := filepath.Join(dir, path)
+	req.NoError(os.MkdirAll(filepath.Dir(fullPath), os.ModePerm))
+	req.NoError(os.WriteFile(fullPath, []byte(content), 0o644))
+	req.NoError(os.Chtimes(fullPath, modTime, modTime))
+}
+
+func requireFileContent(req *require.Assertions, dir, path, content string) {
+	data, err := os.ReadFile(filepath.Join(dir, path))
+	req.NoError(err)
+	req.Equal(content, string(data), path)
+}
@@ -0,0 +1,98 @@
+package dirsyncer
+
+import (
+	"context"
+	"dsync/internal/model"
+	"dsync/internal/settings"
+	"dsync/internal/webhook"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+//webhookReceiver is the local stand-in of the webhook URL, which collects the posted events.
+type webhookReceiver struct {
+	mu     sync.Mutex
+	events []webhook.Event
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var batch webhook.Batch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.events = append(rc.events, batch.Events...)
+}
+
+func TestDirSyncerWebhook(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// 1. arrange
+	rc := &webhookReceiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	src, copyFS := prepareMemDirs(requires)
+	stg := memSettings()
+	stg.SrcDir = "/missing" // the source dir doesn't exist, so the sync stops with the error
+	stg.Webhook = webhook.Config{URL: srv.URL, Delay: time.Minute}
+	syncer := newMemDirSyncer(getMockLogger(mockCtrl, gomock.Any()), stg, src, copyFS)
+
+	// 2. act
+	err := runOnce(syncer)
+
+	// 3. assert
+	requires.Error(err)
+	requires.Nil(syncer.webhook) // the pending events are posted without waiting for the delay
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	requires.Len(rc.events, 1)
+	requires.Equal(webhook.Stopped, rc.events[0].Kind)
+	requires.Equal(err.Error(), rc.events[0].Error)
+}
+
+func TestTaskExecutor_NotifyFailed(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	rc := &webhookReceiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	notifier := webhook.New(getMockLogger(mockCtrl, gomock.Any()), webhook.Config{URL: srv.URL})
+	e := &taskExecutor{settings: settings.Settings{JobName: "docs", CopyDir: "/copy"}, webhook: notifier}
+	now := time.Now()
+	entry := model.EntryInfo{
+		SrcPathInfo: model.PathInfo{Exists: true, Size: 3},
+		OperationPtr: &model.Operation{ID: 5, Kind: model.OpKindCopyFile, Status: model.OpStatusFailed,
+			FailedAt: &now, Error: "no space left on device"},
+	}
+	e.notifyFailed("dir/a.txt", &entry)
+	entry.OperationPtr.Status = model.OpStatusCompleted
+	e.notifyFailed("dir/b.txt", &entry) // only the failures are notified
+	notifier.Close(context.Background())
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	requires.Len(rc.events, 1)
+	event := rc.events[0]
+	requires.Equal(webhook.OpFailed, event.Kind)
+	requires.Equal("docs", event.Job)
+	requires.Equal("/copy", event.CopyDir)
+	requires.Equal("no space left on device", event.Error)
+	requires.Equal(uint64(5), event.Operation.ID)
+	requires.Equal("dir/a.txt", event.Operation.Path)
+	requires.Equal(int64(3), event.Operation.Size)
+}
@@ -0,0 +1,207 @@
+//Package hooks runs the external commands on the sync events, e.g. to reload a web server, when the files are synced.
+//The commands are run in background by a limited number of runners, so the sync workers are never blocked by them.
+package hooks
+
+import (
+	"bytes"
+	"dsync/internal/journal"
+	"dsync/internal/log"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultTimeout is how long the hook's command may run, before it's killed.
+	DefaultTimeout = 30 * time.Second
+	//DefaultConcurrency is the number of the hooks' commands, which may run at once.
+	DefaultConcurrency = 4
+
+	//queueCapacity is the number of the pending events, above which the new events are dropped.
+	queueCapacity = 1000
+	//maxOutputLen is the length of the command's output, which is logged, if the command fails.
+	maxOutputLen = 4 << 10
+)
+
+//Event is the name of the sync event, the hook is run on.
+type Event string
+
+const (
+	OpCompleted    Event = "on-op-completed"
+	OpFailed       Event = "on-op-failed"
+	CycleConverged Event = "on-cycle-converged" // the copy dir has got in sync with the source dir
+)
+
+//Events are all the events, the hooks can be set on.
+var Events = []Event{OpCompleted, OpFailed, CycleConverged}
+
+//Config defines the hooks' commands and how they are run.
+type Config struct {
+	Commands    map[Event]string // the shell commands by the events (the event without a command is ignored)
+	Timeout     time.Duration    // of each command
+	Concurrency int              // the maximum number of the commands run at once
+}
+
+//Payload is the event's details. It's passed to the command as JSON on stdin, and its fields are passed
+//as DSYNC_* env vars as well.
+type Payload struct {
+	Event     Event           `json:"event"`
+	Time      time.Time       `json:"time"`
+	Job       string          `json:"job,omitempty"`
+	CopyDir   string          `json:"copyDir"`
+	Operation *journal.Record `json:"operation,omitempty"` // is set only for the operations' events
+}
+
+func (p Payload) env() []string {
+	env := []string{"DSYNC_EVENT=" + string(p.Event), "DSYNC_JOB=" + p.Job, "DSYNC_COPY_DIR=" + p.CopyDir}
+	if op := p.Operation; op != nil {
+		env = append(env, "DSYNC_OP_ID="+strconv.FormatUint(op.ID, 10), "DSYNC_OP_KIND="+op.Kind,
+			"DSYNC_OP_STATUS="+op.Status, "DSYNC_PATH="+op.Path, "DSYNC_SIZE="+strconv.FormatInt(op.Size, 10),
+			"DSYNC_ERROR="+op.Error)
+	}
+	return env
+}
+
+//Runner runs the hooks' commands on the fired events. It's safe for concurrent use, and its methods do nothing,
+//if it's nil.
+type Runner struct {
+	log   log.Logger
+	cfg   Config
+	queue chan Payload
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+//NewRunner starts the runners of the commands. It returns nil, if there are no commands.
+func NewRunner(logger log.Logger, cfg Config) *Runner {
+	if len(cfg.Commands) == 0 {
+		return nil
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	r := &Runner{log: logger, cfg: cfg, queue: make(chan Payload, queueCapacity)}
+	for i := 0; i < cfg.Concurrency; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for p := range r.queue {
+				r.run(p)
+			}
+		}()
+	}
+	return r
+}
+
+//Handles reports whether the event has the command.
+func (r *Runner) Handles(event Event) bool {
+	return r != nil && r.cfg.Commands[event] != ""
+}
+
+//Fire enqueues the event's command. It never blocks: if too many commands are pending, then the event is dropped.
+func (r *Runner) Fire(p Payload) {
+	if !r.Handles(p.Event) {
+		return
+	}
+	if p.Time.IsZero() {
+		p.Time = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.queue <- p:
+	default:
+		r.log.Warn("hook is dropped, too many hooks are pending", log.String("event", string(p.Event)),
+			log.String("copyDir", p.CopyDir))
+	}
+}
+
+//Close awaits the pending commands. The events fired after that are ignored.
+func (r *Runner) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+func (r *Runner) run(p Payload) {
+	startedAt := time.Now()
+	output, err := r.exec(p)
+	if err != nil {
+		r.log.Warn("hook failed", log.String("event", string(p.Event)), log.Cause(err),
+			log.String("output", output))
+		return
+	}
+	r.log.Debug("hook succeeded", log.String("event", string(p.Event)), log.Duration("duration", time.Since(startedAt)))
+}
+
+//exec runs the event's command in the shell and returns its output (cut to maxOutputLen).
+func (r *Runner) exec(p Payload) (string, error) {
+	stdin, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", r.cfg.Commands[p.Event])
+	} else {
+		cmd = exec.Command("sh", "-c", r.cfg.Commands[p.Event])
+	}
+	output := &limitedBuffer{limit: maxOutputLen}
+	cmd.Env = append(os.Environ(), p.env()...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(append(stdin, '\n')), output, output
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	timer := time.NewTimer(r.cfg.Timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		// the whole group is killed, so that the command's children don't keep its output open
+		killProcessGroup(cmd)
+		<-done
+		err = fmt.Errorf("hook timed out after %s", r.cfg.Timeout)
+	}
+	return output.String(), err
+}
+
+//limitedBuffer keeps only the beginning of the written data.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if rest := b.limit - b.Len(); rest > 0 {
+		if len(p) > rest {
+			b.Buffer.Write(p[:rest])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil // the rest of the output is discarded, but the command must not fail because of it
+}
@@ -0,0 +1,105 @@
+package hooks
+
+import (
+	logmock "dsync/generated/mocks"
+	"dsync/internal/journal"
+	"dsync/internal/log"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are written for sh")
+	}
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	logger := logmock.NewMockLogger(mockCtrl)
+	logger.EXPECT().Debug("hook succeeded", gomock.Any(), gomock.Any()).Times(2)
+	logger.EXPECT().Warn("hook failed", gomock.Any(), gomock.Any(), gomock.Any())
+
+	dir := t.TempDir()
+	r := NewRunner(logger, Config{
+		Commands: map[Event]string{
+			OpCompleted:    `cat > "` + filepath.Join(dir, "completed.json") + `"`,
+			OpFailed:       `echo "$DSYNC_OP_STATUS $DSYNC_PATH $DSYNC_ERROR" > "` + filepath.Join(dir, "failed.txt") + `"`,
+			CycleConverged: `echo converged; exit 3`,
+		},
+		Timeout:     time.Second,
+		Concurrency: 2,
+	})
+	requires.True(r.Handles(OpFailed))
+	r.Fire(Payload{Event: OpCompleted, Job: "docs", CopyDir: "/copy", Operation: &journal.Record{ID: 7,
+		Path: "dir/a.txt", Kind: "copy file", Status: "completed", Size: 3}})
+	r.Fire(Payload{Event: OpFailed, CopyDir: "/copy", Operation: &journal.Record{ID: 8, Path: "b.txt",
+		Status: "failed", Error: "no space"}})
+	r.Fire(Payload{Event: CycleConverged, CopyDir: "/copy"})
+	r.Close()
+	r.Fire(Payload{Event: OpCompleted}) // it's ignored after Close
+
+	data, err := os.ReadFile(filepath.Join(dir, "completed.json"))
+	requires.NoError(err)
+	var p Payload
+	requires.NoError(json.Unmarshal(data, &p))
+	requires.Equal(OpCompleted, p.Event)
+	requires.Equal("docs", p.Job)
+	requires.Equal("dir/a.txt", p.Operation.Path)
+	requires.Equal(int64(3), p.Operation.Size)
+	requires.False(p.Time.IsZero())
+
+	data, err = os.ReadFile(filepath.Join(dir, "failed.txt"))
+	requires.NoError(err)
+	requires.Equal("failed b.txt no space", strings.TrimSpace(string(data)))
+}
+
+func TestRunner_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are written for sh")
+	}
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	logger := logmock.NewMockLogger(mockCtrl)
+	logger.EXPECT().Warn("hook failed", gomock.Any(), gomock.Any(), gomock.Any()).Do(
+		func(_ string, fields ...interface{}) {
+			requires.Contains(fields[1].(log.Field).Interface.(error).Error(), "timed out")
+		})
+
+	// the command is killed along with its children, which keep its output open
+	r := NewRunner(logger, Config{Commands: map[Event]string{CycleConverged: "sleep 10 & sleep 10"},
+		Timeout: 100 * time.Millisecond})
+	startedAt := time.Now()
+	r.Fire(Payload{Event: CycleConverged, CopyDir: "/copy"})
+	r.Close()
+	requires.Less(time.Since(startedAt), 5*time.Second)
+}
+
+func TestRunner_NoCommands(t *testing.T) {
+	requires := require.New(t)
+	r := NewRunner(nil, Config{Timeout: time.Second})
+	requires.Nil(r)
+	requires.False(r.Handles(OpCompleted))
+	r.Fire(Payload{Event: OpCompleted})
+	r.Close()
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	requires := require.New(t)
+	b := &limitedBuffer{limit: 5}
+	n, err := b.Write([]byte("abc"))
+	requires.NoError(err)
+	requires.Equal(3, n)
+	n, err = b.Write([]byte("defgh"))
+	requires.NoError(err)
+	requires.Equal(5, n)
+	requires.Equal("abcde", b.String())
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package hooks
+
+import "os/exec"
+
+func setProcessGroup(*exec.Cmd) {}
+
+//killProcessGroup kills the command (its children are not tracked on windows).
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,49 @@
+package journal
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+//Filter selects the records. Its zero value selects all of them.
+type Filter struct {
+	//Path is the glob pattern of the relative path or of the name; the path without wildcards selects the path itself
+	//and everything inside it.
+	Path   string
+	Since  time.Time // selects the operations, which finished at or after it
+	Status string
+	Job    string
+}
+
+func (f Filter) Match(rec Record) bool {
+	if f.Since.After(rec.FinishedAt) || (f.Status != "" && f.Status != rec.Status) || (f.Job != "" && f.Job != rec.Job) {
+		return false
+	}
+	return f.Path == "" || matchPath(strings.Trim(f.Path, "/"), rec.Path)
+}
+
+func matchPath(pattern, p string) bool {
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return p == pattern || strings.HasPrefix(p, pattern+"/")
+	}
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, path.Base(p))
+	return ok
+}
+
+//ParseSince parses either the duration back from now (e.g. 1h), or the date (2006-01-02) or the RFC 3339 time.
+func ParseSince(s string, now time.Time) (time.Time, bool) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,177 @@
+//Package journal keeps the audit journal of the finished sync operations: one JSON record per line,
+//the journal file is rotated by its size.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultPath is the journal file next to the default log file. The journal is not kept by default,
+	//but the history command reads this file, unless another one is passed.
+	DefaultPath = "tmp/journal.jsonl"
+
+	//MaxSize is the size, after which the journal file is rotated.
+	MaxSize = 10 << 20
+	//MaxBackups is the number of the rotated journal files (with .1, .2 and so on suffixes), which are kept.
+	MaxBackups = 5
+)
+
+//Record is the finished sync operation.
+type Record struct {
+	ID          uint64     `json:"id"`
+	Job         string     `json:"job,omitempty"`
+	CopyDir     string     `json:"copyDir"`
+	Path        string     `json:"path"` // slash-separated and relative to the dirs
+	Kind        string     `json:"kind"`
+	Size        int64      `json:"size"`   // the size of the file, that has been synced
+	Status      string     `json:"status"` // completed, failed or canceled
+	Reverse     bool       `json:"reverse,omitempty"`
+	ScheduledAt time.Time  `json:"scheduledAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  time.Time  `json:"finishedAt"`
+	Error       string     `json:"error,omitempty"`
+}
+
+//Journal appends the records to the journal file. It's safe for concurrent use.
+type Journal struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+//Open opens the journal file for appending (the file and its dir are created if needed).
+func Open(path string) (*Journal, error) {
+	j := &Journal{path: path, maxSize: MaxSize, maxBackups: MaxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create journal dir: %w", err)
+	}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) open() error {
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot open journal: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("cannot open journal: %w", err)
+	}
+	j.file, j.size = f, info.Size()
+	return nil
+}
+
+//Append writes the record as one line. The journal is rotated beforehand, if the line doesn't fit into it.
+func (j *Journal) Append(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return os.ErrClosed
+	}
+	if j.size > 0 && j.size+int64(len(line)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+//rotate shifts the backups (the oldest one is removed) and starts the new journal file.
+func (j *Journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	j.file = nil
+	if err := os.Remove(backupPath(j.path, j.maxBackups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for i := j.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupPath(j.path, i), backupPath(j.path, i+1)); err != nil &&
+			!errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(j.path, backupPath(j.path, 1)); err != nil {
+		return err
+	}
+	return j.open()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func backupPath(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
+
+//Read calls fn for each record of the journal (including its backups) from the oldest one to the newest one.
+//The lines, which are not valid records (e.g. the one cut by the crash), are skipped.
+func Read(path string, fn func(rec Record) error) error {
+	paths := []string{path}
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backupPath(path, i)); err != nil {
+			break
+		}
+		paths = append([]string{backupPath(path, i)}, paths...)
+	}
+	for _, p := range paths {
+		if err := readFile(p, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path string, fn func(rec Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var rec Record
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,94 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	requires := require.New(t)
+	path := filepath.Join(t.TempDir(), "sub", "journal.jsonl")
+	j, err := Open(path)
+	requires.NoError(err)
+	j.maxSize, j.maxBackups = 300, 2
+
+	finished := time.Date(2022, 8, 10, 12, 0, 0, 0, time.Local)
+	for i := 1; i <= 10; i++ {
+		requires.NoError(j.Append(Record{ID: uint64(i), CopyDir: "/copy", Path: "dir/file.txt", Kind: "copy_file",
+			Status: "completed", FinishedAt: finished}))
+	}
+	requires.NoError(j.Close())
+	requires.Error(j.Append(Record{}))
+
+	// the oldest records are gone along with the oldest backup, and the rest are read in order
+	_, err = os.Stat(path + ".3")
+	requires.True(os.IsNotExist(err))
+	var ids []uint64
+	requires.NoError(Read(path, func(rec Record) error {
+		ids = append(ids, rec.ID)
+		return nil
+	}))
+	requires.NotEmpty(ids)
+	requires.Equal(uint64(10), ids[len(ids)-1])
+	requires.Less(len(ids), 10)
+	for i := 1; i < len(ids); i++ {
+		requires.Equal(ids[i-1]+1, ids[i])
+	}
+
+	// the new records are appended to the existing journal
+	j, err = Open(path)
+	requires.NoError(err)
+	requires.NoError(j.Append(Record{ID: 11}))
+	requires.NoError(j.Close())
+	var last uint64
+	requires.NoError(Read(path, func(rec Record) error {
+		last = rec.ID
+		return nil
+	}))
+	requires.Equal(uint64(11), last)
+}
+
+func TestFilter_Match(t *testing.T) {
+	now := time.Date(2022, 8, 10, 12, 0, 0, 0, time.Local)
+	rec := Record{Path: "docs/report.pdf", Status: "failed", Job: "docs", FinishedAt: now.Add(-time.Minute)}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty", filter: Filter{}, want: true},
+		{name: "exact path", filter: Filter{Path: "docs/report.pdf"}, want: true},
+		{name: "dir", filter: Filter{Path: "docs/"}, want: true},
+		{name: "other dir", filter: Filter{Path: "doc"}, want: false},
+		{name: "glob path", filter: Filter{Path: "docs/*.pdf"}, want: true},
+		{name: "glob name", filter: Filter{Path: "*.pdf"}, want: true},
+		{name: "glob mismatch", filter: Filter{Path: "*.txt"}, want: false},
+		{name: "since", filter: Filter{Since: now.Add(-time.Hour)}, want: true},
+		{name: "too old", filter: Filter{Since: now}, want: false},
+		{name: "status", filter: Filter{Status: "failed", Job: "docs"}, want: true},
+		{name: "other status", filter: Filter{Status: "completed"}, want: false},
+		{name: "other job", filter: Filter{Job: "photos"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.filter.Match(rec))
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	requires := require.New(t)
+	now := time.Date(2022, 8, 10, 12, 0, 0, 0, time.Local)
+	since, ok := ParseSince("90m", now)
+	requires.True(ok)
+	requires.Equal(now.Add(-90*time.Minute), since)
+	since, ok = ParseSince("2022-08-01", now)
+	requires.True(ok)
+	requires.Equal(time.Date(2022, 8, 1, 0, 0, 0, 0, time.Local), since)
+	_, ok = ParseSince("yesterday", now)
+	requires.False(ok)
+}
@@ -16,6 +16,31 @@ const (
 	ErrorLevel = "error"
 )
 
+//Format is the encoding of the log messages.
+type Format string
+
+const (
+	JSONFormat    Format = "json"
+	ConsoleFormat Format = "console" // human-readable, the levels are colored in stdout
+)
+
+func (f Format) IsValid() bool {
+	return f == JSONFormat || f == ConsoleFormat
+}
+
+//Components are the names of the components, which can be logged with their own levels.
+var Components = []string{"scanner", "scheduler", "executor"}
+
+//IsComponent reports whether the name is one of Components.
+func IsComponent(name string) bool {
+	for _, component := range Components {
+		if component == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (l Level) IsValid() bool {
 	_, ok := levelsMapping[Level(strings.ToLower(string(l)))]
 	return ok
@@ -1,21 +1,26 @@
 package log
 
 import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-const (
-	stdoutPath  = "stdout"
-	stderrPath  = "stderr"
-	logfilePath = "tmp/log.txt"
-)
+//DefaultFile is the log file, relative to the working dir, which is used unless another one is configured.
+const DefaultFile = "tmp/log.txt"
 
 var (
 	Any      = zap.Any
 	Bool     = zap.Bool
 	Duration = zap.Duration
 	Error    = zap.Error
+	Int      = zap.Int
 	Int64    = zap.Int64
 	Uint64   = zap.Uint64
 	Reflect  = zap.Reflect
@@ -41,31 +46,242 @@ type Logger interface {
 	Sync() error
 }
 
-func New(lvl Level, logToStd bool) (Logger, error) {
-	outPaths := []string{logfilePath}
-	errOutPaths := []string{stderrPath, logfilePath}
-	if logToStd {
-		outPaths = []string{stdoutPath}
-		errOutPaths = []string{stderrPath}
+//Config defines where and how the messages are logged.
+type Config struct {
+	Level      Level
+	Format     Format
+	ToStd      bool             // if true, then the messages go to stdout instead of the file
+	File       string           // the log file (DefaultFile, if it's empty)
+	Rotation   Rotation         // of the log file
+	Components map[string]Level // the levels of the components (see Component), which differ from Level
+}
+
+//Rotation defines how the log file is rotated: it's renamed (with the timestamp added) when it exceeds the size,
+//and the rotated files are removed, when there are too many of them or they are too old.
+type Rotation struct {
+	MaxSize    int  // in megabytes (0 means 100 megabytes)
+	MaxAge     int  // in days (0 means the rotated files are not removed by age)
+	MaxBackups int  // 0 means all the rotated files are kept (unless they are too old)
+	Compress   bool // if true, then the rotated files are gzipped
+}
+
+func New(cfg Config) (Logger, error) {
+	var (
+		out  zapcore.WriteSyncer
+		file *lumberjack.Logger
+	)
+	if cfg.ToStd {
+		out = zapcore.Lock(os.Stdout)
+	} else {
+		path := cfg.File
+		if path == "" {
+			path = DefaultFile
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		file = &lumberjack.Logger{Filename: path, MaxSize: cfg.Rotation.MaxSize, MaxAge: cfg.Rotation.MaxAge,
+			MaxBackups: cfg.Rotation.MaxBackups, Compress: cfg.Rotation.Compress, LocalTime: true}
+		// the file is opened right away, so that its error is returned here and not on the first message
+		if _, err := file.Write(nil); err != nil {
+			return nil, err
+		}
+		out = zapcore.AddSync(file)
+	}
+
+	encoderConfig := zapcore.EncoderConfig{
+		MessageKey:     "msg",
+		LevelKey:       "lvl",
+		TimeKey:        "ts",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		FunctionKey:    zapcore.OmitKey,
+		StacktraceKey:  "stack",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.CapitalLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
-	return zap.Config{
-		Level:    zap.NewAtomicLevelAt(levelsMapping[lvl]),
-		Encoding: "json",
-		EncoderConfig: zapcore.EncoderConfig{
-			MessageKey:     "msg",
-			LevelKey:       "lvl",
-			TimeKey:        "ts",
-			NameKey:        "logger",
-			CallerKey:      "caller",
-			FunctionKey:    zapcore.OmitKey,
-			StacktraceKey:  "stack",
-			LineEnding:     zapcore.DefaultLineEnding,
-			EncodeLevel:    zapcore.CapitalLevelEncoder,
-			EncodeTime:     zapcore.ISO8601TimeEncoder,
-			EncodeDuration: zapcore.StringDurationEncoder,
-			EncodeCaller:   zapcore.ShortCallerEncoder,
+	var encoder zapcore.Encoder
+	if cfg.Format == ConsoleFormat {
+		if cfg.ToStd {
+			encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		}
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	} else {
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	}
+
+	root := &rootLogger{
+		file: file,
+		newLogger: func(level zapcore.LevelEnabler) *zap.Logger {
+			// the internal errors of the logger go to stderr
+			return zap.New(zapcore.NewCore(encoder, out, level), zap.ErrorOutput(zapcore.Lock(os.Stderr)),
+				zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
 		},
-		OutputPaths:      outPaths,
-		ErrorOutputPaths: errOutPaths,
-	}.Build()
+		rootLevel: zap.NewAtomicLevelAt(levelsMapping[cfg.Level]),
+		levels:    make(map[string]*componentLevel),
+		loggers:   make(map[string]*zap.Logger),
+	}
+	for component, lvl := range cfg.Components {
+		level := root.level(component)
+		level.own.SetLevel(levelsMapping[lvl])
+		level.overridden = 1
+	}
+	root.Logger = root.newLogger(root.rootLevel)
+	return root, nil
+}
+
+//rootLogger is the logger made by New. Its components' loggers share its output, but can have their own levels.
+type rootLogger struct {
+	*zap.Logger
+	file      *lumberjack.Logger // is nil, if the messages go to stdout
+	newLogger func(level zapcore.LevelEnabler) *zap.Logger
+	rootLevel zap.AtomicLevel
+
+	mu      sync.Mutex
+	levels  map[string]*componentLevel // by the components
+	loggers map[string]*zap.Logger     // by the components
+}
+
+func (l *rootLogger) level(component string) *componentLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	level, ok := l.levels[component]
+	if !ok {
+		level = &componentLevel{root: l.rootLevel, own: zap.NewAtomicLevel()}
+		l.levels[component] = level
+	}
+	return level
+}
+
+func (l *rootLogger) component(name string) *zap.Logger {
+	level := l.level(name)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	logger, ok := l.loggers[name]
+	if !ok {
+		logger = l.newLogger(level).Named(name)
+		l.loggers[name] = logger
+	}
+	return logger
+}
+
+//Rotate rotates the log file, if the logger writes to the file. It's also used to reopen the file, which has been
+//moved by an external tool (e.g. logrotate).
+func Rotate(logger Logger) error {
+	if l, ok := logger.(*rootLogger); ok && l.file != nil {
+		return l.file.Rotate()
+	}
+	return nil
+}
+
+//Component returns the logger of the component (e.g. the scanner), which has its own level. The logger, which is not
+//made by New (or by With from such a logger), is returned as is.
+func Component(logger Logger, name string) Logger {
+	switch l := logger.(type) {
+	case *rootLogger:
+		return l.component(name)
+	case *loggerWithFields:
+		return With(Component(l.Logger, name), l.fields...)
+	}
+	return logger
+}
+
+//LevelHandler serves the level of the logger (made by New) or of its component (which is passed by the "component"
+//query parameter) as JSON: GET returns {"level":"info"}, and PUT with such a body (or with the "level" form value)
+//changes the level at runtime.
+func LevelHandler(logger Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		root, ok := logger.(*rootLogger)
+		if !ok {
+			http.Error(w, "the logger has no adjustable levels", http.StatusNotImplemented)
+			return
+		}
+		component := r.URL.Query().Get("component")
+		if component != "" && !IsComponent(component) {
+			http.Error(w, "unknown component "+component, http.StatusNotFound)
+			return
+		}
+		if component == "" {
+			root.rootLevel.ServeHTTP(w, r)
+			return
+		}
+		root.level(component).ServeHTTP(w, r)
+	})
+}
+
+//componentLevel is the level of the component's logger. It follows the root logger's level, until the component's
+//own level is set (by the config or at runtime).
+type componentLevel struct {
+	root       zap.AtomicLevel
+	own        zap.AtomicLevel
+	overridden int32 // is set atomically, when the own level is used
+}
+
+func (l *componentLevel) Level() zapcore.Level {
+	if atomic.LoadInt32(&l.overridden) == 1 {
+		return l.own.Level()
+	}
+	return l.root.Level()
+}
+
+func (l *componentLevel) Enabled(level zapcore.Level) bool {
+	return l.Level().Enabled(level)
+}
+
+//ServeHTTP serves the level in the same way as zap.AtomicLevel does, the successful change of the level overrides
+//the root one.
+func (l *componentLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		zap.NewAtomicLevelAt(l.Level()).ServeHTTP(w, r)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+	l.own.ServeHTTP(sw, r)
+	if sw.code == http.StatusOK {
+		atomic.StoreInt32(&l.overridden, 1)
+	}
+}
+
+//statusWriter remembers the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+//With returns the logger, that adds the fields to each message logged by the wrapped logger.
+func With(logger Logger, fields ...Field) Logger {
+	return &loggerWithFields{Logger: logger, fields: fields}
+}
+
+type loggerWithFields struct {
+	Logger
+	fields []Field
+}
+
+func (l *loggerWithFields) Debug(msg string, fields ...Field) {
+	l.Logger.Debug(msg, l.with(fields)...)
+}
+
+func (l *loggerWithFields) Info(msg string, fields ...Field) {
+	l.Logger.Info(msg, l.with(fields)...)
+}
+
+func (l *loggerWithFields) Warn(msg string, fields ...Field) {
+	l.Logger.Warn(msg, l.with(fields)...)
+}
+
+func (l *loggerWithFields) Error(msg string, fields ...Field) {
+	l.Logger.Error(msg, l.with(fields)...)
+}
+
+func (l *loggerWithFields) with(fields []Field) []Field {
+	return append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...)
 }
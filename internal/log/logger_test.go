@@ -0,0 +1,139 @@
+package log
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_Rotate(t *testing.T) {
+	requires := require.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "dsync.log")
+	logger, err := New(Config{Level: InfoLevel, File: path, Rotation: Rotation{MaxBackups: 1}})
+	requires.NoError(err)
+
+	logger.Debug("skipped")
+	logger.Info("first")
+	requires.NoError(Rotate(logger))
+	logger.Info("second")
+	requires.NoError(logger.Sync())
+
+	content, err := os.ReadFile(path)
+	requires.NoError(err)
+	requires.Contains(string(content), `"msg":"second"`)
+	requires.NotContains(string(content), "first")
+	backups, err := filepath.Glob(filepath.Join(dir, "logs", "dsync-*.log"))
+	requires.NoError(err)
+	requires.Len(backups, 1)
+	content, err = os.ReadFile(backups[0])
+	requires.NoError(err)
+	requires.Contains(string(content), `"msg":"first"`)
+	requires.NotContains(string(content), "skipped")
+
+	// the console logger has nothing to rotate
+	logger, err = New(Config{Level: InfoLevel, ToStd: true})
+	requires.NoError(err)
+	requires.NoError(Rotate(logger))
+}
+
+func TestComponent(t *testing.T) {
+	requires := require.New(t)
+	path := filepath.Join(t.TempDir(), "dsync.log")
+	logger, err := New(Config{Level: InfoLevel, Format: ConsoleFormat, File: path,
+		Components: map[string]Level{"executor": DebugLevel}})
+	requires.NoError(err)
+
+	With(Component(logger, "executor"), String("copyDir", "dir2")).Debug("executor message")
+	Component(logger, "scanner").Debug("scanner message")
+	logger.Debug("root message")
+
+	// the levels are changed at runtime
+	handler := LevelHandler(logger)
+	for _, query := range []string{"?component=executor", "?component=scanner"} {
+		body := `{"level":"warn"}`
+		if query == "?component=scanner" {
+			body = `{"level":"debug"}`
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/loglevel"+query, strings.NewReader(body)))
+		requires.Equal(http.StatusOK, rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel?component=executor", nil))
+	requires.JSONEq(`{"level":"warn"}`, rec.Body.String())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+	requires.JSONEq(`{"level":"info"}`, rec.Body.String())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel?component=walker", nil))
+	requires.Equal(http.StatusNotFound, rec.Code)
+
+	Component(logger, "executor").Info("executor info")
+	Component(logger, "scanner").Debug("scanner debug")
+	requires.NoError(logger.Sync())
+
+	content, err := os.ReadFile(path)
+	requires.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	requires.Len(lines, 2)
+	requires.Regexp(`^\S+\tDEBUG\texecutor\t\S+\texecutor message\t\{"copyDir": "dir2"\}$`, lines[0])
+	requires.Regexp(`^\S+\tDEBUG\tscanner\t\S+\tscanner debug$`, lines[1])
+
+	// the other loggers have no adjustable levels
+	rec = httptest.NewRecorder()
+	LevelHandler(nopLogger{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+	requires.Equal(http.StatusNotImplemented, rec.Code)
+}
+
+func TestComponent_FollowsRootLevel(t *testing.T) {
+	requires := require.New(t)
+	path := filepath.Join(t.TempDir(), "dsync.log")
+	logger, err := New(Config{Level: InfoLevel, Format: ConsoleFormat, File: path,
+		Components: map[string]Level{"executor": WarnLevel}})
+	requires.NoError(err)
+	scanner, executor := Component(logger, "scanner"), Component(logger, "executor")
+	scheduler := Component(logger, "scheduler")
+
+	// the root level is changed after the components' loggers are made
+	handler := LevelHandler(logger)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level":"debug"}`)))
+	requires.Equal(http.StatusOK, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel?component=scanner", nil))
+	requires.JSONEq(`{"level":"debug"}`, rec.Body.String())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/loglevel?component=scheduler",
+		strings.NewReader(`{"level":"error"}`)))
+	requires.Equal(http.StatusOK, rec.Code)
+
+	scanner.Debug("scanner debug")
+	executor.Info("executor info")
+	scheduler.Warn("scheduler warn")
+	requires.NoError(logger.Sync())
+
+	content, err := os.ReadFile(path)
+	requires.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	requires.Len(lines, 1)
+	requires.Regexp(`^\S+\tDEBUG\tscanner\t\S+\tscanner debug$`, lines[0])
+
+	// the component, whose level is overridden, doesn't follow the root level anymore
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel?component=scheduler", nil))
+	requires.JSONEq(`{"level":"error"}`, rec.Body.String())
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...Field) {}
+func (nopLogger) Info(string, ...Field)  {}
+func (nopLogger) Warn(string, ...Field)  {}
+func (nopLogger) Error(string, ...Field) {}
+func (nopLogger) Sync() error            { return nil }
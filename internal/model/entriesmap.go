@@ -8,6 +8,8 @@ import (
 //It holds a map with dir entries of the source and copy file trees.
 //A key in this map is a relative path of one dir entry, and a value is this entry's info (in both source and copy file trees).
 //For the safety, concurrent access to the inner map is protected and controlled by a mutex.
+//The operations in the map are never changed in place (they are replaced with the changed copies), so their fields
+//may be read under the mutex, while the workers keep changing their own copies.
 type DirEntriesMap struct {
 	mu   sync.Mutex
 	eMap map[string]EntryInfo
@@ -39,10 +41,11 @@ func (m *DirEntriesMap) UpdateValueByKey(key string, valueUpdater func(*EntryInf
 	m.eMap[key] = entry
 }
 
+//SetValueByKey sets the entry's info by the key. The map keeps its own copy of the entry's operation.
 func (m *DirEntriesMap) SetValueByKey(key string, ei *EntryInfo) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.eMap[key] = *ei
+	m.eMap[key] = ei.withOwnOperation()
 }
 
 func (m *DirEntriesMap) RemoveObsolete() {
@@ -60,6 +63,19 @@ func (m *DirEntriesMap) RemoveObsolete() {
 	}
 }
 
+//ClearOverOperations forgets all the operations that are over (i.e. canceled, failed or completed),
+//so that the entries with such operations may be considered by the scheduler again without waiting for the next cycle.
+func (m *DirEntriesMap) ClearOverOperations() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.eMap {
+		if e.OperationPtr.IsNotNilAndOver() {
+			e.OperationPtr = nil
+			m.eMap[k] = e
+		}
+	}
+}
+
 func (m *DirEntriesMap) ForEach(fn func(key string, eMap map[string]EntryInfo) error) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -70,3 +86,31 @@ func (m *DirEntriesMap) ForEach(fn func(key string, eMap map[string]EntryInfo) e
 	}
 	return nil
 }
+
+//Get returns the copy of the entry's info by the key.
+func (m *DirEntriesMap) Get(key string) (EntryInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.eMap[key]
+	return entry.detached(), ok
+}
+
+func (m *DirEntriesMap) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.eMap)
+}
+
+//Collect returns the copies of the entries' info, that are accepted by the filter func. The func is called
+//under the lock, so it must be fast; the copies are made only for the accepted entries.
+func (m *DirEntriesMap) Collect(accept func(key string, entry *EntryInfo) bool) map[string]EntryInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	collected := make(map[string]EntryInfo)
+	for k, e := range m.eMap {
+		if accept(k, &e) {
+			collected[k] = e.detached()
+		}
+	}
+	return collected
+}
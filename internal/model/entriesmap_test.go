@@ -0,0 +1,34 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirEntriesMap_Collect(t *testing.T) {
+	requires := require.New(t)
+	m := NewDirEntriesMap()
+	op := NewOperation(OpKindCopyFile)
+	m.SetValueByKey("a", &EntryInfo{SrcPathInfo: PathInfo{Exists: true}, OperationPtr: op})
+	m.SetValueByKey("b", &EntryInfo{CopyPathInfo: PathInfo{Exists: true}})
+	requires.Equal(2, m.Len())
+
+	collected := m.Collect(func(_ string, entry *EntryInfo) bool { return entry.OperationPtr != nil })
+	requires.Len(collected, 1)
+	requires.Equal(op.ID, collected["a"].OperationPtr.ID)
+
+	// the map and the collected entries keep their own copies, so they are not changed along with the original one
+	op.Status = OpStatusCompleted
+	requires.Equal(OpStatusScheduled, collected["a"].OperationPtr.Status)
+	entry, ok := m.Get("a")
+	requires.True(ok)
+	requires.Equal(OpStatusScheduled, entry.OperationPtr.Status)
+	m.SetValueByKey("a", &EntryInfo{SrcPathInfo: PathInfo{Exists: true}, OperationPtr: op})
+	entry, ok = m.Get("a")
+	requires.True(ok)
+	requires.Equal(OpStatusCompleted, entry.OperationPtr.Status)
+	requires.NotSame(op, entry.OperationPtr)
+	_, ok = m.Get("c")
+	requires.False(ok)
+}
@@ -1,18 +1,32 @@
 package model
 
-import "time"
+import (
+	"io/fs"
+	"time"
+)
 
 //PathInfo holds info about one dir entry in a file tree (of either source OR copy directory).
 type PathInfo struct {
-	Exists   bool      `json:"exists"`
-	FullPath string    `json:"-"`
-	IsDir    bool      `json:"isDir,omitempty"`
-	Size     int64     `json:"size,omitempty"` // in bytes
-	ModTime  time.Time `json:"modTime"`
+	Exists   bool        `json:"exists"`
+	FullPath string      `json:"-"`
+	IsDir    bool        `json:"isDir,omitempty"`
+	Size     int64       `json:"size,omitempty"` // in bytes
+	ModTime  time.Time   `json:"modTime"`
+	Mode     fs.FileMode `json:"mode,omitempty"`
+	Rdev     uint64      `json:"rdev,omitempty"` // device number (major and minor), makes sense only for device nodes
+}
+
+//IsSpecialMode reports whether the mode is of a special file, i.e. a named pipe (FIFO), a device node or a socket.
+func IsSpecialMode(mode fs.FileMode) bool {
+	return mode&(fs.ModeNamedPipe|fs.ModeDevice|fs.ModeCharDevice|fs.ModeSocket) != 0
 }
 
 func (pi *PathInfo) IsFile() bool {
-	return !pi.IsDir
+	return !pi.IsDir && !pi.IsSpecial()
+}
+
+func (pi *PathInfo) IsSpecial() bool {
+	return IsSpecialMode(pi.Mode)
 }
 
 func (pi *PathInfo) IsSameAs(copy PathInfo) bool {
@@ -23,6 +37,10 @@ func (pi *PathInfo) IsSameAs(copy PathInfo) bool {
 	if pi.Exists && copy.Exists && pi.IsDir && copy.IsDir {
 		return true
 	}
+	//special files have no content, so they are the same if they are of the same type (and the same device number)
+	if pi.IsSpecial() || copy.IsSpecial() {
+		return pi.Exists && copy.Exists && pi.Mode.Type() == copy.Mode.Type() && pi.Rdev == copy.Rdev
+	}
 	return pi.Exists && copy.Exists && (pi.IsDir == copy.IsDir) && (pi.Size == copy.Size) && (pi.ModTime == copy.ModTime)
 }
 
@@ -43,6 +61,14 @@ func (ei *EntryInfo) SetCopyPathInfo(pi PathInfo) {
 	ei.CopyPathInfo = pi
 }
 
+//Swapped returns the entry info, where the source and copy paths info are swapped, so that the reverse operation
+//(from the copy to the source) can be handled in the same way as the usual one.
+func (ei *EntryInfo) Swapped() EntryInfo {
+	swapped := *ei
+	swapped.SrcPathInfo, swapped.CopyPathInfo = ei.CopyPathInfo, ei.SrcPathInfo
+	return swapped
+}
+
 func (ei *EntryInfo) SetOperation(op *Operation) {
 	ei.OperationPtr = op
 }
@@ -51,15 +77,35 @@ func (ei *EntryInfo) IsSyncRequired() bool {
 	return !ei.SrcPathInfo.IsSameAs(ei.CopyPathInfo)
 }
 
+//IsDirMetaSyncRequired reports whether both entries are existing dirs, which differ in modTime
+//(or in permission bits, if withMode is true). IsSyncRequired deliberately treats any two dirs as equal,
+//so this check is used only when dirs metadata preservation is on.
+func (ei *EntryInfo) IsDirMetaSyncRequired(withMode bool) bool {
+	src, cp := ei.SrcPathInfo, ei.CopyPathInfo
+	if !(src.Exists && cp.Exists && src.IsDir && cp.IsDir) {
+		return false
+	}
+	return src.ModTime != cp.ModTime || (withMode && src.Mode.Perm() != cp.Mode.Perm())
+}
+
 func (ei *EntryInfo) ResolveOperationKind() OperationKind {
 	src, cp := ei.SrcPathInfo, ei.CopyPathInfo
 	switch {
 	case src.Exists && src.IsFile() && !cp.Exists:
 		return OpKindCopyFile
+	case src.Exists && src.IsSpecial() && !cp.Exists:
+		return OpKindCopySpecial
+	case cp.Exists && cp.IsSpecial() && !ei.IsSyncRequired():
+		return OpKindNone
+	case cp.Exists && cp.IsSpecial():
+		// special file is removed first, and then (on the next cycle) the source entry is copied to its place
+		return OpKindRemoveFile
+	case src.Exists && src.IsSpecial() && cp.Exists && cp.IsDir: // actually works if cp is an empty dir
+		return OpKindRemoveDir
 	case src.Exists && src.IsDir && !cp.Exists:
 		// actually needed for empty dirs, because non-empty dirs are synced automatically as a part of files full path
 		return OpKindCopyDir
-	case (!src.Exists || src.IsDir) && cp.Exists && cp.IsFile():
+	case (!src.Exists || src.IsDir || src.IsSpecial()) && cp.Exists && cp.IsFile():
 		return OpKindRemoveFile
 	case !src.Exists && cp.Exists && cp.IsDir: // actually works if cp is an empty dir
 		return OpKindRemoveDir
@@ -71,3 +117,22 @@ func (ei *EntryInfo) ResolveOperationKind() OperationKind {
 		return OpKindNone
 	}
 }
+
+//withOwnOperation returns the copy of the entry info, which doesn't share the operation with the original one.
+func (ei EntryInfo) withOwnOperation() EntryInfo {
+	if ei.OperationPtr != nil {
+		op := *ei.OperationPtr
+		ei.OperationPtr = &op
+	}
+	return ei
+}
+
+//detached returns the copy of the entry info, which doesn't share the operation with the original one,
+//so it can be read without the map's lock.
+func (ei EntryInfo) detached() EntryInfo {
+	ei = ei.withOwnOperation()
+	if ei.OperationPtr != nil {
+		ei.OperationPtr.CancelFn = nil
+	}
+	return ei
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"io/fs"
 	"testing"
 	"time"
 
@@ -76,6 +77,30 @@ func TestEntryInfo_IsSyncRequired(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "same fifos",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, Mode: fs.ModeNamedPipe, ModTime: time.Unix(10000, 0)},
+				CopyPathInfo: PathInfo{Exists: true, Mode: fs.ModeNamedPipe, ModTime: time.Unix(10100, 0)},
+			},
+			want: false,
+		},
+		{
+			name: "device numbers differ",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, Mode: fs.ModeDevice | fs.ModeCharDevice, Rdev: 259},
+				CopyPathInfo: PathInfo{Exists: true, Mode: fs.ModeDevice | fs.ModeCharDevice, Rdev: 260},
+			},
+			want: true,
+		},
+		{
+			name: "fifo and file",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, Mode: fs.ModeNamedPipe},
+				CopyPathInfo: PathInfo{Exists: true},
+			},
+			want: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -163,6 +188,54 @@ func TestEntryInfo_ResolveOperationKind(t *testing.T) {
 			},
 			want: OpKindNone,
 		},
+		{
+			name: "copy special",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, Mode: fs.ModeNamedPipe},
+				CopyPathInfo: PathInfo{Exists: false},
+			},
+			want: OpKindCopySpecial,
+		},
+		{
+			name: "remove special 1",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: false},
+				CopyPathInfo: PathInfo{Exists: true, Mode: fs.ModeSocket},
+			},
+			want: OpKindRemoveFile,
+		},
+		{
+			name: "remove special 2",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, IsDir: false, Size: 10},
+				CopyPathInfo: PathInfo{Exists: true, Mode: fs.ModeNamedPipe},
+			},
+			want: OpKindRemoveFile,
+		},
+		{
+			name: "remove file for special",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, Mode: fs.ModeNamedPipe},
+				CopyPathInfo: PathInfo{Exists: true, IsDir: false, Size: 10},
+			},
+			want: OpKindRemoveFile,
+		},
+		{
+			name: "remove dir for special",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, Mode: fs.ModeDevice, Rdev: 2049},
+				CopyPathInfo: PathInfo{Exists: true, IsDir: true},
+			},
+			want: OpKindRemoveDir,
+		},
+		{
+			name: "same specials",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, Mode: fs.ModeDevice, Rdev: 2049},
+				CopyPathInfo: PathInfo{Exists: true, Mode: fs.ModeDevice, Rdev: 2049},
+			},
+			want: OpKindNone,
+		},
 	}
 
 	for _, tt := range tests {
@@ -171,3 +244,86 @@ func TestEntryInfo_ResolveOperationKind(t *testing.T) {
 		})
 	}
 }
+
+func TestEntryInfo_IsDirMetaSyncRequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    *EntryInfo
+		withMode bool
+		want     bool
+	}{
+		{
+			name: "not dirs",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, ModTime: time.Unix(10000, 0)},
+				CopyPathInfo: PathInfo{Exists: true, ModTime: time.Unix(10100, 0)},
+			},
+			want: false,
+		},
+		{
+			name: "only source exist",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10000, 0)},
+				CopyPathInfo: PathInfo{Exists: false},
+			},
+			want: false,
+		},
+		{
+			name: "same dirs",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10000, 0), Mode: 0755},
+				CopyPathInfo: PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10000, 0), Mode: 0755},
+			},
+			withMode: true,
+			want:     false,
+		},
+		{
+			name: "modTime differs",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10000, 0)},
+				CopyPathInfo: PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10100, 0)},
+			},
+			want: true,
+		},
+		{
+			name: "mode differs, but ignored",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10000, 0), Mode: 0755},
+				CopyPathInfo: PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10000, 0), Mode: 0700},
+			},
+			withMode: false,
+			want:     false,
+		},
+		{
+			name: "mode differs",
+			entry: &EntryInfo{
+				SrcPathInfo:  PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10000, 0), Mode: 0755},
+				CopyPathInfo: PathInfo{Exists: true, IsDir: true, ModTime: time.Unix(10000, 0), Mode: 0700},
+			},
+			withMode: true,
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.entry.IsDirMetaSyncRequired(tt.withMode))
+		})
+	}
+}
+
+func TestEntryInfo_Swapped(t *testing.T) {
+	op := NewOperation(OpKindCopyFile)
+	entry := &EntryInfo{
+		SrcPathInfo:  PathInfo{Exists: true, FullPath: "src/a", Size: 1},
+		CopyPathInfo: PathInfo{Exists: false, FullPath: "copy/a"},
+		OperationPtr: op,
+	}
+
+	swapped := entry.Swapped()
+
+	require.Equal(t, entry.SrcPathInfo, swapped.CopyPathInfo)
+	require.Equal(t, entry.CopyPathInfo, swapped.SrcPathInfo)
+	require.Same(t, op, swapped.OperationPtr)
+	require.Equal(t, "src/a", entry.SrcPathInfo.FullPath) // the original entry is left untouched
+}
@@ -23,15 +23,18 @@ const (
 	OpKindNone               OperationKind = "none"
 	OpKindCopyFile           OperationKind = "copy_file"
 	OpKindCopyDir            OperationKind = "copy_dir"
+	OpKindCopySpecial        OperationKind = "copy_special"
 	OpKindRemoveFile         OperationKind = "remove_file"
 	OpKindRemoveDir          OperationKind = "remove_dir"
 	OpKindReplaceFile        OperationKind = "replace_file"
 	OpKindReplaceDirWithFile OperationKind = "replace_dir_with_file"
+	OpKindSyncDirMeta        OperationKind = "sync_dir_meta"
+	OpKindKeepConflict       OperationKind = "keep_conflict"
 )
 
 var generateOperationID = ut.CreateUint64IDGenerator()
 
-// Operation - synchronization operation between the dir entry in the source directory and same entry in the copy directory.
+//Operation - synchronization operation between the dir entry in the source directory and same entry in the copy directory.
 type Operation struct {
 	ID          uint64             `json:"id"`
 	Status      OperationStatus    `json:"status"`
@@ -42,12 +45,24 @@ type Operation struct {
 	CanceledAt  *time.Time         `json:"canceledAt,omitempty"`
 	FailedAt    *time.Time         `json:"failedAt,omitempty"`
 	CompletedAt *time.Time         `json:"completedAt,omitempty"`
+	Error       string             `json:"error,omitempty"`   // the cause of the failure, if the operation has failed
+	Reverse     bool               `json:"reverse,omitempty"` // if true, the operation is from the copy to the source
 }
 
 func NewOperation(kind OperationKind) *Operation {
 	return &Operation{ID: generateOperationID(), Status: OpStatusScheduled, Kind: kind, ScheduledAt: time.Now()}
 }
 
+//FinishedAt returns the time, when the operation was completed, failed or canceled (nil, if it's not over).
+func (op *Operation) FinishedAt() *time.Time {
+	for _, t := range []*time.Time{op.CompletedAt, op.FailedAt, op.CanceledAt} {
+		if t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
 func (op *Operation) IsNotNilAndOver() bool {
 	return op != nil && (op.Status == OpStatusCanceled || op.Status == OpStatusCompleted || op.Status == OpStatusFailed)
 }
@@ -0,0 +1,127 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//jobsConfig is the content of the config file with several sync jobs (passed via -config flag).
+type jobsConfig struct {
+	Jobs []jobConfig `json:"jobs"`
+}
+
+//jobConfig holds the settings of one sync job. Any of the optional settings, which is not set here,
+//is taken from the command line flags.
+type jobConfig struct {
+	Name        string   `json:"name"`
+	SrcDir      string   `json:"src"`                   // relative path is resolved against the config file dir
+	CopyDir     string   `json:"copy"`                  // relative path is resolved against the config file dir
+	ExtraCopies []string `json:"extraCopies,omitempty"` // the additional copy dirs, the source is replicated to
+	ScanPeriod  string   `json:"scanPeriod,omitempty"`
+	Hidden      *bool    `json:"hidden,omitempty"`
+	CopyDirs    *bool    `json:"copyDirs,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"` // these patterns are added to the ones passed via -exclude flag
+}
+
+//loadJobs reads the config file and makes the settings for each sync job on the base of the common settings.
+func loadJobs(configPath string, common Settings) ([]Settings, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %v", err)
+	}
+	var cfg jobsConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse config file %q: %v", configPath, err)
+	}
+	if len(cfg.Jobs) == 0 {
+		return nil, fmt.Errorf("config file %q has no jobs", configPath)
+	}
+
+	baseDir := filepath.Dir(configPath)
+	jobs := make([]Settings, 0, len(cfg.Jobs))
+	for i, jc := range cfg.Jobs {
+		job, err := jc.toSettings(common, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("job #%d (%q) is invalid: %v", i+1, jc.Name, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (jc jobConfig) toSettings(common Settings, baseDir string) (Settings, error) {
+	if jc.Name == "" {
+		return Settings{}, errors.New("job name must be set")
+	}
+	if jc.SrcDir == "" || jc.CopyDir == "" {
+		return Settings{}, errors.New("both source and copy directories must be set")
+	}
+
+	job := common
+	job.Jobs = nil
+	job.JobName = jc.Name
+	job.SrcDir, job.CopyDir = absFrom(baseDir, jc.SrcDir), copyDirFrom(baseDir, jc.CopyDir)
+	if job.SrcDir == job.CopyDir {
+		return Settings{}, errors.New("the directories for synchronization cannot be the same")
+	}
+	job.ExtraCopyDirs = nil
+	for _, copyDir := range jc.ExtraCopies {
+		job.ExtraCopyDirs = append(job.ExtraCopyDirs, copyDirFrom(baseDir, copyDir))
+	}
+	if jc.ScanPeriod != "" {
+		period, err := time.ParseDuration(jc.ScanPeriod)
+		if err != nil {
+			return Settings{}, fmt.Errorf("bad scan period: %v", err)
+		}
+		job.ScanPeriod = period
+	}
+	if jc.Hidden != nil {
+		job.IncludeHidden = *jc.Hidden
+	}
+	if jc.CopyDirs != nil {
+		job.IncludeEmptyDirs = *jc.CopyDirs
+	}
+	job.Exclude = append(append([]string(nil), common.Exclude...), jc.Exclude...)
+	return job, nil
+}
+
+func absFrom(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(baseDir, path)
+}
+
+//copyDirFrom is the same as absFrom, but the remote dir URL is kept as it is.
+func copyDirFrom(baseDir, copyDir string) string {
+	if isRemoteURL(copyDir) {
+		return copyDir
+	}
+	return absFrom(baseDir, copyDir)
+}
+
+//validateJobs validates each job's settings and checks that the jobs don't interfere with each other.
+func (stg *Settings) validateJobs() error {
+	names := make(map[string]bool, len(stg.Jobs))
+	copyDirs := make(map[string]string, len(stg.Jobs))
+	for _, job := range stg.Jobs {
+		if names[job.JobName] {
+			return fmt.Errorf("job name %q is not unique", job.JobName)
+		}
+		names[job.JobName] = true
+		for _, copyDir := range job.AllCopyDirs() {
+			if other, ok := copyDirs[copyDir]; ok && other != job.JobName {
+				return fmt.Errorf("jobs %q and %q have the same copy directory", other, job.JobName)
+			}
+			copyDirs[copyDir] = job.JobName
+		}
+		if err := job.Validate(); err != nil {
+			return fmt.Errorf("job %q is invalid: %v", job.JobName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package settings
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWithConfig(t *testing.T) {
+	requires := require.New(t)
+	configDir := t.TempDir()
+	configPath := filepath.Join(configDir, "jobs.json")
+	config := `{"jobs": [
+		{"name": "docs", "src": "docs", "copy": "/backup/docs", "scanPeriod": "5s", "exclude": ["*.bak"]},
+		{"name": "photos", "src": "/home/photos", "copy": "backup/photos", "extraCopies": ["/mnt/photos"],
+			"hidden": true, "copyDirs": false}
+	]}`
+	requires.NoError(os.WriteFile(configPath, []byte(config), 0o644))
+
+	stg, err := New([]string{"-config=" + configPath, "-copydirs", "-exclude=*.tmp", "-workers=4"}, flag.PanicOnError)
+
+	requires.NoError(err)
+	requires.Len(stg.Jobs, 2)
+
+	docs := stg.Jobs[0]
+	requires.Equal("docs", docs.JobName)
+	requires.Equal(filepath.Join(configDir, "docs"), docs.SrcDir)
+	requires.Equal(filepath.Clean("/backup/docs"), docs.CopyDir)
+	requires.Equal(5*time.Second, docs.ScanPeriod)
+	requires.True(docs.IncludeEmptyDirs)
+	requires.False(docs.IncludeHidden)
+	requires.Equal([]string{"*.tmp", "*.bak"}, docs.Exclude)
+	requires.Equal(4, docs.WorkersCount)
+	requires.Nil(docs.Jobs)
+
+	photos := stg.Jobs[1]
+	requires.Equal("photos", photos.JobName)
+	requires.Equal(filepath.Join(configDir, "backup", "photos"), photos.CopyDir)
+	requires.Equal([]string{filepath.Clean("/mnt/photos")}, photos.ExtraCopyDirs)
+	requires.Equal(time.Second, photos.ScanPeriod)
+	requires.True(photos.IncludeHidden)
+	requires.False(photos.IncludeEmptyDirs)
+	requires.Equal([]string{"*.tmp"}, photos.Exclude)
+}
+
+func TestLoadJobsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		errText string
+	}{
+		{name: "bad json", config: `{"jobs": [`, errText: "cannot parse config file"},
+		{name: "no jobs", config: `{"jobs": []}`, errText: "has no jobs"},
+		{name: "no name", config: `{"jobs": [{"src": "a", "copy": "b"}]}`, errText: "job name must be set"},
+		{name: "no copy", config: `{"jobs": [{"name": "j", "src": "a"}]}`, errText: "both source and copy directories"},
+		{name: "same dirs", config: `{"jobs": [{"name": "j", "src": "a", "copy": "./a"}]}`, errText: "cannot be the same"},
+		{
+			name:    "bad period",
+			config:  `{"jobs": [{"name": "j", "src": "a", "copy": "b", "scanPeriod": "often"}]}`,
+			errText: "bad scan period",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requires := require.New(t)
+			configPath := filepath.Join(t.TempDir(), "jobs.json")
+			requires.NoError(os.WriteFile(configPath, []byte(tt.config), 0o644))
+
+			_, err := loadJobs(configPath, Settings{})
+
+			requires.ErrorContains(err, tt.errText)
+		})
+	}
+}
+
+func TestSettings_validateJobs(t *testing.T) {
+	job := func(name, copyDir string) Settings {
+		return Settings{JobName: name, SrcDir: "../settings", CopyDir: copyDir, ScanPeriod: minScanPeriod,
+			WorkersCount: minWorkersCount}
+	}
+	tests := []struct {
+		name    string
+		jobs    []Settings
+		errText string
+	}{
+		{name: "same names", jobs: []Settings{job("a", "../model"), job("a", "../log")}, errText: "is not unique"},
+		{name: "same copy dirs", jobs: []Settings{job("a", "../model"), job("b", "../model")}, errText: "same copy"},
+		{
+			name:    "same extra copy dirs",
+			jobs:    []Settings{job("a", "../model"), {JobName: "b", CopyDir: "../log", ExtraCopyDirs: []string{"../model"}}},
+			errText: "same copy",
+		},
+		{name: "invalid job", jobs: []Settings{job("a", "../model"), job("b", "noSuchDir")}, errText: `job "b" is invalid`},
+		{name: "ok", jobs: []Settings{job("a", "../model"), job("b", "../log")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Settings{Jobs: tt.jobs}).Validate()
+
+			requires := require.New(t)
+			if tt.errText != "" {
+				requires.ErrorContains(err, tt.errText)
+				return
+			}
+			requires.NoError(err)
+		})
+	}
+}
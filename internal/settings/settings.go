@@ -1,10 +1,23 @@
 package settings
 
 import (
+	"dsync/internal/archive"
+	"dsync/internal/hooks"
+	"dsync/internal/journal"
 	"dsync/internal/log"
+	"dsync/internal/snapshots"
+	"dsync/internal/twoway"
+	"dsync/internal/versions"
+	"dsync/internal/webhook"
+	"dsync/pkg/fsys/compressfs"
+	"dsync/pkg/fsys/netfs"
+	"dsync/pkg/fsys/s3fs"
+	"dsync/pkg/fsys/sftpfs"
+	"dsync/pkg/tracing"
 	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -19,17 +32,62 @@ const (
 	maxWorkersCount = 1000
 )
 
+//TokenEnv is the env var with the token for the dsync servers, it's used unless -token flag is passed.
+const TokenEnv = "DSYNC_TOKEN"
+
+//CompressZstd is the only supported compression of the files in the copy dirs.
+const CompressZstd = "zstd"
+
+//PassphraseEnv is the env var with the passphrase, the copy dirs are encrypted with, unless -keyfile flag is passed.
+const PassphraseEnv = "DSYNC_PASSPHRASE"
+
 type Settings struct {
 	SrcDir           string
-	CopyDir          string
+	CopyDir          string   // the local path, the URL of the remote dir (sftp://, dsync:// or s3://) or the archive path
+	ExtraCopyDirs    []string // the additional copy dirs, the source dir is replicated to all of them
 	ScanPeriod       time.Duration
 	IncludeHidden    bool
 	IncludeEmptyDirs bool
 	LogLevel         log.Level
+	ComponentLevels  map[string]log.Level // the levels of the components' logs, which differ from LogLevel
+	LogFormat        log.Format
 	LogToStd         bool
+	LogFile          string
+	LogRotation      log.Rotation
 	Once             bool
 	PrintPID         bool
 	WorkersCount     int
+	SyncDirMeta      bool
+	SyncPerms        bool
+	SyncSpecials     bool
+	Verify           bool
+	KeepVersions     bool
+	Retention        versions.RetentionPolicy
+	Snapshots        bool
+	TwoWay           bool
+	ConflictPolicy   twoway.ConflictPolicy
+	SSHKeyPath       string   // the private key for the SFTP copy dirs (empty means the default one in ~/.ssh)
+	KnownHostsPath   string   // the known hosts file for the SFTP copy dirs (empty means ~/.ssh/known_hosts)
+	Token            string   `json:"-"` // the token for the dsync servers (it's not logged along with settings)
+	TLS              bool     // if true, then the dsync servers are connected over TLS
+	TLSCAPath        string   // the CA certificates file, the dsync servers' certificates are checked against
+	S3Endpoint       string   // the storage URL for the s3:// copy dirs (empty means AWS S3)
+	S3Region         string   // the storage region for the s3:// copy dirs
+	Encrypt          bool     // if true, then the files are encrypted in the copy dirs
+	EncryptNames     bool     // if true, then the files' names are encrypted as well (only for the new copy dirs)
+	KeyPath          string   // the keyfile, the encryption key is derived from
+	Passphrase       string   `json:"-"` // the passphrase, the encryption key is derived from, if there is no keyfile
+	Compress         string   // how the files are compressed in the copy dirs (empty means they are not compressed)
+	Restore          bool     // if true, then the source dir is the encrypted or compressed copy dir to be restored
+	Exclude          []string // glob patterns of the entries' names or relative paths, that are not synchronized
+	HTTPAddr         string   // the address of the HTTP status API (empty means it's not served)
+	JournalPath      string   // the audit journal of the finished operations (empty means it's not kept)
+	ReportPath       string   // the JSON summary of the one-shot sync (empty means it's not written)
+	Hooks            hooks.Config
+	Webhook          webhook.Config
+	Trace            string     // the OTLP collector's URL or the JSON file, the spans are exported to (empty means off)
+	JobName          string     // is set only for the jobs from the config file
+	Jobs             []Settings // the sync jobs from the config file (if it's passed), each one has its own settings
 }
 
 func New(commandArgs []string, handling flag.ErrorHandling) (*Settings, error) {
@@ -47,11 +105,150 @@ func New(commandArgs []string, handling flag.ErrorHandling) (*Settings, error) {
 			"otherwise - the process is started and lasts indefinitely (until interruption)")
 	flagSet.BoolVar(&stg.PrintPID, "pid", false,
 		"if true, then the PID is printed at the startup (may be useful in case of background running)")
+	flagSet.BoolVar(&stg.SyncDirMeta, "dirmeta", false,
+		"if true, then directories' modification times (and permissions, if -perms is set) are carried over to the copy")
+	flagSet.BoolVar(&stg.SyncPerms, "perms", false,
+		"if true, then permission bits of the copied files (and of directories, if -dirmeta is set) are carried over "+
+			"to the copy")
+	flagSet.BoolVar(&stg.SyncSpecials, "specials", false,
+		"if true, then special files are synchronized as well: named pipes (FIFOs), sockets and "+
+			"(only if the process is privileged) device nodes")
+	flagSet.BoolVar(&stg.Verify, "verify", false,
+		"if true, then each copied file is re-read after writing and compared with the source by content hash")
+	flagSet.BoolVar(&stg.KeepVersions, "versions", false,
+		fmt.Sprintf("if true, then the copy dir files are not destroyed on replacement or removal, but moved to "+
+			"the parallel %q tree (in the copy dir) as path~YYYYMMDD-HHMMSS files", versions.DirName))
+	flagSet.BoolVar(&stg.Snapshots, "snapshots", false,
+		fmt.Sprintf("if true (only with -once), then the new timestamped snapshot dir is made in the copy dir, "+
+			"where the files unchanged since the previous snapshot are hard links to it, and the %q symlink "+
+			"points to the latest snapshot", snapshots.LatestLink))
+	flagSet.BoolVar(&stg.TwoWay, "twoway", false,
+		fmt.Sprintf("if true, then the changes made in the copy dir are synchronized to the source dir as well "+
+			"(the last synced state is kept in the %q file in the copy dir)", twoway.BaseFileName))
+	var policy string
+	flagSet.StringVar(&policy, "conflict", string(twoway.PolicyNewer),
+		fmt.Sprintf("how the entry changed on both sides is synchronized in the -twoway mode, permitted values are: "+
+			"%v (the newer side wins), %v (the source side wins), %v (the copy side is kept with the \".conflict\" "+
+			"suffix)", twoway.PolicyNewer, twoway.PolicySource, twoway.PolicyKeepBoth))
+	flagSet.IntVar(&stg.Retention.KeepLast, "keeplast", 0,
+		"the number of the newest versions of a file (or snapshots) to keep (0 means no such retention rule)")
+	flagSet.IntVar(&stg.Retention.KeepDaily, "keepdaily", 0,
+		"the number of last days, for each of which the newest version of a file (or snapshot) is kept "+
+			"(0 means no such rule)")
+	flagSet.DurationVar(&stg.Retention.KeepWithin, "keepwithin", 0,
+		"all versions of a file (or snapshots) created within this duration are kept (0 means no such rule); "+
+			"if no retention rules are set, then all versions (or snapshots) are kept")
+	flagSet.Func("exclude",
+		"glob pattern of the names or relative paths of the entries, that are not synchronized "+
+			"(the flag may be repeated)",
+		func(pattern string) error {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("bad pattern %q: %v", pattern, err)
+			}
+			stg.Exclude = append(stg.Exclude, pattern)
+			return nil
+		})
+	flagSet.StringVar(&stg.SSHKeyPath, "sshkey", "",
+		"path to the private key (without a passphrase) for the SFTP copy directories, by default the first of "+
+			"~/.ssh/id_ed25519, ~/.ssh/id_ecdsa and ~/.ssh/id_rsa is used")
+	flagSet.StringVar(&stg.KnownHostsPath, "knownhosts", "",
+		"path to the known hosts file, which the SFTP servers' keys are checked against (~/.ssh/known_hosts by default)")
+	flagSet.StringVar(&stg.Token, "token", "",
+		fmt.Sprintf("token for the dsync servers of the dsync:// copy directories (%s env var by default)", TokenEnv))
+	flagSet.BoolVar(&stg.TLS, "tls", false, "if true, then the dsync servers are connected over TLS")
+	flagSet.StringVar(&stg.TLSCAPath, "tlsca", "",
+		"path to the PEM file with CA certificates, the dsync servers' certificates are checked against "+
+			"(the system ones by default), implies -tls")
+	flagSet.StringVar(&stg.S3Endpoint, "s3endpoint", "",
+		"URL of the S3-compatible storage for the s3:// copy directories (AWS S3 of the region by default), "+
+			"the credentials are taken from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN env vars")
+	flagSet.StringVar(&stg.S3Region, "s3region", "",
+		fmt.Sprintf("region of the S3-compatible storage (AWS_REGION env var or %s by default)", s3fs.DefaultRegion))
+	flagSet.BoolVar(&stg.Encrypt, "encrypt", false,
+		fmt.Sprintf("if true, then the content of the files is encrypted in the copy directories by AES-256-GCM, "+
+			"the key is derived from the -keyfile or from the passphrase of %s env var", PassphraseEnv))
+	flagSet.BoolVar(&stg.EncryptNames, "encryptnames", false,
+		"if true, then the names of the files and directories are encrypted as well, implies -encrypt "+
+			"(it can't be changed for the already encrypted copy directory)")
+	flagSet.StringVar(&stg.KeyPath, "keyfile", "",
+		"path to the file (of at least 32 bytes), the encryption key is derived from, implies -encrypt")
+	flagSet.StringVar(&stg.Compress, "compress", "",
+		fmt.Sprintf("if %s, then the files are compressed in the copy directories and kept with %q suffix, "+
+			"except the ones, that are compressed already (judging by the extension or the content)",
+			CompressZstd, compressfs.Suffix))
+	flagSet.StringVar(&stg.HTTPAddr, "http", "",
+		"the address (e.g. localhost:7070) to serve the HTTP status API on: /status, /operations and /entries "+
+			"JSON endpoints and /metrics in the Prometheus format (it's not served by default)")
+	flagSet.StringVar(&stg.JournalPath, "journal", "",
+		"path to the audit journal, which every finished operation is appended to as a JSON line "+
+			"(it's rotated by size, the journal is not kept by default; "+journal.DefaultPath+
+			" is read by the history command by default)")
+	flagSet.StringVar(&stg.ReportPath, "report", "",
+		"path to the JSON file, which the summary of the one-shot sync is written to (requires -once)")
+	flagSet.StringVar(&stg.LogFile, "logfile", log.DefaultFile, "path to the log file")
+	flagSet.IntVar(&stg.LogRotation.MaxSize, "logmaxsize", 100,
+		"the size of the log file in megabytes, after which the file is rotated")
+	flagSet.IntVar(&stg.LogRotation.MaxAge, "logmaxage", 0,
+		"the age in days, after which the rotated log files are removed (0 means they are not removed by age)")
+	flagSet.IntVar(&stg.LogRotation.MaxBackups, "logmaxbackups", 0,
+		"the number of the rotated log files, which are kept (0 means all of them)")
+	flagSet.BoolVar(&stg.LogRotation.Compress, "logcompress", false, "if true, then the rotated log files are gzipped")
+	hookFlag := func(event hooks.Event, when string) {
+		flagSet.Func(string(event),
+			"shell command, which is run "+when+", the details are passed to it as DSYNC_* env vars "+
+				"and as JSON on stdin",
+			func(command string) error {
+				if stg.Hooks.Commands == nil {
+					stg.Hooks.Commands = make(map[hooks.Event]string)
+				}
+				stg.Hooks.Commands[event] = command
+				return nil
+			})
+	}
+	hookFlag(hooks.OpCompleted, "after each completed operation")
+	hookFlag(hooks.OpFailed, "after each failed operation")
+	hookFlag(hooks.CycleConverged, "when the copy dir gets in sync with the source dir")
+	flagSet.DurationVar(&stg.Hooks.Timeout, "hooktimeout", hooks.DefaultTimeout,
+		"how long the hook's command may run, before it's killed")
+	flagSet.IntVar(&stg.Hooks.Concurrency, "hookworkers", hooks.DefaultConcurrency,
+		"the maximum number of the hooks' commands run at once (the events are dropped, when too many are pending)")
+	flagSet.StringVar(&stg.Webhook.URL, "webhook", "",
+		fmt.Sprintf("the URL, which the JSON notifications about the failed operations, the consecutive scan errors "+
+			"and the process stop are posted to (the body is signed with HMAC-SHA256 in the %s header, "+
+			"if the %s env var is set)", webhook.SignatureHeader, webhook.SecretEnv))
+	flagSet.DurationVar(&stg.Webhook.Delay, "webhookdelay", webhook.DefaultDelay,
+		"how long the notifications are collected into one request, before it's posted")
+	flagSet.IntVar(&stg.Webhook.Retries, "webhookretries", webhook.DefaultRetries,
+		"the number of the repeated attempts to post the notifications, if the request fails")
+	flagSet.StringVar(&stg.Trace, "trace", "",
+		"where the tracing spans of the sync cycles and the operations are exported: the http(s) URL of the OTLP "+
+			"collector (e.g. http://localhost:4318) or the path to the JSON file (one span per line)")
+	var configPath string
+	flagSet.StringVar(&configPath, "config", "",
+		"path to the JSON config file with several sync jobs (the directories are not passed as arguments then)")
 	var level string
 	flagSet.StringVar(&level, "loglvl", log.InfoLevel,
 		fmt.Sprintf("level of logging, permitted values are: %v, %v, %v, %v",
 			log.DebugLevel, log.InfoLevel, log.WarnLevel, log.ErrorLevel),
 	)
+	flagSet.Func("complvl",
+		fmt.Sprintf("level of logging of the component (e.g. executor=debug), the components are: %s "+
+			"(the flag may be repeated)", strings.Join(log.Components, ", ")),
+		func(value string) error {
+			component, lvl, ok := strings.Cut(value, "=")
+			if !ok || !log.IsComponent(component) || !log.Level(lvl).IsValid() {
+				return fmt.Errorf("bad component level %q", value)
+			}
+			if stg.ComponentLevels == nil {
+				stg.ComponentLevels = make(map[string]log.Level)
+			}
+			stg.ComponentLevels[component] = log.Level(strings.ToLower(lvl))
+			return nil
+		})
+	var format string
+	flagSet.StringVar(&format, "logformat", string(log.JSONFormat),
+		fmt.Sprintf("format of the log messages: %s or %s (human-readable, with colored levels in the console)",
+			log.JSONFormat, log.ConsoleFormat))
 	flagSet.DurationVar(&stg.ScanPeriod, "scanperiod", time.Second,
 		fmt.Sprintf("period of directories scanning, must be a value between %v and %v", minScanPeriod, maxScanPeriod))
 	flagSet.IntVar(&stg.WorkersCount, "workers", runtime.NumCPU(),
@@ -60,6 +257,45 @@ func New(commandArgs []string, handling flag.ErrorHandling) (*Settings, error) {
 
 	flagSet.Parse(commandArgs)
 
+	if !log.Level(level).IsValid() {
+		return nil, fmt.Errorf("logging level %q does not exist", level)
+	}
+	stg.LogLevel = log.Level(strings.ToLower(level))
+	if stg.LogFormat = log.Format(strings.ToLower(format)); !stg.LogFormat.IsValid() {
+		return nil, fmt.Errorf("logging format %q does not exist", format)
+	}
+	if stg.Token == "" {
+		stg.Token = os.Getenv(TokenEnv)
+	}
+	if stg.TLSCAPath != "" {
+		stg.TLS = true
+	}
+	if stg.S3Region == "" {
+		stg.S3Region = os.Getenv("AWS_REGION")
+	}
+	if stg.KeyPath != "" || stg.EncryptNames {
+		stg.Encrypt = true
+	}
+	stg.Passphrase = os.Getenv(PassphraseEnv)
+	stg.Webhook.Secret = os.Getenv(webhook.SecretEnv)
+	if stg.Compress != "" && stg.Compress != CompressZstd {
+		return nil, fmt.Errorf("compression %q is not supported", stg.Compress)
+	}
+	if stg.ConflictPolicy = twoway.ConflictPolicy(strings.ToLower(policy)); !stg.ConflictPolicy.IsValid() {
+		return nil, fmt.Errorf("conflict policy %q does not exist", policy)
+	}
+
+	if configPath != "" {
+		if flagSet.NArg() > 0 {
+			return nil, errors.New("the directories for synchronization cannot be passed as arguments with -config flag")
+		}
+		var err error
+		if stg.Jobs, err = loadJobs(configPath, *stg); err != nil {
+			return nil, err
+		}
+		return stg, nil
+	}
+
 	if flagSet.NArg() < 2 {
 		return nil, errors.New("at least two arguments (for the directories for synchronization) must present")
 	}
@@ -68,27 +304,56 @@ func New(commandArgs []string, handling flag.ErrorHandling) (*Settings, error) {
 	if stg.SrcDir, err = filepath.Abs(flagSet.Arg(0)); err != nil {
 		return nil, fmt.Errorf("path %q cannot be converted to absolute: %v", flagSet.Arg(0), err)
 	}
-	if stg.CopyDir, err = filepath.Abs(flagSet.Arg(1)); err != nil {
+	if stg.CopyDir, err = copyDirPath(flagSet.Arg(1)); err != nil {
 		return nil, fmt.Errorf("path %q cannot be converted to absolute: %v", flagSet.Arg(1), err)
 	}
 	if stg.SrcDir == stg.CopyDir {
 		return nil, errors.New("the directories for synchronization cannot be the same")
 	}
-	if !log.Level(level).IsValid() {
-		return nil, fmt.Errorf("logging level %q does not exist", level)
+	for _, arg := range flagSet.Args()[2:] {
+		copyDir, err := copyDirPath(arg)
+		if err != nil {
+			return nil, fmt.Errorf("path %q cannot be converted to absolute: %v", arg, err)
+		}
+		stg.ExtraCopyDirs = append(stg.ExtraCopyDirs, copyDir)
 	}
-	stg.LogLevel = log.Level(strings.ToLower(level))
 
 	return stg, nil
 }
 
+//copyDirPath converts the copy dir argument to the absolute path, unless it's the remote dir URL.
+func copyDirPath(arg string) (string, error) {
+	if isRemoteURL(arg) {
+		return arg, nil
+	}
+	return filepath.Abs(arg)
+}
+
+//AllCopyDirs returns the main copy dir and all the additional ones.
+func (stg *Settings) AllCopyDirs() []string {
+	return append([]string{stg.CopyDir}, stg.ExtraCopyDirs...)
+}
+
 func (stg *Settings) Validate() error {
+	if len(stg.Jobs) > 0 {
+		return stg.validateJobs()
+	}
 	if err := validateDirectoryPath(stg.SrcDir); err != nil {
 		return fmt.Errorf("the first (source) directory is invalid: %v", err)
 	}
-	if err := validateDirectoryPath(stg.CopyDir); err != nil {
+	if err := validateCopyDir(stg.CopyDir); err != nil {
 		return fmt.Errorf("the second (copy) directory is invalid: %v", err)
 	}
+	dirs := map[string]bool{stg.SrcDir: true, stg.CopyDir: true}
+	for _, copyDir := range stg.ExtraCopyDirs {
+		if err := validateCopyDir(copyDir); err != nil {
+			return fmt.Errorf("the additional copy directory is invalid: %v", err)
+		}
+		if dirs[copyDir] {
+			return fmt.Errorf("the directory %q is passed more than once", copyDir)
+		}
+		dirs[copyDir] = true
+	}
 	if stg.ScanPeriod < minScanPeriod || stg.ScanPeriod > maxScanPeriod {
 		return fmt.Errorf("period of directories scanning must be a value between %v and %v, while it is %v",
 			minScanPeriod, maxScanPeriod, stg.ScanPeriod)
@@ -97,6 +362,134 @@ func (stg *Settings) Validate() error {
 		return fmt.Errorf("number of workers must be a value between %d and %d, while it is %d",
 			minWorkersCount, maxWorkersCount, stg.WorkersCount)
 	}
+	if stg.LogRotation.MaxSize < 0 || stg.LogRotation.MaxAge < 0 || stg.LogRotation.MaxBackups < 0 {
+		return errors.New("log rotation settings cannot be negative")
+	}
+	if stg.Hooks.Timeout < 0 || stg.Hooks.Concurrency < 0 {
+		return errors.New("hooks' timeout and number of workers cannot be negative")
+	}
+	if err := validateWebhook(stg.Webhook); err != nil {
+		return err
+	}
+	if tracing.IsURL(stg.Trace) {
+		if u, err := url.Parse(stg.Trace); err != nil || u.Host == "" {
+			return fmt.Errorf("trace collector URL %q is invalid", stg.Trace)
+		}
+	}
+	if stg.ReportPath != "" && !stg.Once {
+		return errors.New("the report can be written only with the -once flag")
+	}
+	if stg.Snapshots && !stg.Once {
+		return errors.New("snapshots can be taken only with the -once flag")
+	}
+	if stg.TwoWay && (stg.Snapshots || len(stg.ExtraCopyDirs) > 0) {
+		return errors.New("two-way synchronization is possible only with one copy directory and without snapshots")
+	}
+	if stg.Snapshots && len(stg.ExtraCopyDirs) > 0 {
+		return errors.New("snapshots can be taken only into one copy directory")
+	}
+	if stg.Snapshots && stg.KeepVersions {
+		return errors.New("versions of files cannot be kept in the snapshots mode")
+	}
+	if stg.hasArchiveCopyDir() && (!stg.Once || len(stg.ExtraCopyDirs) > 0) {
+		return errors.New("the archive can be made only with the -once flag and only into one copy destination")
+	}
+	if rel, err := filepath.Rel(stg.SrcDir, stg.CopyDir); err == nil && stg.hasArchiveCopyDir() &&
+		!strings.HasPrefix(rel, "..") {
+		return errors.New("the archive cannot be made inside the source directory")
+	}
+	if stg.hasArchiveCopyDir() && (stg.KeepVersions || stg.Snapshots || stg.TwoWay || stg.SyncSpecials) {
+		return errors.New("versions, snapshots, two-way synchronization and special files are not supported " +
+			"with the archive as the copy destination")
+	}
+	if stg.ReportPath != "" && (stg.Snapshots || stg.hasArchiveCopyDir()) {
+		return errors.New("the report cannot be written when the snapshot or the archive is made")
+	}
+	if stg.hasRemoteCopyDir() && (stg.KeepVersions || stg.Snapshots || stg.TwoWay || stg.SyncSpecials) {
+		return errors.New("versions, snapshots, two-way synchronization and special files are not supported " +
+			"with remote (SFTP, dsync or S3) copy directories")
+	}
+	if stg.hasS3CopyDir() && (stg.SyncPerms || stg.SyncDirMeta) {
+		return errors.New("permissions and directories' metadata are not kept in S3 copy directories")
+	}
+	if stg.Encrypt && stg.KeyPath == "" && stg.Passphrase == "" {
+		return fmt.Errorf("the encryption key must be passed by -keyfile flag or %s env var", PassphraseEnv)
+	}
+	if (stg.Encrypt || stg.Compress != "" || stg.Restore) && (stg.KeepVersions || stg.Snapshots || stg.TwoWay ||
+		stg.SyncSpecials || stg.hasArchiveCopyDir()) {
+		return errors.New("versions, snapshots, two-way synchronization, special files and archives are not " +
+			"supported with encryption or compression")
+	}
+	if stg.Restore && (stg.Encrypt || stg.Compress != "") {
+		return errors.New("the restored directory cannot be encrypted or compressed again")
+	}
+	if stg.Retention.KeepLast < 0 || stg.Retention.KeepDaily < 0 || stg.Retention.KeepWithin < 0 {
+		return errors.New("versions retention rules cannot be negative")
+	}
+	return nil
+}
+
+//hasRemoteCopyDir reports whether any of the copy dirs is accessed over SFTP, the dsync protocol or S3 API.
+func (stg *Settings) hasRemoteCopyDir() bool {
+	for _, copyDir := range stg.AllCopyDirs() {
+		if isRemoteURL(copyDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (stg *Settings) hasS3CopyDir() bool {
+	for _, copyDir := range stg.AllCopyDirs() {
+		if s3fs.IsURL(copyDir) {
+			return true
+		}
+	}
+	return false
+}
+
+//hasArchiveCopyDir reports whether any of the copy dirs is the path of an archive (see archive.IsPath).
+func (stg *Settings) hasArchiveCopyDir() bool {
+	for _, copyDir := range stg.AllCopyDirs() {
+		if archive.IsPath(copyDir) {
+			return true
+		}
+	}
+	return false
+}
+
+//isRemoteURL reports whether the copy dir is the URL of the remote dir rather than the local path.
+func isRemoteURL(copyDir string) bool {
+	return sftpfs.IsURL(copyDir) || netfs.IsURL(copyDir) || s3fs.IsURL(copyDir)
+}
+
+//validateCopyDir validates the local copy dir path, the remote dir URL (the remote dir is checked on the first sync)
+//or the archive path.
+func validateCopyDir(copyDir string) error {
+	switch {
+	case archive.IsPath(copyDir):
+		return validateArchivePath(copyDir)
+	case sftpfs.IsURL(copyDir):
+		_, err := sftpfs.ParseURL(copyDir)
+		return err
+	case netfs.IsURL(copyDir):
+		_, err := netfs.ParseURL(copyDir)
+		return err
+	case s3fs.IsURL(copyDir):
+		_, err := s3fs.ParseURL(copyDir)
+		return err
+	}
+	return validateDirectoryPath(copyDir)
+}
+
+//validateArchivePath checks that the archive can be made at the path, i.e. its dir exists, and it's not a dir itself.
+func validateArchivePath(path string) error {
+	if err := validateDirectoryPath(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return fmt.Errorf("path %q has the archive extension, but it's a directory", path)
+	}
 	return nil
 }
 
@@ -110,3 +503,17 @@ func validateDirectoryPath(path string) error {
 	}
 	return nil
 }
+
+func validateWebhook(cfg webhook.Config) error {
+	if cfg.Delay < 0 || cfg.Retries < 0 {
+		return errors.New("webhook's delay and number of retries cannot be negative")
+	}
+	if cfg.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL %q is invalid, it must be http or https URL", cfg.URL)
+	}
+	return nil
+}
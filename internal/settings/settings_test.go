@@ -1,8 +1,13 @@
 package settings
 
 import (
+	"dsync/internal/hooks"
 	"dsync/internal/log"
+	"dsync/internal/twoway"
+	"dsync/internal/versions"
+	"dsync/internal/webhook"
 	"flag"
+	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
@@ -24,14 +29,24 @@ func TestNew(t *testing.T) {
 		{name: "flag panic 3", commandArgs: []string{"-loglvl"}, panic: true, wantErr: false, want: nil},
 		{name: "flag panic 4", commandArgs: []string{"-workers=a"}, panic: true, wantErr: false, want: nil},
 		{name: "flag panic 5", commandArgs: []string{"-scanperiod=b"}, panic: true, wantErr: false, want: nil},
+		{name: "flag panic 6", commandArgs: []string{"-exclude=[a"}, panic: true, wantErr: false, want: nil},
+		{name: "flag panic 9", commandArgs: []string{"-hookworkers=many"}, panic: true},
+		{name: "bad log format", commandArgs: []string{"-logformat=xml", "dir1", "dir2"}, wantErr: true},
+		{name: "flag panic 7", commandArgs: []string{"-complvl=walker=debug"}, panic: true},
+		{name: "flag panic 8", commandArgs: []string{"-complvl=executor=loud"}, panic: true},
+		{name: "bad compression", commandArgs: []string{"-compress=lz4", "dir1", "dir2"}, wantErr: true},
 		{name: "no args", commandArgs: nil, panic: false, wantErr: true, want: nil},
 		{name: "not enough args", commandArgs: []string{"a"}, panic: false, wantErr: true, want: nil},
 		{name: "bad level", commandArgs: []string{"-loglvl=nope", "d1", "d2"}, panic: false, wantErr: true, want: nil},
+		{name: "bad policy", commandArgs: []string{"-conflict=nope", "d1", "d2"}, panic: false, wantErr: true, want: nil},
 		{name: "same dirs", commandArgs: []string{"dir", "dir"}, panic: false, wantErr: true, want: nil},
+		{name: "config with dirs", commandArgs: []string{"-config=c.json", "d1", "d2"}, panic: false, wantErr: true, want: nil},
+		{name: "no config", commandArgs: []string{"-config=noSuchFile.json"}, panic: false, wantErr: true, want: nil},
 		{
 			name: "valid args",
 			commandArgs: []string{"-hidden", "-copydirs", "-log2std", "-once", "-pid",
-				"-loglvl=debug", "-scanperiod=3s", "-workers=10", "dir1", "dir2"},
+				"-loglvl=debug", "-scanperiod=3s", "-workers=10", "-dirmeta", "-perms", "-specials", "-verify", "-versions", "-keeplast=5", "-keepdaily=7", "-keepwithin=1h",
+				"-snapshots", "-exclude=*.tmp", "-exclude=build", "-twoway", "-conflict=keepboth", "dir1", "dir2"},
 			panic:   false,
 			wantErr: false,
 			want: &Settings{
@@ -45,6 +60,215 @@ func TestNew(t *testing.T) {
 				Once:             true,
 				PrintPID:         true,
 				WorkersCount:     10,
+				SyncDirMeta:      true,
+				SyncPerms:        true,
+				SyncSpecials:     true,
+				Verify:           true,
+				KeepVersions:     true,
+				Retention:        versions.RetentionPolicy{KeepLast: 5, KeepDaily: 7, KeepWithin: time.Hour},
+				Snapshots:        true,
+				TwoWay:           true,
+				ConflictPolicy:   twoway.PolicyKeepBoth,
+				Hooks:            hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:          webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:          log.DefaultFile,
+				LogFormat:        log.JSONFormat,
+				LogRotation:      log.Rotation{MaxSize: 100},
+				Exclude:          []string{"*.tmp", "build"},
+			},
+		},
+		{
+			name:        "several copy dirs",
+			commandArgs: []string{"dir1", "dir2", "dir3", "dir4"},
+			panic:       false,
+			wantErr:     false,
+			want: &Settings{
+				SrcDir:         abs("dir1"),
+				CopyDir:        abs("dir2"),
+				ExtraCopyDirs:  []string{abs("dir3"), abs("dir4")},
+				ScanPeriod:     time.Second,
+				LogLevel:       log.InfoLevel,
+				WorkersCount:   runtime.NumCPU(),
+				ConflictPolicy: twoway.PolicyNewer,
+				Hooks:          hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:        webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:        log.DefaultFile,
+				LogFormat:      log.JSONFormat,
+				LogRotation:    log.Rotation{MaxSize: 100},
+			},
+		},
+		{
+			name:        "sftp copy dir",
+			commandArgs: []string{"-sshkey=key", "-knownhosts=hosts", "dir1", "sftp://bob@host/dir2", "dir3"},
+			panic:       false,
+			wantErr:     false,
+			want: &Settings{
+				SrcDir:         abs("dir1"),
+				CopyDir:        "sftp://bob@host/dir2",
+				ExtraCopyDirs:  []string{abs("dir3")},
+				ScanPeriod:     time.Second,
+				LogLevel:       log.InfoLevel,
+				WorkersCount:   runtime.NumCPU(),
+				ConflictPolicy: twoway.PolicyNewer,
+				Hooks:          hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:        webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:        log.DefaultFile,
+				LogFormat:      log.JSONFormat,
+				LogRotation:    log.Rotation{MaxSize: 100},
+				SSHKeyPath:     "key",
+				KnownHostsPath: "hosts",
+			},
+		},
+		{
+			name:        "dsync copy dir",
+			commandArgs: []string{"-token=secret", "-tlsca=ca.pem", "dir1", "dsync://host/dir2"},
+			panic:       false,
+			wantErr:     false,
+			want: &Settings{
+				SrcDir:         abs("dir1"),
+				CopyDir:        "dsync://host/dir2",
+				ScanPeriod:     time.Second,
+				LogLevel:       log.InfoLevel,
+				WorkersCount:   runtime.NumCPU(),
+				ConflictPolicy: twoway.PolicyNewer,
+				Hooks:          hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:        webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:        log.DefaultFile,
+				LogFormat:      log.JSONFormat,
+				LogRotation:    log.Rotation{MaxSize: 100},
+				Token:          "secret",
+				TLS:            true,
+				TLSCAPath:      "ca.pem",
+			},
+		},
+		{
+			name:        "s3 copy dir",
+			commandArgs: []string{"-s3endpoint=http://localhost:9000", "-s3region=eu-west-1", "dir1", "s3://bucket/dir2"},
+			panic:       false,
+			wantErr:     false,
+			want: &Settings{
+				SrcDir:         abs("dir1"),
+				CopyDir:        "s3://bucket/dir2",
+				ScanPeriod:     time.Second,
+				LogLevel:       log.InfoLevel,
+				WorkersCount:   runtime.NumCPU(),
+				ConflictPolicy: twoway.PolicyNewer,
+				Hooks:          hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:        webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:        log.DefaultFile,
+				LogFormat:      log.JSONFormat,
+				LogRotation:    log.Rotation{MaxSize: 100},
+				S3Endpoint:     "http://localhost:9000",
+				S3Region:       "eu-west-1",
+			},
+		},
+		{
+			name:        "encryption",
+			commandArgs: []string{"-keyfile=key", "-encryptnames", "-compress=zstd", "dir1", "dir2"},
+			panic:       false,
+			wantErr:     false,
+			want: &Settings{
+				SrcDir:         abs("dir1"),
+				CopyDir:        abs("dir2"),
+				ScanPeriod:     time.Second,
+				LogLevel:       log.InfoLevel,
+				WorkersCount:   runtime.NumCPU(),
+				ConflictPolicy: twoway.PolicyNewer,
+				Hooks:          hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:        webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:        log.DefaultFile,
+				LogFormat:      log.JSONFormat,
+				LogRotation:    log.Rotation{MaxSize: 100},
+				Encrypt:        true,
+				EncryptNames:   true,
+				KeyPath:        "key",
+				Compress:       CompressZstd,
+			},
+		},
+		{
+			name:        "console logs",
+			commandArgs: []string{"-logformat=Console", "-complvl=executor=debug", "-complvl=scanner=WARN", "dir1", "dir2"},
+			panic:       false,
+			wantErr:     false,
+			want: &Settings{
+				SrcDir:          abs("dir1"),
+				CopyDir:         abs("dir2"),
+				ScanPeriod:      time.Second,
+				LogLevel:        log.InfoLevel,
+				ComponentLevels: map[string]log.Level{"executor": log.DebugLevel, "scanner": log.WarnLevel},
+				LogFormat:       log.ConsoleFormat,
+				WorkersCount:    runtime.NumCPU(),
+				ConflictPolicy:  twoway.PolicyNewer,
+				LogFile:         log.DefaultFile,
+				LogRotation:     log.Rotation{MaxSize: 100},
+				Hooks:           hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:         webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+			},
+		},
+		{
+			name: "hooks",
+			commandArgs: []string{"-on-op-failed=notify.sh", "-on-cycle-converged", "nginx -s reload",
+				"-hooktimeout=5s", "-hookworkers=2", "dir1", "dir2"},
+			panic:   false,
+			wantErr: false,
+			want: &Settings{
+				SrcDir:         abs("dir1"),
+				CopyDir:        abs("dir2"),
+				ScanPeriod:     time.Second,
+				LogLevel:       log.InfoLevel,
+				LogFormat:      log.JSONFormat,
+				WorkersCount:   runtime.NumCPU(),
+				ConflictPolicy: twoway.PolicyNewer,
+				LogFile:        log.DefaultFile,
+				LogRotation:    log.Rotation{MaxSize: 100},
+				Hooks: hooks.Config{
+					Commands: map[hooks.Event]string{
+						hooks.OpFailed: "notify.sh", hooks.CycleConverged: "nginx -s reload",
+					},
+					Timeout:     5 * time.Second,
+					Concurrency: 2,
+				},
+				Webhook: webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+			},
+		},
+		{
+			name:        "status API",
+			commandArgs: []string{"-http=localhost:7070", "dir1", "dir2"},
+			panic:       false,
+			wantErr:     false,
+			want: &Settings{
+				SrcDir:         abs("dir1"),
+				CopyDir:        abs("dir2"),
+				ScanPeriod:     time.Second,
+				LogLevel:       log.InfoLevel,
+				WorkersCount:   runtime.NumCPU(),
+				ConflictPolicy: twoway.PolicyNewer,
+				Hooks:          hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:        webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:        log.DefaultFile,
+				LogFormat:      log.JSONFormat,
+				LogRotation:    log.Rotation{MaxSize: 100},
+				HTTPAddr:       "localhost:7070",
+			},
+		},
+		{
+			name:        "tracing",
+			commandArgs: []string{"-trace=http://localhost:4318", "dir1", "dir2"},
+			panic:       false,
+			wantErr:     false,
+			want: &Settings{
+				SrcDir:         abs("dir1"),
+				CopyDir:        abs("dir2"),
+				ScanPeriod:     time.Second,
+				LogLevel:       log.InfoLevel,
+				WorkersCount:   runtime.NumCPU(),
+				ConflictPolicy: twoway.PolicyNewer,
+				Hooks:          hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:        webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:        log.DefaultFile,
+				LogFormat:      log.JSONFormat,
+				LogRotation:    log.Rotation{MaxSize: 100},
+				Trace:          "http://localhost:4318",
 			},
 		},
 		{
@@ -63,6 +287,12 @@ func TestNew(t *testing.T) {
 				Once:             false,
 				PrintPID:         false,
 				WorkersCount:     runtime.NumCPU(),
+				ConflictPolicy:   twoway.PolicyNewer,
+				Hooks:            hooks.Config{Timeout: hooks.DefaultTimeout, Concurrency: hooks.DefaultConcurrency},
+				Webhook:          webhook.Config{Delay: webhook.DefaultDelay, Retries: webhook.DefaultRetries},
+				LogFile:          log.DefaultFile,
+				LogFormat:        log.JSONFormat,
+				LogRotation:      log.Rotation{MaxSize: 100},
 			},
 		},
 	}
@@ -105,6 +335,20 @@ func TestSettings_Validate(t *testing.T) {
 		CopyDir      string
 		ScanPeriod   time.Duration
 		WorkersCount int
+		Retention    versions.RetentionPolicy
+		Once         bool
+		KeepVersions bool
+		Snapshots    bool
+		ExtraCopies  []string
+		TwoWay       bool
+		SyncSpecials bool
+		SyncPerms    bool
+		Encrypt      bool
+		KeyPath      string
+		Compress     string
+		ReportPath   string
+		WebhookURL   string
+		Trace        string
 	}
 	tests := []struct {
 		name    string
@@ -148,6 +392,207 @@ func TestSettings_Validate(t *testing.T) {
 			wantErr: true,
 			errText: "number of workers must be a value between",
 		},
+		{
+			name: "bad retention",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Retention: versions.RetentionPolicy{KeepLast: -1}},
+			wantErr: true,
+			errText: "versions retention rules cannot be negative",
+		},
+		{
+			name: "report without once",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, ReportPath: "report.json"},
+			wantErr: true,
+			errText: "the report can be written only with the -once flag",
+		},
+		{
+			name: "bad webhook URL",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, WebhookURL: "ftp://example.com/hook"},
+			wantErr: true,
+			errText: "webhook URL \"ftp://example.com/hook\" is invalid",
+		},
+		{
+			name: "webhook",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, WebhookURL: "https://example.com/hook"},
+			wantErr: false,
+		},
+		{
+			name: "bad trace collector URL",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Trace: "http://"},
+			wantErr: true,
+			errText: "trace collector URL \"http://\" is invalid",
+		},
+		{
+			name: "snapshots without once",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Snapshots: true},
+			wantErr: true,
+			errText: "snapshots can be taken only with the -once flag",
+		},
+		{
+			name: "snapshots with versions",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Once: true, KeepVersions: true, Snapshots: true},
+			wantErr: true,
+			errText: "versions of files cannot be kept in the snapshots mode",
+		},
+		{
+			name: "snapshots with report",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Once: true, Snapshots: true, ReportPath: "report.json"},
+			wantErr: true,
+			errText: "the report cannot be written when the snapshot or the archive is made",
+		},
+		{
+			name: "bad extra copy dir",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, ExtraCopies: []string{"noSuchDir"}},
+			wantErr: true,
+			errText: "the additional copy directory is invalid",
+		},
+		{
+			name: "repeated copy dir",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, ExtraCopies: []string{"../log", "../model"}},
+			wantErr: true,
+			errText: "is passed more than once",
+		},
+		{
+			name: "snapshots with several copy dirs",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Once: true, Snapshots: true, ExtraCopies: []string{"../log"}},
+			wantErr: true,
+			errText: "snapshots can be taken only into one copy directory",
+		},
+		{
+			name: "two-way with several copy dirs",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, TwoWay: true, ExtraCopies: []string{"../log"}},
+			wantErr: true,
+			errText: "two-way synchronization is possible only with one copy directory",
+		},
+		{
+			name:    "bad sftp copy dir",
+			fields:  fields{SrcDir: "../settings", CopyDir: "sftp://bob@host/"},
+			wantErr: true,
+			errText: "no dir path",
+		},
+		{
+			name: "sftp copy dir with specials",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, SyncSpecials: true, ExtraCopies: []string{"sftp://bob@host/copy"}},
+			wantErr: true,
+			errText: "are not supported with remote (SFTP, dsync or S3) copy directories",
+		},
+		{
+			name:    "bad dsync copy dir",
+			fields:  fields{SrcDir: "../settings", CopyDir: "dsync://bob@host/copy"},
+			wantErr: true,
+			errText: "user info, query and fragment are not supported",
+		},
+		{
+			name: "s3 copy dir with perms",
+			fields: fields{SrcDir: "../settings", CopyDir: "s3://bucket/copy", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, SyncPerms: true},
+			wantErr: true,
+			errText: "permissions and directories' metadata are not kept in S3 copy directories",
+		},
+		{
+			name: "archive without once",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model/out.tar.zst", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount},
+			wantErr: true,
+			errText: "the archive can be made only with the -once flag",
+		},
+		{
+			name: "archive in missing dir",
+			fields: fields{SrcDir: "../settings", CopyDir: "../noSuchDir/out.zip", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Once: true},
+			wantErr: true,
+			errText: "the second (copy) directory is invalid",
+		},
+		{
+			name: "archive inside source dir",
+			fields: fields{SrcDir: "../settings", CopyDir: "../settings/out.tar", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Once: true},
+			wantErr: true,
+			errText: "the archive cannot be made inside the source directory",
+		},
+		{
+			name: "archive with versions",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model/out.tgz", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Once: true, KeepVersions: true},
+			wantErr: true,
+			errText: "are not supported with the archive as the copy destination",
+		},
+		{
+			name: "archive with report",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model/out.zip", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Once: true, ReportPath: "report.json"},
+			wantErr: true,
+			errText: "the report cannot be written when the snapshot or the archive is made",
+		},
+		{
+			name: "remote copy dirs named like archives",
+			fields: fields{SrcDir: "../settings", CopyDir: "sftp://bob@host/backups.zip", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, ExtraCopies: []string{"s3://bucket/x.tar"}},
+			wantErr: false,
+		},
+		{
+			name: "ok with archive",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model/out.tar.zst", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Once: true},
+			wantErr: false,
+		},
+		{
+			name: "encryption without key",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Encrypt: true},
+			wantErr: true,
+			errText: "the encryption key must be passed",
+		},
+		{
+			name: "encryption with versions",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Encrypt: true, KeyPath: "key", KeepVersions: true},
+			wantErr: true,
+			errText: "are not supported with encryption",
+		},
+		{
+			name: "compression with two-way sync",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Compress: CompressZstd, TwoWay: true},
+			wantErr: true,
+			errText: "are not supported with encryption or compression",
+		},
+		{
+			name: "ok with encryption",
+			fields: fields{SrcDir: "../settings", CopyDir: "s3://bucket", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, Encrypt: true, KeyPath: "key", Compress: CompressZstd},
+			wantErr: false,
+		},
+		{
+			name: "ok with s3 copy dir",
+			fields: fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount, ExtraCopies: []string{"s3://bucket"}},
+			wantErr: false,
+		},
+		{
+			name: "ok with dsync copy dir",
+			fields: fields{SrcDir: "../settings", CopyDir: "dsync://host/copy", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount},
+			wantErr: false,
+		},
+		{
+			name: "ok with sftp copy dir",
+			fields: fields{SrcDir: "../settings", CopyDir: "sftp://bob@host:2222/copy", ScanPeriod: minScanPeriod,
+				WorkersCount: minWorkersCount},
+			wantErr: false,
+		},
 		{
 			name:    "ok",
 			fields:  fields{SrcDir: "../settings", CopyDir: "../model", ScanPeriod: minScanPeriod, WorkersCount: minWorkersCount},
@@ -159,10 +604,24 @@ func TestSettings_Validate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			err := (&Settings{
-				SrcDir:       tt.fields.SrcDir,
-				CopyDir:      tt.fields.CopyDir,
-				ScanPeriod:   tt.fields.ScanPeriod,
-				WorkersCount: tt.fields.WorkersCount,
+				SrcDir:        tt.fields.SrcDir,
+				CopyDir:       tt.fields.CopyDir,
+				ScanPeriod:    tt.fields.ScanPeriod,
+				WorkersCount:  tt.fields.WorkersCount,
+				Retention:     tt.fields.Retention,
+				Once:          tt.fields.Once,
+				KeepVersions:  tt.fields.KeepVersions,
+				Snapshots:     tt.fields.Snapshots,
+				ExtraCopyDirs: tt.fields.ExtraCopies,
+				TwoWay:        tt.fields.TwoWay,
+				SyncSpecials:  tt.fields.SyncSpecials,
+				SyncPerms:     tt.fields.SyncPerms,
+				Encrypt:       tt.fields.Encrypt,
+				KeyPath:       tt.fields.KeyPath,
+				Compress:      tt.fields.Compress,
+				ReportPath:    tt.fields.ReportPath,
+				Webhook:       webhook.Config{URL: tt.fields.WebhookURL},
+				Trace:         tt.fields.Trace,
 			}).Validate()
 
 			requires := require.New(t)
@@ -176,6 +635,17 @@ func TestSettings_Validate(t *testing.T) {
 	}
 }
 
+func TestSettings_ValidateDirNamedLikeArchive(t *testing.T) {
+	requires := require.New(t)
+	copyDir := filepath.Join(t.TempDir(), "copy.tar")
+	requires.NoError(os.Mkdir(copyDir, 0o755))
+
+	// the existing dir is synced as usual, even without the -once flag
+	stg := &Settings{SrcDir: "../settings", CopyDir: copyDir, ScanPeriod: minScanPeriod, WorkersCount: minWorkersCount,
+		KeepVersions: true}
+	requires.NoError(stg.Validate())
+}
+
 func abs(path string) string {
 	s, _ := filepath.Abs(path)
 	return s
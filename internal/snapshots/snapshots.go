@@ -0,0 +1,190 @@
+package snapshots
+
+import (
+	"dsync/internal/versions"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//LatestLink is the name of the symlink (in the snapshots root dir), that points to the latest snapshot.
+	LatestLink = "latest"
+
+	partialSuffix = ".partial"
+	timeLayout    = "20060102-150405"
+)
+
+//Snapshot is one point-in-time copy of the source dir.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	FullPath  string    `json:"fullPath"`
+	CreatedAt time.Time `json:"createdAt"`
+	seq       int       // the sequence number of the snapshot among the ones created in the same second
+}
+
+//Store manages timestamped snapshot dirs (named as YYYYMMDD-HHMMSS) inside the root dir.
+//A snapshot is built in the dir with the ".partial" suffix, which is renamed on success, so incomplete snapshots
+//are never taken as the previous (latest) ones.
+type Store struct {
+	root string
+	now  func() time.Time
+}
+
+func NewStore(root string) *Store {
+	return &Store{root: root, now: time.Now}
+}
+
+//List returns all complete snapshots, the newest snapshots go first.
+func (s *Store) List() ([]Snapshot, error) {
+	dirEntries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read snapshots dir: %w", err)
+	}
+	var snapshots []Snapshot
+	for _, de := range dirEntries {
+		if !de.IsDir() {
+			continue
+		}
+		createdAt, seq, ok := parseSnapshotName(de.Name())
+		if !ok {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:      de.Name(),
+			FullPath:  filepath.Join(s.root, de.Name()),
+			CreatedAt: createdAt,
+			seq:       seq,
+		})
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if snapshots[i].CreatedAt.Equal(snapshots[j].CreatedAt) {
+			return snapshots[i].seq > snapshots[j].seq
+		}
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+//Latest returns the newest complete snapshot. If there are no snapshots yet, it returns false.
+func (s *Store) Latest() (Snapshot, bool, error) {
+	snapshots, err := s.List()
+	if err != nil || len(snapshots) == 0 {
+		return Snapshot{}, false, err
+	}
+	return snapshots[0], true, nil
+}
+
+//Begin creates the new (empty) partial snapshot dir and returns its path. The leftovers of the previous
+//unsuccessful attempts are removed.
+func (s *Store) Begin() (string, error) {
+	if err := s.removePartials(); err != nil {
+		return "", err
+	}
+	createdAt := s.now().UTC()
+	base := filepath.Join(s.root, createdAt.Format(timeLayout))
+	name := base
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("cannot check snapshot dir: %w", err)
+		}
+		name = base + "-" + strconv.Itoa(i)
+	}
+	partialPath := name + partialSuffix
+	if err := os.Mkdir(partialPath, os.ModePerm); err != nil {
+		return "", fmt.Errorf("cannot make snapshot dir: %w", err)
+	}
+	return partialPath, nil
+}
+
+//Commit makes the partial snapshot complete and points the LatestLink symlink to it.
+func (s *Store) Commit(partialPath string) (Snapshot, error) {
+	fullPath := strings.TrimSuffix(partialPath, partialSuffix)
+	if err := os.Rename(partialPath, fullPath); err != nil {
+		return Snapshot{}, fmt.Errorf("cannot complete snapshot: %w", err)
+	}
+	name := filepath.Base(fullPath)
+	// the symlink is replaced atomically, so LatestLink always points to some complete snapshot
+	tmpLink := filepath.Join(s.root, LatestLink+partialSuffix)
+	_ = os.Remove(tmpLink)
+	if err := os.Symlink(name, tmpLink); err != nil {
+		return Snapshot{}, fmt.Errorf("cannot make %q symlink: %w", LatestLink, err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(s.root, LatestLink)); err != nil {
+		return Snapshot{}, fmt.Errorf("cannot replace %q symlink: %w", LatestLink, err)
+	}
+	createdAt, seq, _ := parseSnapshotName(name)
+	return Snapshot{Name: name, FullPath: fullPath, CreatedAt: createdAt, seq: seq}, nil
+}
+
+//Prune removes the snapshots, that are not kept by the retention policy. The latest snapshot is always kept.
+func (s *Store) Prune(policy versions.RetentionPolicy) ([]Snapshot, error) {
+	snapshots, err := s.List()
+	if err != nil || len(snapshots) < 2 {
+		return nil, err
+	}
+	// the snapshots are retained by the same rules as the versions of a file
+	vers := make([]versions.Version, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		vers = append(vers, versions.Version{
+			Path:      snapshot.Name,
+			FullPath:  snapshot.FullPath,
+			CreatedAt: snapshot.CreatedAt,
+		})
+	}
+	_, obsolete := policy.Apply(vers, s.now())
+	removed := make([]Snapshot, 0, len(obsolete))
+	for _, v := range obsolete {
+		if v.FullPath == snapshots[0].FullPath {
+			continue
+		}
+		if err := os.RemoveAll(v.FullPath); err != nil {
+			return removed, fmt.Errorf("cannot remove obsolete snapshot: %w", err)
+		}
+		removed = append(removed, Snapshot{Name: v.Path, FullPath: v.FullPath, CreatedAt: v.CreatedAt})
+	}
+	return removed, nil
+}
+
+func (s *Store) removePartials() error {
+	partials, err := filepath.Glob(filepath.Join(s.root, "*"+partialSuffix))
+	if err != nil {
+		return err
+	}
+	for _, partial := range partials {
+		if _, _, ok := parseSnapshotName(strings.TrimSuffix(filepath.Base(partial), partialSuffix)); !ok {
+			continue
+		}
+		if err := os.RemoveAll(partial); err != nil {
+			return fmt.Errorf("cannot remove incomplete snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+//parseSnapshotName parses the snapshot creation time and its sequence number (among the snapshots created
+//in the same second) from the snapshot dir name.
+func parseSnapshotName(name string) (createdAt time.Time, seq int, ok bool) {
+	stamp, seq := name, 1
+	if len(name) > len(timeLayout) {
+		suffix := name[len(timeLayout):]
+		n, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+		if err != nil || !strings.HasPrefix(suffix, "-") {
+			return time.Time{}, 0, false
+		}
+		stamp, seq = name[:len(timeLayout)], n
+	}
+	createdAt, err := time.Parse(timeLayout, stamp)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return createdAt, seq, true
+}
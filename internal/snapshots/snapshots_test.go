@@ -0,0 +1,87 @@
+package snapshots
+
+import (
+	"dsync/internal/versions"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_BeginAndCommit(t *testing.T) {
+	requires := require.New(t)
+
+	// 1. arrange
+	root := t.TempDir()
+	now := time.Date(2022, time.August, 10, 12, 0, 0, 0, time.UTC)
+	store := NewStore(root)
+	store.now = func() time.Time { return now }
+
+	_, hasLatest, err := store.Latest()
+	requires.NoError(err)
+	requires.False(hasLatest)
+
+	// 2. act: the first snapshot is left incomplete, and 2 others are taken in the same second
+	_, err = store.Begin()
+	requires.NoError(err)
+	var taken []Snapshot
+	for i := 0; i < 2; i++ {
+		partialPath, err := store.Begin()
+		requires.NoError(err)
+		requires.NoError(os.WriteFile(filepath.Join(partialPath, "file.txt"), nil, 0644))
+		snapshot, err := store.Commit(partialPath)
+		requires.NoError(err)
+		taken = append(taken, snapshot)
+	}
+
+	// 3. assert
+	requires.Equal("20220810-120000", taken[0].Name)
+	requires.Equal("20220810-120000-2", taken[1].Name)
+
+	snapshots, err := store.List()
+	requires.NoError(err)
+	requires.Equal([]Snapshot{taken[1], taken[0]}, snapshots)
+
+	latest, hasLatest, err := store.Latest()
+	requires.NoError(err)
+	requires.True(hasLatest)
+	requires.Equal(taken[1], latest)
+
+	target, err := os.Readlink(filepath.Join(root, LatestLink))
+	requires.NoError(err)
+	requires.Equal(taken[1].Name, target)
+
+	partials, err := filepath.Glob(filepath.Join(root, "*"+partialSuffix))
+	requires.NoError(err)
+	requires.Empty(partials)
+}
+
+func TestStore_Prune(t *testing.T) {
+	requires := require.New(t)
+
+	// 1. arrange
+	root := t.TempDir()
+	now := time.Date(2022, time.August, 10, 12, 0, 0, 0, time.UTC)
+	store := NewStore(root)
+	store.now = func() time.Time { return now }
+	for i := 0; i < 4; i++ {
+		partialPath, err := store.Begin()
+		requires.NoError(err)
+		_, err = store.Commit(partialPath)
+		requires.NoError(err)
+		now = now.Add(time.Hour)
+	}
+
+	// 2. act
+	removed, err := store.Prune(versions.RetentionPolicy{KeepWithin: time.Minute}) // none is within a minute
+
+	// 3. assert
+	requires.NoError(err)
+	requires.Len(removed, 3)
+	snapshots, err := store.List()
+	requires.NoError(err)
+	requires.Len(snapshots, 1) // the latest snapshot is always kept
+	requires.Equal("20220810-150000", snapshots[0].Name)
+}
@@ -0,0 +1,119 @@
+package twoway
+
+import (
+	"dsync/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//BaseFileName is the name of the file (in the copy dir), where the base state is saved between the runs.
+const BaseFileName = ".dsync-base.json"
+
+const tmpSuffix = ".tmp"
+
+//BaseEntry is the state of one entry, which it had on both sides, when they were in sync last time.
+type BaseEntry struct {
+	IsDir   bool        `json:"isDir,omitempty"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"modTime"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+}
+
+//IsBaseFile reports whether the relative path is of the base state file (or of its temporary file).
+func IsBaseFile(path string) bool {
+	return path == BaseFileName || path == BaseFileName+tmpSuffix
+}
+
+func NewBaseEntry(pi model.PathInfo) BaseEntry {
+	return BaseEntry{IsDir: pi.IsDir, Size: pi.Size, ModTime: pi.ModTime, Mode: pi.Mode.Type()}
+}
+
+//IsSameAs reports whether the entry hasn't changed since it was in sync (in the same way as PathInfo.IsSameAs does).
+func (be BaseEntry) IsSameAs(pi model.PathInfo) bool {
+	if !pi.Exists || be.IsDir != pi.IsDir || be.Mode != pi.Mode.Type() {
+		return false
+	}
+	return be.IsDir || model.IsSpecialMode(be.Mode) || (be.Size == pi.Size && be.ModTime.Equal(pi.ModTime))
+}
+
+//BaseState holds the last synced state of each entry, which allows to tell, which side has changed since then.
+//It's safe for concurrent use.
+type BaseState struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]BaseEntry
+	dirty   bool
+}
+
+//LoadBase reads the base state from the copy dir. If it's not saved yet, then the empty base state is returned.
+func LoadBase(copyDir string) (*BaseState, error) {
+	b := &BaseState{path: filepath.Join(copyDir, BaseFileName), entries: make(map[string]BaseEntry)}
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read base state: %w", err)
+	}
+	if err := json.Unmarshal(data, &b.entries); err != nil {
+		return nil, fmt.Errorf("cannot parse base state %q: %w", b.path, err)
+	}
+	return b, nil
+}
+
+func (b *BaseState) Get(path string) (BaseEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.entries[path]
+	return entry, ok
+}
+
+//Update makes the base state of the entry match the entry info, if both sides of the entry are in sync
+//(including the case when the entry doesn't exist on both sides). Otherwise, the base state is left untouched.
+func (b *BaseState) Update(path string, entry *model.EntryInfo) {
+	if entry.IsSyncRequired() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old, ok := b.entries[path]
+	if !entry.SrcPathInfo.Exists {
+		if ok {
+			delete(b.entries, path)
+			b.dirty = true
+		}
+		return
+	}
+	if newEntry := NewBaseEntry(entry.SrcPathInfo); !ok || old != newEntry {
+		b.entries[path] = newEntry
+		b.dirty = true
+	}
+}
+
+//Save writes the base state to the copy dir (atomically), if it has changed since the last saving.
+func (b *BaseState) Save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.dirty {
+		return nil
+	}
+	data, err := json.Marshal(b.entries)
+	if err != nil {
+		return fmt.Errorf("cannot marshal base state: %w", err)
+	}
+	tmpPath := b.path + tmpSuffix
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("cannot write base state: %w", err)
+	}
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		return fmt.Errorf("cannot replace base state: %w", err)
+	}
+	b.dirty = false
+	return nil
+}
@@ -0,0 +1,68 @@
+package twoway
+
+import (
+	"dsync/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseState(t *testing.T) {
+	requires := require.New(t)
+	copyDir := t.TempDir()
+	modTime := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	file := model.PathInfo{Exists: true, Size: 10, ModTime: modTime}
+
+	base, err := LoadBase(copyDir)
+	requires.NoError(err)
+	requires.NoError(base.Save()) // nothing has changed, so nothing is saved
+	requires.NoFileExists(filepath.Join(copyDir, BaseFileName))
+
+	// only the entries, which are in sync, get into the base state
+	base.Update("synced.txt", &model.EntryInfo{SrcPathInfo: file, CopyPathInfo: file})
+	base.Update("changed.txt", &model.EntryInfo{SrcPathInfo: file})
+	requires.NoError(base.Save())
+
+	loaded, err := LoadBase(copyDir)
+	requires.NoError(err)
+	entry, ok := loaded.Get("synced.txt")
+	requires.True(ok)
+	requires.True(entry.IsSameAs(file))
+	_, ok = loaded.Get("changed.txt")
+	requires.False(ok)
+
+	// the entry, which is removed from both sides, is removed from the base state
+	loaded.Update("synced.txt", &model.EntryInfo{})
+	requires.NoError(loaded.Save())
+	loaded, err = LoadBase(copyDir)
+	requires.NoError(err)
+	_, ok = loaded.Get("synced.txt")
+	requires.False(ok)
+}
+
+func TestLoadBaseCorrupted(t *testing.T) {
+	copyDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(copyDir, BaseFileName), []byte("{"), 0o644))
+
+	_, err := LoadBase(copyDir)
+
+	require.ErrorContains(t, err, "cannot parse base state")
+}
+
+func TestBaseEntry_IsSameAs(t *testing.T) {
+	requires := require.New(t)
+	modTime := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	file := model.PathInfo{Exists: true, Size: 10, ModTime: modTime}
+	dir := model.PathInfo{Exists: true, IsDir: true, ModTime: modTime, Mode: os.ModeDir | 0o755}
+	base := NewBaseEntry(file)
+
+	requires.True(base.IsSameAs(file))
+	requires.False(base.IsSameAs(model.PathInfo{}))
+	requires.False(base.IsSameAs(model.PathInfo{Exists: true, Size: 11, ModTime: modTime}))
+	requires.False(base.IsSameAs(model.PathInfo{Exists: true, Size: 10, ModTime: modTime.Add(time.Second)}))
+	requires.False(base.IsSameAs(dir))
+	requires.True(NewBaseEntry(dir).IsSameAs(model.PathInfo{Exists: true, IsDir: true, Mode: os.ModeDir}))
+}
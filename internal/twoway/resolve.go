@@ -0,0 +1,88 @@
+package twoway
+
+import (
+	"dsync/internal/model"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+//ConflictPolicy defines how the entry is synced, if it has been changed on both sides since the last sync.
+type ConflictPolicy string
+
+const (
+	PolicyNewer    ConflictPolicy = "newer"    // the side with the newer modification time wins
+	PolicySource   ConflictPolicy = "source"   // the source side always wins
+	PolicyKeepBoth ConflictPolicy = "keepboth" // the copy file is kept with the ".conflict" suffix on both sides
+
+	conflictSuffix = ".conflict"
+)
+
+func (p ConflictPolicy) IsValid() bool {
+	return p == PolicyNewer || p == PolicySource || p == PolicyKeepBoth
+}
+
+//Direction is the direction, in which the entry has to be synced.
+type Direction int
+
+const (
+	Forward      Direction = iota // from the source to the copy
+	Reverse                       // from the copy to the source
+	KeepConflict                  // the copy file has to be kept aside on both sides, then the source one is copied
+)
+
+//Resolve tells the direction of the sync of the entry (which is not in sync), given its base state.
+//If only one side has changed since the last sync, then that side wins. If both sides have changed, then it's
+//a conflict, which is resolved by the policy. However, if one side has been removed while the other one has been
+//modified, then the modification wins (unless the source always wins), because it's never lost then. Both sides can
+//be kept only if they are files, otherwise the newer side wins.
+func Resolve(entry *model.EntryInfo, base BaseEntry, hasBase bool, policy ConflictPolicy) Direction {
+	src, cp := entry.SrcPathInfo, entry.CopyPathInfo
+	srcChanged, copyChanged := src.Exists, cp.Exists
+	if hasBase {
+		srcChanged, copyChanged = !base.IsSameAs(src), !base.IsSameAs(cp)
+	}
+	switch {
+	case copyChanged && !srcChanged:
+		return Reverse
+	case !copyChanged || policy == PolicySource:
+		return Forward
+	case !src.Exists:
+		return Reverse
+	case !cp.Exists:
+		return Forward
+	case policy == PolicyKeepBoth && src.IsFile() && cp.IsFile():
+		return KeepConflict
+	case cp.ModTime.After(src.ModTime):
+		return Reverse
+	default:
+		return Forward
+	}
+}
+
+//ConflictPath returns the relative path (next to the entry's one), which is free in all the dirs, so that
+//the conflicting copy of the entry can be kept there.
+func ConflictPath(path string, dirs ...string) (string, error) {
+	conflictPath := path + conflictSuffix
+	for i := 2; ; i++ {
+		free, err := isFree(conflictPath, dirs)
+		if err != nil || free {
+			return conflictPath, err
+		}
+		conflictPath = path + conflictSuffix + "-" + strconv.Itoa(i)
+	}
+}
+
+func isFree(path string, dirs []string) (bool, error) {
+	for _, dir := range dirs {
+		if _, err := os.Lstat(filepath.Join(dir, path)); err == nil {
+			return false, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return false, fmt.Errorf("cannot check conflict path: %w", err)
+		}
+	}
+	return true, nil
+}
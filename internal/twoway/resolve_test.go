@@ -0,0 +1,95 @@
+package twoway
+
+import (
+	"dsync/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	t0 := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	file := func(size int64, modTime time.Time) model.PathInfo {
+		return model.PathInfo{Exists: true, Size: size, ModTime: modTime}
+	}
+	dir := model.PathInfo{Exists: true, IsDir: true, ModTime: t0, Mode: os.ModeDir}
+	none := model.PathInfo{}
+	base := NewBaseEntry(file(10, t0))
+
+	tests := []struct {
+		name    string
+		src, cp model.PathInfo
+		hasBase bool
+		policy  ConflictPolicy
+		want    Direction
+	}{
+		{name: "new in source", src: file(10, t0), cp: none, want: Forward},
+		{name: "new in copy", src: none, cp: file(10, t0), want: Reverse},
+		{name: "changed in source", src: file(20, t0), cp: file(10, t0), hasBase: true, want: Forward},
+		{name: "changed in copy", src: file(10, t0), cp: file(10, t0.Add(time.Hour)), hasBase: true, want: Reverse},
+		{name: "removed from source", src: none, cp: file(10, t0), hasBase: true, want: Forward},
+		{name: "removed from copy", src: file(10, t0), cp: none, hasBase: true, want: Reverse},
+		{
+			name: "changed on both sides, copy is newer", src: file(20, t0.Add(time.Minute)),
+			cp: file(30, t0.Add(time.Hour)), hasBase: true, policy: PolicyNewer, want: Reverse,
+		},
+		{
+			name: "changed on both sides, source is newer", src: file(20, t0.Add(time.Hour)),
+			cp: file(30, t0.Add(time.Minute)), hasBase: true, policy: PolicyNewer, want: Forward,
+		},
+		{
+			name: "changed on both sides, source wins", src: file(20, t0.Add(time.Minute)),
+			cp: file(30, t0.Add(time.Hour)), hasBase: true, policy: PolicySource, want: Forward,
+		},
+		{
+			name: "changed on both sides, both are kept", src: file(20, t0.Add(time.Minute)),
+			cp: file(30, t0.Add(time.Hour)), hasBase: true, policy: PolicyKeepBoth, want: KeepConflict,
+		},
+		{
+			name: "new on both sides, both are kept", src: file(20, t0), cp: file(30, t0),
+			policy: PolicyKeepBoth, want: KeepConflict,
+		},
+		{
+			name: "file and dir, newer wins", src: file(20, t0.Add(time.Minute)), cp: dir,
+			hasBase: true, policy: PolicyKeepBoth, want: Forward,
+		},
+		{
+			name: "removed from source, changed in copy", src: none, cp: file(30, t0.Add(time.Hour)),
+			hasBase: true, policy: PolicyKeepBoth, want: Reverse,
+		},
+		{
+			name: "removed from copy, changed in source", src: file(30, t0.Add(time.Hour)), cp: none,
+			hasBase: true, policy: PolicyNewer, want: Forward,
+		},
+		{
+			name: "removed from source, changed in copy, source wins", src: none, cp: file(30, t0.Add(time.Hour)),
+			hasBase: true, policy: PolicySource, want: Forward,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &model.EntryInfo{SrcPathInfo: tt.src, CopyPathInfo: tt.cp}
+			got := Resolve(entry, base, tt.hasBase, tt.policy)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConflictPath(t *testing.T) {
+	requires := require.New(t)
+	srcDir, copyDir := t.TempDir(), t.TempDir()
+
+	path, err := ConflictPath("a.txt", srcDir, copyDir)
+	requires.NoError(err)
+	requires.Equal("a.txt.conflict", path)
+
+	requires.NoError(os.WriteFile(filepath.Join(srcDir, "a.txt.conflict"), nil, 0o644))
+	requires.NoError(os.WriteFile(filepath.Join(copyDir, "a.txt.conflict-2"), nil, 0o644))
+	path, err = ConflictPath("a.txt", srcDir, copyDir)
+	requires.NoError(err)
+	requires.Equal("a.txt.conflict-3", path)
+}
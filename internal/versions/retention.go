@@ -0,0 +1,42 @@
+package versions
+
+import "time"
+
+//RetentionPolicy defines which versions of a file are kept. A version is kept if it's kept by any of the rules.
+//If none of the rules is set (all are zero), then all versions are kept.
+type RetentionPolicy struct {
+	KeepLast   int           // keeps this number of the newest versions
+	KeepDaily  int           // keeps the newest version of each day for this number of last days
+	KeepWithin time.Duration // keeps all versions created within this duration before now
+}
+
+func (p RetentionPolicy) IsZero() bool {
+	return p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWithin == 0
+}
+
+//Apply splits the versions (they must be sorted from the newest to the oldest) into the kept and obsolete ones.
+func (p RetentionPolicy) Apply(versions []Version, now time.Time) (kept, obsolete []Version) {
+	if p.IsZero() {
+		return versions, nil
+	}
+	dailyFrom := truncateToDay(now).AddDate(0, 0, -p.KeepDaily+1)
+	seenDays := make(map[time.Time]bool)
+	for i, v := range versions {
+		keep := i < p.KeepLast || (p.KeepWithin > 0 && !v.CreatedAt.Before(now.Add(-p.KeepWithin)))
+		if day := truncateToDay(v.CreatedAt); p.KeepDaily > 0 && !day.Before(dailyFrom) && !seenDays[day] {
+			seenDays[day] = true // the first version of the day is the newest one
+			keep = true
+		}
+		if keep {
+			kept = append(kept, v)
+		} else {
+			obsolete = append(obsolete, v)
+		}
+	}
+	return kept, obsolete
+}
+
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,51 @@
+package versions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPolicy_Apply(t *testing.T) {
+	now := time.Date(2022, time.August, 10, 12, 0, 0, 0, time.UTC)
+	// the versions are sorted from the newest to the oldest
+	versions := []Version{
+		{FullPath: "v1", CreatedAt: now.Add(-10 * time.Minute)},
+		{FullPath: "v2", CreatedAt: now.Add(-30 * time.Minute)},
+		{FullPath: "v3", CreatedAt: now.Add(-2 * time.Hour)},
+		{FullPath: "v4", CreatedAt: now.Add(-24 * time.Hour)},
+		{FullPath: "v5", CreatedAt: now.Add(-25 * time.Hour)},
+		{FullPath: "v6", CreatedAt: now.Add(-5 * 24 * time.Hour)},
+	}
+
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		wantKept []string
+	}{
+		{name: "no rules", policy: RetentionPolicy{}, wantKept: []string{"v1", "v2", "v3", "v4", "v5", "v6"}},
+		{name: "keep last", policy: RetentionPolicy{KeepLast: 2}, wantKept: []string{"v1", "v2"}},
+		{name: "keep within", policy: RetentionPolicy{KeepWithin: time.Hour}, wantKept: []string{"v1", "v2"}},
+		{name: "keep daily", policy: RetentionPolicy{KeepDaily: 2}, wantKept: []string{"v1", "v4"}},
+		{name: "keep daily long", policy: RetentionPolicy{KeepDaily: 7}, wantKept: []string{"v1", "v4", "v6"}},
+		{
+			name:     "combined",
+			policy:   RetentionPolicy{KeepLast: 1, KeepDaily: 2, KeepWithin: 3 * time.Hour},
+			wantKept: []string{"v1", "v2", "v3", "v4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, obsolete := tt.policy.Apply(versions, now)
+
+			var keptPaths []string
+			for _, v := range kept {
+				keptPaths = append(keptPaths, v.FullPath)
+			}
+			require.Equal(t, tt.wantKept, keptPaths)
+			require.Len(t, obsolete, len(versions)-len(kept))
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package versions
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//DirName is the name of the dir (in the root of the copy dir), that holds the parallel tree of old files' versions.
+	DirName = ".versions"
+
+	separator  = "~"
+	timeLayout = "20060102-150405"
+)
+
+//Version is one kept (old) version of a file in the copy dir.
+type Version struct {
+	Path      string    `json:"path"` // relative path of the versioned file in the copy dir
+	FullPath  string    `json:"fullPath"`
+	CreatedAt time.Time `json:"createdAt"` // when this version was replaced or removed in the copy dir
+	Size      int64     `json:"size"`
+	seq       int       // the sequence number of the version among the ones created in the same second
+}
+
+//Store keeps old versions of the copy dir files as path~YYYYMMDD-HHMMSS files in the parallel tree
+//(inside the DirName dir of the copy dir) and removes the ones, that are not needed according to the retention policy.
+type Store struct {
+	root   string
+	policy RetentionPolicy
+	now    func() time.Time
+}
+
+func NewStore(copyDir string, policy RetentionPolicy) *Store {
+	return &Store{root: filepath.Join(copyDir, DirName), policy: policy, now: time.Now}
+}
+
+//Save moves the file at fullPath (the file at path relative to the copy dir) into the versions tree
+//and then prunes old versions of this path.
+func (s *Store) Save(path, fullPath string) error {
+	createdAt := s.now().UTC()
+	versionPath, err := s.freeVersionPath(path, createdAt)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(versionPath), os.ModePerm); err != nil {
+		return fmt.Errorf("cannot make versions dir: %w", err)
+	}
+	if err := os.Rename(fullPath, versionPath); err != nil {
+		return fmt.Errorf("cannot save file version: %w", err)
+	}
+	return s.Prune(path)
+}
+
+//freeVersionPath returns the path for the new version, that is not taken by another version of the same second.
+func (s *Store) freeVersionPath(path string, createdAt time.Time) (string, error) {
+	base := filepath.Join(s.root, path) + separator + createdAt.Format(timeLayout)
+	versionPath := base
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(versionPath); errors.Is(err, fs.ErrNotExist) {
+			return versionPath, nil
+		} else if err != nil {
+			return "", fmt.Errorf("cannot check file version: %w", err)
+		}
+		versionPath = base + "-" + strconv.Itoa(i)
+	}
+}
+
+//List returns all kept versions of the file at path (relative to the copy dir), the newest versions go first.
+func (s *Store) List(path string) ([]Version, error) {
+	dir := filepath.Join(s.root, filepath.Dir(path))
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read versions dir: %w", err)
+	}
+	var versions []Version
+	for _, de := range dirEntries {
+		name, createdAt, seq, ok := parseVersionName(de.Name())
+		if !ok || de.IsDir() || name != filepath.Base(path) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch version's info: %w", err)
+		}
+		versions = append(versions, Version{
+			Path:      path,
+			FullPath:  filepath.Join(dir, de.Name()),
+			CreatedAt: createdAt,
+			Size:      info.Size(),
+			seq:       seq,
+		})
+	}
+	sortNewestFirst(versions)
+	return versions, nil
+}
+
+//Prune removes the versions of the file at path, that are not kept by the retention policy.
+func (s *Store) Prune(path string) error {
+	versions, err := s.List(path)
+	if err != nil {
+		return err
+	}
+	_, obsolete := s.policy.Apply(versions, s.now())
+	for _, v := range obsolete {
+		if err := os.Remove(v.FullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("cannot remove obsolete version: %w", err)
+		}
+	}
+	return nil
+}
+
+//PruneAll applies the retention policy to the versions of all files in the versions tree.
+func (s *Store) PruneAll() error {
+	paths := make(map[string]bool)
+	err := filepath.WalkDir(s.root, func(fullPath string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && fullPath == s.root {
+				return nil // there are no versions yet
+			}
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		if name, _, _, ok := parseVersionName(de.Name()); ok {
+			dir, err := filepath.Rel(s.root, filepath.Dir(fullPath))
+			if err != nil {
+				return err
+			}
+			paths[filepath.Join(dir, name)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot walk through the versions tree: %w", err)
+	}
+	for path := range paths {
+		if err := s.Prune(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//parseVersionName splits the version file name into the original file name, the version creation time
+//and its sequence number (among the versions created in the same second).
+func parseVersionName(versionName string) (name string, createdAt time.Time, seq int, ok bool) {
+	i := strings.LastIndex(versionName, separator)
+	if i <= 0 {
+		return "", time.Time{}, 0, false
+	}
+	name, stamp := versionName[:i], versionName[i+len(separator):]
+	seq = 1
+	if len(stamp) > len(timeLayout) {
+		// the version may have a numeric suffix (like "-2"), if there are several versions in the same second
+		suffix := stamp[len(timeLayout):]
+		n, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+		if err != nil || !strings.HasPrefix(suffix, "-") {
+			return "", time.Time{}, 0, false
+		}
+		seq, stamp = n, stamp[:len(timeLayout)]
+	}
+	createdAt, err := time.Parse(timeLayout, stamp)
+	if err != nil {
+		return "", time.Time{}, 0, false
+	}
+	return name, createdAt, seq, true
+}
+
+func sortNewestFirst(versions []Version) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].CreatedAt.Equal(versions[j].CreatedAt) {
+			return versions[i].seq > versions[j].seq
+		}
+		return versions[i].CreatedAt.After(versions[j].CreatedAt)
+	})
+}
@@ -0,0 +1,108 @@
+package versions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_SaveAndList(t *testing.T) {
+	requires := require.New(t)
+
+	// 1. arrange
+	copyDir := t.TempDir()
+	path := filepath.Join("subdir", "file.txt")
+	fullPath := filepath.Join(copyDir, path)
+	requires.NoError(os.MkdirAll(filepath.Dir(fullPath), os.ModePerm))
+
+	now := time.Date(2022, time.August, 10, 12, 0, 0, 0, time.UTC)
+	store := NewStore(copyDir, RetentionPolicy{})
+	store.now = func() time.Time { return now }
+
+	// 2. act: save 3 versions, 2 of them are in the same second
+	for _, content := range []string{"v1", "v22", "v333"} {
+		requires.NoError(os.WriteFile(fullPath, []byte(content), 0644))
+		requires.NoError(store.Save(path, fullPath))
+		if content == "v1" {
+			now = now.Add(time.Hour)
+		}
+	}
+
+	// 3. assert
+	_, err := os.Stat(fullPath)
+	requires.ErrorIs(err, os.ErrNotExist)
+
+	versions, err := store.List(path)
+	requires.NoError(err)
+	requires.Len(versions, 3)
+	requires.Equal(int64(4), versions[0].Size) // the newest one goes first
+	requires.Equal(int64(3), versions[1].Size)
+	requires.Equal(int64(2), versions[2].Size)
+	requires.Equal(filepath.Join(copyDir, DirName, "subdir", "file.txt~20220810-130000-2"), versions[0].FullPath)
+	requires.Equal(now, versions[0].CreatedAt)
+
+	otherVersions, err := store.List(filepath.Join("subdir", "other.txt"))
+	requires.NoError(err)
+	requires.Empty(otherVersions)
+}
+
+func TestStore_PruneAll(t *testing.T) {
+	requires := require.New(t)
+
+	// 1. arrange
+	copyDir := t.TempDir()
+	now := time.Date(2022, time.August, 10, 12, 0, 0, 0, time.UTC)
+	store := NewStore(copyDir, RetentionPolicy{})
+	store.now = func() time.Time { return now }
+	for _, path := range []string{"a.txt", "a.txt", "a.txt", filepath.Join("dir", "b.txt"), filepath.Join("dir", "b.txt")} {
+		fullPath := filepath.Join(copyDir, path)
+		requires.NoError(os.MkdirAll(filepath.Dir(fullPath), os.ModePerm))
+		requires.NoError(os.WriteFile(fullPath, []byte(path), 0644))
+		requires.NoError(store.Save(path, fullPath))
+		now = now.Add(time.Minute)
+	}
+
+	// 2. act
+	store.policy = RetentionPolicy{KeepLast: 1}
+	err := store.PruneAll()
+
+	// 3. assert
+	requires.NoError(err)
+	for _, path := range []string{"a.txt", filepath.Join("dir", "b.txt")} {
+		versions, err := store.List(path)
+		requires.NoError(err)
+		requires.Len(versions, 1)
+	}
+}
+
+func TestStore_PruneAllWithoutVersions(t *testing.T) {
+	require.NoError(t, NewStore(t.TempDir(), RetentionPolicy{KeepLast: 1}).PruneAll())
+}
+
+func TestParseVersionName(t *testing.T) {
+	tests := []struct {
+		versionName string
+		wantName    string
+		wantSeq     int
+		wantOk      bool
+	}{
+		{versionName: "file.txt~20220810-120000", wantName: "file.txt", wantSeq: 1, wantOk: true},
+		{versionName: "a~b.txt~20220810-120000-12", wantName: "a~b.txt", wantSeq: 12, wantOk: true},
+		{versionName: "file.txt", wantOk: false},
+		{versionName: "~20220810-120000", wantOk: false},
+		{versionName: "file.txt~2022", wantOk: false},
+		{versionName: "file.txt~20220810-120000x2", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.versionName, func(t *testing.T) {
+			name, _, seq, ok := parseVersionName(tt.versionName)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.wantName, name)
+			require.Equal(t, tt.wantSeq, seq)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+//Package webhook posts the notifications about the sync failures and the process state to the configured URL.
+//The events are batched: the batch is posted in a while after its first event, so a burst of failures makes
+//one request. The failed requests are retried, and the body is signed with HMAC-SHA256, if the secret is set.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dsync/internal/journal"
+	"dsync/internal/log"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultDelay is how long the events are collected into the batch, before it's posted.
+	DefaultDelay = 5 * time.Second
+	//DefaultRetries is the number of the repeated attempts to post the batch.
+	DefaultRetries = 3
+	//SecretEnv is the env var with the secret, the requests' bodies are signed with.
+	SecretEnv = "DSYNC_WEBHOOK_SECRET"
+	//SignatureHeader is the request header with the signature of the body: "sha256=" and the hex-encoded
+	//HMAC-SHA256 of the body keyed by the secret.
+	SignatureHeader = "X-Dsync-Signature"
+	//CloseTimeout is how long the pending events are posted on closing, before they are dropped.
+	CloseTimeout = 15 * time.Second
+
+	//maxBatch is the number of the events, after which the batch is posted without waiting.
+	maxBatch = 100
+	//queueCapacity is the number of the pending events, above which the new events are dropped.
+	queueCapacity  = 1000
+	requestTimeout = 10 * time.Second
+	firstBackoff   = time.Second
+)
+
+//Config defines where and how the notifications are posted.
+type Config struct {
+	URL     string        // the notifications are not posted, if it's empty
+	Secret  string        `json:"-"` // the HMAC key (empty means the requests are not signed)
+	Delay   time.Duration // of the batch (see DefaultDelay)
+	Retries int
+}
+
+//Kind is the kind of the event.
+type Kind string
+
+const (
+	OpFailed   Kind = "op_failed"
+	ScanErrors Kind = "scan_errors" // the scan or the sync cycle has failed, and the sync stops after MaxErrors of them
+	Stopped    Kind = "stopped"     // the process is stopping (Error is the fatal error, if any)
+)
+
+//Event is the notification about the failure or the state change.
+type Event struct {
+	Kind      Kind            `json:"kind"`
+	Time      time.Time       `json:"time"`
+	Job       string          `json:"job,omitempty"`
+	CopyDir   string          `json:"copyDir,omitempty"`
+	Dir       string          `json:"dir,omitempty"`       // the dir, which scan has failed
+	Errors    int             `json:"errors,omitempty"`    // the number of the consecutive errors
+	MaxErrors int             `json:"maxErrors,omitempty"` // the number of the consecutive errors, which stops the sync
+	Error     string          `json:"error,omitempty"`
+	Operation *journal.Record `json:"operation,omitempty"` // the failed operation
+}
+
+//Batch is the body of the request.
+type Batch struct {
+	Host    string    `json:"host"`
+	SentAt  time.Time `json:"sentAt"`
+	Dropped int       `json:"dropped,omitempty"` // the number of the events dropped since the previous batch
+	Events  []Event   `json:"events"`
+}
+
+//Signature returns the value of SignatureHeader for the body.
+func Signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//Notifier posts the events in background. It's safe for concurrent use, and its methods do nothing, if it's nil.
+type Notifier struct {
+	log     log.Logger
+	cfg     Config
+	client  *http.Client
+	host    string
+	backoff time.Duration // before the first retry, it's doubled on each next one
+	events  chan Event
+	done    chan struct{}
+	ctx     context.Context // is canceled, when the deadline of Close is exceeded
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+//New starts the notifier. It returns nil, if the URL is not set.
+func New(logger log.Logger, cfg Config) *Notifier {
+	if cfg.URL == "" {
+		return nil
+	}
+	if cfg.Delay <= 0 {
+		cfg.Delay = DefaultDelay
+	}
+	host, _ := os.Hostname()
+	n := &Notifier{log: logger, cfg: cfg, client: &http.Client{Timeout: requestTimeout}, host: host,
+		backoff: firstBackoff, events: make(chan Event, queueCapacity), done: make(chan struct{})}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	go n.run()
+	return n
+}
+
+//Notify enqueues the event. It never blocks: if too many events are pending, then the event is dropped
+//(and counted in the next batch).
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.events <- e:
+	default:
+		n.dropped++
+	}
+}
+
+//Close posts the pending events (along with their retries) and stops the notifier. If ctx is done before
+//the events are posted, then the posting is aborted, and the events are dropped.
+func (n *Notifier) Close(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.events)
+	}
+	n.mu.Unlock()
+	defer n.cancel()
+	select {
+	case <-n.done:
+	case <-ctx.Done():
+		n.cancel()
+		<-n.done
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	var (
+		batch []Event
+		timer *time.Timer
+		fire  <-chan time.Time // is nil, while the batch is empty
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, fire = nil, nil
+		n.post(batch)
+		batch = nil
+	}
+	for {
+		select {
+		case e, ok := <-n.events:
+			if !ok {
+				if len(batch) > 0 {
+					flush()
+				}
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= maxBatch {
+				flush()
+			} else if timer == nil {
+				timer = time.NewTimer(n.cfg.Delay)
+				fire = timer.C
+			}
+		case <-fire:
+			flush()
+		}
+	}
+}
+
+//post posts the batch and retries it with the growing backoff, unless the URL has rejected it (or the deadline
+//of Close is exceeded).
+func (n *Notifier) post(events []Event) {
+	n.mu.Lock()
+	batch := Batch{Host: n.host, SentAt: time.Now(), Dropped: n.dropped, Events: events}
+	n.dropped = 0
+	n.mu.Unlock()
+	body, err := json.Marshal(batch)
+	if err != nil {
+		n.log.Error("cannot encode webhook events", log.Cause(err))
+		return
+	}
+	backoff := n.backoff
+	for attempt := 0; ; attempt++ {
+		err := n.send(body)
+		if err == nil {
+			n.log.Debug("webhook events posted", log.Int("events", len(events)))
+			return
+		}
+		if n.ctx.Err() != nil {
+			n.log.Warn("webhook events dropped on closing", log.Int("events", len(events)), log.Cause(err))
+			return
+		}
+		var statusErr *statusError
+		if attempt >= n.cfg.Retries || errors.As(err, &statusErr) && !statusErr.retryable() {
+			n.log.Warn("cannot post webhook events", log.Int("events", len(events)), log.Cause(err))
+			return
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-n.ctx.Done(): // the next attempt fails at once, so the events are dropped
+			timer.Stop()
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) send(body []byte) error {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dsync")
+	if n.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Signature(n.cfg.Secret, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // so that the connection is reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+//statusError is the unsuccessful response.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webhook responded with %d %s", e.code, http.StatusText(e.code))
+}
+
+//retryable reports whether the request may succeed later: the server's errors and the rate limiting are retried,
+//while other client errors (e.g. the wrong signature) are not.
+func (e *statusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests || e.code == http.StatusRequestTimeout
+}
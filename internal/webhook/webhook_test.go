@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"context"
+	logmock "dsync/generated/mocks"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+//receiver is the local stand-in of the webhook URL, which fails the first requests.
+type receiver struct {
+	mu       sync.Mutex
+	failures []int // the codes of the next responses, 200 is responded after them
+	requests int
+	batches  []Batch
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests++
+	if len(rc.failures) > 0 {
+		w.WriteHeader(rc.failures[0])
+		rc.failures = rc.failures[1:]
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var batch Batch
+	if err := json.Unmarshal(body, &batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get(SignatureHeader) != Signature("secret", body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	rc.batches = append(rc.batches, batch)
+}
+
+func TestNotifier(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	logger := logmock.NewMockLogger(mockCtrl)
+	logger.EXPECT().Debug("webhook events posted", gomock.Any()).Times(2)
+
+	rc := &receiver{failures: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	n := New(logger, Config{URL: srv.URL, Secret: "secret", Delay: 50 * time.Millisecond, Retries: 2})
+	n.backoff = time.Millisecond
+	n.Notify(Event{Kind: ScanErrors, Dir: "/src", Errors: 1, MaxErrors: 3, Error: "permission denied"})
+	n.Notify(Event{Kind: ScanErrors, Dir: "/src", Errors: 2, MaxErrors: 3, Error: "permission denied"})
+	time.Sleep(500 * time.Millisecond) // the batch is posted after the delay and 2 retries
+	n.Notify(Event{Kind: Stopped, Error: "too many errors"})
+	n.Close(context.Background()) // the last batch is posted without the delay
+	n.Notify(Event{Kind: Stopped})
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	requires.Equal(4, rc.requests)
+	requires.Len(rc.batches, 2)
+	requires.Len(rc.batches[0].Events, 2)
+	requires.Equal(ScanErrors, rc.batches[0].Events[0].Kind)
+	requires.Equal(2, rc.batches[0].Events[1].Errors)
+	requires.False(rc.batches[0].Events[1].Time.IsZero())
+	requires.Len(rc.batches[1].Events, 1)
+	requires.Equal(Stopped, rc.batches[1].Events[0].Kind)
+	requires.Equal("too many errors", rc.batches[1].Events[0].Error)
+}
+
+func TestNotifier_Rejected(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	logger := logmock.NewMockLogger(mockCtrl)
+	logger.EXPECT().Warn("cannot post webhook events", gomock.Any(), gomock.Any())
+
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	// the wrong signature is not retried
+	n := New(logger, Config{URL: srv.URL, Secret: "wrong", Retries: 3})
+	n.Notify(Event{Kind: Stopped})
+	n.Close(context.Background())
+	requires.Equal(1, rc.requests)
+	requires.Empty(rc.batches)
+}
+
+func TestNotifier_CloseDeadline(t *testing.T) {
+	requires := require.New(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	logger := logmock.NewMockLogger(mockCtrl)
+	logger.EXPECT().Warn("webhook events dropped on closing", gomock.Any(), gomock.Any())
+
+	rc := &receiver{failures: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	// the retry of the final batch is not awaited after the deadline
+	n := New(logger, Config{URL: srv.URL, Secret: "secret", Retries: 3})
+	n.backoff = time.Hour
+	n.Notify(Event{Kind: Stopped})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	n.Close(ctx)
+	requires.Less(time.Since(started), 5*time.Second)
+	requires.Equal(1, rc.requests)
+	requires.Empty(rc.batches)
+}
+
+func TestNotifier_Disabled(t *testing.T) {
+	requires := require.New(t)
+	n := New(nil, Config{})
+	requires.Nil(n)
+	n.Notify(Event{Kind: Stopped})
+	n.Close(context.Background())
+}
@@ -0,0 +1,250 @@
+//Package delta implements the rsync-like delta transfer: the receiver sends the signatures of its file's blocks,
+//the sender finds these blocks in the new content by the rolling checksum and sends only the rest of the data.
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	minBlockSize = 2 << 10
+	maxBlockSize = 128 << 10
+	//MaxLiteralSize limits the size of a literal op, so the literal data is sent in chunks.
+	MaxLiteralSize = 64 << 10
+	strongSize     = 16
+)
+
+//BlockSignature identifies the block of the old file.
+type BlockSignature struct {
+	Weak   uint32           // the rolling checksum
+	Strong [strongSize]byte // the truncated SHA-256
+}
+
+//Op is the instruction to build the new file: either to copy the block of the old file, or to write the literal data.
+type Op struct {
+	Block   int    // the index of the old file's block to copy (it's valid, if Literal is nil)
+	Literal []byte // it's valid only until the emit func returns
+}
+
+//BlockSize returns the block size suitable for the file of the size (it's about the square root of the size).
+func BlockSize(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	bs = (bs + 1023) &^ 1023 // round up to KiB
+	if bs < minBlockSize {
+		return minBlockSize
+	}
+	if bs > maxBlockSize {
+		return maxBlockSize
+	}
+	return bs
+}
+
+//Signatures calculates the signatures of all the blocks of the old content (the last block may be shorter).
+func Signatures(old io.Reader, blockSize int) ([]BlockSignature, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %d", blockSize)
+	}
+	var sigs []BlockSignature
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(old, block)
+		if n > 0 {
+			sigs = append(sigs, BlockSignature{Weak: newRollingSum(block[:n]).value(), Strong: strongSum(block[:n])})
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return sigs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+//Diff reads the new content and emits the ops, which build it from the old content with the signatures.
+func Diff(sigs []BlockSignature, blockSize int, content io.Reader, emit func(op Op) error) error {
+	if blockSize <= 0 {
+		return fmt.Errorf("invalid block size %d", blockSize)
+	}
+	index := make(map[uint32][]int, len(sigs))
+	for i, sig := range sigs {
+		index[sig.Weak] = append(index[sig.Weak], i)
+	}
+	d := differ{sigs: sigs, index: index, blockSize: blockSize, emit: emit, r: bufio.NewReader(content)}
+	return d.run()
+}
+
+type differ struct {
+	sigs      []BlockSignature
+	index     map[uint32][]int
+	blockSize int
+	emit      func(op Op) error
+	r         *bufio.Reader
+
+	buf    []byte // the pending literal data followed by the current window
+	litEnd int    // the window is buf[litEnd:]
+}
+
+func (d *differ) run() error {
+	if err := d.fill(d.blockSize); err != nil {
+		return err
+	}
+	sum := newRollingSum(d.window())
+	for len(d.window()) == d.blockSize {
+		if idx, ok := d.match(sum.value(), d.window()); ok {
+			if err := d.flushLiteral(); err != nil {
+				return err
+			}
+			if err := d.emit(Op{Block: idx}); err != nil {
+				return err
+			}
+			d.buf, d.litEnd = d.buf[:0], 0
+			if err := d.fill(d.blockSize); err != nil {
+				return err
+			}
+			sum = newRollingSum(d.window())
+			continue
+		}
+
+		// no match, so the window slides by one byte, which becomes the literal data
+		out := d.buf[d.litEnd]
+		d.litEnd++
+		if err := d.fill(d.blockSize); err != nil {
+			return err
+		}
+		if len(d.window()) < d.blockSize { // EOF, the window is the tail now
+			break
+		}
+		sum.roll(out, d.window()[d.blockSize-1])
+		if d.litEnd >= MaxLiteralSize {
+			if err := d.flushLiteral(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the tail, which is shorter than the block size, may match only the old file's last block
+	if tail := d.window(); len(tail) > 0 {
+		if idx, ok := d.match(newRollingSum(tail).value(), tail); ok && idx == len(d.sigs)-1 {
+			if err := d.flushLiteral(); err != nil {
+				return err
+			}
+			return d.emit(Op{Block: idx})
+		}
+		d.litEnd = len(d.buf)
+	}
+	return d.flushLiteral()
+}
+
+func (d *differ) window() []byte {
+	return d.buf[d.litEnd:]
+}
+
+//fill reads the new content, until the window is full or EOF is reached.
+func (d *differ) fill(size int) error {
+	for len(d.window()) < size {
+		b, err := d.r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d.buf = append(d.buf, b)
+	}
+	return nil
+}
+
+func (d *differ) match(weak uint32, block []byte) (int, bool) {
+	candidates, ok := d.index[weak]
+	if !ok {
+		return 0, false
+	}
+	strong := strongSum(block)
+	for _, idx := range candidates {
+		if d.sigs[idx].Strong == strong {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+func (d *differ) flushLiteral() error {
+	for lit := d.buf[:d.litEnd]; len(lit) > 0; {
+		n := len(lit)
+		if n > MaxLiteralSize {
+			n = MaxLiteralSize
+		}
+		if err := d.emit(Op{Literal: lit[:n]}); err != nil {
+			return err
+		}
+		lit = lit[n:]
+	}
+	d.buf = append(d.buf[:0], d.buf[d.litEnd:]...)
+	d.litEnd = 0
+	return nil
+}
+
+//Apply builds the new content from the old one by the ops.
+func Apply(old io.ReaderAt, blockSize int, ops func() (Op, bool, error), w io.Writer) error {
+	block := make([]byte, blockSize)
+	for {
+		op, ok, err := ops()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if op.Literal != nil {
+			if _, err = w.Write(op.Literal); err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := old.ReadAt(block, int64(op.Block)*int64(blockSize))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("block %d is out of the old file", op.Block)
+		}
+		if _, err = w.Write(block[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+func strongSum(block []byte) (sum [strongSize]byte) {
+	full := sha256.Sum256(block)
+	copy(sum[:], full[:strongSize])
+	return sum
+}
+
+//rollingSum is the rsync weak checksum, which is updated in O(1), when the window slides by one byte.
+type rollingSum struct {
+	a, b uint32
+	n    uint32 // the window length
+}
+
+func newRollingSum(block []byte) rollingSum {
+	s := rollingSum{n: uint32(len(block))}
+	for i, c := range block {
+		s.a += uint32(c)
+		s.b += uint32(len(block)-i) * uint32(c)
+	}
+	return s
+}
+
+func (s *rollingSum) roll(out, in byte) {
+	s.a = s.a - uint32(out) + uint32(in)
+	s.b = s.b - s.n*uint32(out) + s.a
+}
+
+func (s rollingSum) value() uint32 {
+	return (s.a & 0xffff) | (s.b << 16)
+}
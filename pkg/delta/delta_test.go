@@ -0,0 +1,111 @@
+package delta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockSize(t *testing.T) {
+	require.Equal(t, minBlockSize, BlockSize(0))
+	require.Equal(t, 10<<10, BlockSize(100<<20)) // sqrt(100 MiB) = 10 KiB
+	require.Equal(t, maxBlockSize, BlockSize(1<<40))
+}
+
+func TestRollingSum(t *testing.T) {
+	data := randomBytes(100, 1)
+	const n = 16
+	sum := newRollingSum(data[:n])
+	for i := 1; i+n <= len(data); i++ {
+		sum.roll(data[i-1], data[i+n-1])
+		require.Equal(t, newRollingSum(data[i:i+n]).value(), sum.value(), i)
+	}
+}
+
+func TestDiffAndApply(t *testing.T) {
+	const blockSize = 2048
+	old := randomBytes(10*blockSize+100, 2)
+	tests := []struct {
+		name         string
+		content      []byte
+		maxLiterals  int // the max total size of the literal data
+		wantNoBlocks bool
+	}{
+		{name: "same", content: old, maxLiterals: 0},
+		{name: "empty", content: nil, maxLiterals: 0, wantNoBlocks: true},
+		{name: "inserted in the middle", content: concat(old[:5000], []byte("inserted"), old[5000:]),
+			maxLiterals: blockSize + len("inserted")},
+		{name: "removed at the start", content: old[100:], maxLiterals: blockSize},
+		{name: "appended", content: concat(old, randomBytes(3*MaxLiteralSize, 3)), maxLiterals: 3*MaxLiteralSize + 100},
+		{name: "changed byte", content: concat(old[:blockSize*3], []byte{^old[blockSize*3]}, old[blockSize*3+1:]),
+			maxLiterals: blockSize},
+		{name: "different", content: randomBytes(5*blockSize, 4), maxLiterals: 5 * blockSize, wantNoBlocks: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requires := require.New(t)
+			sigs, err := Signatures(bytes.NewReader(old), blockSize)
+			requires.NoError(err)
+			requires.Len(sigs, 11)
+
+			var ops []Op
+			literals, blocks := 0, 0
+			err = Diff(sigs, blockSize, bytes.NewReader(tt.content), func(op Op) error {
+				if op.Literal != nil {
+					requires.LessOrEqual(len(op.Literal), MaxLiteralSize)
+					op.Literal = append([]byte(nil), op.Literal...)
+					literals += len(op.Literal)
+				} else {
+					blocks++
+				}
+				ops = append(ops, op)
+				return nil
+			})
+			requires.NoError(err)
+			requires.LessOrEqual(literals, tt.maxLiterals)
+			requires.Equal(tt.wantNoBlocks, blocks == 0)
+
+			var built bytes.Buffer
+			next := 0
+			err = Apply(bytes.NewReader(old), blockSize, func() (Op, bool, error) {
+				if next == len(ops) {
+					return Op{}, false, nil
+				}
+				next++
+				return ops[next-1], true, nil
+			}, &built)
+			requires.NoError(err)
+			requires.Equal(len(tt.content), built.Len())
+			requires.True(bytes.Equal(tt.content, built.Bytes()))
+		})
+	}
+}
+
+func TestApply_BadBlock(t *testing.T) {
+	ops := []Op{{Block: 5}}
+	err := Apply(bytes.NewReader([]byte("short")), 4, func() (Op, bool, error) {
+		if len(ops) == 0 {
+			return Op{}, false, nil
+		}
+		op := ops[0]
+		ops = ops[1:]
+		return op, true, nil
+	}, &bytes.Buffer{})
+	require.ErrorContains(t, err, "block 5 is out of the old file")
+}
+
+func randomBytes(n int, seed int64) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func concat(parts ...[]byte) []byte {
+	var res []byte
+	for _, p := range parts {
+		res = append(res, p...)
+	}
+	return res
+}
@@ -0,0 +1,451 @@
+package compressfs
+
+import (
+	"dsync/pkg/fsys"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//Suffix is added to the names of the compressed files.
+const Suffix = ".zst"
+
+//Config tells, how the files are compressed.
+type Config struct {
+	//Restore means, that the dir is read to be restored: it must be compressed already, and the modification times
+	//are taken from the index rather than from the underlying file system.
+	Restore bool
+}
+
+//FS is the file system, which compresses the files in the root dir of the underlying file system by zstd and keeps
+//them with Suffix. The content, which is compressed already (by the extension of the name or by the entropy
+//of its beginning), is kept as is. The original sizes and modification times are kept in the index (IndexName),
+//so the compressed dir can be compared with the plain one. The index is saved on Close (and from time to time,
+//while the files are written), so the files written after the last save are just copied again after the crash.
+type FS struct {
+	under fsys.FS
+	root  string
+	cfg   Config
+
+	mu      sync.Mutex
+	idx     *index // is nil, until the index is read
+	idxErr  error  // the error of the index, if it can't be read
+	dirty   bool   // whether the index is changed since it was saved
+	savedAt time.Time
+
+	saveMu sync.Mutex // the index is saved by one goroutine at a time
+}
+
+//New returns the file system of the root dir of the underlying file system.
+func New(under fsys.FS, root string, cfg Config) *FS {
+	return &FS{under: under, root: root, cfg: cfg, savedAt: time.Now()}
+}
+
+//IsCompressed reports whether the root dir of the file system has the index of the compressed dir.
+func IsCompressed(f fsys.FS, root string) (bool, error) {
+	_, err := f.Stat(f.Join(root, IndexName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//index returns the index, it's read on the first call.
+func (f *FS) index() (*index, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.idx != nil || f.idxErr != nil {
+		return f.idx, f.idxErr
+	}
+	r, err := f.under.Open(f.under.Join(f.root, IndexName))
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && f.cfg.Restore:
+		f.idxErr = fmt.Errorf("%q is not compressed by dsync: %w", f.root, err)
+	case errors.Is(err, fs.ErrNotExist):
+		f.idx = newIndex()
+	case err != nil:
+		f.idxErr = fmt.Errorf("cannot open compression index: %w", err)
+	default:
+		f.idx, f.idxErr = readIndex(r)
+		_ = r.Close()
+	}
+	return f.idx, f.idxErr
+}
+
+//resolve returns the index key of the name and its entry, if the file is compressed. The key is empty for the names
+//outside the root dir and for the root itself.
+func (f *FS) resolve(name string) (string, entry, bool, error) {
+	idx, err := f.index()
+	if err != nil {
+		return "", entry{}, false, err
+	}
+	rel, err := f.under.Rel(f.root, name)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", entry{}, false, nil
+	}
+	key := indexKey(rel)
+	if key == IndexName {
+		return "", entry{}, false, fmt.Errorf("%q is reserved for compression index", IndexName)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := idx.Files[key]
+	return key, e, ok, nil
+}
+
+//underName returns the name of the entry in the underlying file system.
+func (f *FS) underName(name string) (string, error) {
+	_, _, compressed, err := f.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	if compressed {
+		return name + Suffix, nil
+	}
+	return name, nil
+}
+
+//canCompress reports whether the file can be kept compressed: the name with Suffix must not be taken
+//by the file, which is kept as is.
+func (f *FS) canCompress(name, key string) bool {
+	f.mu.Lock()
+	_, compressed := f.idx.Files[key]
+	f.mu.Unlock()
+	if compressed {
+		return true
+	}
+	_, err := f.under.Stat(name + Suffix)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+//written updates the index, when the file has been written, and removes the file's other variant
+//(the compressed one, if it's kept as is now, and vice versa).
+func (f *FS) written(w *writer) error {
+	compressed := w.enc != nil
+	if _, ok := f.under.(fsys.ModTimeCreator); !ok && !w.modTime.IsZero() {
+		underName := w.name
+		if compressed {
+			underName += Suffix
+		}
+		if err := f.under.Chtimes(underName, time.Now(), w.modTime); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	_, wasCompressed := f.idx.Files[w.rel]
+	if compressed {
+		f.idx.Files[w.rel] = entry{Size: w.size, ModTime: w.modTime, Stored: w.out.n}
+	} else {
+		delete(f.idx.Files, w.rel)
+	}
+	f.dirty = true
+	f.mu.Unlock()
+
+	var err error
+	if compressed {
+		if info, sErr := f.under.Stat(w.name); sErr == nil && info.Mode().IsRegular() {
+			err = f.under.Remove(w.name)
+		}
+	} else if wasCompressed {
+		err = f.under.Remove(w.name + Suffix)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot remove the file's previous copy: %w", err)
+	}
+	return f.save(false)
+}
+
+//save saves the index, if it's changed, and either it's forced or the last save is not recent.
+func (f *FS) save(force bool) error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+	f.mu.Lock()
+	if f.idx == nil || !f.dirty || (!force && time.Since(f.savedAt) < saveInterval) {
+		f.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(f.idx)
+	f.dirty, f.savedAt = false, time.Now()
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	indexPath := f.under.Join(f.root, IndexName)
+	w, err := f.under.Create(indexPath + ".tmp")
+	if err == nil {
+		_, err = w.Write(data)
+		if cErr := w.Close(); err == nil {
+			err = cErr
+		}
+	}
+	if err == nil {
+		err = f.under.Rename(indexPath+".tmp", indexPath)
+	}
+	if err != nil {
+		f.mu.Lock()
+		f.dirty = true
+		f.mu.Unlock()
+		return fmt.Errorf("cannot save compression index: %w", err)
+	}
+	return nil
+}
+
+func (f *FS) Walk(root string, fn fs.WalkDirFunc) error {
+	if _, err := f.index(); err != nil {
+		return fn(root, nil, err)
+	}
+	indexPath := f.under.Join(f.root, IndexName)
+	return f.under.Walk(root, func(underName string, de fs.DirEntry, err error) error {
+		if err != nil || de == nil {
+			return fn(underName, de, err)
+		}
+		if underName == indexPath || underName == indexPath+".tmp" {
+			return nil
+		}
+		if de.Type().IsRegular() && strings.HasSuffix(underName, Suffix) {
+			name := strings.TrimSuffix(underName, Suffix)
+			if _, e, ok, _ := f.resolve(name); ok {
+				return fn(name, &dirEntry{DirEntry: de, fs: f, name: filepath.Base(name), e: e}, nil)
+			}
+		}
+		return fn(underName, de, nil)
+	})
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	_, e, compressed, err := f.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if !compressed {
+		return f.under.Stat(name)
+	}
+	info, err := f.under.Stat(name + Suffix)
+	if err != nil {
+		return nil, err
+	}
+	return f.plainInfo(filepath.Base(name), info, e), nil
+}
+
+//plainInfo returns the compressed file's info with the original name, size and modification time. If the stored
+//file is not the one of the index entry (e.g. it has been changed outside), then its own info is kept,
+//so it's just replaced on the next sync.
+func (f *FS) plainInfo(name string, info fs.FileInfo, e entry) fs.FileInfo {
+	plain := &fileInfo{FileInfo: info, name: name, size: info.Size(), modTime: info.ModTime()}
+	if info.Size() == e.Stored {
+		plain.size = e.Size
+		if f.cfg.Restore && !e.ModTime.IsZero() {
+			plain.modTime = e.ModTime
+		}
+	}
+	return plain
+}
+
+func (f *FS) Open(name string) (io.ReadCloser, error) {
+	_, _, compressed, err := f.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if !compressed {
+		return f.under.Open(name)
+	}
+	in, err := f.under.Open(name + Suffix)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	return &reader{dec: dec, in: in}, nil
+}
+
+func (f *FS) Create(name string) (io.WriteCloser, error) {
+	return f.CreateWithModTime(name, time.Time{})
+}
+
+//CreateWithModTime creates the file, which is kept compressed or as is, that's decided by the beginning
+//of its content. The modification time is set, when the file is closed.
+func (f *FS) CreateWithModTime(name string, modTime time.Time) (io.WriteCloser, error) {
+	key, _, _, err := f.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(name, Suffix) {
+		if _, _, compressed, err := f.resolve(strings.TrimSuffix(name, Suffix)); err == nil && compressed {
+			return nil, fmt.Errorf("cannot create %q: the name is taken by the compressed file", name)
+		}
+	}
+	if key == "" {
+		return f.under.Create(name) // e.g. the files outside the root are not compressed
+	}
+	return &writer{fs: f, name: name, rel: key, modTime: modTime}, nil
+}
+
+func (f *FS) Rename(oldName, newName string) error {
+	oldKey, e, compressed, err := f.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	newKey, _, newCompressed, err := f.resolve(newName)
+	if err != nil {
+		return err
+	}
+	oldUnder, newUnder := oldName, newName
+	if compressed {
+		oldUnder, newUnder = oldName+Suffix, newName+Suffix
+	}
+	if err := f.under.Rename(oldUnder, newUnder); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer func() {
+		f.dirty = true
+		f.mu.Unlock()
+	}()
+	if compressed {
+		delete(f.idx.Files, oldKey)
+		f.idx.Files[newKey] = e
+		return nil
+	}
+	if newCompressed {
+		delete(f.idx.Files, newKey) // the compressed file is replaced by the one kept as is
+	}
+	// the compressed files inside the renamed dir are moved along with it
+	prefix := oldKey + "/"
+	for key, e := range f.idx.Files {
+		if oldKey != "" && strings.HasPrefix(key, prefix) {
+			delete(f.idx.Files, key)
+			f.idx.Files[newKey+"/"+strings.TrimPrefix(key, prefix)] = e
+		}
+	}
+	return nil
+}
+
+func (f *FS) Remove(name string) error {
+	key, _, compressed, err := f.resolve(name)
+	if err != nil {
+		return err
+	}
+	if !compressed {
+		return f.under.Remove(name)
+	}
+	if err := f.under.Remove(name + Suffix); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	delete(f.idx.Files, key)
+	f.dirty = true
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	key, e, compressed, err := f.resolve(name)
+	if err != nil {
+		return err
+	}
+	if !compressed {
+		return f.under.Chtimes(name, atime, mtime)
+	}
+	if err := f.under.Chtimes(name+Suffix, atime, mtime); err != nil {
+		return err
+	}
+	e.ModTime = mtime
+	f.mu.Lock()
+	f.idx.Files[key] = e
+	f.dirty = true
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	underName, err := f.underName(name)
+	if err != nil {
+		return err
+	}
+	return f.under.Chmod(underName, mode)
+}
+
+func (f *FS) MkdirAll(name string) error {
+	return f.under.MkdirAll(name)
+}
+
+func (f *FS) Join(root, path string) string {
+	return f.under.Join(root, path)
+}
+
+func (f *FS) Rel(root, name string) (string, error) {
+	return f.under.Rel(root, name)
+}
+
+//ModTimePrecision is the one of the underlying file system.
+func (f *FS) ModTimePrecision() time.Duration {
+	return fsys.ModTimePrecision(f.under)
+}
+
+//Close saves the index and closes the underlying file system, if it's closable (e.g. the SFTP connection).
+func (f *FS) Close() error {
+	err := f.save(true)
+	if closer, ok := f.under.(io.Closer); ok {
+		if cErr := closer.Close(); err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+//reader decompresses the content of the underlying file.
+type reader struct {
+	dec *zstd.Decoder
+	in  io.ReadCloser
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.dec.Read(p)
+}
+
+func (r *reader) Close() error {
+	r.dec.Close()
+	return r.in.Close()
+}
+
+type fileInfo struct {
+	fs.FileInfo
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+
+type dirEntry struct {
+	fs.DirEntry
+	fs   *FS
+	name string
+	e    entry
+}
+
+func (e *dirEntry) Name() string {
+	return e.name
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return e.fs.plainInfo(e.name, info, e.e), nil
+}
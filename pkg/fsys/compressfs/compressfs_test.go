@@ -0,0 +1,153 @@
+package compressfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"dsync/pkg/fsys"
+	"dsync/pkg/fsys/memfs"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(requires *require.Assertions, f fsys.FS, name string, content []byte, modTime time.Time) {
+	w, err := f.(fsys.ModTimeCreator).CreateWithModTime(name, modTime)
+	requires.NoError(err)
+	_, err = w.Write(content)
+	requires.NoError(err)
+	requires.NoError(w.Close())
+}
+
+func readFile(f fsys.FS, name string) ([]byte, error) {
+	r, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestFS(t *testing.T) {
+	requires := require.New(t)
+	under := memfs.New()
+	f := New(under, "/copy", Config{})
+	var _ fsys.FS = f
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 891, time.Local)
+	logs := bytes.Repeat([]byte("2021-03-04 INFO something has happened\n"), 10000)
+	random := make([]byte, 2*probeSize)
+	_, err := rand.Read(random)
+	requires.NoError(err)
+
+	requires.NoError(f.MkdirAll("/copy/logs"))
+	writeFile(requires, f, "/copy/logs/app.log", logs, modTime)
+	writeFile(requires, f, "/copy/logs/small.txt", []byte("small"), modTime)
+	writeFile(requires, f, "/copy/random.bin", random, modTime)
+	writeFile(requires, f, "/copy/photo.jpg", logs, modTime)
+
+	// the compressible files are kept compressed, the other ones are kept as is
+	info, err := under.Stat("/copy/logs/app.log" + Suffix)
+	requires.NoError(err)
+	requires.Less(info.Size(), int64(len(logs)/10))
+	_, err = under.Stat("/copy/logs/small.txt" + Suffix)
+	requires.NoError(err)
+	for _, name := range []string{"/copy/random.bin", "/copy/photo.jpg"} {
+		_, err = under.Stat(name)
+		requires.NoError(err, name)
+	}
+
+	// the original content, sizes and modification times are seen
+	content, err := readFile(f, "/copy/logs/app.log")
+	requires.NoError(err)
+	requires.Equal(logs, content)
+	content, err = readFile(f, "/copy/random.bin")
+	requires.NoError(err)
+	requires.Equal(random, content)
+	info, err = f.Stat("/copy/logs/app.log")
+	requires.NoError(err)
+	requires.Equal("app.log", info.Name())
+	requires.Equal(int64(len(logs)), info.Size())
+	requires.Equal(modTime, info.ModTime())
+	sizes := make(map[string]int64)
+	requires.NoError(f.Walk("/copy", func(name string, de fs.DirEntry, err error) error {
+		requires.NoError(err)
+		info, err := de.Info()
+		requires.NoError(err)
+		requires.Equal(info.Name(), de.Name())
+		sizes[name] = info.Size()
+		return nil
+	}))
+	requires.Equal(map[string]int64{"/copy": 0, "/copy/logs": 0, "/copy/logs/app.log": int64(len(logs)),
+		"/copy/logs/small.txt": 5, "/copy/random.bin": int64(len(random)), "/copy/photo.jpg": int64(len(logs))}, sizes)
+
+	// the file replaced by the incompressible content is kept as is, and its compressed copy is removed
+	writeFile(requires, f, "/copy/logs/app.log", random, modTime)
+	_, err = under.Stat("/copy/logs/app.log" + Suffix)
+	requires.ErrorIs(err, fs.ErrNotExist)
+	content, err = readFile(f, "/copy/logs/app.log")
+	requires.NoError(err)
+	requires.Equal(random, content)
+
+	// Rename, Remove, Chtimes
+	requires.NoError(f.Rename("/copy/logs", "/copy/old"))
+	requires.NoError(f.Chtimes("/copy/old/small.txt", time.Now(), modTime.Add(time.Hour)))
+	info, err = f.Stat("/copy/old/small.txt")
+	requires.NoError(err)
+	requires.Equal(int64(5), info.Size())
+	requires.Equal(modTime.Add(time.Hour), info.ModTime())
+	requires.NoError(f.Remove("/copy/old/small.txt"))
+	_, err = under.Stat("/copy/old/small.txt" + Suffix)
+	requires.ErrorIs(err, fs.ErrNotExist)
+
+	// the index is saved on Close, and the restored dir has the original modification times from it
+	writeFile(requires, f, "/copy/new.txt", logs, modTime)
+	requires.NoError(f.Close())
+	requires.NoError(under.Chtimes("/copy/new.txt"+Suffix, time.Now(), time.Now()))
+	compressed, err := IsCompressed(under, "/copy")
+	requires.NoError(err)
+	requires.True(compressed)
+	restored := New(under, "/copy", Config{Restore: true})
+	info, err = restored.Stat("/copy/new.txt")
+	requires.NoError(err)
+	requires.Equal(int64(len(logs)), info.Size())
+	requires.Equal(modTime, info.ModTime())
+	_, err = New(under, "/other", Config{Restore: true}).Stat("/other/new.txt")
+	requires.ErrorIs(err, fs.ErrNotExist)
+}
+
+func TestFS_NameCollision(t *testing.T) {
+	requires := require.New(t)
+	under := memfs.New()
+	f := New(under, "/copy", Config{})
+	text := []byte(strings.Repeat("text ", 100))
+	requires.NoError(f.MkdirAll("/copy"))
+
+	// the file with the compressed file's name is kept as is, so the other file can't be compressed
+	writeFile(requires, f, "/copy/a"+Suffix, text, time.Now())
+	writeFile(requires, f, "/copy/a", text, time.Now())
+	content, err := readFile(f, "/copy/a"+Suffix)
+	requires.NoError(err)
+	requires.Equal(text, content)
+	content, err = readFile(f, "/copy/a")
+	requires.NoError(err)
+	requires.Equal(text, content)
+
+	// and vice versa
+	writeFile(requires, f, "/copy/b", text, time.Now())
+	_, err = f.Create("/copy/b" + Suffix)
+	requires.Error(err)
+}
+
+func TestEntropy(t *testing.T) {
+	requires := require.New(t)
+	requires.Zero(entropy(bytes.Repeat([]byte("a"), 100)))
+	requires.InDelta(1, entropy([]byte("abababab")), 0.001)
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	requires.InDelta(8, entropy(all), 0.001)
+}
@@ -0,0 +1,54 @@
+package compressfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	//IndexName is the file in the root of the compressed dir, which keeps the original sizes and modification times
+	//of the compressed files. It's not seen through FS.
+	IndexName = ".dsync-compress.json"
+
+	indexVersion = 1
+	//saveInterval is how often the index is saved at most while the files are written (it's saved on Close anyway).
+	saveInterval = 10 * time.Second
+)
+
+//entry is the index entry of the compressed file.
+type entry struct {
+	Size    int64     `json:"size"`    // the original size
+	ModTime time.Time `json:"modTime"` // the original modification time
+	Stored  int64     `json:"stored"`  // the compressed size, the entry is valid only if the stored file has it
+}
+
+//index is the content of the IndexName file. The files are keyed by their slash-separated paths relative to the root.
+type index struct {
+	Version int              `json:"version"`
+	Files   map[string]entry `json:"files"`
+}
+
+func newIndex() *index {
+	return &index{Version: indexVersion, Files: make(map[string]entry)}
+}
+
+func readIndex(r io.Reader) (*index, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read compression index: %w", err)
+	}
+	idx := newIndex()
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("cannot parse compression index: %w", err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("unsupported compression index version %d", idx.Version)
+	}
+	for path, e := range idx.Files {
+		e.ModTime = e.ModTime.Local() // the times are compared with the local ones scanned in the source dir
+		idx.Files[path] = e
+	}
+	return idx, nil
+}
@@ -0,0 +1,190 @@
+package compressfs
+
+import (
+	"dsync/pkg/fsys"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	//probeSize is the size of the content's beginning, which is probed before the file is created.
+	probeSize = 64 << 10
+	//maxEntropy is the entropy (in bits per byte) of the probe, above which the content is considered
+	//already compressed (or encrypted, or random), so it's stored as is.
+	maxEntropy = 7.5
+)
+
+//storedExts are the extensions of the formats, which are compressed already.
+var storedExts = map[string]bool{
+	".zst": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".lz4": true, ".lzma": true, ".7z": true,
+	".zip": true, ".rar": true, ".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true, ".m4a": true,
+	".mp4": true, ".mkv": true, ".webm": true, ".mov": true, ".avi": true, ".m4v": true,
+}
+
+//isCompressedExt reports whether the file's name has the extension of the already compressed format.
+func isCompressedExt(name string) bool {
+	return storedExts[strings.ToLower(filepath.Ext(name))]
+}
+
+//entropy returns the Shannon entropy of the data in bits per byte.
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var e float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(len(data))
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+//countingWriter counts the bytes written to the underlying file.
+type countingWriter struct {
+	io.WriteCloser
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+//writer buffers the beginning of the content, until it's decided, whether the content is compressed,
+//and then it creates the file (with or without Suffix) in the underlying file system.
+type writer struct {
+	fs      *FS
+	name    string // the plain full name
+	rel     string // the index key
+	modTime time.Time
+	probe   []byte
+	out     *countingWriter // is nil, until it's decided
+	enc     *zstd.Encoder   // is nil, if the content is stored as is
+	size    int64           // the original size
+	err     error
+	closed  bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.size += int64(len(p))
+	if w.out == nil {
+		w.probe = append(w.probe, p...)
+		if len(w.probe) < probeSize {
+			return len(p), nil
+		}
+		if w.err = w.decide(); w.err != nil {
+			return 0, w.err
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		_, w.err = w.enc.Write(p)
+	} else {
+		_, w.err = w.out.Write(p)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+//decide creates the file and writes the probe into it.
+func (w *writer) decide() error {
+	compress := !isCompressedExt(w.name) && entropy(w.probe) <= maxEntropy && w.fs.canCompress(w.name, w.rel)
+	underName := w.name
+	if compress {
+		underName += Suffix
+	}
+	var (
+		out io.WriteCloser
+		err error
+	)
+	if creator, ok := w.fs.under.(fsys.ModTimeCreator); ok && !w.modTime.IsZero() {
+		out, err = creator.CreateWithModTime(underName, w.modTime)
+	} else {
+		out, err = w.fs.under.Create(underName)
+	}
+	if err != nil {
+		return err
+	}
+	w.out = &countingWriter{WriteCloser: out}
+	if compress {
+		if w.enc, err = zstd.NewWriter(w.out, zstd.WithEncoderConcurrency(1)); err != nil {
+			_ = out.Close()
+			return err
+		}
+		_, err = w.enc.Write(w.probe)
+	} else {
+		_, err = w.out.Write(w.probe)
+	}
+	w.probe = nil
+	return err
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.err == nil && w.out == nil {
+		w.err = w.decide()
+	}
+	if w.out == nil {
+		return w.err
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); w.err == nil {
+			w.err = err
+		}
+	}
+	if err := w.out.Close(); w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.fs.written(w)
+	return w.err
+}
+
+//Abort discards the written content, if the underlying file system can do it (see fsys.Aborter).
+func (w *writer) Abort() error {
+	w.closed = true
+	if w.out == nil {
+		return nil
+	}
+	if w.enc != nil {
+		_ = w.enc.Close()
+	}
+	if aborter, ok := w.out.WriteCloser.(fsys.Aborter); ok {
+		return aborter.Abort()
+	}
+	return w.out.Close()
+}
+
+//indexKey returns the index key of the path relative to the root.
+func indexKey(rel string) string {
+	return path.Clean(filepath.ToSlash(rel))
+}
@@ -0,0 +1,399 @@
+package cryptfs
+
+import (
+	"bytes"
+	"dsync/pkg/fsys"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Config tells, how the dir is encrypted.
+type Config struct {
+	KeyPath    string // the keyfile, the master key is derived from (it's preferred over the passphrase)
+	Passphrase string // the passphrase, the master key is derived from, if there is no keyfile
+	Names      bool   // whether the names are encrypted along with the content (only for the new dir)
+	//Restore means, that the dir is read to be decrypted: it must be encrypted already, and the modification
+	//times are taken from the files' headers rather than from the underlying file system.
+	Restore bool
+}
+
+//FS is the file system, which encrypts the content (and, optionally, the names) of the files in the root dir
+//of the underlying file system. The sizes are the ones of the plain content, and the modification times are kept
+//in the files' headers as well, so the encrypted dir can be compared with the plain one. The dir's config is made
+//on the first access to the dir.
+type FS struct {
+	under fsys.FS
+	root  string
+	sep   string // the path separator of the underlying file system
+	cfg   Config
+
+	mu      sync.Mutex
+	keys    *keys
+	names   bool  // whether the names are encrypted in the dir
+	initErr error // the error of the dir's config, if it can't be made or read
+}
+
+//New returns the file system of the root dir of the underlying file system.
+func New(under fsys.FS, root string, cfg Config) *FS {
+	return &FS{under: under, root: root, sep: separator(under), cfg: cfg}
+}
+
+//separator returns the path separator of the file system, which is known from its Join (e.g. it's the slash
+//for the remote file systems regardless of the local OS).
+func separator(under fsys.FS) string {
+	return strings.TrimPrefix(under.Join("a", "b"), "a")[:1]
+}
+
+//init reads the dir's config or makes it, if the dir is not encrypted yet.
+func (f *FS) init() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.keys != nil || f.initErr != nil {
+		return f.initErr
+	}
+	dc, err := f.readConfig()
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && f.cfg.Restore:
+		err = fmt.Errorf("%q is not encrypted by dsync: %w", f.root, err)
+	case errors.Is(err, fs.ErrNotExist):
+		err = f.makeConfig()
+	case err == nil:
+		err = f.useConfig(dc)
+	}
+	f.initErr = err
+	return err
+}
+
+func (f *FS) readConfig() (dirConfig, error) {
+	var dc dirConfig
+	r, err := f.under.Open(f.under.Join(f.root, ConfigName))
+	if err != nil {
+		return dc, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return dc, fmt.Errorf("cannot read encryption config: %w", err)
+	}
+	if err := json.Unmarshal(data, &dc); err != nil {
+		return dc, fmt.Errorf("cannot parse encryption config: %w", err)
+	}
+	if dc.Version != configVersion {
+		return dc, fmt.Errorf("unsupported encryption config version %d", dc.Version)
+	}
+	return dc, nil
+}
+
+func (f *FS) makeConfig() error {
+	dc, k, err := newDirConfig(f.cfg)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(dc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := f.under.MkdirAll(f.root); err != nil {
+		return fmt.Errorf("cannot make dir: %w", err)
+	}
+	w, err := f.under.Create(f.under.Join(f.root, ConfigName))
+	if err == nil {
+		_, err = w.Write(data)
+		if cErr := w.Close(); err == nil {
+			err = cErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write encryption config: %w", err)
+	}
+	f.keys, f.names = k, dc.Names
+	return nil
+}
+
+func (f *FS) useConfig(dc dirConfig) error {
+	if !f.cfg.Restore && dc.Names != f.cfg.Names {
+		return fmt.Errorf("names encryption of %q can't be changed (it's %t)", f.root, dc.Names)
+	}
+	master, err := masterKey(f.cfg, dc)
+	if err != nil {
+		return err
+	}
+	k, err := deriveKeys(master)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(k.check, dc.Check) {
+		return ErrWrongKey
+	}
+	f.keys, f.names = k, dc.Names
+	return nil
+}
+
+//underName returns the name of the entry in the underlying file system. The names outside the root dir are not
+//changed.
+func (f *FS) underName(name string) (string, error) {
+	if err := f.init(); err != nil {
+		return "", err
+	}
+	prefix, parts, ok := f.splitName(name)
+	if !ok {
+		return name, nil
+	}
+	if len(parts) == 1 && parts[0] == ConfigName {
+		return "", fmt.Errorf("%q is reserved for encryption config", ConfigName)
+	}
+	if !f.names {
+		return name, nil
+	}
+	var err error
+	for i, part := range parts {
+		if parts[i], err = f.keys.encryptName(part); err != nil {
+			return "", err
+		}
+	}
+	return prefix + strings.Join(parts, f.sep), nil
+}
+
+//plainName returns the name of the entry of the underlying file system (inside the root dir).
+func (f *FS) plainName(underName string) (string, error) {
+	prefix, parts, ok := f.splitName(underName)
+	if !ok || !f.names {
+		return underName, nil
+	}
+	var err error
+	for i, part := range parts {
+		if parts[i], err = f.keys.decryptName(part); err != nil {
+			return "", err
+		}
+	}
+	return prefix + strings.Join(parts, f.sep), nil
+}
+
+//splitName splits the name inside the root dir into the prefix (which is the root dir with the trailing separator)
+//and the names of the entries on the path from the root dir. The name is split by the separator of the underlying
+//file system, so the names with the local OS separator inside are kept intact. ok is false, if the name is
+//the root dir itself or is outside it.
+func (f *FS) splitName(name string) (prefix string, parts []string, ok bool) {
+	clean := filepath.Clean
+	if f.sep == "/" {
+		clean = path.Clean
+	}
+	root, name := clean(f.root), clean(name)
+	prefix = strings.TrimSuffix(root, f.sep) + f.sep
+	if name == root || !strings.HasPrefix(name, prefix) {
+		return "", nil, false
+	}
+	return prefix, strings.Split(strings.TrimPrefix(name, prefix), f.sep), true
+}
+
+func (f *FS) Walk(root string, fn fs.WalkDirFunc) error {
+	underRoot, err := f.underName(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	return f.under.Walk(underRoot, func(underName string, de fs.DirEntry, err error) error {
+		if de != nil && !de.IsDir() && underName == f.under.Join(f.root, ConfigName) {
+			return nil
+		}
+		name, nameErr := f.plainName(underName)
+		if nameErr != nil {
+			return fn(underName, nil, nameErr)
+		}
+		if err != nil || de == nil {
+			return fn(name, de, err)
+		}
+		return fn(name, &dirEntry{DirEntry: de, fs: f, name: name, underName: underName}, nil)
+	})
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	underName, err := f.underName(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.under.Stat(underName)
+	if err != nil {
+		return nil, err
+	}
+	return f.plainInfo(name, underName, info)
+}
+
+//plainInfo returns the entry's info with the plain name and the size of the plain content.
+func (f *FS) plainInfo(name, underName string, info fs.FileInfo) (fs.FileInfo, error) {
+	plain := &fileInfo{FileInfo: info, name: filepath.Base(name), size: info.Size(), modTime: info.ModTime()}
+	if !info.Mode().IsRegular() {
+		return plain, nil
+	}
+	// the size of the broken (e.g. partially written) file is kept as is, so it's just replaced on the next sync
+	if size := plainSize(info.Size()); size >= 0 {
+		plain.size = size
+	}
+	if f.cfg.Restore {
+		r, err := f.under.Open(underName)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		header := make([]byte, headerSize)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("cannot read %q: %w", name, errNotEncrypted)
+		}
+		_, modTime, err := parseHeader(header)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %q: %w", name, err)
+		}
+		if !modTime.IsZero() {
+			plain.modTime = modTime.Local()
+		}
+	}
+	return plain, nil
+}
+
+func (f *FS) Open(name string) (io.ReadCloser, error) {
+	underName, err := f.underName(name)
+	if err != nil {
+		return nil, err
+	}
+	in, err := f.under.Open(underName)
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := newDecReader(f.keys, in)
+	if err != nil {
+		_ = in.Close()
+		return nil, fmt.Errorf("cannot decrypt %q: %w", name, err)
+	}
+	return r, nil
+}
+
+func (f *FS) Create(name string) (io.WriteCloser, error) {
+	return f.CreateWithModTime(name, time.Time{})
+}
+
+//CreateWithModTime creates the file, which has the modification time in its header. The time is set
+//in the underlying file system as well, when the file is closed.
+func (f *FS) CreateWithModTime(name string, modTime time.Time) (io.WriteCloser, error) {
+	underName, err := f.underName(name)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		out     io.WriteCloser
+		onClose func() error
+	)
+	if creator, ok := f.under.(fsys.ModTimeCreator); ok && !modTime.IsZero() {
+		out, err = creator.CreateWithModTime(underName, modTime)
+	} else {
+		out, err = f.under.Create(underName)
+		if !modTime.IsZero() {
+			onClose = func() error { return f.under.Chtimes(underName, time.Now(), modTime) }
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newEncWriter(f.keys, out, modTime, onClose)
+}
+
+func (f *FS) Rename(oldName, newName string) error {
+	oldUnder, err := f.underName(oldName)
+	if err != nil {
+		return err
+	}
+	newUnder, err := f.underName(newName)
+	if err != nil {
+		return err
+	}
+	return f.under.Rename(oldUnder, newUnder)
+}
+
+func (f *FS) Remove(name string) error {
+	underName, err := f.underName(name)
+	if err != nil {
+		return err
+	}
+	return f.under.Remove(underName)
+}
+
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	underName, err := f.underName(name)
+	if err != nil {
+		return err
+	}
+	return f.under.Chtimes(underName, atime, mtime)
+}
+
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	underName, err := f.underName(name)
+	if err != nil {
+		return err
+	}
+	return f.under.Chmod(underName, mode)
+}
+
+func (f *FS) MkdirAll(name string) error {
+	underName, err := f.underName(name)
+	if err != nil {
+		return err
+	}
+	return f.under.MkdirAll(underName)
+}
+
+func (f *FS) Join(root, path string) string {
+	return f.under.Join(root, path)
+}
+
+func (f *FS) Rel(root, name string) (string, error) {
+	return f.under.Rel(root, name)
+}
+
+//ModTimePrecision is the one of the underlying file system.
+func (f *FS) ModTimePrecision() time.Duration {
+	return fsys.ModTimePrecision(f.under)
+}
+
+//Close closes the underlying file system, if it's closable (e.g. the SFTP connection).
+func (f *FS) Close() error {
+	if closer, ok := f.under.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type fileInfo struct {
+	fs.FileInfo
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+
+type dirEntry struct {
+	fs.DirEntry
+	fs        *FS
+	name      string
+	underName string
+}
+
+func (e *dirEntry) Name() string {
+	return filepath.Base(e.name)
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return e.fs.plainInfo(e.name, e.underName, info)
+}
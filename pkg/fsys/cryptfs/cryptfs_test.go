@@ -0,0 +1,196 @@
+package cryptfs
+
+import (
+	"bytes"
+	"dsync/pkg/fsys"
+	"dsync/pkg/fsys/memfs"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(requires *require.Assertions, f fsys.FS, name string, content []byte, modTime time.Time) {
+	w, err := f.(fsys.ModTimeCreator).CreateWithModTime(name, modTime)
+	requires.NoError(err)
+	_, err = w.Write(content)
+	requires.NoError(err)
+	requires.NoError(w.Close())
+}
+
+func readFile(f fsys.FS, name string) ([]byte, error) {
+	r, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func keyFile(requires *require.Assertions, dir string) string {
+	path := filepath.Join(dir, "key")
+	requires.NoError(os.WriteFile(path, bytes.Repeat([]byte("k"), keySize), 0o600))
+	return path
+}
+
+func TestPlainSize(t *testing.T) {
+	for _, size := range []int64{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 7} {
+		require.Equal(t, size, plainSize(encryptedSize(size)), size)
+	}
+	require.Equal(t, int64(-1), plainSize(int64(headerSize)+tagSize-1))
+	require.Equal(t, int64(-1), plainSize(encryptedSize(chunkSize)+tagSize-1))
+}
+
+func TestFS_splitName(t *testing.T) {
+	requires := require.New(t)
+	f := New(memfs.New(), "/copy/", Config{}) // the slash-separated file system
+	prefix, parts, ok := f.splitName("/copy/dir/a\\b.txt")
+	requires.True(ok)
+	requires.Equal("/copy/", prefix)
+	requires.Equal([]string{"dir", "a\\b.txt"}, parts) // the backslash is the part of the name
+	_, _, ok = f.splitName("/copy")
+	requires.False(ok)
+	_, _, ok = f.splitName("/copy/../other/a.txt")
+	requires.False(ok)
+
+	f = &FS{root: "/", sep: "/"}
+	prefix, parts, ok = f.splitName("/a.txt")
+	requires.True(ok)
+	requires.Equal("/", prefix)
+	requires.Equal([]string{"a.txt"}, parts)
+}
+
+func TestFS(t *testing.T) {
+	for _, names := range []bool{false, true} {
+		t.Run(map[bool]string{false: "content", true: "names"}[names], func(t *testing.T) {
+			requires := require.New(t)
+			under := memfs.New()
+			cfg := Config{KeyPath: keyFile(requires, t.TempDir()), Names: names}
+			f := New(under, "/copy", cfg)
+			var _ fsys.FS = f
+			modTime := time.Date(2021, 3, 4, 5, 6, 7, 891, time.Local)
+			big := bytes.Repeat([]byte("0123456789"), chunkSize/5)
+
+			requires.NoError(f.MkdirAll("/copy/dir"))
+			writeFile(requires, f, "/copy/dir/big.bin", big, modTime)
+			writeFile(requires, f, "/copy/empty.txt", nil, modTime)
+
+			// the content and the sizes are the plain ones, and the modification time is set
+			content, err := readFile(f, "/copy/dir/big.bin")
+			requires.NoError(err)
+			requires.Equal(big, content)
+			info, err := f.Stat("/copy/dir/big.bin")
+			requires.NoError(err)
+			requires.Equal("big.bin", info.Name())
+			requires.Equal(int64(len(big)), info.Size())
+			requires.Equal(modTime, info.ModTime())
+			content, err = readFile(f, "/copy/empty.txt")
+			requires.NoError(err)
+			requires.Empty(content)
+
+			// the underlying files are encrypted, and the names are encrypted only if it's configured
+			var underNames []string
+			requires.NoError(under.Walk("/copy", func(name string, _ fs.DirEntry, err error) error {
+				underNames = append(underNames, name)
+				return err
+			}))
+			requires.Len(underNames, 5)
+			requires.Contains(underNames, "/copy/"+ConfigName)
+			requires.Equal(!names, strings.Contains(strings.Join(underNames, ","), "big.bin"))
+			underBig, err := f.underName("/copy/dir/big.bin")
+			requires.NoError(err)
+			encrypted, err := under.ReadFile(underBig)
+			requires.NoError(err)
+			requires.Equal(encryptedSize(int64(len(big))), int64(len(encrypted)))
+			requires.False(bytes.Contains(encrypted, []byte("0123456789")))
+
+			// Walk sees the plain names and sizes, but not the config
+			sizes := make(map[string]int64)
+			requires.NoError(f.Walk("/copy", func(name string, de fs.DirEntry, err error) error {
+				requires.NoError(err)
+				info, err := de.Info()
+				requires.NoError(err)
+				requires.Equal(filepath.Base(name), de.Name())
+				sizes[name] = info.Size()
+				return nil
+			}))
+			requires.Len(sizes, 4)
+			requires.Equal(int64(len(big)), sizes["/copy/dir/big.bin"])
+			requires.Equal(int64(0), sizes["/copy/empty.txt"])
+
+			// Rename, Remove
+			requires.NoError(f.Rename("/copy/dir/big.bin", "/copy/big.bin"))
+			content, err = readFile(f, "/copy/big.bin")
+			requires.NoError(err)
+			requires.Equal(big, content)
+			requires.NoError(f.Remove("/copy/big.bin"))
+			_, err = f.Stat("/copy/big.bin")
+			requires.ErrorIs(err, fs.ErrNotExist)
+
+			// the dir is opened with the same key, but not with another one or with other names encryption
+			_, err = New(under, "/copy", cfg).Stat("/copy/empty.txt")
+			requires.NoError(err)
+			_, err = New(under, "/copy", Config{Passphrase: "secret", Names: names}).Stat("/copy/empty.txt")
+			requires.Error(err)
+			other := Config{KeyPath: filepath.Join(t.TempDir(), "other")}
+			requires.NoError(os.WriteFile(other.KeyPath, bytes.Repeat([]byte("o"), keySize), 0o600))
+			other.Names = names
+			_, err = New(under, "/copy", other).Stat("/copy/empty.txt")
+			requires.ErrorIs(err, ErrWrongKey)
+			cfg.Names = !names
+			_, err = New(under, "/copy", cfg).Stat("/copy/empty.txt")
+			requires.Error(err)
+		})
+	}
+}
+
+func TestFS_Restore(t *testing.T) {
+	requires := require.New(t)
+	under := memfs.New()
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 891, time.Local)
+
+	_, err := New(under, "/copy", Config{Passphrase: "secret", Restore: true}).Stat("/copy/file.txt")
+	requires.ErrorIs(err, fs.ErrNotExist)
+
+	writeFile(requires, New(under, "/copy", Config{Passphrase: "secret", Names: true}), "/copy/file.txt",
+		[]byte("content"), modTime)
+	requires.NoError(under.Walk("/copy", func(name string, _ fs.DirEntry, err error) error {
+		return under.Chtimes(name, time.Now(), time.Now()) // e.g. the dir has been copied without the times
+	}))
+
+	// the names encryption is taken from the dir, and the modification time is taken from the header
+	f := New(under, "/copy", Config{Passphrase: "secret", Restore: true})
+	info, err := f.Stat("/copy/file.txt")
+	requires.NoError(err)
+	requires.Equal(modTime, info.ModTime())
+	content, err := readFile(f, "/copy/file.txt")
+	requires.NoError(err)
+	requires.Equal("content", string(content))
+}
+
+func TestFS_Tampered(t *testing.T) {
+	requires := require.New(t)
+	under := memfs.New()
+	f := New(under, "/copy", Config{Passphrase: "secret"})
+	content := bytes.Repeat([]byte("x"), 2*chunkSize)
+	writeFile(requires, f, "/copy/file.bin", content, time.Now())
+	encrypted, err := under.ReadFile("/copy/file.bin")
+	requires.NoError(err)
+	flipped := append([]byte{}, encrypted...)
+	flipped[headerSize+5] ^= 1
+
+	for name, data := range map[string][]byte{
+		"flipped":   flipped,
+		"truncated": encrypted[:headerSize+chunkSize+tagSize],
+		"header":    encrypted[:headerSize-1],
+	} {
+		requires.NoError(under.WriteFile("/copy/file.bin", data, time.Now()))
+		_, err := readFile(f, "/copy/file.bin")
+		requires.Error(err, name)
+	}
+}
@@ -0,0 +1,123 @@
+package cryptfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	//ConfigName is the file in the root of the encrypted dir, which keeps how the dir is encrypted
+	//(but not the key itself). It's not seen through FS.
+	ConfigName = ".dsync-crypt.json"
+
+	configVersion = 1
+	kdfKeyfile    = "keyfile"
+	kdfArgon2id   = "argon2id"
+	keySize       = 32
+)
+
+//ErrWrongKey is returned, when the key doesn't match the one the dir is encrypted with.
+var ErrWrongKey = errors.New("the key doesn't match the one the dir is encrypted with")
+
+//dirConfig is kept in the ConfigName file.
+type dirConfig struct {
+	Version int    `json:"version"`
+	Names   bool   `json:"names"` // whether the names are encrypted
+	KDF     string `json:"kdf"`   // how the master key is derived: from the keyfile or from the passphrase
+	Salt    []byte `json:"salt"`  // the salt of the passphrase KDF
+	Check   []byte `json:"check"` // the MAC of the constant, which tells, whether the key is right
+}
+
+//keys are the subkeys of the master key.
+type keys struct {
+	content []byte // the files' keys are derived from it
+	nameMAC []byte // the synthetic IVs of the names are made with it
+	names   cipher.AEAD
+	check   []byte
+}
+
+//masterKey derives the master key from the keyfile content (by SHA-256) or from the passphrase (by Argon2id).
+func masterKey(cfg Config, dc dirConfig) ([]byte, error) {
+	switch dc.KDF {
+	case kdfKeyfile:
+		if cfg.KeyPath == "" {
+			return nil, errors.New("the dir is encrypted with a keyfile, but no keyfile is passed")
+		}
+		data, err := os.ReadFile(cfg.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read keyfile: %w", err)
+		}
+		if len(data) < keySize {
+			return nil, fmt.Errorf("keyfile must have at least %d bytes", keySize)
+		}
+		sum := sha256.Sum256(data)
+		return sum[:], nil
+	case kdfArgon2id:
+		if cfg.KeyPath != "" || cfg.Passphrase == "" {
+			return nil, errors.New("the dir is encrypted with a passphrase, but no passphrase is passed")
+		}
+		return argon2.IDKey([]byte(cfg.Passphrase), dc.Salt, 3, 64<<10, 4, keySize), nil
+	default:
+		return nil, fmt.Errorf("unknown key derivation %q", dc.KDF)
+	}
+}
+
+//newDirConfig makes the config of the new encrypted dir along with its keys.
+func newDirConfig(cfg Config) (dirConfig, *keys, error) {
+	dc := dirConfig{Version: configVersion, Names: cfg.Names, KDF: kdfKeyfile}
+	if cfg.KeyPath == "" {
+		dc.KDF, dc.Salt = kdfArgon2id, make([]byte, 16)
+		if _, err := rand.Read(dc.Salt); err != nil {
+			return dirConfig{}, nil, err
+		}
+	}
+	master, err := masterKey(cfg, dc)
+	if err != nil {
+		return dirConfig{}, nil, err
+	}
+	k, err := deriveKeys(master)
+	if err != nil {
+		return dirConfig{}, nil, err
+	}
+	dc.Check = k.check
+	return dc, k, nil
+}
+
+func deriveKeys(master []byte) (*keys, error) {
+	k := &keys{
+		content: mac(master, "content"),
+		nameMAC: mac(master, "name-iv"),
+		check:   mac(master, "check"),
+	}
+	block, err := aes.NewCipher(mac(master, "name"))
+	if err != nil {
+		return nil, err
+	}
+	if k.names, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+//fileAEAD returns the cipher of the file, its key is derived from the file's random salt.
+func (k *keys) fileAEAD(salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(mac(k.content, string(salt)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func mac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
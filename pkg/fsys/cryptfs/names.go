@@ -0,0 +1,37 @@
+package cryptfs
+
+import (
+	"encoding/base32"
+	"fmt"
+)
+
+//maxNameLen is the limit of the name length of the most file systems.
+const maxNameLen = 255
+
+//nameEncoding has only lowercase letters, so the encrypted names are safe for case-insensitive file systems.
+var nameEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+//encryptName encrypts the name deterministically (the same name has always the same encrypted one, which is needed
+//to find the entry by its name): the nonce is the MAC of the name itself, so it's a synthetic IV construction.
+func (k *keys) encryptName(name string) (string, error) {
+	nonce := mac(k.nameMAC, name)[:k.names.NonceSize()]
+	sealed := k.names.Seal(nonce, nonce, []byte(name), nil)
+	encrypted := nameEncoding.EncodeToString(sealed)
+	if len(encrypted) > maxNameLen {
+		return "", fmt.Errorf("name %q is too long to be encrypted", name)
+	}
+	return encrypted, nil
+}
+
+func (k *keys) decryptName(encrypted string) (string, error) {
+	sealed, err := nameEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < k.names.NonceSize()+k.names.Overhead() {
+		return "", fmt.Errorf("name %q is not encrypted by dsync", encrypted)
+	}
+	nonce, sealed := sealed[:k.names.NonceSize()], sealed[k.names.NonceSize():]
+	name, err := k.names.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt name %q: %w", encrypted, ErrWrongKey)
+	}
+	return string(name), nil
+}
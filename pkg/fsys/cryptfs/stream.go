@@ -0,0 +1,262 @@
+package cryptfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"dsync/pkg/fsys"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+//The encrypted file is the header followed by the chunks of the content, each one is sealed by AES-256-GCM
+//separately under the file's key (derived from the salt of the header). The header is authenticated along with
+//each chunk, and the last chunk is marked in its nonce, so neither the header nor the chunks can be changed,
+//reordered or cut off unnoticed. As all chunks but the last one are full, the content size is derived from
+//the encrypted file size.
+const (
+	magic         = "DSYNCENC"
+	formatVersion = 1
+	saltSize      = 32
+	headerSize    = len(magic) + 1 + saltSize + 8 // magic, version, salt and modification time
+	chunkSize     = 64 << 10
+	tagSize       = 16
+)
+
+var (
+	errNotEncrypted = errors.New("file is not encrypted by dsync")
+	errTruncated    = errors.New("encrypted file is truncated")
+)
+
+//plainSize returns the content size of the encrypted file of the size (-1, if it can't be of any encrypted file).
+func plainSize(size int64) int64 {
+	body := size - int64(headerSize)
+	full, rest := body/(chunkSize+tagSize), body%(chunkSize+tagSize)
+	switch {
+	case body < tagSize:
+		return -1
+	case rest == 0:
+		return full * chunkSize
+	case rest < tagSize:
+		return -1
+	default:
+		return full*chunkSize + rest - tagSize
+	}
+}
+
+//encryptedSize returns the size of the encrypted file of the content size.
+func encryptedSize(size int64) int64 {
+	chunks := (size + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1 // the empty content has the empty last chunk
+	}
+	return int64(headerSize) + size + chunks*tagSize
+}
+
+func newHeader(modTime time.Time) ([]byte, error) {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = formatVersion
+	salt := header[len(magic)+1 : len(magic)+1+saltSize]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("cannot generate the file salt: %w", err)
+	}
+	var nanos int64
+	if !modTime.IsZero() {
+		nanos = modTime.UnixNano()
+	}
+	binary.BigEndian.PutUint64(header[headerSize-8:], uint64(nanos))
+	return header, nil
+}
+
+//parseHeader returns the file's salt and the content modification time (zero, if it's unknown).
+func parseHeader(header []byte) ([]byte, time.Time, error) {
+	if !bytes.HasPrefix(header, []byte(magic)) {
+		return nil, time.Time{}, errNotEncrypted
+	}
+	if v := header[len(magic)]; v != formatVersion {
+		return nil, time.Time{}, fmt.Errorf("unsupported encrypted file version %d", v)
+	}
+	salt := header[len(magic)+1 : len(magic)+1+saltSize]
+	var modTime time.Time
+	if nanos := int64(binary.BigEndian.Uint64(header[headerSize-8:])); nanos != 0 {
+		modTime = time.Unix(0, nanos)
+	}
+	return salt, modTime, nil
+}
+
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+//encWriter encrypts the content written to the underlying file.
+type encWriter struct {
+	out     io.WriteCloser
+	aead    cipher.AEAD
+	header  []byte
+	buf     []byte // the content, which is not sealed yet (the last chunk is sealed only on Close)
+	counter uint64
+	err     error        // the first write error, after which nothing is written anymore
+	aborted bool         // the content is not sealed on Close, if it's aborted
+	closed  bool         // the underlying file is closed only once
+	onClose func() error // is called after the file is closed successfully (may be nil)
+}
+
+func newEncWriter(k *keys, out io.WriteCloser, modTime time.Time, onClose func() error) (*encWriter, error) {
+	header, err := newHeader(modTime)
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	salt, _, _ := parseHeader(header)
+	aead, err := k.fileAEAD(salt)
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	w := &encWriter{out: out, aead: aead, header: header, buf: make([]byte, 0, chunkSize), onClose: onClose}
+	if _, err := out.Write(header); err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *encWriter) Write(p []byte) (int, error) {
+	if w.closed || w.aborted {
+		return 0, fs.ErrClosed
+	}
+	written := 0
+	for len(p) > 0 && w.err == nil {
+		if len(w.buf) == chunkSize {
+			w.seal(false) // there is more content, so the full chunk is not the last one
+		}
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, w.err
+}
+
+func (w *encWriter) seal(last bool) {
+	sealed := w.aead.Seal(nil, chunkNonce(w.counter, last), w.buf, w.header)
+	_, w.err = w.out.Write(sealed)
+	w.buf = w.buf[:0]
+	w.counter++
+}
+
+func (w *encWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.aborted {
+		return w.out.Close()
+	}
+	if w.err == nil {
+		w.seal(true)
+	}
+	if err := w.out.Close(); w.err == nil {
+		w.err = err
+	}
+	if w.err == nil && w.onClose != nil {
+		w.err = w.onClose()
+	}
+	return w.err
+}
+
+//Abort discards the written content, if the underlying file system can do it (see fsys.Aborter).
+func (w *encWriter) Abort() error {
+	w.aborted = true
+	if aborter, ok := w.out.(fsys.Aborter); ok {
+		return aborter.Abort()
+	}
+	return nil
+}
+
+//decReader decrypts the content of the underlying file.
+type decReader struct {
+	in      io.ReadCloser
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	sealed  []byte
+	plain   []byte // the decrypted content, which is not read yet
+	counter uint64
+	last    bool // is set, when the last chunk has been decrypted
+}
+
+func newDecReader(k *keys, in io.ReadCloser) (*decReader, time.Time, error) {
+	r := bufio.NewReaderSize(in, chunkSize+tagSize)
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = errNotEncrypted
+		}
+		return nil, time.Time{}, err
+	}
+	salt, modTime, err := parseHeader(header)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	aead, err := k.fileAEAD(salt)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &decReader{in: in, r: r, aead: aead, header: header, sealed: make([]byte, chunkSize+tagSize)}, modTime, nil
+}
+
+func (d *decReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+//next decrypts the next chunk. The chunk is the last one, if the file ends right after it.
+func (d *decReader) next() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	switch {
+	case errors.Is(err, io.EOF):
+		return errTruncated
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		d.last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+			d.last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	plain, err := d.aead.Open(d.sealed[:0], chunkNonce(d.counter, d.last), d.sealed[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("encrypted file is corrupted or truncated: %w", err)
+	}
+	d.plain = plain
+	d.counter++
+	return nil
+}
+
+func (d *decReader) Close() error {
+	return d.in.Close()
+}
@@ -0,0 +1,128 @@
+package fsys
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//FS is the file system, where the synchronized dirs reside. Names are the full paths in the form native
+//for the file system (e.g. they may be slash-separated for remote file systems regardless of the local OS).
+type FS interface {
+	//Walk walks the file tree rooted at root in the same way as filepath.WalkDir does (the entries' info is not
+	//following symlinks).
+	Walk(root string, fn fs.WalkDirFunc) error
+	//Stat returns the info of the named entry (following symlinks).
+	Stat(name string) (fs.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	//Create creates or truncates the named file.
+	Create(name string) (io.WriteCloser, error)
+	Rename(oldName, newName string) error
+	//Remove removes the named file or empty dir.
+	Remove(name string) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Chmod(name string, mode fs.FileMode) error
+	//MkdirAll makes the named dir along with any necessary parents.
+	MkdirAll(name string) error
+	//Join joins the root full path and the relative path (which is always in the local OS form).
+	Join(root, path string) string
+	//Rel returns the relative path (in the local OS form) of the full path to the root.
+	Rel(root, name string) (string, error)
+}
+
+//ModTimePrecision returns the precision of the modification times kept by the file system.
+//Zero means the full (nanosecond) precision.
+func ModTimePrecision(fsys FS) time.Duration {
+	if p, ok := fsys.(interface{ ModTimePrecision() time.Duration }); ok {
+		return p.ModTimePrecision()
+	}
+	return 0
+}
+
+//Updater is implemented by the file systems, which can replace the file's content by transferring only the changed
+//blocks (e.g. over the network). The file is created, if it doesn't exist.
+type Updater interface {
+	UpdateFile(name string, content io.Reader) error
+}
+
+//ModTimeCreator is implemented by the file systems, which keep the modification time along with the file's content
+//(e.g. the object storages, where it's the object's metadata), so the file is created with it at once.
+type ModTimeCreator interface {
+	CreateWithModTime(name string, modTime time.Time) (io.WriteCloser, error)
+}
+
+//Aborter is implemented by the files created by the file systems, which can discard the written content
+//(e.g. the object storages, where the object is created only on Close).
+type Aborter interface {
+	Abort() error
+}
+
+//JoinSlash implements FS.Join for the file systems with slash-separated paths.
+func JoinSlash(root, p string) string {
+	return path.Join(root, filepath.ToSlash(p))
+}
+
+//RelSlash implements FS.Rel for the file systems with slash-separated paths.
+func RelSlash(root, name string) (string, error) {
+	root, name = path.Clean(root), path.Clean(name)
+	if name == root {
+		return ".", nil
+	}
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	if !strings.HasPrefix(name, prefix) {
+		return "", fmt.Errorf("%q is not inside %q", name, root)
+	}
+	return filepath.FromSlash(strings.TrimPrefix(name, prefix)), nil
+}
+
+//Local is the file system of the local disk.
+type Local struct{}
+
+func (Local) Walk(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(root, fn)
+}
+
+func (Local) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (Local) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (Local) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (Local) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (Local) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (Local) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (Local) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (Local) MkdirAll(name string) error {
+	return os.MkdirAll(name, os.ModePerm)
+}
+
+func (Local) Join(root, path string) string {
+	return filepath.Join(root, path)
+}
+
+func (Local) Rel(root, name string) (string, error) {
+	return filepath.Rel(root, name)
+}
@@ -0,0 +1,366 @@
+package memfs
+
+import (
+	"bytes"
+	"dsync/pkg/fsys"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//these errors are matched by the error text by the callers (like the ones of the os package).
+var (
+	errDirNotEmpty = errors.New("directory not empty")
+	errNotDir      = errors.New("not a directory")
+	errIsDir       = errors.New("is a directory")
+	errInvalidMove = errors.New("cannot move a dir into itself")
+)
+
+//FS is the file system kept in memory. Its paths are slash-separated and rooted at "/" (relative names are resolved
+//against the root). The root dir always exists. It's safe for the concurrent use, and it's meant mostly for tests.
+type FS struct {
+	mu    sync.RWMutex
+	nodes map[string]*node // by the cleaned full path
+}
+
+type node struct {
+	dir     bool
+	data    []byte // is only appended by the writer, so its prefixes given to the readers never change
+	perm    fs.FileMode
+	modTime time.Time
+}
+
+//New returns the empty file system.
+func New() *FS {
+	return &FS{nodes: map[string]*node{"/": {dir: true, perm: 0777, modTime: now()}}}
+}
+
+//now returns the current time as the local file systems report it, i.e. without the monotonic clock reading,
+//so the modification times are comparable with ==.
+func now() time.Time {
+	return time.Now().Round(0)
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+//Walk walks the file tree in the lexical order like filepath.WalkDir does. The dir's entries are read before
+//they are visited, so the changes of the dir made meanwhile are not seen.
+func (f *FS) Walk(root string, fn fs.WalkDirFunc) error {
+	info, err := f.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = f.walk(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (f *FS) walk(name string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	for _, child := range f.readDir(name) {
+		if err := f.walk(path.Join(name, child.Name()), child, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+//readDir returns the sorted entries of the dir.
+func (f *FS) readDir(name string) []fs.DirEntry {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	dir := clean(name)
+	var entries []fs.DirEntry
+	for p, n := range f.nodes {
+		if p != "/" && path.Dir(p) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(n.info(p)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	p := clean(name)
+	n, err := f.lookup(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return n.info(p), nil
+}
+
+//lookup returns the node of the cleaned path. The error tells, whether the node or its parent dir is missing.
+func (f *FS) lookup(p string) (*node, error) {
+	if n, ok := f.nodes[p]; ok {
+		return n, nil
+	}
+	for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		if n, ok := f.nodes[dir]; ok && !n.dir {
+			return nil, errNotDir
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+//parent checks, that the parent dir of the cleaned path exists.
+func (f *FS) parent(p string) error {
+	n, err := f.lookup(path.Dir(p))
+	if err != nil {
+		return err
+	}
+	if !n.dir {
+		return errNotDir
+	}
+	return nil
+}
+
+func (f *FS) Open(name string) (io.ReadCloser, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	n, err := f.lookup(clean(name))
+	if err == nil && n.dir {
+		err = errIsDir
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return io.NopCloser(bytes.NewReader(n.data)), nil
+}
+
+func (f *FS) Create(name string) (io.WriteCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := clean(name)
+	n, ok := f.nodes[p]
+	if ok && n.dir {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	if !ok {
+		if err := f.parent(p); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		n = &node{perm: 0666}
+		f.nodes[p] = n
+	}
+	n.data, n.modTime = nil, now()
+	return &writer{fs: f, node: n}, nil
+}
+
+//writer appends to the file, which stays the same even if it's renamed or removed meanwhile (like an open file).
+type writer struct {
+	fs     *FS
+	node   *node
+	closed bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	w.node.data = append(w.node.data, p...)
+	w.node.modTime = now()
+	return len(p), nil
+}
+
+func (w *writer) Close() error {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	return nil
+}
+
+//Rename moves the file or the dir with its whole tree. The existing file or the empty dir at the new name is
+//replaced like os.Rename does on Unix.
+func (f *FS) Rename(oldName, newName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	oldPath, newPath := clean(oldName), clean(newName)
+	fail := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	n, err := f.lookup(oldPath)
+	if err != nil {
+		return fail(err)
+	}
+	if oldPath == newPath {
+		return nil
+	}
+	if oldPath == "/" || strings.HasPrefix(newPath, oldPath+"/") {
+		return fail(errInvalidMove)
+	}
+	if err := f.parent(newPath); err != nil {
+		return fail(err)
+	}
+	if existing, ok := f.nodes[newPath]; ok {
+		switch {
+		case existing.dir && !n.dir:
+			return fail(errIsDir)
+		case !existing.dir && n.dir:
+			return fail(errNotDir)
+		case existing.dir && f.hasChildren(newPath):
+			return fail(errDirNotEmpty)
+		}
+	}
+	moved := map[string]*node{newPath: n}
+	for p, child := range f.nodes {
+		if strings.HasPrefix(p, oldPath+"/") {
+			moved[newPath+strings.TrimPrefix(p, oldPath)] = child
+			delete(f.nodes, p)
+		}
+	}
+	delete(f.nodes, oldPath)
+	for p, child := range moved {
+		f.nodes[p] = child
+	}
+	return nil
+}
+
+func (f *FS) hasChildren(dir string) bool {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for p := range f.nodes {
+		if p != "/" && strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FS) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := clean(name)
+	n, err := f.lookup(p)
+	if err == nil && n.dir && (p == "/" || f.hasChildren(p)) {
+		err = errDirNotEmpty
+	}
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	delete(f.nodes, p)
+	return nil
+}
+
+func (f *FS) Chtimes(name string, _, mtime time.Time) error {
+	return f.update("chtimes", name, func(n *node) { n.modTime = mtime.Round(0).Local() })
+}
+
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	return f.update("chmod", name, func(n *node) { n.perm = mode.Perm() })
+}
+
+func (f *FS) update(op, name string, fn func(n *node)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.lookup(clean(name))
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	fn(n)
+	return nil
+}
+
+func (f *FS) MkdirAll(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := clean(name)
+	var missing []string
+	for ; ; p = path.Dir(p) {
+		if n, ok := f.nodes[p]; ok {
+			if !n.dir {
+				return &fs.PathError{Op: "mkdir", Path: p, Err: errNotDir}
+			}
+			break
+		}
+		missing = append(missing, p)
+	}
+	modTime := now()
+	for _, dir := range missing {
+		f.nodes[dir] = &node{dir: true, perm: 0777, modTime: modTime}
+	}
+	return nil
+}
+
+func (f *FS) Join(root, p string) string {
+	return fsys.JoinSlash(root, p)
+}
+
+func (f *FS) Rel(root, name string) (string, error) {
+	return fsys.RelSlash(root, name)
+}
+
+//WriteFile creates the file along with its parent dirs. It's a shortcut to fill the file system in tests.
+func (f *FS) WriteFile(name string, content []byte, modTime time.Time) error {
+	if err := f.MkdirAll(path.Dir(clean(name))); err != nil {
+		return err
+	}
+	w, err := f.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Chtimes(name, modTime, modTime)
+}
+
+//ReadFile returns the file's content.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	r, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (n *node) info(p string) fs.FileInfo {
+	info := fileInfo{name: path.Base(p), size: int64(len(n.data)), mode: n.perm, modTime: n.modTime}
+	if n.dir {
+		info.mode |= fs.ModeDir
+		info.size = 0
+	}
+	return info
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,119 @@
+package memfs
+
+import (
+	"dsync/pkg/fsys"
+	"dsync/pkg/helpers/iout"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	requires := require.New(t)
+	f := New()
+	var _ fsys.FS = f
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 891, time.Local)
+
+	// MkdirAll, WriteFile, Stat
+	requires.NoError(f.MkdirAll("/root/empty"))
+	requires.NoError(f.WriteFile("/root/a/b/file.txt", []byte("content"), modTime))
+	info, err := f.Stat("/root/a/b/file.txt")
+	requires.NoError(err)
+	requires.Equal("file.txt", info.Name())
+	requires.Equal(int64(len("content")), info.Size())
+	requires.Equal(modTime, info.ModTime())
+	info, err = f.Stat("/root/a")
+	requires.NoError(err)
+	requires.True(info.IsDir())
+	_, err = f.Stat("/root/missing")
+	requires.ErrorIs(err, fs.ErrNotExist)
+	_, err = f.Stat("/root/a/b/file.txt/x")
+	requires.True(iout.IsErrNotDir(err))
+	requires.True(iout.IsErrNotDir(f.MkdirAll("/root/a/b/file.txt/x")))
+
+	// Create truncates the file, Chmod
+	w, err := f.Create("/root/a/c.txt")
+	requires.NoError(err)
+	_, err = w.Write([]byte("old"))
+	requires.NoError(err)
+	requires.NoError(w.Close())
+	w, err = f.Create("/root/a/c.txt")
+	requires.NoError(err)
+	_, err = w.Write([]byte("new!"))
+	requires.NoError(err)
+	requires.NoError(w.Close())
+	requires.NoError(f.Chmod("/root/a/c.txt", 0600))
+	info, err = f.Stat("/root/a/c.txt")
+	requires.NoError(err)
+	requires.Equal(fs.FileMode(0600), info.Mode())
+	content, err := f.ReadFile("/root/a/c.txt")
+	requires.NoError(err)
+	requires.Equal("new!", string(content))
+	_, err = f.Create("/root/missing/c.txt")
+	requires.ErrorIs(err, fs.ErrNotExist)
+	_, err = f.Open("/root/a")
+	requires.Error(err)
+
+	// Walk with SkipDir
+	var walked []string
+	requires.NoError(f.Walk("/root", func(name string, d fs.DirEntry, err error) error {
+		requires.NoError(err)
+		rel, err := f.Rel("/root", name)
+		requires.NoError(err)
+		walked = append(walked, fmt.Sprintf("%s %v", rel, d.IsDir()))
+		if d.IsDir() && d.Name() == "b" {
+			return fs.SkipDir
+		}
+		return nil
+	}))
+	requires.Equal([]string{". true", "a true", "a/b true", "a/c.txt false", "empty true"}, walked)
+	err = f.Walk("/root/missing", func(name string, d fs.DirEntry, err error) error { return err })
+	requires.ErrorIs(err, fs.ErrNotExist)
+
+	// Rename moves the whole tree, Remove of the non-empty dir fails
+	requires.NoError(f.Rename("/root/a", "/root/empty"))
+	content, err = f.ReadFile("/root/empty/b/file.txt")
+	requires.NoError(err)
+	requires.Equal("content", string(content))
+	_, err = f.Stat("/root/a")
+	requires.ErrorIs(err, fs.ErrNotExist)
+	requires.Error(f.Rename("/root/empty", "/root/empty/b/x"))
+	err = f.Remove("/root/empty/b")
+	var pErr *fs.PathError
+	requires.ErrorAs(err, &pErr)
+	requires.ErrorContains(pErr.Err, "directory not empty")
+	requires.NoError(f.Remove("/root/empty/b/file.txt"))
+	requires.NoError(f.Remove("/root/empty/b"))
+	requires.ErrorIs(f.Remove("/root/empty/b"), fs.ErrNotExist)
+}
+
+func TestFS_OpenFilesAreStable(t *testing.T) {
+	requires := require.New(t)
+	f := New()
+	requires.NoError(f.WriteFile("/file", []byte("old"), time.Now()))
+	r, err := f.Open("/file")
+	requires.NoError(err)
+
+	// the open reader keeps the old content, and the writer keeps writing to the renamed file
+	w, err := f.Create("/file")
+	requires.NoError(err)
+	_, err = w.Write([]byte("new"))
+	requires.NoError(err)
+	requires.NoError(f.Rename("/file", "/renamed"))
+	_, err = w.Write([]byte(" content"))
+	requires.NoError(err)
+	requires.NoError(w.Close())
+	_, err = w.Write([]byte("x"))
+	requires.ErrorIs(err, fs.ErrClosed)
+
+	content, err := io.ReadAll(r)
+	requires.NoError(err)
+	requires.Equal("old", string(content))
+	content, err = f.ReadFile("/renamed")
+	requires.NoError(err)
+	requires.Equal("new content", string(content))
+}
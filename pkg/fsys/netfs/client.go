@@ -0,0 +1,487 @@
+package netfs
+
+import (
+	"crypto/tls"
+	"dsync/pkg/delta"
+	"dsync/pkg/fsys"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 15 * time.Second
+
+//Config is the client config.
+type Config struct {
+	Token     string      // is sent to the server, if it requires authentication
+	TLSConfig *tls.Config // nil means the plain TCP connection
+}
+
+//FS is the remote file system served by the dsync server. It keeps a pool of connections (one per concurrent
+//operation), which are dialed on demand. If the idle connection turns out to be broken (e.g. the server has been
+//restarted), then the operation is retried once with a new connection.
+type FS struct {
+	loc Location
+	cfg Config
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+//New returns the file system of the remote location. It doesn't connect until the first operation.
+func New(loc Location, cfg Config) *FS {
+	return &FS{loc: loc, cfg: cfg}
+}
+
+//Location returns the remote location of the file system.
+func (f *FS) Location() Location {
+	return f.loc
+}
+
+//Close closes the idle connections. The file system may still be used, it reconnects in such case.
+func (f *FS) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.idle {
+		_ = c.close()
+	}
+	f.idle = nil
+	return nil
+}
+
+func (f *FS) dial() (*conn, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	var raw net.Conn
+	var err error
+	if f.cfg.TLSConfig != nil {
+		raw, err = tls.DialWithDialer(&d, "tcp", f.loc.Addr, f.cfg.TLSConfig)
+	} else {
+		raw, err = d.Dial("tcp", f.loc.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", f.loc.Addr, err)
+	}
+
+	c := newConn(raw)
+	var e encoder
+	e.uvarint(protoVersion).string(f.cfg.Token)
+	if err = c.send(msgHello, e.b); err == nil {
+		if err = c.flush(); err == nil {
+			_, err = c.recvReply(msgOK)
+		}
+	}
+	if err != nil {
+		_ = c.close()
+		return nil, fmt.Errorf("handshake with %s failed: %w", f.loc.Addr, err)
+	}
+	return c, nil
+}
+
+func (f *FS) get() (c *conn, reused bool, err error) {
+	f.mu.Lock()
+	if n := len(f.idle); n > 0 {
+		c = f.idle[n-1]
+		f.idle = f.idle[:n-1]
+		f.mu.Unlock()
+		return c, true, nil
+	}
+	f.mu.Unlock()
+	c, err = f.dial()
+	return c, false, err
+}
+
+func (f *FS) put(c *conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.idle = append(f.idle, c)
+}
+
+//release returns the connection to the pool, unless err is a transport error (the connection is closed then).
+func (f *FS) release(c *conn, err error) {
+	var rErr *remoteError
+	if err == nil || errors.As(err, &rErr) {
+		f.put(c)
+		return
+	}
+	_ = c.close()
+}
+
+//start runs the request on a connection and returns the connection (which has to be released), if the request
+//succeeds. The request is retried once with a new connection, if it fails on the idle one due to a transport error.
+func (f *FS) start(request func(c *conn) error) (*conn, error) {
+	for {
+		c, reused, err := f.get()
+		if err != nil {
+			return nil, err
+		}
+		err = request(c)
+		if err == nil {
+			return c, nil
+		}
+		f.release(c, err)
+		var rErr *remoteError
+		if !reused || errors.As(err, &rErr) {
+			return nil, err
+		}
+	}
+}
+
+//do runs the request, which is completed by its reply (see start).
+func (f *FS) do(request func(c *conn) error) error {
+	c, err := f.start(request)
+	if err != nil {
+		return err
+	}
+	f.put(c)
+	return nil
+}
+
+//call sends the request frame and receives the reply of the type.
+func (f *FS) call(t msgType, payload []byte, reply msgType) ([]byte, error) {
+	var res []byte
+	err := f.do(func(c *conn) (err error) {
+		if err = c.send(t, payload); err != nil {
+			return err
+		}
+		if err = c.flush(); err != nil {
+			return err
+		}
+		res, err = c.recvReply(reply)
+		return err
+	})
+	return res, err
+}
+
+//Walk lists the remote file tree (in the same order as filepath.WalkDir does) and then calls fn for its entries.
+func (f *FS) Walk(root string, fn fs.WalkDirFunc) error {
+	var entries []entry
+	err := f.do(func(c *conn) error {
+		entries = entries[:0]
+		if err := c.send(msgList, new(encoder).string(root).b); err != nil {
+			return err
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+		for {
+			t, payload, err := c.recv()
+			if err != nil {
+				return err
+			}
+			switch t {
+			case msgEntry:
+				en, err := decodeEntry(payload)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, en)
+			case msgEnd:
+				return nil
+			case msgError:
+				return decodeError(payload)
+			default:
+				return fmt.Errorf("unexpected reply type %d to the listing", t)
+			}
+		}
+	})
+	if err != nil {
+		if err = fn(root, nil, wrapErr("lstat", root, err)); errors.Is(err, fs.SkipDir) {
+			return nil
+		}
+		return err
+	}
+
+	skipPrefix := "" // the entries with such prefix are skipped (if it's not empty)
+	for _, en := range entries {
+		name := path.Join(root, en.path)
+		if skipPrefix != "" {
+			if strings.HasPrefix(name, skipPrefix) {
+				continue
+			}
+			skipPrefix = ""
+		}
+		err := fn(name, fs.FileInfoToDirEntry(fileInfo{name: path.Base(name), en: en}), nil)
+		if errors.Is(err, fs.SkipDir) {
+			if en.path == "." {
+				return nil
+			}
+			if en.mode.IsDir() {
+				skipPrefix = dirPrefix(name) // the dir is skipped
+			} else {
+				skipPrefix = dirPrefix(path.Dir(name)) // the rest of the parent dir is skipped
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dirPrefix(dir string) string {
+	return strings.TrimSuffix(dir, "/") + "/"
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	payload, err := f.call(msgStat, new(encoder).string(name).b, msgEntry)
+	if err != nil {
+		return nil, wrapErr("stat", name, err)
+	}
+	en, err := decodeEntry(payload)
+	if err != nil {
+		return nil, wrapErr("stat", name, err)
+	}
+	return fileInfo{name: path.Base(name), en: en}, nil
+}
+
+func (f *FS) Open(name string) (io.ReadCloser, error) {
+	c, err := f.start(func(c *conn) error {
+		if err := c.send(msgOpen, new(encoder).string(name).b); err != nil {
+			return err
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+		_, err := c.recvReply(msgOK)
+		return err
+	})
+	if err != nil {
+		return nil, wrapErr("open", name, err)
+	}
+	return &reader{fs: f, c: c, name: name}, nil
+}
+
+//reader reads the file content streamed by the server.
+type reader struct {
+	fs   *FS
+	c    *conn // is nil, when the stream is over
+	name string
+	data []byte
+	err  error // it's returned, when the data is over
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.c == nil {
+			return 0, r.err
+		}
+		t, payload, err := r.c.recv()
+		switch {
+		case err != nil:
+			r.finish(err)
+		case t == msgData:
+			r.data = payload
+		case t == msgEnd:
+			r.finish(nil)
+		case t == msgError:
+			r.finish(wrapErr("read", r.name, decodeError(payload)))
+		default:
+			r.finish(fmt.Errorf("unexpected frame type %d in the file content", t))
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *reader) finish(err error) {
+	r.fs.release(r.c, err)
+	r.c = nil
+	r.err = io.EOF
+	if err != nil {
+		r.err = err
+	}
+}
+
+func (r *reader) Close() error {
+	if r.c != nil {
+		_ = r.c.close() // the rest of the stream isn't read, so the connection cannot be reused
+		r.c = nil
+	}
+	return nil
+}
+
+func (f *FS) Create(name string) (io.WriteCloser, error) {
+	c, err := f.start(func(c *conn) error {
+		if err := c.send(msgCreate, new(encoder).string(name).b); err != nil {
+			return err
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+		_, err := c.recvReply(msgOK)
+		return err
+	})
+	if err != nil {
+		return nil, wrapErr("create", name, err)
+	}
+	return &writer{fs: f, c: c, name: name}, nil
+}
+
+//writer streams the file content to the server. The server acknowledges it on Close.
+type writer struct {
+	fs   *FS
+	c    *conn // is nil, when it's closed
+	name string
+	err  error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.c == nil {
+		return 0, fs.ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	for written := 0; written < len(p); {
+		n := len(p) - written
+		if n > dataChunk {
+			n = dataChunk
+		}
+		if w.err = w.c.send(msgData, p[written:written+n]); w.err != nil {
+			return written, w.err
+		}
+		written += n
+	}
+	return len(p), nil
+}
+
+func (w *writer) Close() error {
+	if w.c == nil {
+		return nil
+	}
+	err := w.err
+	if err == nil {
+		if err = w.c.send(msgEnd, nil); err == nil {
+			if err = w.c.flush(); err == nil {
+				_, err = w.c.recvReply(msgOK)
+			}
+		}
+	}
+	w.fs.release(w.c, err)
+	w.c = nil
+	return wrapErr("write", w.name, err)
+}
+
+//UpdateFile implements fsys.Updater: the server sends the signatures of the blocks of its file, and only the
+//blocks, which are not found in the content, are sent.
+func (f *FS) UpdateFile(name string, content io.Reader) error {
+	var (
+		blockSize int
+		sigs      []delta.BlockSignature
+	)
+	c, err := f.start(func(c *conn) error {
+		sigs = sigs[:0]
+		if err := c.send(msgSignature, new(encoder).string(name).b); err != nil {
+			return err
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+		payload, err := c.recvReply(msgOK)
+		if err != nil {
+			return err
+		}
+		d := decoder{b: payload}
+		if blockSize = int(d.uvarint()); d.err != nil {
+			return d.err
+		}
+		for {
+			t, payload, err := c.recv()
+			if err != nil {
+				return err
+			}
+			switch t {
+			case msgBlock:
+				d := decoder{b: payload}
+				sig := delta.BlockSignature{Weak: uint32(d.uvarint())}
+				copy(sig.Strong[:], d.bytes(len(sig.Strong)))
+				if d.err != nil {
+					return d.err
+				}
+				sigs = append(sigs, sig)
+			case msgEnd:
+				return nil
+			default:
+				return fmt.Errorf("unexpected frame type %d in the signatures", t)
+			}
+		}
+	})
+	if err != nil {
+		return wrapErr("update", name, err)
+	}
+
+	var e encoder
+	e.string(name).uvarint(uint64(blockSize))
+	if err = c.send(msgPatch, e.b); err == nil {
+		err = delta.Diff(sigs, blockSize, content, func(op delta.Op) error {
+			if op.Literal != nil {
+				return c.send(msgData, op.Literal)
+			}
+			return c.send(msgCopy, new(encoder).uvarint(uint64(op.Block)).b)
+		})
+	}
+	if err != nil {
+		_ = c.close() // the patch is incomplete, so the connection cannot be reused
+		return wrapErr("update", name, err)
+	}
+	if err = c.send(msgEnd, nil); err == nil {
+		if err = c.flush(); err == nil {
+			_, err = c.recvReply(msgOK)
+		}
+	}
+	f.release(c, err)
+	return wrapErr("update", name, err)
+}
+
+func (f *FS) Rename(oldName, newName string) error {
+	_, err := f.call(msgRename, new(encoder).string(oldName).string(newName).b, msgOK)
+	return wrapErr("rename", oldName, err)
+}
+
+func (f *FS) Remove(name string) error {
+	_, err := f.call(msgRemove, new(encoder).string(name).b, msgOK)
+	return wrapErr("remove", name, err)
+}
+
+func (f *FS) Chtimes(name string, _, mtime time.Time) error {
+	_, err := f.call(msgChtimes, new(encoder).string(name).varint(mtime.UnixNano()).b, msgOK)
+	return wrapErr("chtimes", name, err)
+}
+
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	_, err := f.call(msgChmod, new(encoder).string(name).uvarint(uint64(mode)).b, msgOK)
+	return wrapErr("chmod", name, err)
+}
+
+func (f *FS) MkdirAll(name string) error {
+	_, err := f.call(msgMkdirAll, new(encoder).string(name).b, msgOK)
+	return wrapErr("mkdir", name, err)
+}
+
+func (f *FS) Join(root, p string) string {
+	return fsys.JoinSlash(root, p)
+}
+
+func (f *FS) Rel(root, name string) (string, error) {
+	return fsys.RelSlash(root, name)
+}
+
+//wrapErr adds the operation and path to the error like the os package does.
+func wrapErr(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pErr *fs.PathError
+	if errors.As(err, &pErr) {
+		return err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
@@ -0,0 +1,315 @@
+package netfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dsync/pkg/fsys"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    Location
+		wantErr string
+	}{
+		{raw: "dsync://backup.local/data/copy/", want: Location{Addr: "backup.local:" + DefaultPort, Path: "/data/copy"}},
+		{raw: "dsync://127.0.0.1:9000/../x", want: Location{Addr: "127.0.0.1:9000", Path: "/x"}},
+		{raw: "dsync://host", want: Location{Addr: "host:" + DefaultPort, Path: "/"}},
+		{raw: "sftp://host/x", wantErr: "the scheme must be dsync"},
+		{raw: "dsync:///x", wantErr: "no host"},
+		{raw: "dsync://user@host/x", wantErr: "user info, query and fragment are not supported"},
+	}
+	for _, tt := range tests {
+		got, err := ParseURL(tt.raw)
+		if tt.wantErr != "" {
+			require.ErrorContains(t, err, tt.wantErr, tt.raw)
+			continue
+		}
+		require.NoError(t, err, tt.raw)
+		require.Equal(t, tt.want, got, tt.raw)
+		require.True(t, IsURL(tt.raw))
+	}
+}
+
+//countingListener counts the bytes received by the server.
+type countingListener struct {
+	net.Listener
+	received *int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: c, received: l.received}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	received *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.received, int64(n))
+	return n, err
+}
+
+type testServer struct {
+	root     string
+	addr     string
+	received int64
+	stop     func()
+}
+
+func startServer(t *testing.T, srv *Server) *testServer {
+	ts := &testServer{root: t.TempDir()}
+	srv.Root = ts.root
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ts.addr = listener.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, countingListener{Listener: listener, received: &ts.received}) }()
+	ts.stop = func() {
+		cancel()
+		require.NoError(t, <-done)
+	}
+	return ts
+}
+
+func TestFS(t *testing.T) {
+	requires := require.New(t)
+	ts := startServer(t, &Server{Token: "secret"})
+	defer ts.stop()
+	f := New(Location{Addr: ts.addr, Path: "/copy"}, Config{Token: "secret"})
+	defer f.Close()
+	root := "/copy"
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 891, time.UTC)
+
+	// MkdirAll, Create, Chtimes, Chmod, Stat
+	requires.NoError(f.MkdirAll(f.Join(root, filepath.Join("a", "b"))))
+	w, err := f.Create(f.Join(root, "a/b/file.txt"))
+	requires.NoError(err)
+	_, err = io.Copy(w, strings.NewReader("content"))
+	requires.NoError(err)
+	requires.NoError(w.Close())
+	requires.NoError(f.Chtimes(f.Join(root, "a/b/file.txt"), modTime, modTime))
+	requires.NoError(f.Chmod(f.Join(root, "a/b/file.txt"), 0o600))
+	info, err := f.Stat(f.Join(root, "a/b/file.txt"))
+	requires.NoError(err)
+	requires.Equal("file.txt", info.Name())
+	requires.Equal(int64(len("content")), info.Size())
+	requires.True(info.ModTime().Equal(modTime)) // the full precision is kept
+	requires.Equal(fs.FileMode(0o600), info.Mode().Perm())
+	_, err = f.Stat(f.Join(root, "missing"))
+	requires.ErrorIs(err, fs.ErrNotExist)
+	requires.NotContains(err.Error(), ts.root) // the server's local paths are not revealed
+
+	// MkdirAll fails, if there's a file in the way, and paths cannot escape the root
+	requires.ErrorContains(f.MkdirAll(f.Join(root, "a/b/file.txt/c")), "not a directory")
+	requires.NoError(f.MkdirAll("/../../escaped"))
+	requires.DirExists(filepath.Join(ts.root, "escaped"))
+
+	// Walk with SkipDir
+	w, err = f.Create(f.Join(root, "a/c.txt"))
+	requires.NoError(err)
+	requires.NoError(w.Close())
+	var walked []string
+	requires.NoError(f.Walk(root, func(name string, d fs.DirEntry, err error) error {
+		requires.NoError(err)
+		rel, err := f.Rel(root, name)
+		requires.NoError(err)
+		walked = append(walked, filepath.ToSlash(rel))
+		if d.IsDir() && d.Name() == "b" {
+			return fs.SkipDir
+		}
+		return nil
+	}))
+	requires.Equal([]string{".", "a", "a/b", "a/c.txt"}, walked)
+	err = f.Walk(f.Join(root, "missing"), func(name string, d fs.DirEntry, err error) error { return err })
+	requires.ErrorIs(err, fs.ErrNotExist)
+
+	// Open
+	r, err := f.Open(f.Join(root, "a/b/file.txt"))
+	requires.NoError(err)
+	content, err := io.ReadAll(r)
+	requires.NoError(err)
+	requires.NoError(r.Close())
+	requires.Equal("content", string(content))
+
+	// Rename and Remove
+	requires.NoError(f.Rename(f.Join(root, "a/b/file.txt"), f.Join(root, "a/c.txt")))
+	err = f.Remove(f.Join(root, "a"))
+	var pErr *fs.PathError
+	requires.ErrorAs(err, &pErr)
+	requires.ErrorContains(pErr.Err, "directory not empty")
+	requires.NoError(f.Remove(f.Join(root, "a/c.txt")))
+	requires.NoError(f.Remove(f.Join(root, "a/b")))
+	requires.NoDirExists(filepath.Join(ts.root, "copy", "a", "b"))
+}
+
+func TestFS_UpdateFile(t *testing.T) {
+	requires := require.New(t)
+	ts := startServer(t, &Server{})
+	defer ts.stop()
+	f := New(Location{Addr: ts.addr, Path: "/"}, Config{})
+	defer f.Close()
+	var _ fsys.Updater = f
+
+	old := make([]byte, 1<<20)
+	_, _ = rand.Read(old) // it's incompressible
+	requires.NoError(f.UpdateFile("/big.bin", bytes.NewReader(old)))
+	requireContent(requires, filepath.Join(ts.root, "big.bin"), old)
+	requires.Greater(atomic.LoadInt64(&ts.received), int64(len(old)))
+
+	// only the changed block and the checksums of the other blocks are transferred
+	changed := append([]byte(nil), old...)
+	copy(changed[500000:], "changed")
+	atomic.StoreInt64(&ts.received, 0)
+	requires.NoError(f.UpdateFile("/big.bin", bytes.NewReader(changed)))
+	requireContent(requires, filepath.Join(ts.root, "big.bin"), changed)
+	requires.Less(atomic.LoadInt64(&ts.received), int64(len(old)/50))
+
+	// the patch of the missing dir fails, but the connection stays usable
+	err := f.UpdateFile("/no/such/dir/file", bytes.NewReader(old))
+	requires.ErrorIs(err, fs.ErrNotExist)
+	_, err = f.Stat("/big.bin")
+	requires.NoError(err)
+	entries, err := os.ReadDir(ts.root)
+	requires.NoError(err)
+	requires.Len(entries, 1) // no temporary files are left
+}
+
+func TestFS_Symlinks(t *testing.T) {
+	requires := require.New(t)
+	ts := startServer(t, &Server{})
+	defer ts.stop()
+	f := New(Location{Addr: ts.addr, Path: "/"}, Config{})
+	defer f.Close()
+
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	requires.NoError(os.WriteFile(secret, []byte("secret"), 0o600))
+	if err := os.Symlink(outside, filepath.Join(ts.root, "dirlink")); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+	requires.NoError(os.Symlink(secret, filepath.Join(ts.root, "filelink")))
+
+	// the symlinks inside the root don't lead outside it
+	_, err := f.Open("/dirlink/secret.txt")
+	requires.ErrorContains(err, "the path goes through a symlink")
+	requires.NotContains(err.Error(), outside)
+	_, err = f.Stat("/filelink")
+	requires.ErrorContains(err, "the path goes through a symlink")
+	w, err := f.Create("/dirlink/new.txt")
+	if err == nil {
+		err = w.Close()
+	}
+	requires.ErrorContains(err, "the path goes through a symlink")
+	requires.NoFileExists(filepath.Join(outside, "new.txt"))
+	requires.ErrorContains(f.UpdateFile("/filelink", strings.NewReader("patched")), "the path goes through a symlink")
+	requires.ErrorContains(f.Chmod("/filelink", 0o666), "the path goes through a symlink")
+	requires.ErrorContains(f.Remove("/dirlink/secret.txt"), "the path goes through a symlink")
+	requireContent(requires, secret, []byte("secret"))
+
+	// the symlink itself can be removed
+	requires.NoError(f.Remove("/filelink"))
+	requires.NoFileExists(filepath.Join(ts.root, "filelink"))
+	requireContent(requires, secret, []byte("secret"))
+}
+
+func requireContent(req *require.Assertions, path string, want []byte) {
+	got, err := os.ReadFile(path)
+	req.NoError(err)
+	req.True(bytes.Equal(want, got))
+}
+
+func TestFS_AuthAndReconnect(t *testing.T) {
+	requires := require.New(t)
+	srv := &Server{Token: "secret"}
+	ts := startServer(t, srv)
+
+	_, err := New(Location{Addr: ts.addr, Path: "/"}, Config{Token: "wrong"}).Stat("/")
+	requires.ErrorIs(err, ErrAuth)
+
+	f := New(Location{Addr: ts.addr, Path: "/"}, Config{Token: "secret"})
+	defer f.Close()
+	_, err = f.Stat("/")
+	requires.NoError(err)
+
+	// the server is restarted on the same address, so the idle connection is broken
+	ts.stop()
+	listener, err := net.Listen("tcp", ts.addr)
+	requires.NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = srv.Serve(ctx, listener) }()
+	_, err = f.Stat("/")
+	requires.NoError(err)
+
+	cancel()
+	requires.Eventually(func() bool {
+		_, err = f.Stat("/")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	requires.ErrorContains(err, "cannot connect")
+}
+
+func TestFS_TLS(t *testing.T) {
+	requires := require.New(t)
+	cert, pool := newTestCert(requires)
+	ts := startServer(t, &Server{TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}})
+	defer ts.stop()
+
+	f := New(Location{Addr: ts.addr, Path: "/"}, Config{TLSConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}})
+	defer f.Close()
+	requires.NoError(f.MkdirAll("/dir"))
+	requires.DirExists(filepath.Join(ts.root, "dir"))
+
+	// the plain TCP client cannot talk to the TLS server
+	_, err := New(Location{Addr: ts.addr, Path: "/"}, Config{}).Stat("/")
+	requires.ErrorContains(err, "handshake")
+}
+
+func newTestCert(req *require.Assertions) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	req.NoError(err)
+	parsed, err := x509.ParseCertificate(der)
+	req.NoError(err)
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
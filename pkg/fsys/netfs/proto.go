@@ -0,0 +1,317 @@
+package netfs
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"time"
+)
+
+//protoVersion is incremented on any incompatible change of the protocol.
+const protoVersion = 1
+
+//msgType is the first byte of each frame. A frame is the type, the uvarint length and the payload.
+type msgType byte
+
+const (
+	msgHello     msgType = iota + 1 // version, token
+	msgOK                           // optional payload depending on the request
+	msgError                        // error kind, message
+	msgList                         // path; the reply is msgEntry frames (the root is the first one) and msgEnd
+	msgEntry                        // relative path, mode, size, mtime
+	msgEnd                          // ends a stream of frames
+	msgStat                         // path; the reply is msgEntry
+	msgOpen                         // path; the reply is msgOK, msgData frames and msgEnd
+	msgData                         // raw bytes
+	msgCreate                       // path, followed by msgData frames and msgEnd; the reply is msgOK
+	msgSignature                    // path; the reply is msgOK (block size), msgBlock frames and msgEnd
+	msgBlock                        // weak checksum, strong checksum
+	msgPatch                        // path, block size, followed by msgCopy and msgData frames and msgEnd
+	msgCopy                         // block index
+	msgRename                       // old path, new path
+	msgRemove                       // path
+	msgChtimes                      // path, mtime
+	msgChmod                        // path, mode
+	msgMkdirAll                     // path
+)
+
+const (
+	maxFrameSize = 1 << 20
+	dataChunk    = 64 << 10
+)
+
+//errKind is the kind of the error sent by the server, which lets the client restore the error kind.
+type errKind byte
+
+const (
+	kindOther errKind = iota
+	kindNotExist
+	kindNotDir
+	kindNotEmpty
+	kindAuth
+	kindProtocol
+)
+
+var (
+	errNotDir   = errors.New("not a directory")     // is matched by the text (like stdlib's one)
+	errNotEmpty = errors.New("directory not empty") // is matched by the text (like stdlib's one)
+	//ErrAuth is returned, if the server rejects the client's token.
+	ErrAuth = errors.New("authentication failed")
+)
+
+//remoteError is the error reported by the server. It's not a transport error, so the connection stays usable.
+type remoteError struct {
+	kind errKind
+	msg  string
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	switch e.kind {
+	case kindNotExist:
+		return fs.ErrNotExist
+	case kindNotDir:
+		return errNotDir
+	case kindNotEmpty:
+		return errNotEmpty
+	case kindAuth:
+		return ErrAuth
+	}
+	return nil
+}
+
+//conn is the framed and compressed connection. Frames are buffered, until flush is called.
+type conn struct {
+	raw net.Conn
+	r   *bufio.Reader
+	w   *flate.Writer
+	buf []byte
+}
+
+func newConn(raw net.Conn) *conn {
+	w, _ := flate.NewWriter(raw, flate.BestSpeed) // the error is possible only for the invalid level
+	return &conn{raw: raw, r: bufio.NewReader(flate.NewReader(raw)), w: w}
+}
+
+func (c *conn) close() error {
+	return c.raw.Close()
+}
+
+func (c *conn) send(t msgType, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes is too large", len(payload))
+	}
+	var e encoder
+	e.b = c.buf[:0]
+	e.byte(byte(t)).uvarint(uint64(len(payload)))
+	c.buf = e.b
+	if _, err := c.w.Write(c.buf); err != nil {
+		return err
+	}
+	_, err := c.w.Write(payload)
+	return err
+}
+
+func (c *conn) flush() error {
+	return c.w.Flush()
+}
+
+func (c *conn) recv() (msgType, []byte, error) {
+	t, err := c.r.ReadByte()
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// the compressed stream is never finished, so the peer's close between frames looks like this
+		return 0, nil, io.EOF
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return msgType(t), payload, nil
+}
+
+//recvReply receives the reply and converts msgError into remoteError. Any other type than want is the protocol error.
+func (c *conn) recvReply(want msgType) ([]byte, error) {
+	t, payload, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case want:
+		return payload, nil
+	case msgError:
+		return nil, decodeError(payload)
+	}
+	return nil, fmt.Errorf("unexpected reply type %d (want %d)", t, want)
+}
+
+//sendError sends the error to the client. The path errors are stripped of the path (it's the server's local one).
+func (c *conn) sendError(err error) error {
+	kind := errKindOf(err)
+	var pErr *fs.PathError
+	var lErr *os.LinkError
+	if errors.As(err, &pErr) {
+		err = pErr.Err
+	} else if errors.As(err, &lErr) {
+		err = lErr.Err
+	}
+	var e encoder
+	e.byte(byte(kind)).string(err.Error())
+	return c.send(msgError, e.b)
+}
+
+func decodeError(payload []byte) error {
+	d := decoder{b: payload}
+	kind, msg := errKind(d.byte()), d.string()
+	if d.err != nil {
+		return d.err
+	}
+	return &remoteError{kind: kind, msg: msg}
+}
+
+//entry is the file info sent over the wire.
+type entry struct {
+	path    string // slash-separated and relative to the listed root (or the stat'ed path itself)
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+}
+
+func (en entry) encode() []byte {
+	var e encoder
+	e.string(en.path).uvarint(uint64(en.mode)).varint(en.size).varint(en.modTime.UnixNano())
+	return e.b
+}
+
+func decodeEntry(payload []byte) (entry, error) {
+	d := decoder{b: payload}
+	en := entry{path: d.string(), mode: fs.FileMode(d.uvarint()), size: d.varint(), modTime: time.Unix(0, d.varint())}
+	return en, d.err
+}
+
+//fileInfo implements fs.FileInfo for the entry.
+type fileInfo struct {
+	name string
+	en   entry
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.en.size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.en.mode }
+func (fi fileInfo) ModTime() time.Time { return fi.en.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.en.mode.IsDir() }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) byte(v byte) *encoder {
+	e.b = append(e.b, v)
+	return e
+}
+
+func (e *encoder) uvarint(v uint64) *encoder {
+	var tmp [binary.MaxVarintLen64]byte
+	e.b = append(e.b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+	return e
+}
+
+func (e *encoder) varint(v int64) *encoder {
+	var tmp [binary.MaxVarintLen64]byte
+	e.b = append(e.b, tmp[:binary.PutVarint(tmp[:], v)]...)
+	return e
+}
+
+func (e *encoder) string(s string) *encoder {
+	e.uvarint(uint64(len(s)))
+	e.b = append(e.b, s...)
+	return e
+}
+
+func (e *encoder) bytes(b []byte) *encoder {
+	e.b = append(e.b, b...)
+	return e
+}
+
+//decoder reads the payload fields. The first error is kept, and the following reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+var errShortPayload = errors.New("short frame payload")
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(int(d.uvarint())))
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil || n < 0 || len(d.b) < n {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errShortPayload
+	}
+}
@@ -0,0 +1,543 @@
+package netfs
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"dsync/pkg/delta"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+//Server serves the local dir tree (the root) to the dsync clients. The clients' paths are confined to the root:
+//the paths, which go through the symlinks inside the root, are refused.
+type Server struct {
+	Root      string
+	Token     string          // if it's not empty, then the clients must send the same token
+	TLSConfig *tls.Config     // nil means the plain TCP connections
+	OnError   func(err error) // is called on the errors of the client sessions (it may be nil)
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+//Serve accepts the client connections on the listener, until the context is done (then nil is returned).
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	if s.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.TLSConfig)
+	}
+	s.mu.Lock()
+	s.conns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = listener.Close()
+		case <-done:
+		}
+	}()
+	defer s.wg.Wait()
+	defer s.closeConns()
+
+	for {
+		raw, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[raw] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.serveConn(raw); err != nil && s.OnError != nil {
+				s.OnError(fmt.Errorf("client %s: %w", raw.RemoteAddr(), err))
+			}
+			s.mu.Lock()
+			delete(s.conns, raw)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+//serveConn serves the client's requests one by one. The end of the client connection is not an error.
+func (s *Server) serveConn(raw net.Conn) error {
+	defer raw.Close()
+	c := newConn(raw)
+	if err := s.handshake(c); err != nil {
+		return err
+	}
+	for {
+		t, payload, err := c.recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if err = s.handle(c, t, payload); err != nil {
+			return err
+		}
+		if err = c.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handshake(c *conn) error {
+	_ = c.raw.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.raw.SetDeadline(time.Time{})
+
+	payload, err := c.recvReply(msgHello)
+	if err != nil {
+		return fmt.Errorf("bad handshake: %w", err)
+	}
+	d := decoder{b: payload}
+	version, token := d.uvarint(), d.string()
+	switch {
+	case d.err != nil:
+		err = d.err
+	case version != protoVersion:
+		err = &remoteError{kind: kindProtocol, msg: fmt.Sprintf("protocol version %d is not supported", version)}
+	case s.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1:
+		err = &remoteError{kind: kindAuth, msg: ErrAuth.Error()}
+	}
+	if err != nil {
+		_ = c.sendError(err)
+		_ = c.flush()
+		return err
+	}
+	if err = c.send(msgOK, nil); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+//errSymlink is the error of the path, which goes through the symlink, since it may lead outside the root.
+var errSymlink = errors.New("the path goes through a symlink")
+
+//resolve converts the client's path to the local one inside the root. None of the path's elements may be a symlink,
+//except the last one, if the operation doesn't follow it (nofollow).
+func (s *Server) resolve(name string, nofollow bool) (string, error) {
+	rel := filepath.FromSlash(path.Clean("/" + name))
+	elems := strings.Split(strings.Trim(rel, string(filepath.Separator)), string(filepath.Separator))
+	if nofollow {
+		elems = elems[:len(elems)-1]
+	}
+	local := s.Root
+	for _, elem := range elems {
+		if elem == "" { // the root itself
+			break
+		}
+		local = filepath.Join(local, elem)
+		info, err := os.Lstat(local)
+		if err != nil {
+			break // the missing path is the operation's error (or it's going to be created)
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: errSymlink}
+		}
+	}
+	return filepath.Join(s.Root, rel), nil
+}
+
+//handle handles the request. It returns only the transport errors, while the file system errors are sent to
+//the client.
+func (s *Server) handle(c *conn, t msgType, payload []byte) error {
+	d := decoder{b: payload}
+	name := d.string()
+	var err error
+	switch t {
+	case msgList:
+		err = s.list(c, name)
+	case msgStat:
+		err = s.stat(c, name)
+	case msgOpen:
+		err = s.open(c, name)
+	case msgCreate:
+		err = s.create(c, name)
+	case msgSignature:
+		err = s.signature(c, name)
+	case msgPatch:
+		blockSize := int(d.uvarint())
+		if d.err == nil && blockSize <= 0 {
+			d.err = fmt.Errorf("invalid block size %d", blockSize)
+		}
+		if d.err == nil {
+			err = s.patch(c, name, blockSize)
+		}
+	case msgRename:
+		newName := d.string()
+		if d.err == nil {
+			err = s.reply(c, s.rename(name, newName))
+		}
+	case msgRemove:
+		err = s.reply(c, s.do(name, true, os.Remove))
+	case msgChtimes:
+		mtime := time.Unix(0, d.varint())
+		if d.err == nil {
+			err = s.reply(c, s.do(name, false, func(local string) error {
+				return os.Chtimes(local, time.Now(), mtime)
+			}))
+		}
+	case msgChmod:
+		mode := fs.FileMode(d.uvarint())
+		if d.err == nil {
+			err = s.reply(c, s.do(name, false, func(local string) error { return os.Chmod(local, mode) }))
+		}
+	case msgMkdirAll:
+		err = s.reply(c, s.do(name, false, func(local string) error { return os.MkdirAll(local, os.ModePerm) }))
+	default:
+		return fmt.Errorf("unexpected request type %d", t)
+	}
+	if d.err != nil {
+		return fmt.Errorf("bad request of type %d: %w", t, d.err)
+	}
+	return err
+}
+
+//do resolves the client's path and calls op with the local one.
+func (s *Server) do(name string, nofollow bool, op func(local string) error) error {
+	local, err := s.resolve(name, nofollow)
+	if err != nil {
+		return err
+	}
+	return op(local)
+}
+
+func (s *Server) rename(name, newName string) error {
+	local, err := s.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	newLocal, err := s.resolve(newName, true)
+	if err != nil {
+		return err
+	}
+	return os.Rename(local, newLocal)
+}
+
+//reply sends the acknowledgement or the error of the request.
+func (s *Server) reply(c *conn, opErr error) error {
+	if opErr != nil {
+		return c.sendError(opErr)
+	}
+	return c.send(msgOK, nil)
+}
+
+func (s *Server) list(c *conn, name string) error {
+	root, err := s.resolve(name, true)
+	if err != nil {
+		return c.sendError(err)
+	}
+	info, err := os.Lstat(root)
+	if err != nil {
+		return c.sendError(err)
+	}
+	if err = c.send(msgEntry, entryOf(".", info).encode()); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return c.send(msgEnd, nil)
+	}
+
+	var sendErr error // the transport error stops the walk
+	err = filepath.WalkDir(root, func(fullPath string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fullPath == root {
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			return err
+		}
+		sendErr = c.send(msgEntry, entryOf(filepath.ToSlash(rel), info).encode())
+		return sendErr
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return c.sendError(err)
+	}
+	return c.send(msgEnd, nil)
+}
+
+func (s *Server) stat(c *conn, name string) error {
+	local, err := s.resolve(name, false)
+	if err != nil {
+		return c.sendError(err)
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return c.sendError(err)
+	}
+	return c.send(msgEntry, entryOf(name, info).encode())
+}
+
+func entryOf(p string, info fs.FileInfo) entry {
+	return entry{path: p, mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+}
+
+func (s *Server) open(c *conn, name string) error {
+	local, err := s.resolve(name, false)
+	if err != nil {
+		return c.sendError(err)
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return c.sendError(err)
+	}
+	defer f.Close()
+	if err = c.send(msgOK, nil); err != nil {
+		return err
+	}
+	buf := make([]byte, dataChunk)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if sendErr := c.send(msgData, buf[:n]); sendErr != nil {
+				return sendErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return c.send(msgEnd, nil)
+		}
+		if err != nil {
+			return c.sendError(err)
+		}
+	}
+}
+
+func (s *Server) create(c *conn, name string) error {
+	local, err := s.resolve(name, false)
+	if err != nil {
+		return c.sendError(err)
+	}
+	f, err := os.Create(local)
+	if err != nil {
+		return c.sendError(err)
+	}
+	if err = c.send(msgOK, nil); err == nil {
+		err = c.flush()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	var writeErr error // the content is received anyway, so that the connection stays in sync
+	err = s.recvStream(c, func(t msgType, payload []byte) error {
+		if t != msgData {
+			return fmt.Errorf("unexpected frame type %d in the file content", t)
+		}
+		if writeErr == nil {
+			_, writeErr = f.Write(payload)
+		}
+		return nil
+	})
+	if closeErr := f.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return s.reply(c, writeErr)
+}
+
+//recvStream receives the frames until msgEnd.
+func (s *Server) recvStream(c *conn, handle func(t msgType, payload []byte) error) error {
+	for {
+		t, payload, err := c.recv()
+		if err != nil {
+			return err
+		}
+		if t == msgEnd {
+			return nil
+		}
+		if err = handle(t, payload); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) signature(c *conn, name string) error {
+	local, err := s.resolve(name, false)
+	if err != nil {
+		return c.sendError(err)
+	}
+	f, err := os.Open(local)
+	if errors.Is(err, fs.ErrNotExist) {
+		// there is nothing to reuse, so all the content is sent
+		if err = c.send(msgOK, new(encoder).uvarint(uint64(delta.BlockSize(0))).b); err != nil {
+			return err
+		}
+		return c.send(msgEnd, nil)
+	}
+	if err != nil {
+		return c.sendError(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return c.sendError(err)
+	}
+	if !info.Mode().IsRegular() {
+		return c.sendError(fmt.Errorf("%q is not a regular file", name))
+	}
+	blockSize := delta.BlockSize(info.Size())
+	sigs, err := delta.Signatures(f, blockSize)
+	if err != nil {
+		return c.sendError(err)
+	}
+
+	if err = c.send(msgOK, new(encoder).uvarint(uint64(blockSize)).b); err != nil {
+		return err
+	}
+	for _, sig := range sigs {
+		if err = c.send(msgBlock, new(encoder).uvarint(uint64(sig.Weak)).bytes(sig.Strong[:]).b); err != nil {
+			return err
+		}
+	}
+	return c.send(msgEnd, nil)
+}
+
+//patch builds the new content in the temporary file from the old file's blocks and the literal data,
+//and then it replaces the old file.
+func (s *Server) patch(c *conn, name string, blockSize int) error {
+	target, opErr := s.resolve(name, false)
+	if opErr != nil {
+		if err := s.drain(c); err != nil {
+			return err
+		}
+		return s.reply(c, opErr)
+	}
+	tmp, opErr, err := s.patchToTemp(c, target, blockSize)
+	if err == nil && opErr == nil {
+		opErr = os.Rename(tmp, target)
+	}
+	if tmp != "" && (err != nil || opErr != nil) {
+		_ = os.Remove(tmp)
+	}
+	if err != nil {
+		return err
+	}
+	return s.reply(c, opErr)
+}
+
+//patchToTemp returns the temporary file with the new content, the error of building it and the transport error.
+func (s *Server) patchToTemp(c *conn, target string, blockSize int) (tmpName string, opErr, err error) {
+	var base io.ReaderAt = emptyReaderAt{} // all the content is literal, if there is no old file
+	mode := fs.FileMode(0o644)
+	if old, err := os.Open(target); err == nil {
+		defer old.Close()
+		base = old
+		if info, err := old.Stat(); err == nil {
+			mode = info.Mode().Perm()
+		}
+	}
+	tmp, opErr := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".dsync-*")
+	if opErr != nil {
+		return "", opErr, s.drain(c)
+	}
+
+	done := false
+	var recvErr error // the transport or protocol error, after which the connection is out of sync
+	ops := func() (delta.Op, bool, error) {
+		t, payload, err := c.recv()
+		switch {
+		case err != nil:
+			recvErr = err
+		case t == msgEnd:
+			done = true
+			return delta.Op{}, false, nil
+		case t == msgData:
+			return delta.Op{Literal: payload}, true, nil
+		case t == msgCopy:
+			d := decoder{b: payload}
+			op := delta.Op{Block: int(d.uvarint())}
+			recvErr = d.err
+			return op, true, recvErr
+		default:
+			recvErr = fmt.Errorf("unexpected frame type %d in the patch", t)
+		}
+		return delta.Op{}, false, recvErr
+	}
+	opErr = delta.Apply(base, blockSize, ops, tmp)
+	if opErr == nil {
+		opErr = tmp.Chmod(mode)
+	}
+	if opErr == nil {
+		opErr = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); opErr == nil {
+		opErr = closeErr
+	}
+	switch {
+	case recvErr != nil:
+		return tmp.Name(), nil, recvErr
+	case !done:
+		return tmp.Name(), opErr, s.drain(c)
+	}
+	return tmp.Name(), opErr, nil
+}
+
+//drain receives the rest of the request stream, so that the connection stays in sync.
+func (s *Server) drain(c *conn) error {
+	return s.recvStream(c, func(msgType, []byte) error { return nil })
+}
+
+type emptyReaderAt struct{}
+
+func (emptyReaderAt) ReadAt([]byte, int64) (int, error) {
+	return 0, io.EOF
+}
+
+//errKindOf classifies the error, the message of the path errors is stripped of the server's local path.
+func errKindOf(err error) errKind {
+	var rErr *remoteError
+	switch {
+	case errors.As(err, &rErr):
+		return rErr.kind
+	case errors.Is(err, fs.ErrNotExist):
+		return kindNotExist
+	case errors.Is(err, syscall.ENOTDIR) || strings.Contains(err.Error(), errNotDir.Error()):
+		return kindNotDir
+	case errors.Is(err, syscall.ENOTEMPTY) || strings.Contains(err.Error(), errNotEmpty.Error()):
+		return kindNotEmpty
+	}
+	return kindOther
+}
@@ -0,0 +1,52 @@
+package netfs
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+//Scheme is the URL scheme of the dirs served by the dsync server.
+const Scheme = "dsync"
+
+//DefaultPort is the port the dsync server listens on by default.
+const DefaultPort = "7707"
+
+//Location is the remote dir addressed as dsync://host[:port]/path, the path is relative to the server's root.
+type Location struct {
+	Addr string // host:port
+	Path string // slash-separated and absolute (i.e. it starts with the server's root "/")
+}
+
+//IsURL reports whether s looks like a dsync URL (and so it isn't a local path).
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, Scheme+"://")
+}
+
+//ParseURL parses the dsync URL of the remote dir.
+func ParseURL(raw string) (Location, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Location{}, fmt.Errorf("invalid dsync URL: %w", err)
+	}
+	if u.Scheme != Scheme {
+		return Location{}, fmt.Errorf("invalid dsync URL %q: the scheme must be %s", raw, Scheme)
+	}
+	if u.Hostname() == "" {
+		return Location{}, fmt.Errorf("invalid dsync URL %q: no host", raw)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return Location{}, fmt.Errorf("invalid dsync URL %q: user info, query and fragment are not supported", raw)
+	}
+	loc := Location{Addr: u.Host, Path: path.Clean("/" + u.Path)}
+	if u.Port() == "" {
+		loc.Addr = net.JoinHostPort(u.Hostname(), DefaultPort)
+	}
+	return loc, nil
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s://%s%s", Scheme, l.Addr, l.Path)
+}
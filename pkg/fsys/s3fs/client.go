@@ -0,0 +1,339 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//metaModTime is the user metadata of the object, which keeps the source file's modification time.
+const metaModTime = "X-Amz-Meta-Mtime"
+
+//apiError is the error response of the storage.
+type apiError struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *apiError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 request failed with status %d", e.Status)
+	}
+	return fmt.Sprintf("S3 request failed with status %d: %s: %s", e.Status, e.Code, e.Message)
+}
+
+func (e *apiError) Unwrap() error {
+	if e.Status == http.StatusNotFound && e.Code != "NoSuchBucket" {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+//object is the object's info returned by the listing or HEAD request.
+type object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+	modTime      time.Time // the time from the metadata (zero, if it's absent or not requested)
+}
+
+//client makes the S3 API requests addressing the bucket in the path style (which the S3-compatible storages support).
+type client struct {
+	endpoint *url.URL
+	bucket   string
+	signer   signer
+	http     *http.Client
+}
+
+type request struct {
+	method  string
+	key     string
+	query   url.Values
+	header  http.Header
+	body    []byte
+	okCodes []int // the expected status codes (200 by default)
+}
+
+//do sends the signed request. The response body must be closed by the caller on success.
+func (c *client) do(ctx context.Context, r request) (*http.Response, error) {
+	u := *c.endpoint
+	p := strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if r.key != "" {
+		p += "/" + r.key
+	}
+	u.Path, u.RawPath = p, uriEncode(p, false)
+	u.RawQuery = r.query.Encode()
+	req, err := http.NewRequestWithContext(ctx, r.method, u.String(), bytes.NewReader(r.body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = strings.ReplaceAll(req.URL.RawQuery, "+", "%20")
+	for name, vals := range r.header {
+		req.Header[name] = vals
+	}
+	req.ContentLength = int64(len(r.body))
+	if len(r.body) == 0 {
+		req.Body = http.NoBody
+	}
+	payloadHash := emptyPayloadHash
+	if len(r.body) > 0 {
+		payloadHash = hashHex(r.body)
+	}
+	c.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	okCodes := r.okCodes
+	if okCodes == nil {
+		okCodes = []int{http.StatusOK}
+	}
+	for _, code := range okCodes {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	apiErr := &apiError{Status: resp.StatusCode}
+	if r.method != http.MethodHead {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		_ = xml.Unmarshal(body, apiErr)
+	}
+	return nil, apiErr
+}
+
+//doXML sends the request and decodes the XML response into out (if it isn't nil).
+func (c *client) doXML(ctx context.Context, r request, out interface{}) error {
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// CopyObject and CompleteMultipartUpload may report an error with the 200 status
+	if bytes.Contains(body[:minInt(len(body), 256)], []byte("<Error>")) {
+		apiErr := &apiError{Status: resp.StatusCode}
+		_ = xml.Unmarshal(body, apiErr)
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(body, out)
+}
+
+type listResult struct {
+	Contents              []object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+//list lists all objects with the prefix (recursively, i.e. without the delimiter). If maxKeys is positive,
+//then only the first page of at most maxKeys objects is listed.
+func (c *client) list(ctx context.Context, prefix string, maxKeys int) ([]object, error) {
+	var objects []object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		if maxKeys > 0 {
+			query.Set("max-keys", strconv.Itoa(maxKeys))
+		}
+		var res listResult
+		if err := c.doXML(ctx, request{method: http.MethodGet, query: query}, &res); err != nil {
+			return nil, err
+		}
+		objects = append(objects, res.Contents...)
+		if !res.IsTruncated || maxKeys > 0 {
+			return objects, nil
+		}
+		if res.NextContinuationToken == "" {
+			return nil, errors.New("truncated S3 listing without the continuation token")
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+func (c *client) head(ctx context.Context, key string) (object, error) {
+	resp, err := c.do(ctx, request{method: http.MethodHead, key: key})
+	if err != nil {
+		return object{}, err
+	}
+	defer resp.Body.Close()
+	obj := object{Key: key, Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	obj.LastModified = lastModified.Local()
+	obj.modTime = parseModTime(resp.Header.Get(metaModTime))
+	return obj, nil
+}
+
+func (c *client) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, key: key})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+//put uploads the whole object and returns its ETag.
+func (c *client) put(ctx context.Context, key string, body []byte, header http.Header) (string, error) {
+	resp, err := c.do(ctx, request{method: http.MethodPut, key: key, header: header, body: body})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+type copyResult struct {
+	ETag string `xml:"ETag"`
+}
+
+//copyObject copies the object within the bucket and replaces its metadata with the header's one.
+func (c *client) copyObject(ctx context.Context, srcKey, dstKey string, header http.Header) (string, error) {
+	h := header.Clone()
+	h.Set("X-Amz-Copy-Source", uriEncode("/"+c.bucket+"/"+srcKey, false))
+	h.Set("X-Amz-Metadata-Directive", "REPLACE")
+	var res copyResult
+	err := c.doXML(ctx, request{method: http.MethodPut, key: dstKey, header: h}, &res)
+	return res.ETag, err
+}
+
+type deleteRequest struct {
+	XMLName xml.Name       `xml:"Delete"`
+	Quiet   bool           `xml:"Quiet"`
+	Objects []deleteObject `xml:"Object"`
+}
+
+type deleteObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteResult struct {
+	Errors []struct {
+		Key     string `xml:"Key"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+//deleteObjects deletes the objects (at most maxDeleteKeys) by one request. It returns the errors of the
+//particular keys, which failed to be deleted. Missing keys are not an error.
+func (c *client) deleteObjects(ctx context.Context, keys []string) (map[string]error, error) {
+	dr := deleteRequest{Quiet: true, Objects: make([]deleteObject, len(keys))}
+	for i, key := range keys {
+		dr.Objects[i].Key = key
+	}
+	body, err := xml.Marshal(dr)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(body)
+	header := http.Header{
+		"Content-Type": {"application/xml"},
+		"Content-Md5":  {base64.StdEncoding.EncodeToString(sum[:])},
+	}
+	var res deleteResult
+	err = c.doXML(ctx, request{method: http.MethodPost, query: url.Values{"delete": {""}}, header: header, body: body},
+		&res)
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]error, len(res.Errors))
+	for _, e := range res.Errors {
+		failed[e.Key] = &apiError{Status: http.StatusOK, Code: e.Code, Message: e.Message}
+	}
+	return failed, nil
+}
+
+type initiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeRequest struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []completePart `xml:"Part"`
+}
+
+type completePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *client) initiateUpload(ctx context.Context, key string, header http.Header) (string, error) {
+	var res initiateResult
+	err := c.doXML(ctx, request{method: http.MethodPost, key: key, query: url.Values{"uploads": {""}}, header: header},
+		&res)
+	return res.UploadID, err
+}
+
+func (c *client) uploadPart(ctx context.Context, key, uploadID string, number int, body []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	resp, err := c.do(ctx, request{method: http.MethodPut, key: key, query: query, body: body})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c *client) completeUpload(ctx context.Context, key, uploadID string, parts []completePart) (string, error) {
+	body, err := xml.Marshal(completeRequest{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	var res copyResult // the result has the same ETag element
+	err = c.doXML(ctx, request{method: http.MethodPost, key: key, query: url.Values{"uploadId": {uploadID}},
+		header: http.Header{"Content-Type": {"application/xml"}}, body: body}, &res)
+	return res.ETag, err
+}
+
+func (c *client) abortUpload(ctx context.Context, key, uploadID string) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, key: key, query: url.Values{"uploadId": {uploadID}},
+		okCodes: []int{http.StatusNoContent, http.StatusOK}})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+//modTimeHeader returns the metadata header with the modification time.
+func modTimeHeader(modTime time.Time) http.Header {
+	return http.Header{metaModTime: {modTime.UTC().Format(time.RFC3339Nano)}}
+}
+
+//parseModTime parses the modification time from the metadata. It's in the local time zone like the ones of the
+//local files, so they are comparable as is.
+func parseModTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Local()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,640 @@
+package s3fs
+
+import (
+	"context"
+	"dsync/pkg/fsys"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultPartSize is the size of the multipart upload parts, the smaller files are uploaded by one request.
+	DefaultPartSize = 8 << 20
+	//DefaultRegion is used, if the region is not set.
+	DefaultRegion = "us-east-1"
+	//maxDeleteKeys is the limit of the keys deleted by one request.
+	maxDeleteKeys = 1000
+	//deleteBatchDelay is how long the deletion waits for other ones to be batched with it.
+	deleteBatchDelay = 20 * time.Millisecond
+	dirMode          = fs.ModeDir | 0o755
+	fileMode         = 0o644
+)
+
+//errDirNotEmpty is matched by the error text by the callers (like the one of os.Remove).
+var errDirNotEmpty = errors.New("directory not empty")
+
+//Config is the storage access config.
+type Config struct {
+	Endpoint    string // the storage URL, by default it's the AWS S3 one of the region
+	Region      string
+	Credentials Credentials
+	PartSize    int // the size of the multipart upload parts (DefaultPartSize, if it's not positive)
+	Concurrency int // the limit of the concurrent requests made by one operation (e.g. the listing), 1 by default
+}
+
+//FS is the file system of the bucket prefix. Objects map to the files by their keys, and dirs are implied by
+//the keys' prefixes (empty dirs are kept as zero-size "dir/" marker objects). The modification times are kept
+//in the objects' metadata, so the files are created along with them, and the permissions are not kept at all.
+type FS struct {
+	loc         Location
+	api         *client
+	partSize    int
+	concurrency int
+
+	mu    sync.Mutex
+	meta  map[string]cachedMeta // the known modification times of the objects by their keys
+	dirs  map[string]bool       // the keys of the dirs known to exist (from the last listing or made since then)
+	batch *deleteBatch          // the deletion batch, which is being collected
+}
+
+//cachedMeta is the object's metadata, which stays valid, while the object's ETag is the same.
+//It saves HEAD requests, as the listing doesn't return the user metadata.
+type cachedMeta struct {
+	etag    string
+	modTime time.Time
+}
+
+type deleteBatch struct {
+	keys   []string
+	done   chan struct{}
+	failed map[string]error
+	err    error
+}
+
+//New returns the file system of the bucket prefix. It doesn't make any request until the first operation.
+func New(loc Location, cfg Config) (*FS, error) {
+	if cfg.Region == "" {
+		cfg.Region = DefaultRegion
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Credentials.AccessKey == "" || cfg.Credentials.SecretKey == "" {
+		return nil, errors.New("S3 access key and secret key must be set")
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = DefaultPartSize
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.Concurrency
+	return &FS{
+		loc: loc,
+		api: &client{
+			endpoint: endpoint,
+			bucket:   loc.Bucket,
+			signer:   signer{creds: cfg.Credentials, region: cfg.Region, service: "s3"},
+			http:     &http.Client{Transport: transport},
+		},
+		partSize:    cfg.PartSize,
+		concurrency: cfg.Concurrency,
+		meta:        make(map[string]cachedMeta),
+		dirs:        make(map[string]bool),
+	}, nil
+}
+
+//Close closes the idle connections. The file system may still be used.
+func (f *FS) Close() error {
+	f.api.http.CloseIdleConnections()
+	return nil
+}
+
+//key returns the object key of the path.
+func key(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+//dirPrefix returns the prefix of the keys of the dir's content.
+func dirPrefix(dirKey string) string {
+	if dirKey == "" {
+		return ""
+	}
+	return dirKey + "/"
+}
+
+//isRoot reports whether the key is the bucket or the location prefix itself, which are always treated as existing.
+func (f *FS) isRoot(k string) bool {
+	return k == "" || k == f.loc.Prefix
+}
+
+//node is the entry of the file tree built from the listing.
+type node struct {
+	info     fileInfo
+	children map[string]*node
+}
+
+func (n *node) child(name string) *node {
+	c, ok := n.children[name]
+	if !ok {
+		c = &node{info: fileInfo{name: name, mode: dirMode}, children: make(map[string]*node)}
+		n.children[name] = c
+	}
+	return c
+}
+
+//Walk lists the objects under root and walks the implied file tree in the lexical order like filepath.WalkDir does.
+func (f *FS) Walk(root string, fn fs.WalkDirFunc) error {
+	rootKey := key(root)
+	tree, err := f.listTree(rootKey)
+	if err == nil && tree == nil {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		err = fn(root, nil, wrapErr("lstat", root, err))
+	} else {
+		tree.info.name = path.Base(root)
+		err = walkNode(root, tree, fn)
+	}
+	if errors.Is(err, fs.SkipDir) {
+		return nil
+	}
+	return err
+}
+
+//listTree lists the objects under the dir and builds the file tree. It returns nil, if the dir doesn't exist.
+func (f *FS) listTree(dirKey string) (*node, error) {
+	prefix := dirPrefix(dirKey)
+	objects, err := f.api.list(context.Background(), prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 && !f.isRoot(dirKey) {
+		return nil, nil
+	}
+	if err = f.fillModTimes(objects); err != nil {
+		return nil, err
+	}
+
+	tree := &node{info: fileInfo{mode: dirMode}, children: make(map[string]*node)}
+	dirs := map[string]bool{dirKey: true}
+	for _, obj := range objects {
+		if obj.Key == "" {
+			continue // it's gone since the listing
+		}
+		rel := strings.TrimPrefix(obj.Key, prefix)
+		isMarker := strings.HasSuffix(rel, "/")
+		parts := strings.Split(strings.TrimSuffix(rel, "/"), "/")
+		n := tree
+		for i, part := range parts {
+			if part == "" {
+				break // the marker of the dir itself or a malformed key
+			}
+			if i < len(parts)-1 || isMarker {
+				n = n.child(part)
+				dirs[prefix+strings.Join(parts[:i+1], "/")] = true
+				continue
+			}
+			modTime := obj.modTime
+			if modTime.IsZero() {
+				modTime = obj.LastModified.Local() // the object is not uploaded by dsync
+			}
+			if existing, ok := n.children[part]; !ok || !existing.info.IsDir() {
+				n.children[part] = &node{info: fileInfo{name: part, size: obj.Size, mode: fileMode, modTime: modTime}}
+			}
+		}
+	}
+
+	f.mu.Lock()
+	if f.isRoot(dirKey) {
+		f.dirs = dirs
+	} else {
+		for d := range dirs {
+			f.dirs[d] = true
+		}
+	}
+	f.mu.Unlock()
+	return tree, nil
+}
+
+//fillModTimes sets the modification times of the listed objects from the cache or, if the object has changed,
+//from its metadata (by HEAD requests made concurrently). The objects, which are gone since the listing,
+//get the empty key.
+func (f *FS) fillModTimes(objects []object) error {
+	var missing []*object
+	f.mu.Lock()
+	for i := range objects {
+		obj := &objects[i]
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		if m, ok := f.meta[obj.Key]; ok && m.etag == normETag(obj.ETag) {
+			obj.modTime = m.modTime
+		} else {
+			missing = append(missing, obj)
+		}
+	}
+	f.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, f.concurrency)
+	for _, obj := range missing {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(obj *object) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			head, err := f.api.head(context.Background(), obj.Key)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				obj.Key = ""
+			case err != nil:
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			default:
+				obj.Size, obj.ETag, obj.modTime = head.Size, head.ETag, head.modTime
+				f.remember(obj.Key, head.ETag, head.modTime)
+			}
+		}(obj)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func walkNode(name string, n *node, fn fs.WalkDirFunc) error {
+	d := fs.FileInfoToDirEntry(n.info)
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if errors.Is(err, fs.SkipDir) && d.IsDir() {
+			err = nil // the dir is skipped, but its siblings are not
+		}
+		return err
+	}
+	names := make([]string, 0, len(n.children))
+	for childName := range n.children {
+		names = append(names, childName)
+	}
+	sort.Strings(names)
+	for _, childName := range names {
+		if err := walkNode(path.Join(name, childName), n.children[childName], fn); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				break // the rest of the dir is skipped
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	k := key(name)
+	if f.isRoot(k) {
+		return fileInfo{name: path.Base(name), mode: dirMode}, nil
+	}
+	obj, err := f.api.head(context.Background(), k)
+	if err == nil {
+		f.remember(k, obj.ETag, obj.modTime)
+		modTime := obj.modTime
+		if modTime.IsZero() {
+			modTime = obj.LastModified
+		}
+		return fileInfo{name: path.Base(name), size: obj.Size, mode: fileMode, modTime: modTime}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, wrapErr("stat", name, err)
+	}
+	isDir, err := f.isDir(k)
+	if err != nil {
+		return nil, wrapErr("stat", name, err)
+	}
+	if !isDir {
+		return nil, wrapErr("stat", name, fs.ErrNotExist)
+	}
+	return fileInfo{name: path.Base(name), mode: dirMode}, nil
+}
+
+//isDir reports whether there are any objects under the dir key.
+func (f *FS) isDir(k string) (bool, error) {
+	objects, err := f.api.list(context.Background(), dirPrefix(k), 1)
+	return len(objects) > 0, err
+}
+
+func (f *FS) Open(name string) (io.ReadCloser, error) {
+	r, err := f.api.get(context.Background(), key(name))
+	if err != nil {
+		return nil, wrapErr("open", name, err)
+	}
+	return r, nil
+}
+
+//Create creates the object without the modification time (it's set by Chtimes, which copies the object).
+func (f *FS) Create(name string) (io.WriteCloser, error) {
+	return f.newWriter(key(name), time.Time{}), nil
+}
+
+//CreateWithModTime creates the object along with its modification time, so it doesn't need to be copied later.
+func (f *FS) CreateWithModTime(name string, modTime time.Time) (io.WriteCloser, error) {
+	return f.newWriter(key(name), modTime), nil
+}
+
+//Rename copies the object to the new key and deletes the old one (dirs cannot be renamed).
+func (f *FS) Rename(oldName, newName string) error {
+	oldKey := key(oldName)
+	obj, err := f.api.head(context.Background(), oldKey)
+	if err != nil {
+		return wrapErr("rename", oldName, err)
+	}
+	etag, err := f.api.copyObject(context.Background(), oldKey, key(newName), modTimeHeader(obj.modTime))
+	if err != nil {
+		return wrapErr("rename", oldName, err)
+	}
+	f.remember(key(newName), etag, obj.modTime)
+	return wrapErr("rename", oldName, f.deleteKey(oldKey))
+}
+
+//Remove removes the object or the empty dir (i.e. its marker object, if any). The deletions are batched,
+//so the call may wait for other ones a bit.
+func (f *FS) Remove(name string) error {
+	k := key(name)
+	f.mu.Lock()
+	_, isFile := f.meta[k]
+	f.mu.Unlock()
+	if !isFile && k != "" {
+		objects, err := f.api.list(context.Background(), dirPrefix(k), 2)
+		if err != nil {
+			return wrapErr("remove", name, err)
+		}
+		for _, obj := range objects {
+			if obj.Key != dirPrefix(k) {
+				return wrapErr("remove", name, errDirNotEmpty)
+			}
+		}
+		if len(objects) > 0 {
+			f.mu.Lock()
+			delete(f.dirs, k)
+			f.mu.Unlock()
+			return wrapErr("remove", name, f.deleteKey(dirPrefix(k)))
+		}
+	}
+	return wrapErr("remove", name, f.deleteKey(k))
+}
+
+//deleteKey adds the key to the current deletion batch and waits until the batch is deleted.
+func (f *FS) deleteKey(k string) error {
+	f.mu.Lock()
+	delete(f.meta, k)
+	b := f.batch
+	if b == nil {
+		b = &deleteBatch{done: make(chan struct{})}
+		f.batch = b
+		time.AfterFunc(deleteBatchDelay, func() { f.flushDeletes(b) })
+	}
+	b.keys = append(b.keys, k)
+	full := len(b.keys) == maxDeleteKeys
+	if full {
+		f.batch = nil // the timer finds that it's already detached
+	}
+	f.mu.Unlock()
+
+	if full {
+		f.sendDeletes(b)
+	}
+	<-b.done
+	if b.err != nil {
+		return b.err
+	}
+	return b.failed[k]
+}
+
+func (f *FS) flushDeletes(b *deleteBatch) {
+	f.mu.Lock()
+	if f.batch != b {
+		f.mu.Unlock()
+		return // the full batch is sent by the deletion, which has filled it
+	}
+	f.batch = nil
+	f.mu.Unlock()
+	f.sendDeletes(b)
+}
+
+func (f *FS) sendDeletes(b *deleteBatch) {
+	b.failed, b.err = f.api.deleteObjects(context.Background(), b.keys)
+	close(b.done)
+}
+
+//Chtimes sets the modification time of the object by copying it to itself with the new metadata.
+//It does nothing for dirs.
+func (f *FS) Chtimes(name string, _, mtime time.Time) error {
+	k := key(name)
+	etag, err := f.api.copyObject(context.Background(), k, k, modTimeHeader(mtime))
+	if errors.Is(err, fs.ErrNotExist) {
+		if isDir, dErr := f.isDir(k); dErr == nil && (isDir || f.isRoot(k)) {
+			return nil
+		}
+	}
+	if err != nil {
+		return wrapErr("chtimes", name, err)
+	}
+	f.remember(k, etag, mtime)
+	return nil
+}
+
+//Chmod does nothing, as the permissions are not kept.
+func (f *FS) Chmod(string, fs.FileMode) error {
+	return nil
+}
+
+//MkdirAll makes the marker object of the dir, unless the dir is known to exist.
+func (f *FS) MkdirAll(name string) error {
+	k := key(name)
+	f.mu.Lock()
+	known := f.isRoot(k) || f.dirs[k]
+	f.mu.Unlock()
+	if known {
+		return nil
+	}
+	if _, err := f.api.put(context.Background(), dirPrefix(k), nil, nil); err != nil {
+		return wrapErr("mkdir", name, err)
+	}
+	f.mu.Lock()
+	for d := k; d != "." && d != ""; d = path.Dir(d) {
+		f.dirs[d] = true
+	}
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *FS) Join(root, p string) string {
+	return fsys.JoinSlash(root, p)
+}
+
+func (f *FS) Rel(root, name string) (string, error) {
+	return fsys.RelSlash(root, name)
+}
+
+//remember caches the object's modification time along with its ETag.
+func (f *FS) remember(k, etag string, modTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.meta[k] = cachedMeta{etag: normETag(etag), modTime: modTime}
+	for d := path.Dir(k); d != "." && d != ""; d = path.Dir(d) {
+		f.dirs[d] = true
+	}
+}
+
+func normETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+//writer buffers the written content and uploads it by one request on Close or, if it exceeds the part size,
+//by the multipart upload part by part (so at most one part is kept in memory).
+type writer struct {
+	f        *FS
+	key      string
+	modTime  time.Time
+	buf      []byte
+	uploadID string
+	parts    []completePart
+	closed   bool
+	err      error
+}
+
+func (f *FS) newWriter(k string, modTime time.Time) *writer {
+	return &writer{f: f, key: k, modTime: modTime}
+}
+
+func (w *writer) header() http.Header {
+	if w.modTime.IsZero() {
+		return nil
+	}
+	return modTimeHeader(w.modTime)
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to the closed object")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := w.f.partSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf, p = append(w.buf, p[:n]...), p[n:]
+		written += n
+		if len(w.buf) == w.f.partSize {
+			if err := w.uploadPart(); err != nil {
+				w.fail(err)
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) uploadPart() error {
+	ctx := context.Background()
+	if w.uploadID == "" {
+		id, err := w.f.api.initiateUpload(ctx, w.key, w.header())
+		if err != nil {
+			return err
+		}
+		w.uploadID = id
+	}
+	number := len(w.parts) + 1
+	etag, err := w.f.api.uploadPart(ctx, w.key, w.uploadID, number, w.buf)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, completePart{PartNumber: number, ETag: etag})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+//fail aborts the multipart upload (if it's started), so the storage doesn't keep the uploaded parts.
+func (w *writer) fail(err error) {
+	w.err = err
+	if w.uploadID != "" {
+		_ = w.f.api.abortUpload(context.Background(), w.key, w.uploadID)
+		w.uploadID = ""
+	}
+}
+
+//Abort discards the written content, the object is not created (or replaced). The following Close does nothing.
+func (w *writer) Abort() error {
+	if !w.closed {
+		w.closed = true
+		w.fail(nil)
+	}
+	return nil
+}
+
+//Close uploads the rest of the content, so the object is created only on the successful Close.
+func (w *writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	var etag string
+	var err error
+	if w.uploadID == "" {
+		etag, err = w.f.api.put(context.Background(), w.key, w.buf, w.header())
+	} else {
+		if len(w.buf) > 0 {
+			err = w.uploadPart()
+		}
+		if err == nil {
+			etag, err = w.f.api.completeUpload(context.Background(), w.key, w.uploadID, w.parts)
+		}
+	}
+	if err != nil {
+		w.fail(err)
+		return wrapErr("write", "/"+w.key, err)
+	}
+	w.buf = nil
+	w.f.remember(w.key, etag, w.modTime)
+	return nil
+}
+
+//fileInfo implements fs.FileInfo for the objects and the implied dirs.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+//wrapErr adds the operation and path to the error like the os package does.
+func wrapErr(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pErr *fs.PathError
+	if errors.As(err, &pErr) {
+		return err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
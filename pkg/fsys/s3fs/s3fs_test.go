@@ -0,0 +1,252 @@
+package s3fs
+
+import (
+	"bytes"
+	"dsync/pkg/fsys"
+	"dsync/pkg/fsys/s3fs/s3test"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    Location
+		wantErr string
+	}{
+		{raw: "s3://bucket/data/copy/", want: Location{Bucket: "bucket", Prefix: "data/copy"}},
+		{raw: "s3://bucket", want: Location{Bucket: "bucket", Prefix: ""}},
+		{raw: "s3://bucket/../x", want: Location{Bucket: "bucket", Prefix: "x"}},
+		{raw: "sftp://host/x", wantErr: "the scheme must be s3"},
+		{raw: "s3:///x", wantErr: "no bucket"},
+		{raw: "s3://bucket:80/x", wantErr: "user info, port, query and fragment are not supported"},
+	}
+	for _, tt := range tests {
+		got, err := ParseURL(tt.raw)
+		if tt.wantErr != "" {
+			require.ErrorContains(t, err, tt.wantErr, tt.raw)
+			continue
+		}
+		require.NoError(t, err, tt.raw)
+		require.Equal(t, tt.want, got, tt.raw)
+		require.True(t, IsURL(tt.raw))
+	}
+}
+
+//TestSigner checks the signature against the example of the AWS Signature Version 4 documentation.
+func TestSigner(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	s := signer{
+		creds:   Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"},
+		region:  "us-east-1",
+		service: "iam",
+	}
+	s.sign(req, emptyPayloadHash, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	require.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7", req.Header.Get("Authorization"))
+}
+
+func newTestFS(t *testing.T, server *s3test.Server, prefix string, partSize int) *FS {
+	f, err := New(Location{Bucket: "bucket", Prefix: prefix}, Config{
+		Endpoint:    server.URL,
+		Credentials: Credentials{AccessKey: s3test.AccessKey, SecretKey: s3test.SecretKey},
+		PartSize:    partSize,
+		Concurrency: 4,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func writeObject(req *require.Assertions, f *FS, name, content string, modTime time.Time) {
+	w, err := f.CreateWithModTime(name, modTime)
+	req.NoError(err)
+	_, err = io.Copy(w, strings.NewReader(content))
+	req.NoError(err)
+	req.NoError(w.Close())
+}
+
+func TestFS(t *testing.T) {
+	requires := require.New(t)
+	server := s3test.NewServer()
+	defer server.Close()
+	f := newTestFS(t, server, "copy", 0)
+	var _ fsys.FS = f
+	root := "/copy"
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 891, time.UTC)
+
+	// the prefix is the existing empty dir
+	info, err := f.Stat(root)
+	requires.NoError(err)
+	requires.True(info.IsDir())
+
+	// MkdirAll, CreateWithModTime, Stat
+	requires.NoError(f.MkdirAll(f.Join(root, "empty")))
+	writeObject(requires, f, f.Join(root, "a/b/file.txt"), "content", modTime)
+	info, err = f.Stat(f.Join(root, "a/b/file.txt"))
+	requires.NoError(err)
+	requires.Equal("file.txt", info.Name())
+	requires.Equal(int64(len("content")), info.Size())
+	requires.True(info.ModTime().Equal(modTime)) // the full precision is kept
+	info, err = f.Stat(f.Join(root, "a"))
+	requires.NoError(err)
+	requires.True(info.IsDir())
+	_, err = f.Stat(f.Join(root, "missing"))
+	requires.ErrorIs(err, fs.ErrNotExist)
+	_, meta, ok := server.Object("bucket", "copy/a/b/file.txt")
+	requires.True(ok)
+	requires.Equal(modTime.Format(time.RFC3339Nano), meta.Get("X-Amz-Meta-Mtime"))
+
+	// Create without the modification time and Chtimes
+	w, err := f.Create(f.Join(root, "a/c.txt"))
+	requires.NoError(err)
+	requires.NoError(w.Close())
+	requires.NoError(f.Chtimes(f.Join(root, "a/c.txt"), time.Now(), modTime))
+	requires.NoError(f.Chtimes(f.Join(root, "a"), time.Now(), modTime)) // it does nothing for dirs
+	info, err = f.Stat(f.Join(root, "a/c.txt"))
+	requires.NoError(err)
+	requires.True(info.ModTime().Equal(modTime))
+	requires.ErrorIs(f.Chtimes(f.Join(root, "missing"), time.Now(), modTime), fs.ErrNotExist)
+
+	// the object uploaded by another client has the upload time as the modification time
+	server.PutObject("bucket", "copy/foreign.txt", []byte("foreign"))
+
+	// Walk with SkipDir
+	var walked []string
+	requires.NoError(f.Walk(root, func(name string, d fs.DirEntry, err error) error {
+		requires.NoError(err)
+		rel, err := f.Rel(root, name)
+		requires.NoError(err)
+		info, err := d.Info()
+		requires.NoError(err)
+		walked = append(walked, fmt.Sprintf("%s %v %d", rel, d.IsDir(), info.Size()))
+		if rel == "foreign.txt" {
+			requires.WithinDuration(time.Now(), info.ModTime(), time.Minute)
+		}
+		if d.IsDir() && d.Name() == "b" {
+			return fs.SkipDir
+		}
+		return nil
+	}))
+	requires.Equal([]string{". true 0", "a true 0", "a/b true 0", "a/c.txt false 0", "empty true 0",
+		"foreign.txt false 7"}, walked)
+	err = f.Walk(f.Join(root, "missing"), func(name string, d fs.DirEntry, err error) error { return err })
+	requires.ErrorIs(err, fs.ErrNotExist)
+
+	// Open
+	r, err := f.Open(f.Join(root, "a/b/file.txt"))
+	requires.NoError(err)
+	content, err := io.ReadAll(r)
+	requires.NoError(err)
+	requires.NoError(r.Close())
+	requires.Equal("content", string(content))
+	_, err = f.Open(f.Join(root, "missing"))
+	requires.ErrorIs(err, fs.ErrNotExist)
+
+	// Rename keeps the modification time, Remove of the non-empty dir fails
+	requires.NoError(f.Rename(f.Join(root, "a/b/file.txt"), f.Join(root, "a/d.txt")))
+	info, err = f.Stat(f.Join(root, "a/d.txt"))
+	requires.NoError(err)
+	requires.True(info.ModTime().Equal(modTime))
+	err = f.Remove(f.Join(root, "a"))
+	var pErr *fs.PathError
+	requires.ErrorAs(err, &pErr)
+	requires.ErrorContains(pErr.Err, "directory not empty")
+	requires.NoError(f.Remove(f.Join(root, "a/c.txt")))
+	requires.NoError(f.Remove(f.Join(root, "a/d.txt")))
+	requires.NoError(f.Remove(f.Join(root, "empty")))
+	requires.NoError(f.Remove(f.Join(root, "foreign.txt")))
+	requires.Empty(server.Keys("bucket"))
+}
+
+func TestFS_Multipart(t *testing.T) {
+	requires := require.New(t)
+	server := s3test.NewServer()
+	defer server.Close()
+	const partSize = 1 << 10
+	f := newTestFS(t, server, "", partSize)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), partSize*7/32) // 3.5 parts
+	writeObject(requires, f, "/big.bin", string(content), time.Now())
+	got, meta, ok := server.Object("bucket", "big.bin")
+	requires.True(ok)
+	requires.True(bytes.Equal(content, got))
+	requires.NotEmpty(meta.Get("X-Amz-Meta-Mtime"))
+	requires.Equal(1, server.Count("CreateMultipartUpload"))
+	requires.Equal(4, server.Count("UploadPart"))
+	requires.Equal(1, server.Count("CompleteMultipartUpload"))
+	requires.Equal(0, server.Count("PutObject"))
+
+	// the small file is uploaded by one request
+	writeObject(requires, f, "/small.txt", "small", time.Now())
+	requires.Equal(1, server.Count("PutObject"))
+
+	// the aborted upload leaves neither the object nor the parts
+	w, err := f.CreateWithModTime("/aborted.bin", time.Now())
+	requires.NoError(err)
+	_, err = w.Write(content)
+	requires.NoError(err)
+	requires.NoError(w.(interface{ Abort() error }).Abort())
+	requires.NoError(w.Close())
+	requires.Equal(0, server.PendingUploads())
+	requires.Equal([]string{"big.bin", "small.txt"}, server.Keys("bucket"))
+}
+
+func TestFS_BatchedDeletesAndCachedModTimes(t *testing.T) {
+	requires := require.New(t)
+	server := s3test.NewServer()
+	defer server.Close()
+	f := newTestFS(t, server, "copy", 0)
+
+	const filesCount = 40
+	for i := 0; i < filesCount; i++ {
+		server.PutObject("bucket", fmt.Sprintf("copy/dir/file%02d", i), []byte("x"))
+	}
+
+	// the first listing reads the metadata of each object, and the next one takes it from the cache
+	walk := func() (count int) {
+		requires.NoError(f.Walk("/copy", func(string, fs.DirEntry, error) error {
+			count++
+			return nil
+		}))
+		return count
+	}
+	requires.Equal(filesCount+2, walk())
+	requires.Equal(filesCount, server.Count("HeadObject"))
+	server.ResetCounts()
+	requires.Equal(filesCount+2, walk())
+	requires.Equal(0, server.Count("HeadObject"))
+
+	// the concurrent deletions are batched
+	server.ResetCounts()
+	var wg sync.WaitGroup
+	paths := make(chan string, filesCount)
+	for i := 0; i < filesCount; i++ {
+		paths <- fmt.Sprintf("/copy/dir/file%02d", i)
+	}
+	close(paths)
+	for worker := 0; worker < 10; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				requires.NoError(f.Remove(p))
+			}
+		}()
+	}
+	wg.Wait()
+	requires.Empty(server.Keys("bucket"))
+	requires.Less(server.Count("DeleteObjects"), filesCount/4)
+	requires.Equal(0, server.Count("ListObjectsV2")) // the files are known from the listing
+}
@@ -0,0 +1,389 @@
+//Package s3test provides the in-process fake of the S3-compatible storage for tests. It keeps the objects in memory
+//and supports the subset of the API used by s3fs (with the path-style addressing).
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//AccessKey and SecretKey are the credentials accepted by the server.
+	AccessKey = "test-access-key"
+	SecretKey = "test-secret-key"
+)
+
+type object struct {
+	data     []byte
+	meta     http.Header // X-Amz-Meta-* headers
+	etag     string
+	modified time.Time
+}
+
+type upload struct {
+	bucket, key string
+	meta        http.Header
+	parts       map[int][]byte
+}
+
+//Server is the fake storage. Buckets are created on the first use.
+type Server struct {
+	URL string
+
+	srv     *httptest.Server
+	mu      sync.Mutex
+	buckets map[string]map[string]*object
+	uploads map[string]*upload
+	counts  map[string]int // the requests count by the API operation name
+	nextID  int
+}
+
+//NewServer starts the fake storage on a random local port.
+func NewServer() *Server {
+	s := &Server{
+		buckets: make(map[string]map[string]*object),
+		uploads: make(map[string]*upload),
+		counts:  make(map[string]int),
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+//Count returns the number of requests of the API operation (e.g. "PutObject" or "DeleteObjects").
+func (s *Server) Count(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[op]
+}
+
+//ResetCounts zeroes the requests counters.
+func (s *Server) ResetCounts() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts = make(map[string]int)
+}
+
+//Object returns the object's content and the user metadata.
+func (s *Server) Object(bucket, key string) ([]byte, http.Header, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.bucket(bucket)[key]
+	if !ok {
+		return nil, nil, false
+	}
+	return obj.data, obj.meta, true
+}
+
+//PutObject stores the object without any metadata (as if it's uploaded by another client).
+func (s *Server) PutObject(bucket, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(bucket, key, data, http.Header{}, md5Hex(data))
+}
+
+//Keys returns all keys of the bucket in the lexical order.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedKeys(bucket, "")
+}
+
+//PendingUploads returns the number of the multipart uploads, which are neither completed nor aborted.
+func (s *Server) PendingUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func (s *Server) bucket(name string) map[string]*object {
+	b, ok := s.buckets[name]
+	if !ok {
+		b = make(map[string]*object)
+		s.buckets[name] = b
+	}
+	return b
+}
+
+func (s *Server) store(bucket, key string, data []byte, meta http.Header, etag string) *object {
+	obj := &object{data: data, meta: meta, etag: etag, modified: time.Now().UTC()}
+	s.bucket(bucket)[key] = obj
+	return obj
+}
+
+func (s *Server) sortedKeys(bucket, prefix string) []string {
+	var keys []string
+	for key := range s.bucket(bucket) {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Authorization"), "Credential="+AccessKey+"/") ||
+		r.Header.Get("X-Amz-Date") == "" {
+		writeError(w, http.StatusForbidden, "AccessDenied", "the request is not signed with the known access key")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	bucket, key := r.URL.Path, ""
+	bucket = strings.TrimPrefix(bucket, "/")
+	if i := strings.IndexByte(bucket, '/'); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && key == "" && q.Get("list-type") == "2":
+		s.list(w, bucket, q)
+	case r.Method == http.MethodPost && key == "" && q.Has("delete"):
+		s.deleteObjects(w, r, bucket, body)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		s.uploadPart(w, q, body)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.counts["PutObject"]++
+		obj := s.store(bucket, key, body, metaOf(r.Header), md5Hex(body))
+		w.Header().Set("ETag", quote(obj.etag))
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.counts["CreateMultipartUpload"]++
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &upload{bucket: bucket, key: key, meta: metaOf(r.Header), parts: make(map[int][]byte)}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: id})
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		s.completeUpload(w, q, body)
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		s.counts["AbortMultipartUpload"]++
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		s.counts["DeleteObject"]++
+		delete(s.bucket(bucket), key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.String())
+	}
+}
+
+type listedObject struct {
+	Key          string `xml:"Key"`
+	Size         int    `xml:"Size"`
+	ETag         string `xml:"ETag"`
+	LastModified string `xml:"LastModified"`
+}
+
+func (s *Server) list(w http.ResponseWriter, bucket string, q map[string][]string) {
+	s.counts["ListObjectsV2"]++
+	get := func(name string) string {
+		if v := q[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	keys := s.sortedKeys(bucket, get("prefix"))
+	start, _ := strconv.Atoi(get("continuation-token"))
+	maxKeys := 1000
+	if v := get("max-keys"); v != "" {
+		maxKeys, _ = strconv.Atoi(v)
+	}
+	end := start + maxKeys
+	if end > len(keys) {
+		end = len(keys)
+	}
+	res := struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Contents              []listedObject `xml:"Contents"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	}{IsTruncated: end < len(keys)}
+	if res.IsTruncated {
+		res.NextContinuationToken = strconv.Itoa(end)
+	}
+	for _, key := range keys[start:end] {
+		obj := s.buckets[bucket][key]
+		res.Contents = append(res.Contents, listedObject{Key: key, Size: len(obj.data), ETag: quote(obj.etag),
+			LastModified: obj.modified.Format("2006-01-02T15:04:05.000Z")})
+	}
+	writeXML(w, res)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if r.Method == http.MethodHead {
+		s.counts["HeadObject"]++
+	} else {
+		s.counts["GetObject"]++
+	}
+	obj, ok := s.bucket(bucket)[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "the key does not exist")
+		return
+	}
+	for name, vals := range obj.meta {
+		w.Header()[name] = vals
+	}
+	w.Header().Set("ETag", quote(obj.etag))
+	w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(obj.data)
+	}
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.counts["CopyObject"]++
+	source := strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/")
+	i := strings.IndexByte(source, '/')
+	if i < 0 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "bad copy source")
+		return
+	}
+	src, ok := s.bucket(source[:i])[source[i+1:]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "the copy source does not exist")
+		return
+	}
+	meta := src.meta
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		meta = metaOf(r.Header)
+	}
+	obj := s.store(bucket, key, src.data, meta, src.etag)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: quote(obj.etag)})
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string, body []byte) {
+	s.counts["DeleteObjects"]++
+	if r.Header.Get("Content-Md5") == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "Content-MD5 is required")
+		return
+	}
+	var req struct {
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	for _, o := range req.Objects {
+		delete(s.bucket(bucket), o.Key)
+	}
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+	}{})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, q map[string][]string, body []byte) {
+	s.counts["UploadPart"]++
+	up, ok := s.uploads[q["uploadId"][0]]
+	number, err := strconv.Atoi(q["partNumber"][0])
+	if !ok || err != nil {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "the upload does not exist")
+		return
+	}
+	up.parts[number] = body
+	w.Header().Set("ETag", quote(md5Hex(body)))
+}
+
+func (s *Server) completeUpload(w http.ResponseWriter, q map[string][]string, body []byte) {
+	s.counts["CompleteMultipartUpload"]++
+	id := q["uploadId"][0]
+	up, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "the upload does not exist")
+		return
+	}
+	var req struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	var data bytes.Buffer
+	for i, p := range req.Parts {
+		part, ok := up.parts[p.PartNumber]
+		if !ok || p.PartNumber != i+1 || quote(md5Hex(part)) != p.ETag {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d is invalid", p.PartNumber))
+			return
+		}
+		data.Write(part)
+	}
+	delete(s.uploads, id)
+	obj := s.store(up.bucket, up.key, data.Bytes(), up.meta, fmt.Sprintf("%s-%d", md5Hex(data.Bytes()), len(req.Parts)))
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: quote(obj.etag)})
+}
+
+func metaOf(h http.Header) http.Header {
+	meta := http.Header{}
+	for name, vals := range h {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			meta[name] = vals
+		}
+	}
+	return meta
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func quote(etag string) string {
+	return `"` + etag + `"`
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: msg})
+}
@@ -0,0 +1,49 @@
+package s3fs
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+//Scheme is the URL scheme of the copy dirs in the S3-compatible object storage.
+const Scheme = "s3"
+
+//Location is the prefix of the bucket addressed as s3://bucket/prefix.
+type Location struct {
+	Bucket string
+	Prefix string // slash-separated without the leading and trailing slashes (empty means the whole bucket)
+}
+
+//IsURL reports whether s looks like an S3 URL (and so it isn't a local path).
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, Scheme+"://")
+}
+
+//ParseURL parses the S3 URL of the copy dir.
+func ParseURL(raw string) (Location, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Location{}, fmt.Errorf("invalid S3 URL: %w", err)
+	}
+	if u.Scheme != Scheme {
+		return Location{}, fmt.Errorf("invalid S3 URL %q: the scheme must be %s", raw, Scheme)
+	}
+	if u.Host == "" {
+		return Location{}, fmt.Errorf("invalid S3 URL %q: no bucket", raw)
+	}
+	if u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" {
+		return Location{}, fmt.Errorf("invalid S3 URL %q: user info, port, query and fragment are not supported", raw)
+	}
+	return Location{Bucket: u.Host, Prefix: strings.Trim(path.Clean("/"+u.Path), "/")}, nil
+}
+
+//Root is the path of the copy dir in the file system of the bucket.
+func (l Location) Root() string {
+	return "/" + l.Prefix
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s://%s%s", Scheme, l.Bucket, l.Root())
+}
@@ -0,0 +1,349 @@
+package sftpfs
+
+import (
+	"dsync/pkg/fsys"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	dialTimeout = 15 * time.Second
+	//maxAttempts is the number of attempts of an operation, if the connection is lost in between
+	//(i.e. the operation is retried once after reconnecting).
+	maxAttempts = 2
+)
+
+//errDirNotEmpty is matched by the error text by the callers (like the one of os.Remove).
+var errDirNotEmpty = errors.New("directory not empty")
+
+//Config is the SSH authentication config.
+type Config struct {
+	KeyPath        string // the private key file, by default it's ~/.ssh/id_ed25519, id_ecdsa or id_rsa (the first found)
+	KnownHostsPath string // the known hosts file, by default it's ~/.ssh/known_hosts
+}
+
+//FS is the remote file system accessed over SFTP. It connects lazily and reconnects,
+//if the connection is lost (an operation is retried once in such case).
+//Modification times are kept by SFTP with a second precision.
+type FS struct {
+	loc       Location
+	sshConfig *ssh.ClientConfig
+
+	mu   sync.Mutex
+	conn *conn // is nil until the first operation or after the connection is lost
+}
+
+type conn struct {
+	ssh *ssh.Client
+	*sftp.Client
+}
+
+func (c *conn) close() {
+	_ = c.Client.Close()
+	_ = c.ssh.Close()
+}
+
+//New returns the file system of the remote location. It doesn't connect until the first operation.
+func New(loc Location, cfg Config) (*FS, error) {
+	auth, err := keyAuth(cfg.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	knownHostsPath := cfg.KnownHostsPath
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the known hosts file: %w", err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the known hosts file: %w", err)
+	}
+	return &FS{
+		loc: loc,
+		sshConfig: &ssh.ClientConfig{
+			User:            loc.User,
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         dialTimeout,
+		},
+	}, nil
+}
+
+//keyAuth reads the private key (it must not be protected by a passphrase).
+func keyAuth(keyPath string) (ssh.AuthMethod, error) {
+	if keyPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the private key: %w", err)
+		}
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			if p := filepath.Join(home, ".ssh", name); fileExists(p) {
+				keyPath = p
+				break
+			}
+		}
+		if keyPath == "" {
+			return nil, errors.New("cannot find the private key in ~/.ssh, it must be specified explicitly")
+		}
+	}
+	pem, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the private key %q: %w", keyPath, err)
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+//Location returns the remote location of the file system.
+func (f *FS) Location() Location {
+	return f.loc
+}
+
+//Close closes the current connection (if any). The file system may still be used, it reconnects in such case.
+func (f *FS) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil {
+		f.conn.close()
+		f.conn = nil
+	}
+	return nil
+}
+
+func (f *FS) connect() (*conn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil {
+		return f.conn, nil
+	}
+	sshClient, err := ssh.Dial("tcp", f.loc.Addr, f.sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", f.loc.Addr, err)
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, fmt.Errorf("cannot start SFTP session with %s: %w", f.loc.Addr, err)
+	}
+	f.conn = &conn{ssh: sshClient, Client: sftpClient}
+	return f.conn, nil
+}
+
+//drop closes the broken connection, so the next operation reconnects.
+func (f *FS) drop(broken *conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn == broken {
+		broken.close()
+		f.conn = nil
+	}
+}
+
+//do calls fn with the current connection. If the connection is lost, then it reconnects and calls fn again.
+func (f *FS) do(fn func(c *conn) error) error {
+	for attempt := 1; ; attempt++ {
+		c, err := f.connect()
+		if err != nil {
+			return err
+		}
+		err = fn(c)
+		if err == nil || !isConnectionLost(err) {
+			return err
+		}
+		f.drop(c)
+		if attempt == maxAttempts {
+			return err
+		}
+	}
+}
+
+func isConnectionLost(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.As(err, &netErr)
+}
+
+//Walk walks the remote file tree in the lexical order like filepath.WalkDir does.
+func (f *FS) Walk(root string, fn fs.WalkDirFunc) error {
+	var info fs.FileInfo
+	err := f.do(func(c *conn) (err error) {
+		info, err = c.Lstat(root)
+		return err
+	})
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = f.walkDir(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if errors.Is(err, fs.SkipDir) {
+		return nil
+	}
+	return err
+}
+
+func (f *FS) walkDir(name string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if errors.Is(err, fs.SkipDir) && d.IsDir() {
+			err = nil // the dir is skipped, but its siblings are not
+		}
+		return err
+	}
+
+	var infos []fs.FileInfo
+	err := f.do(func(c *conn) (err error) {
+		infos, err = c.ReadDir(name)
+		return err
+	})
+	if err != nil {
+		// the second call of fn for the same dir reports the error
+		if err = fn(name, d, err); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				err = nil
+			}
+			return err
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	for _, info := range infos {
+		if err := f.walkDir(path.Join(name, info.Name()), fs.FileInfoToDirEntry(info), fn); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				break // the rest of the dir is skipped
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	var info fs.FileInfo
+	err := f.do(func(c *conn) (err error) {
+		info, err = c.Stat(name)
+		return err
+	})
+	return info, wrapErr("stat", name, err)
+}
+
+func (f *FS) Open(name string) (io.ReadCloser, error) {
+	var file *sftp.File
+	err := f.do(func(c *conn) (err error) {
+		file, err = c.Open(name)
+		return err
+	})
+	if err != nil {
+		return nil, wrapErr("open", name, err)
+	}
+	return file, nil
+}
+
+func (f *FS) Create(name string) (io.WriteCloser, error) {
+	var file *sftp.File
+	err := f.do(func(c *conn) (err error) {
+		file, err = c.Create(name)
+		return err
+	})
+	if err != nil {
+		return nil, wrapErr("create", name, err)
+	}
+	// fsync isn't supported by all servers, so Sync of the file is not exposed
+	return struct {
+		io.Writer
+		io.ReaderFrom // it writes concurrently, which is much faster
+		io.Closer
+	}{file, file, file}, nil
+}
+
+func (f *FS) Rename(oldName, newName string) error {
+	return wrapErr("rename", oldName, f.do(func(c *conn) error {
+		// the plain SFTP rename fails, if the new name exists
+		err := c.PosixRename(oldName, newName)
+		if errors.Is(err, sftp.ErrSSHFxOpUnsupported) {
+			if err = c.Remove(newName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			err = c.Rename(oldName, newName)
+		}
+		return err
+	}))
+}
+
+//Remove removes the file or empty dir. A failure to remove a non-empty dir is reported in the same way
+//as os.Remove does (SFTP servers report it as a generic failure).
+func (f *FS) Remove(name string) error {
+	return wrapErr("remove", name, f.do(func(c *conn) error {
+		err := c.Remove(name)
+		if err != nil && !isConnectionLost(err) {
+			if infos, rErr := c.ReadDir(name); rErr == nil && len(infos) > 0 {
+				return errDirNotEmpty
+			}
+		}
+		return err
+	}))
+}
+
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return wrapErr("chtimes", name, f.do(func(c *conn) error {
+		return c.Chtimes(name, atime, mtime)
+	}))
+}
+
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	return wrapErr("chmod", name, f.do(func(c *conn) error {
+		return c.Chmod(name, mode)
+	}))
+}
+
+func (f *FS) MkdirAll(name string) error {
+	return f.do(func(c *conn) error {
+		return c.MkdirAll(name)
+	})
+}
+
+func (f *FS) Join(root, p string) string {
+	return fsys.JoinSlash(root, p)
+}
+
+func (f *FS) Rel(root, name string) (string, error) {
+	return fsys.RelSlash(root, name)
+}
+
+func (f *FS) ModTimePrecision() time.Duration {
+	return time.Second
+}
+
+//wrapErr adds the operation and path to the error like the os package does
+//(SFTP errors are often bare, e.g. os.ErrNotExist).
+func wrapErr(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pErr *fs.PathError
+	if errors.As(err, &pErr) {
+		return err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
@@ -0,0 +1,177 @@
+package sftpfs
+
+import (
+	"dsync/pkg/fsys/sftpfs/sftptest"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    Location
+		wantErr string
+	}{
+		{name: "default port", raw: "sftp://bob@example.com/data/copy/",
+			want: Location{User: "bob", Addr: "example.com:22", Path: "/data/copy"}},
+		{name: "port", raw: "sftp://bob@example.com:2222/data",
+			want: Location{User: "bob", Addr: "example.com:2222", Path: "/data"}},
+		{name: "home dir", raw: "sftp://bob@[::1]/~/backup/copy",
+			want: Location{User: "bob", Addr: "[::1]:22", Path: "backup/copy"}},
+		{name: "bad scheme", raw: "ssh://bob@example.com/data", wantErr: "the scheme must be sftp"},
+		{name: "no host", raw: "sftp:///data", wantErr: "no host"},
+		{name: "no path", raw: "sftp://bob@example.com/", wantErr: "no dir path"},
+		{name: "home itself", raw: "sftp://bob@example.com/~", wantErr: "home dir itself"},
+		{name: "query", raw: "sftp://bob@example.com/data?x=1", wantErr: "query and fragment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseURL(tt.raw)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			require.True(t, IsURL(tt.raw))
+			reparsed, err := ParseURL(got.String())
+			require.NoError(t, err)
+			require.Equal(t, got, reparsed)
+		})
+	}
+}
+
+func TestFS_Rel(t *testing.T) {
+	f := &FS{}
+	tests := []struct {
+		root, name, want string
+		wantErr          bool
+	}{
+		{root: "/data", name: "/data", want: "."},
+		{root: "/data/", name: "/data/a/b.txt", want: filepath.Join("a", "b.txt")},
+		{root: "/", name: "/a", want: "a"},
+		{root: "rel", name: "rel/a", want: "a"},
+		{root: "/data", name: "/database/a", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := f.Rel(tt.root, tt.name)
+		if tt.wantErr {
+			require.Error(t, err, tt.name)
+			continue
+		}
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.want, got, tt.name)
+		require.Equal(t, filepath.ToSlash(filepath.Join(tt.root, tt.want)), f.Join(tt.root, got))
+	}
+}
+
+func newTestFS(t *testing.T) (*FS, *sftptest.Server, string) {
+	server, err := sftptest.NewServer(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+
+	root := t.TempDir()
+	loc, err := ParseURL(server.URL("tester", root))
+	require.NoError(t, err)
+	f, err := New(loc, Config{KeyPath: server.KeyPath, KnownHostsPath: server.KnownHostsPath})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f, server, filepath.ToSlash(root)
+}
+
+func TestFS(t *testing.T) {
+	requires := require.New(t)
+	f, _, root := newTestFS(t)
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	// MkdirAll, Create, Chtimes, Chmod
+	requires.NoError(f.MkdirAll(f.Join(root, filepath.Join("a", "b"))))
+	w, err := f.Create(f.Join(root, "a/b/file.txt"))
+	requires.NoError(err)
+	_, err = io.Copy(w, strings.NewReader("content"))
+	requires.NoError(err)
+	requires.NoError(w.Close())
+	requires.NoError(f.Chtimes(f.Join(root, "a/b/file.txt"), modTime, modTime))
+	requires.NoError(f.Chmod(f.Join(root, "a/b/file.txt"), 0o600))
+
+	info, err := f.Stat(f.Join(root, "a/b/file.txt"))
+	requires.NoError(err)
+	requires.Equal(int64(len("content")), info.Size())
+	requires.True(info.ModTime().Equal(modTime))
+	requires.Equal(fs.FileMode(0o600), info.Mode().Perm())
+
+	// MkdirAll fails, if there's a file in the way
+	requires.ErrorContains(f.MkdirAll(f.Join(root, "a/b/file.txt/c")), "not a directory")
+
+	// Walk
+	var walked []string
+	requires.NoError(f.Walk(root, func(name string, d fs.DirEntry, err error) error {
+		requires.NoError(err)
+		rel, err := f.Rel(root, name)
+		requires.NoError(err)
+		walked = append(walked, filepath.ToSlash(rel))
+		return nil
+	}))
+	requires.Equal([]string{".", "a", "a/b", "a/b/file.txt"}, walked)
+
+	// Open
+	r, err := f.Open(f.Join(root, "a/b/file.txt"))
+	requires.NoError(err)
+	content, err := io.ReadAll(r)
+	requires.NoError(err)
+	requires.NoError(r.Close())
+	requires.Equal("content", string(content))
+
+	// Rename over the existing file
+	w, err = f.Create(f.Join(root, "a/other.txt"))
+	requires.NoError(err)
+	requires.NoError(w.Close())
+	requires.NoError(f.Rename(f.Join(root, "a/b/file.txt"), f.Join(root, "a/other.txt")))
+	content, err = os.ReadFile(filepath.Join(filepath.FromSlash(root), "a", "other.txt"))
+	requires.NoError(err)
+	requires.Equal("content", string(content))
+
+	// Remove
+	err = f.Remove(f.Join(root, "a"))
+	var pErr *fs.PathError
+	requires.ErrorAs(err, &pErr)
+	requires.ErrorContains(pErr.Err, "directory not empty")
+	requires.NoError(f.Remove(f.Join(root, "a/other.txt")))
+	requires.NoError(f.Remove(f.Join(root, "a/b")))
+	_, err = f.Stat(f.Join(root, "a/b"))
+	requires.ErrorIs(err, fs.ErrNotExist)
+}
+
+func TestFS_Reconnect(t *testing.T) {
+	requires := require.New(t)
+	f, server, root := newTestFS(t)
+
+	requires.NoError(f.MkdirAll(f.Join(root, "a")))
+	server.DropConnections()
+	// the first attempt finds the connection lost, and the operation is retried with the new one
+	_, err := f.Stat(f.Join(root, "a"))
+	requires.NoError(err)
+
+	requires.NoError(server.Close())
+	_, err = f.Stat(f.Join(root, "a"))
+	requires.ErrorContains(err, "cannot connect")
+}
+
+func TestNew_Errors(t *testing.T) {
+	dir := t.TempDir()
+	loc := Location{User: "tester", Addr: "127.0.0.1:22", Path: "/data"}
+	_, err := New(loc, Config{KeyPath: filepath.Join(dir, "missing"), KnownHostsPath: filepath.Join(dir, "kh")})
+	require.ErrorContains(t, err, "cannot read the private key")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad_key"), []byte("not a key"), 0o600))
+	_, err = New(loc, Config{KeyPath: filepath.Join(dir, "bad_key")})
+	require.ErrorContains(t, err, "cannot parse the private key")
+}
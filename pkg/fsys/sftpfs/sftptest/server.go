@@ -0,0 +1,186 @@
+//Package sftptest provides the in-process SFTP server for tests (like net/http/httptest does for HTTP).
+package sftptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//Server is the SSH server listening on the loopback interface, which serves the local file system over SFTP.
+//Only the client key generated along with the server is authorized.
+type Server struct {
+	Addr           string // host:port
+	KeyPath        string // the client's private key file
+	KnownHostsPath string // the known hosts file with the server's host key
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+//NewServer generates the keys into the dir and starts the server.
+func NewServer(dir string) (*Server, error) {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	clientPub, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		KeyPath:        filepath.Join(dir, "id_ecdsa"),
+		KnownHostsPath: filepath.Join(dir, "known_hosts"),
+		conns:          make(map[net.Conn]struct{}),
+	}
+	if err = writeKey(s.KeyPath, clientKey); err != nil {
+		return nil, err
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientPub.Marshal()) {
+				return nil, fmt.Errorf("unknown public key")
+			}
+			return nil, nil
+		},
+	}
+	s.config.AddHostKey(hostSigner)
+
+	if s.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	s.Addr = s.listener.Addr().String()
+	knownHostsLine := knownhosts.Line([]string{s.Addr}, hostSigner.PublicKey()) + "\n"
+	if err = os.WriteFile(s.KnownHostsPath, []byte(knownHostsLine), 0o600); err != nil {
+		_ = s.listener.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+//URL returns the SFTP URL of the dir on the server.
+func (s *Server) URL(user, dir string) string {
+	return fmt.Sprintf("sftp://%s@%s%s", user, s.Addr, filepath.ToSlash(dir))
+}
+
+//DropConnections closes all the current client connections (the server keeps accepting new ones).
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+//Close stops the server and closes all the client connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return // the listener is closed
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(c)
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer c.Close()
+	sshConn, channels, requests, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveSession(channel, channelRequests)
+		}()
+	}
+}
+
+//serveSession serves the session, which requested the sftp subsystem (other requests are rejected).
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		// the payload of the subsystem request is the length-prefixed name
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		_ = req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		_ = server.Serve()
+		return
+	}
+}
@@ -0,0 +1,75 @@
+package sftpfs
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os/user"
+	"path"
+	"strings"
+)
+
+//Scheme is the URL scheme of the remote dirs accessed over SFTP.
+const Scheme = "sftp"
+
+const defaultPort = "22"
+
+//Location is the remote dir addressed as sftp://user@host[:port]/path. The path /~/dir is relative to
+//the user's home dir.
+type Location struct {
+	User string
+	Addr string // host:port
+	Path string // slash-separated, it's relative to the home dir, if it isn't absolute
+}
+
+//IsURL reports whether s looks like an SFTP URL (and so it isn't a local path).
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, Scheme+"://")
+}
+
+//ParseURL parses the SFTP URL of the remote dir. If the user is omitted, then the current OS user is taken.
+func ParseURL(raw string) (Location, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Location{}, fmt.Errorf("invalid SFTP URL: %w", err)
+	}
+	if u.Scheme != Scheme {
+		return Location{}, fmt.Errorf("invalid SFTP URL %q: the scheme must be %s", raw, Scheme)
+	}
+	if u.Hostname() == "" {
+		return Location{}, fmt.Errorf("invalid SFTP URL %q: no host", raw)
+	}
+	if u.Path == "" || u.Path == "/" {
+		return Location{}, fmt.Errorf("invalid SFTP URL %q: no dir path", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return Location{}, fmt.Errorf("invalid SFTP URL %q: query and fragment are not supported", raw)
+	}
+
+	loc := Location{User: u.User.Username(), Addr: u.Host, Path: path.Clean(u.Path)}
+	if u.Port() == "" {
+		loc.Addr = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	if loc.Path == "/~" {
+		return Location{}, fmt.Errorf("invalid SFTP URL %q: the home dir itself cannot be synchronized", raw)
+	}
+	if strings.HasPrefix(loc.Path, "/~/") {
+		loc.Path = strings.TrimPrefix(loc.Path, "/~/")
+	}
+	if loc.User == "" {
+		cur, err := user.Current()
+		if err != nil {
+			return Location{}, fmt.Errorf("invalid SFTP URL %q: no user and cannot get the current one: %w", raw, err)
+		}
+		loc.User = cur.Username
+	}
+	return loc, nil
+}
+
+func (l Location) String() string {
+	p := l.Path
+	if !path.IsAbs(p) {
+		p = "/~/" + p
+	}
+	return fmt.Sprintf("%s://%s@%s%s", Scheme, l.User, l.Addr, p)
+}
@@ -1,10 +1,14 @@
 package iout
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"dsync/pkg/fsys"
 	"dsync/pkg/helpers/ut"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
@@ -17,8 +21,48 @@ var (
 	errDirNotEmpty = errors.New("directory not empty")
 	// because stdlib's fsys.errNotDir is not exported
 	errNotDir = errors.New("not a directory")
+
+	ErrVerificationFailed = errors.New("copy verification failed: written content differs from the source")
 )
 
+//CopyOption customizes the behavior of the file copying functions.
+type CopyOption func(*copyConfig)
+
+type copyConfig struct {
+	verify bool
+}
+
+//WithVerification makes the file copying functions to hash the source content while it's read, and then
+//to re-read the written destination file (after dropping its page cache, if possible) and compare the hashes.
+//In case of mismatch ErrVerificationFailed is returned.
+func WithVerification(verify bool) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.verify = verify
+	}
+}
+
+func newCopyConfig(opts []CopyOption) copyConfig {
+	var cfg copyConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+//Transfer copies files from the source file system to the destination one and manages the destination entries.
+type Transfer struct {
+	Src fsys.FS
+	Dst fsys.FS
+}
+
+//local is the transfer between the dirs on the local disk, it's used by the package-level functions.
+var local = Transfer{Src: fsys.Local{}, Dst: fsys.Local{}}
+
+//Reversed returns the transfer in the opposite direction.
+func (t Transfer) Reversed() Transfer {
+	return Transfer{Src: t.Dst, Dst: t.Src}
+}
+
 func IsErrNotDir(err error) bool {
 	return ut.IsSameError(err, errNotDir)
 }
@@ -44,7 +88,12 @@ func (r *readerWithContext) Read(p []byte) (int, error) {
 
 //Remove removes a file or an empty directory. It silently ignores non-empty directory.
 func Remove(path string) error {
-	if err := os.Remove(path); err != nil {
+	return local.Remove(path)
+}
+
+//Remove removes a destination file or an empty directory. It silently ignores non-empty directory.
+func (t Transfer) Remove(path string) error {
+	if err := t.Dst.Remove(path); err != nil {
 		var pErr *fs.PathError
 		if errors.As(err, &pErr) && ut.IsSameError(pErr.Err, errDirNotEmpty) {
 			return nil
@@ -56,15 +105,36 @@ func Remove(path string) error {
 
 //CopyFile copies the entry at the source path (must be a regular file) to the specified destination.
 //It sets for the copied file the same modTime as the source file modTime.
-func CopyFile(ctx context.Context, srcPath, dstPath string, srcModTime time.Time) error {
+func CopyFile(ctx context.Context, srcPath, dstPath string, srcModTime time.Time, opts ...CopyOption) error {
+	return local.CopyFile(ctx, srcPath, dstPath, srcModTime, opts...)
+}
+
+//CopyFile is the same as the package-level CopyFile, but it copies between the transfer's file systems.
+func (t Transfer) CopyFile(ctx context.Context, srcPath, dstPath string, srcModTime time.Time, opts ...CopyOption) error {
+	if err := t.EnsureDirExists(ctx, t.Dst.Join(dstPath, "..")); err != nil {
+		return err
+	}
+	return t.ReplaceFile(ctx, srcPath, dstPath, srcModTime, opts...)
+}
+
+//LinkFile makes a hard link at the destination path to the existing file at the source path.
+func LinkFile(ctx context.Context, srcPath, dstPath string) error {
 	if err := EnsureDirExists(ctx, filepath.Dir(dstPath)); err != nil {
 		return err
 	}
-	return ReplaceFile(ctx, srcPath, dstPath, srcModTime)
+	if err := os.Link(srcPath, dstPath); err != nil {
+		return fmt.Errorf("cannot make hard link: %w", err)
+	}
+	return nil
 }
 
 func EnsureDirExists(ctx context.Context, dirPath string) error {
-	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+	return local.EnsureDirExists(ctx, dirPath)
+}
+
+//EnsureDirExists makes the destination dir (along with its parents), if it doesn't exist yet.
+func (t Transfer) EnsureDirExists(ctx context.Context, dirPath string) error {
+	if err := t.Dst.MkdirAll(dirPath); err != nil {
 		if !IsErrNotDir(err) {
 			return fmt.Errorf("cannot make dir: %w", err)
 		}
@@ -77,7 +147,7 @@ func EnsureDirExists(ctx context.Context, dirPath string) error {
 			return ctx.Err()
 		case <-time.After(retryTime):
 		}
-		if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		if err := t.Dst.MkdirAll(dirPath); err != nil {
 			return fmt.Errorf("cannot make dir: %w", err)
 		}
 	}
@@ -86,44 +156,144 @@ func EnsureDirExists(ctx context.Context, dirPath string) error {
 
 //ReplaceFile truncates the file at dstPath (or creates it, if absent) and writes the source file's content into it.
 //It sets for the "replaced" file the same modTime as the source file modTime.
-func ReplaceFile(ctx context.Context, srcPath string, dstPath string, srcModTime time.Time) error {
-	if err := copyFileContents(ctx, srcPath, dstPath); err != nil {
+func ReplaceFile(ctx context.Context, srcPath string, dstPath string, srcModTime time.Time, opts ...CopyOption) error {
+	return local.ReplaceFile(ctx, srcPath, dstPath, srcModTime, opts...)
+}
+
+//ReplaceFile is the same as the package-level ReplaceFile, but it copies between the transfer's file systems.
+func (t Transfer) ReplaceFile(
+	ctx context.Context, srcPath string, dstPath string, srcModTime time.Time, opts ...CopyOption,
+) error {
+	cfg := newCopyConfig(opts)
+	var srcHash hash.Hash
+	if cfg.verify {
+		srcHash = sha256.New()
+	}
+	if err := t.copyFileContents(ctx, srcPath, dstPath, srcModTime, srcHash); err != nil {
 		return fmt.Errorf("cannot copy file contents: %w", err)
 	}
-	if err := os.Chtimes(dstPath, time.Now(), srcModTime); err != nil {
+	if cfg.verify {
+		if err := verifyFileContents(ctx, t.Dst, dstPath, srcHash.Sum(nil)); err != nil {
+			return err
+		}
+	}
+	if _, ok := t.Dst.(fsys.ModTimeCreator); ok {
+		return nil // the file is created along with the modification time
+	}
+	if err := t.Dst.Chtimes(dstPath, time.Now(), srcModTime); err != nil {
 		return fmt.Errorf("cannot set file modification time: %w", err)
 	}
 	return nil
 }
 
+//SetMeta sets the modTime for the entry at the path. If mode is not zero,
+//then it also sets the entry's permission bits to the ones of mode.
+func SetMeta(path string, modTime time.Time, mode fs.FileMode) error {
+	return local.SetMeta(path, modTime, mode)
+}
+
+//SetMeta is the same as the package-level SetMeta, but for the destination entry.
+func (t Transfer) SetMeta(path string, modTime time.Time, mode fs.FileMode) error {
+	if mode != 0 {
+		if err := t.Dst.Chmod(path, mode.Perm()); err != nil {
+			return fmt.Errorf("cannot set permissions: %w", err)
+		}
+	}
+	if err := t.Dst.Chtimes(path, time.Now(), modTime); err != nil {
+		return fmt.Errorf("cannot set modification time: %w", err)
+	}
+	return nil
+}
+
 //ReplaceDirWithFile removes an empty directory dstPath and, if succeeded, copies the source file to its place.
 //Method does nothing in case of non-empty dstPath.
-func ReplaceDirWithFile(ctx context.Context, srcPath string, dstPath string, srcModTime time.Time) error {
-	if err := os.Remove(dstPath); err != nil {
+func ReplaceDirWithFile(
+	ctx context.Context, srcPath string, dstPath string, srcModTime time.Time, opts ...CopyOption,
+) error {
+	return local.ReplaceDirWithFile(ctx, srcPath, dstPath, srcModTime, opts...)
+}
+
+//ReplaceDirWithFile is the same as the package-level ReplaceDirWithFile, but it copies between the transfer's
+//file systems.
+func (t Transfer) ReplaceDirWithFile(
+	ctx context.Context, srcPath string, dstPath string, srcModTime time.Time, opts ...CopyOption,
+) error {
+	if err := t.Dst.Remove(dstPath); err != nil {
 		var pErr *fs.PathError
 		if errors.As(err, &pErr) && ut.IsSameError(pErr.Err, errDirNotEmpty) {
 			return nil
 		}
 		return fmt.Errorf("cannot remove dir: %w", err)
 	}
-	return ReplaceFile(ctx, srcPath, dstPath, srcModTime)
+	return t.ReplaceFile(ctx, srcPath, dstPath, srcModTime, opts...)
 }
 
-func copyFileContents(ctx context.Context, src, dst string) error {
-	in, err := os.Open(src)
+//copyFileContents copies the content of the src file into the dst file.
+//If srcHash is not nil, then the source content is written to it as well while it's read.
+func (t Transfer) copyFileContents(
+	ctx context.Context, src, dst string, srcModTime time.Time, srcHash hash.Hash,
+) error {
+	in, err := t.Src.Open(src)
 	if err != nil {
 		return fmt.Errorf("cannot open file: %w", err)
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
+	var r io.Reader = in
+	if srcHash != nil {
+		r = io.TeeReader(in, srcHash)
+	}
+	if updater, ok := t.Dst.(fsys.Updater); ok {
+		// only the changed blocks of the existing file are transferred
+		if err = updater.UpdateFile(dst, newReaderWithContext(ctx, r)); err != nil {
+			return fmt.Errorf("cannot update file content: %w", err)
+		}
+		return nil
+	}
+
+	var out io.WriteCloser
+	if creator, ok := t.Dst.(fsys.ModTimeCreator); ok {
+		out, err = creator.CreateWithModTime(dst, srcModTime)
+	} else {
+		out, err = t.Dst.Create(dst)
+	}
 	if err != nil {
 		return fmt.Errorf("cannot create file: %w", err)
 	}
 	defer out.Close()
 
-	if _, err = io.Copy(out, newReaderWithContext(ctx, in)); err != nil {
+	if _, err = io.Copy(out, newReaderWithContext(ctx, r)); err != nil {
+		if aborter, ok := out.(fsys.Aborter); ok {
+			_ = aborter.Abort() // the incomplete content must not replace the existing file
+		}
 		return fmt.Errorf("cannot read/write file content: %w", err)
 	}
-	return out.Sync()
+	if syncer, ok := out.(interface{ Sync() error }); ok {
+		if err = syncer.Sync(); err != nil {
+			return err
+		}
+	}
+	if f, ok := out.(*os.File); ok && srcHash != nil {
+		// the written content has to be re-read from the storage itself, not from the page cache
+		_ = dropPageCache(f)
+	}
+	return out.Close()
+}
+
+//verifyFileContents re-reads the file at the path and compares its content hash with the expected one.
+func verifyFileContents(ctx context.Context, fsys fsys.FS, path string, expectedHash []byte) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open file for verification: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, newReaderWithContext(ctx, f)); err != nil {
+		return fmt.Errorf("cannot read file for verification: %w", err)
+	}
+	if !bytes.Equal(h.Sum(nil), expectedHash) {
+		return fmt.Errorf("%w (file %q)", ErrVerificationFailed, path)
+	}
+	return nil
 }
@@ -2,9 +2,12 @@ package iout
 
 import (
 	"context"
+	"crypto/sha256"
+	"dsync/pkg/fsys"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -42,6 +45,32 @@ func TestCopyFile(t *testing.T) {
 	requires.Equal(srcFileInfo.ModTime(), copiedFileInfo.ModTime())
 }
 
+func TestCopyFileWithVerification(t *testing.T) {
+	requires := require.New(t)
+	wd, err := os.Getwd()
+	requires.NoError(err)
+	copyDir, err := os.MkdirTemp(filepath.Join(wd, "testdata"), "copy")
+	requires.NoError(err)
+	defer os.RemoveAll(copyDir)
+
+	srcAbsPath := filepath.Join(wd, "testdata/src/some_file.txt")
+	destAbsPath := filepath.Join(copyDir, "some_file.txt")
+	err = CopyFile(context.Background(), srcAbsPath, destAbsPath, time.Now(), WithVerification(true))
+
+	requires.NoError(err)
+}
+
+func TestVerifyFileContentsMismatch(t *testing.T) {
+	requires := require.New(t)
+	wd, err := os.Getwd()
+	requires.NoError(err)
+
+	someHash := sha256.Sum256([]byte("some other content"))
+	err = verifyFileContents(context.Background(), fsys.Local{}, filepath.Join(wd, "testdata/src/some_file.txt"), someHash[:])
+
+	requires.ErrorIs(err, ErrVerificationFailed)
+}
+
 func TestEnsureDirExistsCannotMakeDir(t *testing.T) {
 	requires := require.New(t)
 	wd, err := os.Getwd()
@@ -70,6 +99,24 @@ func TestRemoveIgnoresNonEmptyDir(t *testing.T) {
 	requires.True(dirInfo.IsDir())
 }
 
+func TestSetMeta(t *testing.T) {
+	requires := require.New(t)
+	wd, err := os.Getwd()
+	requires.NoError(err)
+	dirPath, err := os.MkdirTemp(filepath.Join(wd, "testdata"), "dir")
+	requires.NoError(err)
+	defer os.RemoveAll(dirPath)
+
+	modTime := time.Date(2020, time.May, 1, 12, 0, 0, 0, time.Local)
+	err = SetMeta(dirPath, modTime, os.ModeDir|0700)
+
+	requires.NoError(err)
+	dirInfo, err := os.Stat(dirPath)
+	requires.NoError(err)
+	requires.True(dirInfo.ModTime().Equal(modTime))
+	requires.Equal(os.FileMode(0700), dirInfo.Mode().Perm())
+}
+
 func BenchmarkCopyFile(b *testing.B) {
 	b.StopTimer()
 
@@ -0,0 +1,19 @@
+//go:build linux && (amd64 || arm64)
+
+package iout
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadviseDontNeed = 4 // POSIX_FADV_DONTNEED
+
+//dropPageCache asks the kernel to evict the file's cached pages (the file must be synced before that).
+func dropPageCache(f *os.File) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadviseDontNeed, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+package iout
+
+import "syscall"
+
+//mknod creates the file system node (the device number is 64-bit on FreeBSD).
+func mknod(path string, mode uint32, dev uint64) error {
+	return syscall.Mknod(path, mode, dev)
+}
@@ -0,0 +1,10 @@
+//go:build linux || darwin || netbsd || openbsd || dragonfly
+
+package iout
+
+import "syscall"
+
+//mknod creates the file system node.
+func mknod(path string, mode uint32, dev uint64) error {
+	return syscall.Mknod(path, mode, int(dev))
+}
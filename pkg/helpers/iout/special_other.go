@@ -0,0 +1,24 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package iout
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+var errSpecialsNotSupported = errors.New("special files are not supported on this platform")
+
+func CanMakeDevices() bool {
+	return false
+}
+
+func DeviceNumber(fs.FileInfo) uint64 {
+	return 0
+}
+
+func CreateSpecial(context.Context, string, fs.FileMode, uint64, time.Time) error {
+	return errSpecialsNotSupported
+}
@@ -0,0 +1,60 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package iout
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+//CanMakeDevices reports whether the current process is privileged enough to create device nodes.
+func CanMakeDevices() bool {
+	return os.Geteuid() == 0
+}
+
+//DeviceNumber returns the device number (major and minor) of the device node described by info.
+//For other entries it returns 0.
+func DeviceNumber(info fs.FileInfo) uint64 {
+	if info.Mode()&fs.ModeDevice == 0 {
+		return 0
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Rdev)
+	}
+	return 0
+}
+
+//CreateSpecial creates a special file (a named pipe, a device node or a socket) of the same type and permission bits
+//as in mode at the destination path. For device nodes rdev is used as the device number.
+//It sets for the created file the same modTime as the source file modTime.
+func CreateSpecial(ctx context.Context, dstPath string, mode fs.FileMode, rdev uint64, srcModTime time.Time) error {
+	if err := EnsureDirExists(ctx, filepath.Dir(dstPath)); err != nil {
+		return err
+	}
+	perm := uint32(mode.Perm())
+	var err error
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		err = syscall.Mkfifo(dstPath, perm)
+	case mode&fs.ModeCharDevice != 0:
+		err = mknod(dstPath, syscall.S_IFCHR|perm, rdev)
+	case mode&fs.ModeDevice != 0:
+		err = mknod(dstPath, syscall.S_IFBLK|perm, rdev)
+	case mode&fs.ModeSocket != 0:
+		err = mknod(dstPath, syscall.S_IFSOCK|perm, 0)
+	default:
+		return fmt.Errorf("cannot create special file: mode %v is not special", mode)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create special file: %w", &fs.PathError{Op: "mknod", Path: dstPath, Err: err})
+	}
+	if err := os.Chtimes(dstPath, time.Now(), srcModTime); err != nil {
+		return fmt.Errorf("cannot set file modification time: %w", err)
+	}
+	return nil
+}
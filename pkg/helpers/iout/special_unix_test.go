@@ -0,0 +1,61 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package iout
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateSpecialFifo(t *testing.T) {
+	requires := require.New(t)
+	wd, err := os.Getwd()
+	requires.NoError(err)
+	copyDir, err := os.MkdirTemp(filepath.Join(wd, "testdata"), "copy")
+	requires.NoError(err)
+	defer os.RemoveAll(copyDir)
+
+	dstPath := filepath.Join(copyDir, "subdir/fifo")
+	modTime := time.Date(2020, time.May, 1, 12, 0, 0, 0, time.Local)
+	err = CreateSpecial(context.Background(), dstPath, fs.ModeNamedPipe|0600, 0, modTime)
+
+	requires.NoError(err)
+	info, err := os.Lstat(dstPath)
+	requires.NoError(err)
+	requires.Equal(fs.ModeNamedPipe, info.Mode().Type())
+	requires.True(info.ModTime().Equal(modTime))
+	requires.Zero(DeviceNumber(info))
+}
+
+func TestCreateSpecialDevice(t *testing.T) {
+	if !CanMakeDevices() {
+		t.Skip("device nodes can be created only by a privileged process")
+	}
+	requires := require.New(t)
+	wd, err := os.Getwd()
+	requires.NoError(err)
+	copyDir, err := os.MkdirTemp(filepath.Join(wd, "testdata"), "copy")
+	requires.NoError(err)
+	defer os.RemoveAll(copyDir)
+
+	nullInfo, err := os.Stat(os.DevNull)
+	requires.NoError(err)
+	dstPath := filepath.Join(copyDir, "null")
+	err = CreateSpecial(context.Background(), dstPath, nullInfo.Mode(), DeviceNumber(nullInfo), nullInfo.ModTime())
+
+	if errors.Is(err, fs.ErrPermission) {
+		t.Skip("device nodes cannot be created here (e.g. in a container without CAP_MKNOD)")
+	}
+	requires.NoError(err)
+	info, err := os.Lstat(dstPath)
+	requires.NoError(err)
+	requires.Equal(nullInfo.Mode().Type(), info.Mode().Type())
+	requires.Equal(DeviceNumber(nullInfo), DeviceNumber(info))
+}
@@ -0,0 +1,146 @@
+package run
+
+import (
+	"context"
+	"sync"
+)
+
+//FairLimiter limits the number of concurrently running activities (e.g. workers' tasks) by the common budget,
+//which is shared fairly between different consumers (keys): when a slot gets free, the consumers waiting for it
+//get it in turn (round-robin), regardless of the number of waiters each consumer has. In the same way the turn
+//of the consumer is shared between its sub-consumers (subKeys), e.g. the copy dirs of one sync job.
+type FairLimiter struct {
+	mu     sync.Mutex
+	free   int
+	queues map[string]*fairQueue // waiters of each consumer by its sub-consumers
+	turns  []string              // consumers that have waiters, in the order of their turns
+}
+
+func NewFairLimiter(budget int) *FairLimiter {
+	return &FairLimiter{free: budget, queues: make(map[string]*fairQueue)}
+}
+
+//Acquire blocks until a slot is given to the sub-consumer with the subKey of the consumer with the key
+//(or until ctx is done). Each successful Acquire must be followed by Release.
+func (l *FairLimiter) Acquire(ctx context.Context, key, subKey string) error {
+	l.mu.Lock()
+	if l.free > 0 && len(l.turns) == 0 {
+		l.free--
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	q, ok := l.queues[key]
+	if !ok {
+		q = &fairQueue{waiters: make(map[string][]chan struct{})}
+		l.queues[key] = q
+		l.turns = append(l.turns, key)
+	}
+	q.push(subKey, ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-ready:
+			// the slot has been given concurrently with the cancellation, so it has to be passed on
+			l.releaseLocked()
+		default:
+			l.removeWaiterLocked(key, subKey, ready)
+		}
+		return ctx.Err()
+	}
+}
+
+//Release frees the slot and gives it to the next waiting consumer (if any).
+func (l *FairLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *FairLimiter) releaseLocked() {
+	if len(l.turns) == 0 {
+		l.free++
+		return
+	}
+	key := l.turns[0]
+	l.turns = l.turns[1:]
+	q := l.queues[key]
+	close(q.pop())
+	if q.isEmpty() {
+		delete(l.queues, key)
+	} else {
+		l.turns = append(l.turns, key) // the consumer goes to the end of the line
+	}
+}
+
+func (l *FairLimiter) removeWaiterLocked(key, subKey string, ready chan struct{}) {
+	q := l.queues[key]
+	q.remove(subKey, ready)
+	if !q.isEmpty() {
+		return
+	}
+	delete(l.queues, key)
+	l.turns = removeKey(l.turns, key)
+}
+
+//fairQueue is the queue of waiters, which are taken in turn (round-robin) by their keys.
+type fairQueue struct {
+	waiters map[string][]chan struct{} // waiters of each key, in the order of their arrival
+	turns   []string                   // keys that have waiters, in the order of their turns
+}
+
+func (q *fairQueue) isEmpty() bool {
+	return len(q.turns) == 0
+}
+
+func (q *fairQueue) push(key string, ready chan struct{}) {
+	if len(q.waiters[key]) == 0 {
+		q.turns = append(q.turns, key)
+	}
+	q.waiters[key] = append(q.waiters[key], ready)
+}
+
+//pop takes the first waiter of the key, whose turn it is. The queue must not be empty.
+func (q *fairQueue) pop() chan struct{} {
+	key := q.turns[0]
+	q.turns = q.turns[1:]
+	queue := q.waiters[key]
+	if len(queue) > 1 {
+		q.waiters[key] = queue[1:]
+		q.turns = append(q.turns, key) // the key goes to the end of the line
+	} else {
+		delete(q.waiters, key)
+	}
+	return queue[0]
+}
+
+func (q *fairQueue) remove(key string, ready chan struct{}) {
+	queue := q.waiters[key]
+	for i, ch := range queue {
+		if ch == ready {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		q.waiters[key] = queue
+		return
+	}
+	delete(q.waiters, key)
+	q.turns = removeKey(q.turns, key)
+}
+
+func removeKey(keys []string, key string) []string {
+	for i, k := range keys {
+		if k == key {
+			return append(keys[:i], keys[i+1:]...)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,124 @@
+package run
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFairLimiter_RoundRobin(t *testing.T) {
+	requires := require.New(t)
+	ctx := context.Background()
+	limiter := NewFairLimiter(1)
+	requires.NoError(limiter.Acquire(ctx, "busy", ""))
+
+	// consumer "a" comes with 3 waiters, and only then consumer "b" comes with 1 waiter
+	var (
+		mu      sync.Mutex
+		order   []string
+		wg      sync.WaitGroup
+		waiting = make(map[string]int)
+	)
+	for _, key := range []string{"a", "a", "a", "b"} {
+		key := key
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requires.NoError(limiter.Acquire(ctx, key, ""))
+			mu.Lock()
+			order = append(order, key)
+			mu.Unlock()
+			limiter.Release()
+		}()
+		// the next waiter comes only after this one is enqueued
+		waiting[key]++
+		want := waiting[key]
+		requires.Eventually(func() bool { return waitersCount(limiter, key) == want }, time.Second, time.Millisecond)
+	}
+
+	limiter.Release()
+	wg.Wait()
+
+	requires.Equal([]string{"a", "b", "a", "a"}, order)
+	requires.Equal(1, limiter.free)
+}
+
+func TestFairLimiter_AcquireCanceled(t *testing.T) {
+	requires := require.New(t)
+	limiter := NewFairLimiter(1)
+	requires.NoError(limiter.Acquire(context.Background(), "a", ""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := limiter.Acquire(ctx, "b", "")
+
+	requires.ErrorIs(err, context.DeadlineExceeded)
+	requires.Empty(limiter.turns)
+	requires.Empty(limiter.queues)
+
+	limiter.Release()
+	requires.Equal(1, limiter.free)
+}
+
+func TestFairLimiter_RoundRobinWithinConsumer(t *testing.T) {
+	requires := require.New(t)
+	ctx := context.Background()
+	limiter := NewFairLimiter(1)
+	requires.NoError(limiter.Acquire(ctx, "busy", ""))
+
+	// job "a" has 2 copy dirs with 4 waiters in total, and only then job "b" comes with 1 waiter
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+		count int
+	)
+	for _, keys := range [][2]string{{"a", "a1"}, {"a", "a1"}, {"a", "a1"}, {"a", "a2"}, {"b", "b1"}} {
+		keys := keys
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requires.NoError(limiter.Acquire(ctx, keys[0], keys[1]))
+			mu.Lock()
+			order = append(order, keys[1])
+			mu.Unlock()
+			limiter.Release()
+		}()
+		// the next waiter comes only after this one is enqueued
+		count++
+		want := count
+		requires.Eventually(func() bool { return totalWaitersCount(limiter) == want }, time.Second, time.Millisecond)
+	}
+
+	limiter.Release()
+	wg.Wait()
+
+	// the jobs take turns, and so do the copy dirs of the job "a"
+	requires.Equal([]string{"a1", "b1", "a2", "a1", "a1"}, order)
+	requires.Equal(1, limiter.free)
+	requires.Empty(limiter.queues)
+}
+
+func waitersCount(l *FairLimiter, key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if q, ok := l.queues[key]; ok {
+		return len(q.waiters[""])
+	}
+	return 0
+}
+
+func totalWaitersCount(l *FairLimiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := 0
+	for _, q := range l.queues {
+		for _, waiters := range q.waiters {
+			count += len(waiters)
+		}
+	}
+	return count
+}
@@ -0,0 +1,131 @@
+//Package metrics contains the minimal Prometheus metrics primitives (the histogram) and the writer of the Prometheus
+//text exposition format. The values are collected by their owners and written on each scrape.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+
+	//ContentType is the content type of the text exposition format.
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+//DurationBuckets are the default upper bounds (in seconds) of the duration histograms' buckets.
+var DurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+//Label is the name and the value of the sample's label.
+type Label struct {
+	Name, Value string
+}
+
+//Histogram counts the observed values in the buckets. It's safe for concurrent use.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64 // the sorted upper bounds of the buckets (without +Inf)
+	counts []uint64  // the non-cumulative counts of the buckets, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	sorted := append([]float64{}, bounds...)
+	sort.Float64s(sorted)
+	return &Histogram{bounds: sorted, counts: make([]uint64, len(sorted)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v) // the first bound >= v
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+//Writer writes the metric families in the text exposition format. All the samples of a family must be written
+//right after its Family call. The first write error is kept and returned by Flush.
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+//Family writes the HELP and TYPE lines of the metric family.
+func (w *Writer) Family(name, typ, help string) {
+	_, _ = w.w.WriteString("# HELP " + name + " " + escape(help, false) + "\n# TYPE " + name + " " + typ + "\n")
+}
+
+//Sample writes the counter or gauge sample.
+func (w *Writer) Sample(name string, value float64, labels ...Label) {
+	w.sample(name, labels, formatFloat(value))
+}
+
+//Histogram writes the histogram's cumulative buckets, sum and count.
+func (w *Writer) Histogram(name string, h *Histogram, labels ...Label) {
+	h.mu.Lock()
+	counts := append([]uint64{}, h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, c := range counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		w.sample(name+"_bucket", append(labels[:len(labels):len(labels)], Label{"le", le}),
+			strconv.FormatUint(cumulative, 10))
+	}
+	w.sample(name+"_sum", labels, formatFloat(sum))
+	w.sample(name+"_count", labels, strconv.FormatUint(count, 10))
+}
+
+func (w *Writer) sample(name string, labels []Label, value string) {
+	_, _ = w.w.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, len(labels))
+		for i, l := range labels {
+			pairs[i] = l.Name + `="` + escape(l.Value, true) + `"`
+		}
+		_, _ = w.w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	_, _ = w.w.WriteString(" " + value + "\n")
+}
+
+//Flush writes the buffered data and returns the first write error (if any).
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//escape escapes the backslashes and the line feeds (and the double quotes in the label values).
+func escape(s string, quotes bool) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if quotes {
+		replacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return replacer.Replace(s)
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	requires := require.New(t)
+	h := NewHistogram([]float64{1, 0.5})
+	for _, v := range []float64{0.1, 0.5, 0.7, 3} {
+		h.Observe(v)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Family("ops_total", TypeCounter, "the number of\nthe operations")
+	w.Sample("ops_total", 2, Label{"dir", `C:\a "b"`})
+	w.Sample("ops_total", 1e9)
+	w.Family("duration_seconds", TypeHistogram, "the duration")
+	w.Histogram("duration_seconds", h, Label{"kind", "copy"})
+	requires.NoError(w.Flush())
+
+	requires.Equal(`# HELP ops_total the number of\nthe operations
+# TYPE ops_total counter
+ops_total{dir="C:\\a \"b\""} 2
+ops_total 1e+09
+# HELP duration_seconds the duration
+# TYPE duration_seconds histogram
+duration_seconds_bucket{kind="copy",le="0.5"} 2
+duration_seconds_bucket{kind="copy",le="1"} 3
+duration_seconds_bucket{kind="copy",le="+Inf"} 4
+duration_seconds_sum{kind="copy"} 4.3
+duration_seconds_count{kind="copy"} 4
+`, buf.String())
+}
@@ -0,0 +1,247 @@
+//Package tracing records the OpenTelemetry-style spans and exports them in batches to the JSON file
+//or to the OTLP/HTTP collector. Only the small subset of the model is implemented: the spans have the attributes
+//and the error status, and their parents are passed through the context.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	//flushPeriod is how often the ended spans are exported.
+	flushPeriod = 5 * time.Second
+	//maxBatch is the number of the ended spans, after which they are exported without waiting.
+	maxBatch = 512
+	//maxPending is the number of the spans waiting for the export, above which the new spans are dropped
+	//(e.g. while the collector is unavailable).
+	maxPending = 10000
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+//SpanContext identifies the span. The zero SpanContext means there is no span.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.SpanID != SpanID{}
+}
+
+//Attr is the attribute of the span, its value is a string, an int64, a float64 or a bool.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Attr          { return Attr{Key: key, Value: value} }
+func Int(key string, value int) Attr         { return Attr{Key: key, Value: int64(value)} }
+func Int64(key string, value int64) Attr     { return Attr{Key: key, Value: value} }
+func Uint64(key string, value uint64) Attr   { return Attr{Key: key, Value: int64(value)} }
+func Float64(key string, value float64) Attr { return Attr{Key: key, Value: value} }
+func Bool(key string, value bool) Attr       { return Attr{Key: key, Value: value} }
+
+//SpanData is the ended span, which is passed to the exporter.
+type SpanData struct {
+	Name     string
+	Context  SpanContext
+	ParentID SpanID // is zero for the root span
+	Start    time.Time
+	End      time.Time
+	Attrs    []Attr
+	Error    string // the span has the error status, if it's not empty
+}
+
+//Exporter sends the ended spans to the storage.
+type Exporter interface {
+	Export(spans []SpanData) error
+	Close() error
+}
+
+//Tracer starts the spans and exports them in background. It's safe for concurrent use, and its methods
+//(as well as the methods of its spans) do nothing, if it's nil.
+type Tracer struct {
+	exporter Exporter
+	onError  func(err error) // is called with the export errors
+	flush    chan struct{}
+	done     chan struct{}
+
+	mu      sync.Mutex
+	pending []SpanData
+	closed  bool
+}
+
+//NewTracer starts the tracer, which exports the spans with the exporter.
+func NewTracer(exporter Exporter, onError func(err error)) *Tracer {
+	t := &Tracer{exporter: exporter, onError: onError, flush: make(chan struct{}, 1), done: make(chan struct{})}
+	go t.run()
+	return t
+}
+
+type spanKey struct{}
+
+//ContextWithSpan returns the context, which carries the span, so that the spans started with it are its children.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+//SpanContextFrom returns the span carried by the context (the zero SpanContext, if there is none).
+func SpanContextFrom(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanKey{}).(SpanContext)
+	return sc
+}
+
+//Start starts the span, which is the child of the context's span (or the root of the new trace).
+//The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := t.StartAt(SpanContextFrom(ctx), name, time.Now(), attrs...)
+	return ContextWithSpan(ctx, span.data.Context), span
+}
+
+//StartAt starts the span at the given time as the child of the parent (or as the root of the new trace,
+//if the parent is zero). It's used for the spans, which start before they are known to the tracer.
+func (t *Tracer) StartAt(parent SpanContext, name string, start time.Time, attrs ...Attr) *Span {
+	if t == nil {
+		return nil
+	}
+	data := SpanData{Name: name, Start: start, Attrs: attrs, ParentID: parent.SpanID}
+	data.Context.TraceID = parent.TraceID
+	if !parent.IsValid() {
+		_, _ = rand.Read(data.Context.TraceID[:])
+	}
+	_, _ = rand.Read(data.Context.SpanID[:])
+	return &Span{tracer: t, data: data}
+}
+
+func (t *Tracer) export(data SpanData) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || len(t.pending) >= maxPending {
+		return
+	}
+	t.pending = append(t.pending, data)
+	if len(t.pending) >= maxBatch {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case _, ok := <-t.flush:
+			if !ok {
+				t.exportPending()
+				return
+			}
+		}
+		t.exportPending()
+	}
+}
+
+func (t *Tracer) exportPending() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.exporter.Export(spans); err != nil && t.onError != nil {
+		t.onError(err)
+	}
+}
+
+//Close exports the ended spans and closes the exporter. The spans ended after that are dropped.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.flush)
+	t.mu.Unlock()
+	<-t.done
+	return t.exporter.Close()
+}
+
+//Span is the timed operation. It's exported, when it's ended.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+//Context returns the span's identifiers (the zero SpanContext, if the span is nil).
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+func (s *Span) SetAttrs(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attrs = append(s.data.Attrs, attrs...)
+}
+
+//SetError sets the error status of the span, if the error is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+//EndAt ends the span at the given time. The span is exported only once, the repeated calls are ignored.
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.export(data)
+}
@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer_FileExporter(t *testing.T) {
+	requires := require.New(t)
+	path := filepath.Join(t.TempDir(), "traces", "spans.jsonl")
+	exporter, err := NewExporter(path, "dsync")
+	requires.NoError(err)
+	tracer := NewTracer(exporter, func(err error) { t.Error(err) })
+
+	ctx, root := tracer.Start(context.Background(), "sync cycle", String("dir", "/src"))
+	_, child := tracer.Start(ctx, "walk")
+	child.SetError(errors.New("permission denied"))
+	child.End()
+	enqueuedAt := time.Now().Add(-time.Second)
+	op := tracer.StartAt(root.Context(), "operation", enqueuedAt, Uint64("op.id", 7), Bool("reverse", false))
+	op.SetAttrs(Int("size", 3))
+	op.End()
+	op.End() // it's exported only once
+	root.End()
+	requires.NoError(tracer.Close())
+	tracer.Start(context.Background(), "dropped") // the spans aren't exported after Close
+
+	file, err := os.Open(path)
+	requires.NoError(err)
+	defer file.Close()
+	spans := make(map[string]fileSpan)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var span fileSpan
+		requires.NoError(json.Unmarshal(scanner.Bytes(), &span))
+		spans[span.Name] = span
+	}
+	requires.Len(spans, 3)
+	cycle, walk, operation := spans["sync cycle"], spans["walk"], spans["operation"]
+	requires.Empty(cycle.ParentSpanID)
+	requires.Equal("/src", cycle.Attributes["dir"])
+	requires.Equal(cycle.TraceID, walk.TraceID)
+	requires.Equal(cycle.SpanID, walk.ParentSpanID)
+	requires.Equal("permission denied", walk.Error)
+	requires.Equal(cycle.TraceID, operation.TraceID)
+	requires.Equal(cycle.SpanID, operation.ParentSpanID)
+	requires.Equal(float64(7), operation.Attributes["op.id"])
+	requires.Equal(float64(3), operation.Attributes["size"])
+	requires.True(operation.Start.Equal(enqueuedAt))
+	requires.GreaterOrEqual(operation.End.Sub(operation.Start), time.Second)
+}
+
+func TestTracer_OTLPExporter(t *testing.T) {
+	requires := require.New(t)
+	requests := make(chan otlpRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" ||
+			json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- req
+	}))
+	defer srv.Close()
+
+	exporter, err := NewExporter(srv.URL, "dsync")
+	requires.NoError(err)
+	tracer := NewTracer(exporter, func(err error) { t.Error(err) })
+	span := tracer.StartAt(SpanContext{}, "operation", time.Unix(1, 0), Int64("op.id", 5), String("kind", "copy"))
+	span.SetError(errors.New("no space"))
+	span.EndAt(time.Unix(2, 0))
+	requires.NoError(tracer.Close())
+
+	req := <-requests
+	requires.Len(req.ResourceSpans, 1)
+	requires.Equal("service.name", req.ResourceSpans[0].Resource.Attributes[0].Key)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	requires.Len(spans, 1)
+	requires.Equal("operation", spans[0].Name)
+	requires.Len(spans[0].TraceID, 32)
+	requires.Len(spans[0].SpanID, 16)
+	requires.Empty(spans[0].ParentSpanID)
+	requires.Equal("1000000000", spans[0].StartTimeUnixNano)
+	requires.Equal("2000000000", spans[0].EndTimeUnixNano)
+	requires.Equal(map[string]interface{}{"intValue": "5"}, spans[0].Attributes[0].Value)
+	requires.Equal(map[string]interface{}{"stringValue": "copy"}, spans[0].Attributes[1].Value)
+	requires.Equal(&otlpStatus{Code: otlpStatusError, Message: "no space"}, spans[0].Status)
+
+	_, err = NewExporter("http://", "dsync")
+	requires.Error(err)
+}
+
+func TestTracer_Nil(t *testing.T) {
+	requires := require.New(t)
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "cycle")
+	requires.Nil(span)
+	requires.False(SpanContextFrom(ctx).IsValid())
+	span.SetAttrs(Int("n", 1))
+	span.SetError(errors.New("error"))
+	span.End()
+	requires.False(span.Context().IsValid())
+	requires.NoError(tracer.Close())
+}